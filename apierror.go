@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+
+	"computacion-nube-proyecto/ddns"
+	"computacion-nube-proyecto/vbox"
+)
+
+// ============================== API Errors ==================================
+
+// APIError es el error tipado que la API retorna a los clientes, tanto en las
+// respuestas de error ({"error": {...}}) como en los jobs fallidos.
+type APIError struct {
+	Code      string         `json:"code"`                // Código estable (ej: "vm_exists")
+	Step      string         `json:"step,omitempty"`      // Paso de la operación en que falló
+	Message   string         `json:"message"`             // Mensaje legible
+	Retryable bool           `json:"retryable"`           // true si reintentar puede funcionar
+	Status    int            `json:"status"`              // Código HTTP equivalente
+	ExitCode  int            `json:"exit_code,omitempty"` // Código de salida del script, si aplica
+	Detail    string         `json:"detail,omitempty"`    // Error original
+	Rollback  []RollbackStep `json:"rollback,omitempty"`  // Pasos revertidos tras el fallo
+	Err       error          `json:"-"`                   // Error subyacente
+}
+
+// Error implementa la interfaz error.
+func (e *APIError) Error() string {
+	if e.Detail != "" {
+		return e.Message + ": " + e.Detail
+	}
+	return e.Message
+}
+
+// Unwrap expone el error subyacente.
+func (e *APIError) Unwrap() error { return e.Err }
+
+// Códigos de error de la API.
+const (
+	codeBadRequest          = "bad_request"
+	codeNotFound            = "not_found"
+	codeMethodNotAllowed    = "method_not_allowed"
+	codeConflict            = "conflict"
+	codeInternal            = "internal_error"
+	codeCanceled            = "canceled"
+	codeTimeout             = "timeout"
+	codeUnavailable         = "unavailable"
+	codePrecondition        = "precondition_failed"
+	codeInstanceExists      = "instance_exists"
+	codeInstanceBusy        = "instance_busy"
+	codeInstanceNotFound    = "instance_not_found"
+	codeVMExists            = "vm_exists"
+	codeVMNotFound          = "vm_not_found"
+	codeVMCreateFailed      = "vm_create_failed"
+	codeVMRunning           = "vm_running"
+	codeVBoxManageNotFound  = "vboxmanage_not_found"
+	codeVBoxManageFailed    = "vboxmanage_failed"
+	codeControllerNotFound  = "controller_not_found"
+	codeDiskNotFound        = "disk_not_found"
+	codeDiskNotMultiattach  = "disk_not_multiattach"
+	codeDiskAlreadyAttached = "disk_already_attached"
+	codeNoFreePort          = "no_free_port"
+	codeDiskAttachFailed    = "disk_attach_failed"
+	codeIPConfigFailed      = "ip_config_failed"
+	codeNoIPAvailable       = "no_ip_available"
+	codeIPReserved          = "ip_reserved"
+	codeIPConflict          = "ip_conflict"
+	codeDNSUnreachable      = "dns_unreachable"
+	codeDNSNotConfigured    = "dns_not_configured"
+	codeDNSRejected         = "dns_update_rejected"
+	codeDNSNotApplied       = "dns_not_applied"
+	codeDNSZoneInvalid      = "dns_zone_invalid"
+	codeDeployFailed        = "deploy_failed"
+	codeHealthCheckFailed   = "health_check_failed"
+	codeDestroyFailed       = "destroy_failed"
+	codeScriptFailed        = "script_failed"
+	codeStorageCorrupt      = "storage_corrupt"
+	codeUnauthorized        = "unauthorized"
+	codeForbidden           = "forbidden"
+	codeQuotaExceeded       = "quota_exceeded"
+	codeHostNotAllowed      = "host_not_allowed"
+	codeZipTooLarge         = "zip_too_large"
+	codeSecretsLocked       = "secrets_locked"
+	codeSSHUnreachable      = "ssh_unreachable"
+	codeSSHAuthFailed       = "ssh_auth_failed"
+	codeSSHHostKey          = "ssh_host_key_mismatch"
+	codeRemoteCommandFailed = "remote_command_failed"
+)
+
+// errorSpec define el estado HTTP, si es reintentable y el mensaje de un código.
+type errorSpec struct {
+	status    int
+	retryable bool
+	message   string
+}
+
+// errorCatalog describe cada código de error de la API.
+var errorCatalog = map[string]errorSpec{
+	codeBadRequest:          {http.StatusBadRequest, false, "solicitud inválida"},
+	codeNotFound:            {http.StatusNotFound, false, "recurso no encontrado"},
+	codeMethodNotAllowed:    {http.StatusMethodNotAllowed, false, "método no permitido"},
+	codeConflict:            {http.StatusConflict, false, "conflicto con el estado actual"},
+	codeInternal:            {http.StatusInternalServerError, false, "error interno"},
+	codeCanceled:            {http.StatusConflict, true, "operación cancelada"},
+	codeTimeout:             {http.StatusGatewayTimeout, true, "un paso excedió su tiempo máximo"},
+	codeUnavailable:         {http.StatusServiceUnavailable, true, "el servidor está ocupado, reintentar más tarde"},
+	codePrecondition:        {http.StatusInternalServerError, false, "faltan requisitos en el servidor (parámetros, VBoxManage, ssh o disco plantilla)"},
+	codeInstanceExists:      {http.StatusConflict, false, "ya existe una instancia para el host"},
+	codeInstanceBusy:        {http.StatusConflict, true, "la instancia no admite la operación en su estado actual"},
+	codeInstanceNotFound:    {http.StatusNotFound, false, "instancia no encontrada"},
+	codeVMExists:            {http.StatusConflict, false, "la VM ya existe"},
+	codeVMNotFound:          {http.StatusNotFound, false, "la VM no existe"},
+	codeVMCreateFailed:      {http.StatusBadGateway, true, "VirtualBox no pudo crear o arrancar la VM"},
+	codeVMRunning:           {http.StatusConflict, true, "la VM está en ejecución"},
+	codeVBoxManageNotFound:  {http.StatusInternalServerError, false, "VBoxManage no encontrado"},
+	codeVBoxManageFailed:    {http.StatusBadGateway, true, "VBoxManage falló"},
+	codeControllerNotFound:  {http.StatusInternalServerError, false, "controlador de almacenamiento no encontrado"},
+	codeDiskNotFound:        {http.StatusInternalServerError, false, "disco plantilla no encontrado"},
+	codeDiskNotMultiattach:  {http.StatusInternalServerError, false, "el disco plantilla no es tipo multiattach"},
+	codeDiskAlreadyAttached: {http.StatusConflict, false, "el disco ya está adjuntado a la VM"},
+	codeNoFreePort:          {http.StatusConflict, false, "no hay puertos libres en el controlador"},
+	codeDiskAttachFailed:    {http.StatusBadGateway, true, "no se pudo adjuntar el disco a la VM"},
+	codeIPConfigFailed:      {http.StatusBadGateway, true, "no se pudo reservar la IP en el DHCP host-only"},
+	codeNoIPAvailable:       {http.StatusConflict, false, "no hay IPs disponibles"},
+	codeIPReserved:          {http.StatusConflict, false, "la IP está reservada o asignada a otro host"},
+	codeIPConflict:          {http.StatusConflict, false, "la IP reservada para el host está en uso en la red, el DNS o el DHCP"},
+	codeDNSUnreachable:      {http.StatusServiceUnavailable, true, "servidor DNS inaccesible"},
+	codeDNSNotConfigured:    {http.StatusServiceUnavailable, false, "actualizaciones DNS sin clave TSIG configurada"},
+	codeDNSRejected:         {http.StatusBadGateway, false, "el servidor DNS rechazó la actualización"},
+	codeDNSNotApplied:       {http.StatusServiceUnavailable, true, "el registro DNS no quedó resoluble"},
+	codeDNSZoneInvalid:      {http.StatusBadGateway, false, "la zona leída del servidor DNS no es válida"},
+	codeDeployFailed:        {http.StatusBadGateway, true, "no se pudo desplegar el sitio en la VM"},
+	codeHealthCheckFailed:   {http.StatusBadGateway, true, "el sitio no responde en /health.txt"},
+	codeDestroyFailed:       {http.StatusBadGateway, true, "no se pudo eliminar la VM"},
+	codeScriptFailed:        {http.StatusBadGateway, false, "el script de automatización falló"},
+	codeStorageCorrupt:      {http.StatusServiceUnavailable, false, "almacenamiento corrupto: modificaciones bloqueadas hasta que un operador lo restaure"},
+	codeUnauthorized:        {http.StatusUnauthorized, false, "autenticación requerida"},
+	codeForbidden:           {http.StatusForbidden, false, "el rol del usuario no permite la operación"},
+	codeQuotaExceeded:       {http.StatusForbidden, false, "cuota del tenant excedida"},
+	codeHostNotAllowed:      {http.StatusForbidden, false, "el tenant no admite ese nombre de host"},
+	codeZipTooLarge:         {http.StatusRequestEntityTooLarge, false, "el ZIP supera el tamaño máximo del tenant"},
+	codeSecretsLocked:       {http.StatusServiceUnavailable, false, "no se puede leer ni rotar el archivo de secretos: falta la frase de cifrado o no es correcta"},
+	codeSSHUnreachable:      {http.StatusBadGateway, true, "no se pudo conectar por SSH"},
+	codeSSHAuthFailed:       {http.StatusBadGateway, false, "el host rechazó la clave SSH"},
+	codeSSHHostKey:          {http.StatusBadGateway, false, "la clave de host SSH no coincide con la registrada"},
+	codeRemoteCommandFailed: {http.StatusBadGateway, false, "el comando remoto falló"},
+}
+
+// newAPIError crea un APIError con el estado y mensaje del catálogo.
+func newAPIError(code, step string, err error) *APIError {
+	spec, ok := errorCatalog[code]
+	if !ok {
+		spec = errorCatalog[codeInternal]
+	}
+	e := &APIError{Code: code, Step: step, Message: spec.message, Retryable: spec.retryable, Status: spec.status, Err: err}
+	if err != nil {
+		e.Detail = err.Error()
+	}
+	return e
+}
+
+// Errores de los provisioners que la API distingue.
+var (
+	errVMExists         = errors.New("VM ya existe")
+	errNoIPAvailable    = errors.New("sin IP disponible")
+	errDNSNotConfigured = errors.New("clave TSIG no configurada")
+	errDNSNotApplied    = errors.New("DNS no aplicado")
+	errHealthCheck      = errors.New("health check falló")
+)
+
+// dnsUpdateError envuelve un fallo al enviar una actualización RFC 2136.
+type dnsUpdateError struct {
+	Err error
+}
+
+// Error implementa la interfaz error.
+func (e *dnsUpdateError) Error() string { return e.Err.Error() }
+
+// Unwrap expone el error subyacente.
+func (e *dnsUpdateError) Unwrap() error { return e.Err }
+
+// scriptError es el fallo de un script batch con su código de salida.
+type scriptError struct {
+	Script     string // Nombre del script (ej: "crearVMyDNS.bat")
+	ExitCode   int    // Código de salida del script
+	AttachCode int    // Código de UnirMaquinaDisco.bat, si crearVMyDNS falló al adjuntar el disco
+	Err        error  // Error de os/exec
+}
+
+// Error implementa la interfaz error.
+func (e *scriptError) Error() string {
+	if e.AttachCode != 0 {
+		return fmt.Sprintf("%s terminó con código %d (UnirMaquinaDisco.bat código %d: %s)",
+			e.Script, e.ExitCode, e.AttachCode, vbox.ExitCode(e.AttachCode))
+	}
+	return fmt.Sprintf("%s terminó con código %d: %v", e.Script, e.ExitCode, e.Err)
+}
+
+// Unwrap expone el error subyacente.
+func (e *scriptError) Unwrap() error { return e.Err }
+
+// scriptCodes traduce los códigos de salida documentados de cada script.
+var scriptCodes = map[string]map[int]string{
+	"crearVMyDNS.bat": {
+		1: codePrecondition,
+		2: codeVMExists,
+		3: codeVMCreateFailed,
+		4: codeDiskAttachFailed,
+		5: codeIPConfigFailed,
+	},
+	"desplegarSitio.bat": {
+		1: codePrecondition,
+		2: codeDeployFailed,
+	},
+	"eliminarInstancia.bat": {
+		1: codePrecondition,
+	},
+}
+
+// vboxCodes traduce los códigos de UnirMaquinaDisco.bat (y del paquete vbox).
+var vboxCodes = map[vbox.ExitCode]string{
+	vbox.ExitNoVBoxManage:       codeVBoxManageNotFound,
+	vbox.ExitVMNotFound:         codeVMNotFound,
+	vbox.ExitControllerNotFound: codeControllerNotFound,
+	vbox.ExitDiskNotFound:       codeDiskNotFound,
+	vbox.ExitNoFreePort:         codeNoFreePort,
+	vbox.ExitAttachFailed:       codeDiskAttachFailed,
+	vbox.ExitNotMultiattach:     codeDiskNotMultiattach,
+	vbox.ExitVMRunning:          codeVMRunning,
+	vbox.ExitDuplicateDisk:      codeDiskAlreadyAttached,
+}
+
+// toAPIError clasifica err en un APIError. step se usa si el error no trae
+// uno propio (normalmente el paso del job en curso).
+func toAPIError(err error, step string) *APIError {
+	var rb *rollbackError
+	if errors.As(err, &rb) {
+		e := toAPIError(rb.Err, step)
+		e.Detail = err.Error()
+		e.Rollback = rb.Steps
+		// Si quedó algo sin deshacer, reintentar puede chocar con lo que quedó
+		for _, st := range rb.Steps {
+			if !st.OK {
+				e.Retryable = false
+			}
+		}
+		return e
+	}
+	var ae *APIError
+	if errors.As(err, &ae) {
+		out := *ae
+		if out.Step == "" {
+			out.Step = step
+		}
+		return &out
+	}
+	var se *scriptError
+	var ve *vbox.Error
+	var ce *vbox.CommandError
+	var de *dnsUpdateError
+	var xe *sshExitError
+	switch {
+	case errors.Is(err, context.Canceled):
+		return newAPIError(codeCanceled, step, err)
+	case errors.Is(err, context.DeadlineExceeded) && !errors.As(err, &de):
+		return newAPIError(codeTimeout, step, err)
+	case errors.As(err, &se):
+		code, ok := scriptCodes[se.Script][se.ExitCode]
+		if !ok {
+			code = codeScriptFailed
+			if se.Script == "eliminarInstancia.bat" {
+				code = codeDestroyFailed
+			}
+		}
+		if attach, ok := vboxCodes[vbox.ExitCode(se.AttachCode)]; ok {
+			code = attach
+		}
+		e := newAPIError(code, step, err)
+		e.ExitCode = se.ExitCode
+		return e
+	case errors.Is(err, errStoreCorrupt):
+		return newAPIError(codeStorageCorrupt, step, err)
+	case errors.Is(err, errInstanceExists), errors.Is(err, errHostInUse):
+		return newAPIError(codeInstanceExists, step, err)
+	case errors.Is(err, errInstanceBusy):
+		return newAPIError(codeInstanceBusy, step, err)
+	case errors.Is(err, errInstanceMissing):
+		return newAPIError(codeInstanceNotFound, step, err)
+	case errors.Is(err, errQueueFull):
+		return newAPIError(codeUnavailable, step, err)
+	case errors.Is(err, errInvalidHost):
+		return newAPIError(codeBadRequest, step, err)
+	case errors.Is(err, errQuotaExceeded):
+		return newAPIError(codeQuotaExceeded, step, err)
+	case errors.Is(err, errHostForbidden):
+		return newAPIError(codeHostNotAllowed, step, err)
+	case errors.Is(err, errZipTooLarge):
+		return newAPIError(codeZipTooLarge, step, err)
+	case errors.Is(err, errSecretsLocked), errors.Is(err, errBadPassphrase):
+		return newAPIError(codeSecretsLocked, step, err)
+	case errors.Is(err, errHostKeyMismatch):
+		return newAPIError(codeSSHHostKey, step, err)
+	case errors.Is(err, errSSHAuth):
+		return newAPIError(codeSSHAuthFailed, step, err)
+	case errors.Is(err, errSSHUnreachable):
+		return newAPIError(codeSSHUnreachable, step, err)
+	case errors.As(err, &xe):
+		e := newAPIError(codeRemoteCommandFailed, step, err)
+		e.ExitCode = xe.Status
+		return e
+	case errors.Is(err, errVMExists), errors.Is(err, vbox.ErrVMExists):
+		return newAPIError(codeVMExists, step, err)
+	case errors.As(err, &ve):
+		code, ok := vboxCodes[ve.Code]
+		if !ok {
+			code = codeVBoxManageFailed
+		}
+		e := newAPIError(code, step, err)
+		e.ExitCode = int(ve.Code)
+		return e
+	case errors.Is(err, vbox.ErrNoMAC):
+		return newAPIError(codeIPConfigFailed, step, err)
+	case errors.As(err, &ce):
+		return newAPIError(codeVBoxManageFailed, step, err)
+	case errors.Is(err, errNoIPAvailable):
+		return newAPIError(codeNoIPAvailable, step, err)
+	case errors.Is(err, errIPReserved):
+		return newAPIError(codeIPReserved, step, err)
+	case errors.Is(err, errIPConflict):
+		return newAPIError(codeIPConflict, step, err)
+	case errors.Is(err, errDNSNotConfigured):
+		return newAPIError(codeDNSNotConfigured, step, err)
+	case errors.Is(err, errDNSNotApplied):
+		return newAPIError(codeDNSNotApplied, step, err)
+	case errors.Is(err, ddns.ErrZoneSyntax):
+		return newAPIError(codeDNSZoneInvalid, step, err)
+	case errors.As(err, &de):
+		var rc *ddns.RcodeError
+		var ne net.Error
+		switch {
+		case errors.As(err, &rc):
+			return newAPIError(codeDNSRejected, step, err)
+		case errors.As(err, &ne), errors.Is(err, context.DeadlineExceeded), errors.Is(err, os.ErrDeadlineExceeded):
+			return newAPIError(codeDNSUnreachable, step, err)
+		}
+		return newAPIError(codeDNSRejected, step, err)
+	case errors.Is(err, errHealthCheck):
+		return newAPIError(codeHealthCheckFailed, step, err)
+	}
+	return newAPIError(codeInternal, step, err)
+}
+
+// writeAPIError responde con el estado HTTP del error y {"error": {...}}.
+func writeAPIError(w http.ResponseWriter, e *APIError) {
+	if aw, ok := w.(*auditWriter); ok {
+		aw.ev.Code, aw.ev.Error = e.Code, e.Error()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.Status)
+	json.NewEncoder(w).Encode(map[string]any{"error": e})
+}
+
+// writeError responde un error simple con un código del catálogo y un mensaje propio.
+func writeError(w http.ResponseWriter, code, message string) {
+	e := newAPIError(code, "", nil)
+	e.Message = message
+	writeAPIError(w, e)
+}
@@ -0,0 +1,591 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================== Audit =======================================
+
+// AuditEvent registra una operación que modifica el estado: cada petición a
+// un endpoint de escritura y el resultado final de cada job.
+type AuditEvent struct {
+	Seq        uint64            `json:"seq"`                   // Número de secuencia creciente; cursor de paginación
+	Time       string            `json:"time"`                  // Inicio de la operación (RFC3339)
+	Actor      string            `json:"actor"`                 // Usuario que la pidió; IP del cliente sin autenticación, o "system"
+	RemoteAddr string            `json:"remote_addr,omitempty"` // IP del cliente, si vino de una petición
+	Operation  string            `json:"operation"`             // ej: "prepare", "destroy", "ipam.reserve"
+	Method     string            `json:"method,omitempty"`      // Método HTTP, si vino de una petición
+	Path       string            `json:"path,omitempty"`        // Ruta HTTP, si vino de una petición
+	Host       string            `json:"host,omitempty"`        // FQDN afectado
+	InstanceID string            `json:"instance_id,omitempty"` // Instancia afectada
+	JobID      string            `json:"job_id,omitempty"`      // Job encolado o terminado
+	Params     map[string]string `json:"params,omitempty"`      // Parámetros de la petición, sin secretos
+	Result     string            `json:"result"`                // success, accepted, failure o canceled
+	Status     int               `json:"status,omitempty"`      // Código HTTP de la respuesta
+	Code       string            `json:"code,omitempty"`        // Código de error de la API
+	Error      string            `json:"error,omitempty"`       // Mensaje de error
+	DurationMs int64             `json:"duration_ms"`           // Duración de la petición o del job
+}
+
+// Resultados de un AuditEvent.
+const (
+	auditSuccess  = "success"
+	auditAccepted = "accepted" // Job encolado; su resultado es otro evento con el mismo job_id
+	auditFailure  = "failure"
+	auditCanceled = "canceled"
+)
+
+// actorSystem actor de las operaciones que no vienen de una petición.
+const actorSystem = "system"
+
+// secretParams fragmentos de nombres de parámetros cuyo valor nunca se registra.
+var secretParams = []string{"password", "secret", "token", "key", "passphrase", "credential"}
+
+// auditPath solo guarda los eventos recientes: al llegar a
+// auditRotateEntries se mueve a auditArchiveDir como un segmento inmutable
+// (audit-<primero>-<último>.jsonl), igual que el historial DNS. Las
+// consultas recorren los segmentos y se detienen al completar la página.
+
+var (
+	muAudit    sync.Mutex // Serializa las escrituras y la rotación de auditPath
+	auditSeq   uint64     // Seq del último evento escrito
+	auditFirst uint64     // Seq del primer evento de auditPath; 0 si está vacío
+	auditCount int        // Eventos en auditPath
+)
+
+// auditKey clave de contexto para el AuditEvent de la petición en curso.
+type auditKey struct{}
+
+// auditFrom retorna el AuditEvent de la petición en curso, o nil.
+func auditFrom(ctx context.Context) *AuditEvent {
+	ev, _ := ctx.Value(auditKey{}).(*AuditEvent)
+	return ev
+}
+
+// actorFrom retorna el actor de la petición en curso o, dentro de un job, el
+// de la petición que lo creó. Fuera de ambos retorna "system".
+func actorFrom(ctx context.Context) string {
+	if ev := auditFrom(ctx); ev != nil {
+		return ev.Actor
+	}
+	if id := jobIDFrom(ctx); id != "" && jobs != nil {
+		if j, ok := jobs.Get(id); ok && j.Actor != "" {
+			return j.Actor
+		}
+	}
+	return actorSystem
+}
+
+// auditTarget completa el host y la instancia del evento de la petición en curso.
+func auditTarget(ctx context.Context, host, instanceID string) {
+	if ev := auditFrom(ctx); ev != nil {
+		ev.Host, ev.InstanceID = host, instanceID
+	}
+}
+
+// remoteIP retorna la IP del cliente de la petición. Es el actor hasta que
+// requireRole (o /login) lo reemplaza por el usuario autenticado.
+func remoteIP(r *http.Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// auditOps operación de auditoría de cada método HTTP de un endpoint. Los
+// métodos que no están (ej: GET) no se registran.
+type auditOps map[string]string
+
+// auditWriter captura el estado de la respuesta. writeAPIError y
+// writeJobAccepted completan además el error tipado y el job en ev.
+type auditWriter struct {
+	http.ResponseWriter
+	ev     *AuditEvent
+	status int
+}
+
+// WriteHeader registra el estado antes de enviarlo.
+func (w *auditWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write registra 200 si el handler no llamó a WriteHeader.
+func (w *auditWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// audited envuelve h para registrar un AuditEvent por cada petición cuyo
+// método esté en ops, con el actor, los parámetros, el resultado y la duración.
+func audited(ops auditOps, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		op, ok := ops[r.Method]
+		if !ok {
+			h(w, r)
+			return
+		}
+		start := time.Now()
+		ip := remoteIP(r)
+		ev := &AuditEvent{
+			Time:       start.UTC().Format(time.RFC3339),
+			Actor:      ip,
+			RemoteAddr: ip,
+			Operation:  op,
+			Method:     r.Method,
+			Path:       r.URL.Path,
+		}
+		body := auditJSONBody(r)
+		aw := &auditWriter{ResponseWriter: w, ev: ev}
+		r = r.WithContext(context.WithValue(r.Context(), auditKey{}, ev))
+		h(aw, r)
+
+		ev.DurationMs = time.Since(start).Milliseconds()
+		ev.Status = aw.status
+		if ev.Status == 0 {
+			ev.Status = http.StatusOK
+		}
+		switch {
+		case ev.Status >= 400:
+			ev.Result = auditFailure
+		case ev.Status == http.StatusAccepted:
+			ev.Result = auditAccepted
+		default:
+			ev.Result = auditSuccess
+		}
+		ev.Params = auditParams(r, body)
+		if ev.Host == "" {
+			ev.Host = cmp.Or(ev.Params["hostname"], ev.Params["host"])
+		}
+		recordAudit(*ev)
+	}
+}
+
+// auditJSONBody lee los parámetros de un cuerpo JSON y lo deja disponible
+// para el handler. Retorna nil si el cuerpo no es un objeto JSON.
+func auditJSONBody(r *http.Request) map[string]any {
+	if r.Body == nil || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		return nil
+	}
+	b, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(b), r.Body), r.Body}
+	if err != nil {
+		return nil
+	}
+	var m map[string]any
+	if json.Unmarshal(b, &m) != nil {
+		return nil
+	}
+	return m
+}
+
+// auditParams junta los parámetros de la query, del formulario (de los
+// archivos solo el nombre y el tamaño) y del cuerpo JSON, ocultando los
+// secretos.
+func auditParams(r *http.Request, body map[string]any) map[string]string {
+	p := map[string]string{}
+	for k, vs := range r.URL.Query() {
+		p[k] = strings.Join(vs, ",")
+	}
+	for k, vs := range r.PostForm {
+		p[k] = strings.Join(vs, ",")
+	}
+	if r.MultipartForm != nil {
+		for k, vs := range r.MultipartForm.Value {
+			p[k] = strings.Join(vs, ",")
+		}
+		for k, fs := range r.MultipartForm.File {
+			names := make([]string, len(fs))
+			for i, f := range fs {
+				names[i] = fmt.Sprintf("%s (%d bytes)", f.Filename, f.Size)
+			}
+			p[k] = strings.Join(names, ",")
+		}
+	}
+	for k, v := range body {
+		p[k] = fmt.Sprint(v)
+	}
+	for k := range p {
+		lk := strings.ToLower(k)
+		if slices.ContainsFunc(secretParams, func(s string) bool { return strings.Contains(lk, s) }) {
+			p[k] = "[oculto]"
+		}
+	}
+	if len(p) == 0 {
+		return nil
+	}
+	return p
+}
+
+// auditJob registra el resultado final de un job.
+func auditJob(j Job) {
+	ev := AuditEvent{
+		Time:      j.CreatedAt,
+		Actor:     j.Actor,
+		Operation: j.Kind,
+		Host:      j.Host,
+		JobID:     j.ID,
+		Error:     j.Error,
+	}
+	if ev.Actor == "" {
+		ev.Actor = actorSystem
+	}
+	if j.StartedAt != "" {
+		ev.Time = j.StartedAt
+	}
+	if !j.started.IsZero() {
+		ev.DurationMs = time.Since(j.started).Milliseconds()
+	}
+	if j.ErrorInfo != nil {
+		ev.Code = j.ErrorInfo.Code
+	}
+	switch j.State {
+	case jobSucceeded:
+		ev.Result = auditSuccess
+	case jobCanceled:
+		ev.Result = auditCanceled
+	default:
+		ev.Result = auditFailure
+	}
+	if inst, ok := j.Result.(Instance); ok {
+		ev.InstanceID = inst.ID
+	}
+	recordAudit(ev)
+}
+
+// loadAudit lee el último Seq de auditPath o, si está vacío, del último
+// segmento archivado. Si el archivo quedó con una línea incompleta (ej:
+// corte de luz durante una escritura), la termina para que el próximo evento
+// empiece en una línea nueva.
+func loadAudit() error {
+	segments, err := listSegments(auditArchiveDir, "audit")
+	if err != nil {
+		return err
+	}
+	if n := len(segments); n > 0 {
+		auditSeq = segments[n-1].last
+	}
+	b, err := os.ReadFile(auditPath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	events, _ := parseAudit(b)
+	if n := len(events); n > 0 {
+		auditSeq = max(auditSeq, events[n-1].Seq)
+		auditFirst, auditCount = events[0].Seq, n
+	}
+	if len(b) > 0 && b[len(b)-1] != '\n' {
+		f, err := os.OpenFile(auditPath, os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		_, err = f.WriteString("\n")
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	}
+	return nil
+}
+
+// recordAudit agrega ev a auditPath con el Seq siguiente. Los errores se
+// informan por consola: una falla de auditoría no revierte la operación.
+func recordAudit(ev AuditEvent) {
+	muAudit.Lock()
+	defer muAudit.Unlock()
+	ev.Seq = auditSeq + 1
+	b, err := json.Marshal(ev)
+	if err == nil {
+		err = appendLine(auditPath, b)
+	}
+	if err != nil {
+		fmt.Println("Error guardando auditoría:", err)
+		return
+	}
+	auditSeq = ev.Seq
+	if auditCount == 0 {
+		auditFirst = ev.Seq
+	}
+	auditCount++
+	if auditCount < auditRotateEntries {
+		return
+	}
+	// Si falla se reintenta con el próximo evento
+	if err := rotateAudit(); err != nil {
+		fmt.Println("Error rotando auditoría:", err)
+	}
+}
+
+// rotateAudit mueve auditPath a un segmento de auditArchiveDir. Se llama con
+// muAudit tomado.
+func rotateAudit() error {
+	if err := os.MkdirAll(auditArchiveDir, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(auditArchiveDir, segmentName("audit", auditFirst, auditSeq))
+	if err := os.Rename(auditPath, path); err != nil {
+		return err
+	}
+	auditFirst, auditCount = 0, 0
+	return nil
+}
+
+// appendLine agrega b y un salto de línea al final de path.
+func appendLine(path string, b []byte) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// parseAudit decodifica las líneas de auditPath. Las líneas inválidas se
+// omiten y se cuentan en bad.
+func parseAudit(b []byte) (events []AuditEvent, bad int) {
+	sc := bufio.NewScanner(bytes.NewReader(b))
+	sc.Buffer(nil, 1<<20)
+	for sc.Scan() {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		var ev AuditEvent
+		if err := json.Unmarshal(sc.Bytes(), &ev); err != nil {
+			bad++
+			continue
+		}
+		events = append(events, ev)
+	}
+	return events, bad
+}
+
+// AuditQuery filtros y paginación de GET /audit.
+type AuditQuery struct {
+	pageQuery
+	Actor     string // Actor exacto
+	Operation string // Operación exacta
+	Host      string // FQDN (sin distinguir mayúsculas)
+	Instance  string // ID de instancia
+	Job       string // ID de job
+	Result    string // success, accepted, failure o canceled
+}
+
+// matches indica si ev cumple los filtros de q (sin considerar el cursor).
+func (q AuditQuery) matches(ev AuditEvent) bool {
+	return (q.Actor == "" || ev.Actor == q.Actor) &&
+		(q.Operation == "" || ev.Operation == q.Operation) &&
+		(q.Host == "" || strings.EqualFold(ev.Host, q.Host)) &&
+		(q.Instance == "" || ev.InstanceID == q.Instance) &&
+		(q.Job == "" || ev.JobID == q.Job) &&
+		(q.Result == "" || ev.Result == q.Result) &&
+		q.inRange(ev.Time)
+}
+
+// parseAuditQuery lee los filtros de GET /audit: actor, operation, host,
+// instance, job y result más los de parsePageQuery.
+func parseAuditQuery(r *http.Request) (AuditQuery, error) {
+	v := r.URL.Query()
+	p, err := parsePageQuery(v)
+	q := AuditQuery{
+		pageQuery: p,
+		Actor:     v.Get("actor"),
+		Operation: v.Get("operation"),
+		Host:      strings.TrimSuffix(v.Get("host"), "."),
+		Instance:  v.Get("instance"),
+		Job:       v.Get("job"),
+		Result:    v.Get("result"),
+	}
+	return q, err
+}
+
+// scanAudit recorre los eventos que cumplen q en el orden pedido y a partir
+// del cursor, llamando a fn con cada uno hasta que retorne false. Los
+// segmentos que quedan antes del cursor no se leen y cada segmento se lee
+// solo cuando hace falta.
+func scanAudit(q AuditQuery, fn func(AuditEvent) bool) error {
+	// auditPath se lee junto con la lista de segmentos para que una rotación
+	// no mueva eventos entre ambos
+	muAudit.Lock()
+	segments, err := listSegments(auditArchiveDir, "audit")
+	var recent []byte
+	if err == nil {
+		recent, err = os.ReadFile(auditPath)
+		if os.IsNotExist(err) {
+			err = nil
+		}
+	}
+	muAudit.Unlock()
+	if err != nil {
+		return err
+	}
+
+	bad := 0
+	defer func() {
+		if bad > 0 {
+			fmt.Printf("Auditoría: %d líneas inválidas omitidas\n", bad)
+		}
+	}()
+	// visit pasa a fn los eventos de b en el orden pedido; retorna false si
+	// fn pidió terminar
+	visit := func(b []byte) bool {
+		events, n := parseAudit(b)
+		bad += n
+		if !q.Asc {
+			slices.Reverse(events)
+		}
+		for _, ev := range events {
+			if q.afterCursor(ev.Seq) && q.matches(ev) && !fn(ev) {
+				return false
+			}
+		}
+		return true
+	}
+	// segment lee y recorre un segmento salvo que quede antes del cursor
+	segment := func(a logSegment) (bool, error) {
+		if q.Cursor != 0 && (q.Asc && a.last <= q.Cursor || !q.Asc && a.first >= q.Cursor) {
+			return true, nil
+		}
+		b, err := os.ReadFile(a.path)
+		if err != nil {
+			return false, err
+		}
+		return visit(b), nil
+	}
+
+	if q.Asc {
+		for _, a := range segments {
+			if more, err := segment(a); !more || err != nil {
+				return err
+			}
+		}
+		visit(recent)
+		return nil
+	}
+	if !visit(recent) {
+		return nil
+	}
+	for i := len(segments) - 1; i >= 0; i-- {
+		if more, err := segment(segments[i]); !more || err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// queryAudit retorna la página de eventos que cumplen q y el cursor de la
+// siguiente (0 si no hay más).
+func queryAudit(q AuditQuery) ([]AuditEvent, uint64, error) {
+	out := []AuditEvent{}
+	// Se junta un evento más del límite para saber si hay otra página
+	err := scanAudit(q, func(ev AuditEvent) bool {
+		out = append(out, ev)
+		return len(out) <= q.Limit
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	if len(out) <= q.Limit {
+		return out, 0, nil
+	}
+	out = out[:q.Limit]
+	return out, out[len(out)-1].Seq, nil
+}
+
+// handleAudit maneja GET /audit: eventos de auditoría, por defecto los 100
+// más recientes primero. Acepta los filtros de parseAuditQuery (ej:
+// ?operation=destroy&result=failure&since=2026-09-01). Si hay más eventos,
+// el header X-Next-Cursor trae el valor de ?cursor= para la página siguiente.
+func handleAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	q, err := parseAuditQuery(r)
+	if err != nil {
+		writeError(w, codeBadRequest, err.Error())
+		return
+	}
+	events, next, err := queryAudit(q)
+	if err != nil {
+		writeAPIError(w, toAPIError(err, "leyendo auditoría"))
+		return
+	}
+	if next != 0 {
+		w.Header().Set("X-Next-Cursor", strconv.FormatUint(next, 10))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(events)
+}
+
+// handleAuditExport maneja GET /audit/export: todos los eventos que cumplen
+// los filtros de GET /audit (sin paginar, más antiguos primero salvo
+// ?order=desc) como JSON Lines, para descargar.
+func handleAuditExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	if r.URL.Query().Get("order") == "" {
+		v := r.URL.Query()
+		v.Set("order", "asc")
+		r.URL.RawQuery = v.Encode()
+	}
+	q, err := parseAuditQuery(r)
+	if err != nil {
+		writeError(w, codeBadRequest, err.Error())
+		return
+	}
+	// Los headers se envían con el primer evento: si la lectura falla antes
+	// se responde con el error en lugar de un archivo vacío
+	var enc *json.Encoder
+	err = scanAudit(q, func(ev AuditEvent) bool {
+		if enc == nil {
+			enc = startAuditExport(w)
+		}
+		return enc.Encode(ev) == nil
+	})
+	switch {
+	case err != nil && enc == nil:
+		writeAPIError(w, toAPIError(err, "leyendo auditoría"))
+	case err != nil:
+		fmt.Println("Error exportando auditoría:", err)
+	case enc == nil:
+		startAuditExport(w)
+	}
+}
+
+// startAuditExport prepara los headers de la descarga de GET /audit/export.
+func startAuditExport(w http.ResponseWriter) *json.Encoder {
+	name := "audit-" + time.Now().UTC().Format("20060102T150405Z") + ".jsonl"
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+name+`"`)
+	return json.NewEncoder(w)
+}
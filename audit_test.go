@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// auditTestEnv usa un auditPath y un directorio de segmentos temporales.
+func auditTestEnv(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	prevPath, prevDir, prevRotate := auditPath, auditArchiveDir, auditRotateEntries
+	auditPath, auditArchiveDir = filepath.Join(dir, "audit.jsonl"), filepath.Join(dir, "audit-archive")
+	auditSeq, auditFirst, auditCount = 0, 0, 0
+	t.Cleanup(func() {
+		auditPath, auditArchiveDir, auditRotateEntries = prevPath, prevDir, prevRotate
+		auditSeq, auditFirst, auditCount = 0, 0, 0
+	})
+}
+
+func TestAuditParams(t *testing.T) {
+	multipartReq := func() *http.Request {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		mw.WriteField("hostname", "web1.grid.lab")
+		mw.WriteField("SSH_Key", "-----BEGIN")
+		fw, _ := mw.CreateFormFile("file", "sitio.zip")
+		fw.Write([]byte("PK1234"))
+		mw.Close()
+		r := httptest.NewRequest(http.MethodPost, "/publish", &body)
+		r.Header.Set("Content-Type", mw.FormDataContentType())
+		r.ParseMultipartForm(1 << 20)
+		return r
+	}
+	formReq := func() *http.Request {
+		r := httptest.NewRequest(http.MethodPost, "/users?role=admin", strings.NewReader("username=ana&password=secreto123"))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.ParseForm()
+		return r
+	}
+
+	for _, tc := range []struct {
+		name string
+		req  func() *http.Request
+		body map[string]any
+		want map[string]string
+	}{
+		{
+			name: "query y formulario",
+			req:  formReq,
+			want: map[string]string{"role": "admin", "username": "ana", "password": "[oculto]"},
+		},
+		{
+			name: "multipart con archivo",
+			req:  multipartReq,
+			want: map[string]string{"hostname": "web1.grid.lab", "SSH_Key": "[oculto]", "file": "sitio.zip (6 bytes)"},
+		},
+		{
+			name: "cuerpo JSON",
+			req:  func() *http.Request { return httptest.NewRequest(http.MethodPost, "/tokens", nil) },
+			body: map[string]any{"name": "ci", "api_token": "abc", "client_secret": "x", "Passphrase": "y", "ttl": 30},
+			want: map[string]string{"name": "ci", "api_token": "[oculto]", "client_secret": "[oculto]", "Passphrase": "[oculto]", "ttl": "30"},
+		},
+		{
+			name: "sin parámetros",
+			req:  func() *http.Request { return httptest.NewRequest(http.MethodDelete, "/instances/i1", nil) },
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := auditParams(tc.req(), tc.body)
+			if len(got) != len(tc.want) || tc.want == nil && got != nil {
+				t.Fatalf("auditParams = %v; quiero %v", got, tc.want)
+			}
+			for k, v := range tc.want {
+				if got[k] != v {
+					t.Errorf("%s = %q; quiero %q", k, got[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestAuditedResult(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		method string
+		status int // 0 = el handler no llama a WriteHeader
+		want   string
+	}{
+		{"éxito implícito", http.MethodPost, 0, auditSuccess},
+		{"creado", http.MethodPost, http.StatusCreated, auditSuccess},
+		{"job encolado", http.MethodPost, http.StatusAccepted, auditAccepted},
+		{"petición inválida", http.MethodPost, http.StatusBadRequest, auditFailure},
+		{"error interno", http.MethodDelete, http.StatusInternalServerError, auditFailure},
+		{"método no auditado", http.MethodGet, http.StatusOK, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			auditTestEnv(t)
+			h := audited(auditOps{http.MethodPost: "prepare", http.MethodDelete: "destroy"}, func(w http.ResponseWriter, r *http.Request) {
+				if tc.status != 0 {
+					w.WriteHeader(tc.status)
+				}
+				w.Write([]byte("{}"))
+			})
+			r := httptest.NewRequest(tc.method, "/instances?hostname=web1.grid.lab", nil)
+			r.RemoteAddr = "192.168.56.1:50000"
+			h(httptest.NewRecorder(), r)
+
+			events, _, err := queryAudit(AuditQuery{pageQuery: pageQuery{Limit: 10}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.want == "" {
+				if len(events) != 0 {
+					t.Errorf("eventos = %+v; quiero ninguno", events)
+				}
+				return
+			}
+			if len(events) != 1 {
+				t.Fatalf("eventos = %+v; quiero uno", events)
+			}
+			ev := events[0]
+			if ev.Result != tc.want || ev.Actor != "192.168.56.1" || ev.Host != "web1.grid.lab" {
+				t.Errorf("evento = %+v; quiero result %s, actor 192.168.56.1 y host web1.grid.lab", ev, tc.want)
+			}
+			if tc.status != 0 && ev.Status != tc.status || tc.status == 0 && ev.Status != http.StatusOK {
+				t.Errorf("status = %d; quiero %d", ev.Status, tc.status)
+			}
+		})
+	}
+}
+
+func TestAuditJobResult(t *testing.T) {
+	auditTestEnv(t)
+	for _, state := range []string{jobSucceeded, jobFailed, jobCanceled} {
+		auditJob(Job{ID: "job-" + state, Kind: "publish", State: state})
+	}
+	events, _, err := queryAudit(AuditQuery{pageQuery: pageQuery{Limit: 10, Asc: true}})
+	if err != nil || len(events) != 3 {
+		t.Fatalf("eventos = %+v, %v; quiero 3", events, err)
+	}
+	for i, want := range []string{auditSuccess, auditFailure, auditCanceled} {
+		if events[i].Result != want || events[i].Actor != actorSystem {
+			t.Errorf("%s: result %s, actor %s; quiero %s, %s", events[i].JobID, events[i].Result, events[i].Actor, want, actorSystem)
+		}
+	}
+}
+
+func TestQueryAuditSegments(t *testing.T) {
+	auditTestEnv(t)
+	auditRotateEntries = 3
+	for _, op := range []string{"prepare", "publish", "destroy", "prepare", "publish", "destroy", "prepare", "publish"} {
+		recordAudit(AuditEvent{Operation: op, Result: auditSuccess})
+	}
+	segments, err := listSegments(auditArchiveDir, "audit")
+	if err != nil || len(segments) != 2 || segments[0].first != 1 || segments[1].last != 6 {
+		t.Fatalf("segmentos = %+v, %v; quiero 1-3 y 4-6", segments, err)
+	}
+
+	// Al reiniciar el Seq continúa desde el archivo activo o, vacío, desde los segmentos
+	auditSeq, auditFirst, auditCount = 0, 0, 0
+	if err := loadAudit(); err != nil || auditSeq != 8 || auditFirst != 7 || auditCount != 2 {
+		t.Fatalf("loadAudit: seq %d, primero %d, %d eventos, %v; quiero 8, 7, 2", auditSeq, auditFirst, auditCount, err)
+	}
+	os.Remove(auditPath)
+	auditSeq, auditFirst, auditCount = 0, 0, 0
+	if err := loadAudit(); err != nil || auditSeq != 6 || auditCount != 0 {
+		t.Fatalf("loadAudit sin archivo activo: seq %d, %d eventos, %v; quiero 6, 0", auditSeq, auditCount, err)
+	}
+	recordAudit(AuditEvent{Operation: "destroy", Result: auditFailure})
+	recordAudit(AuditEvent{Operation: "prepare", Result: auditSuccess})
+
+	// pages recorre todas las páginas y retorna los Seq en orden
+	pages := func(q AuditQuery) []uint64 {
+		var seqs []uint64
+		for range 10 {
+			events, next, err := queryAudit(q)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, ev := range events {
+				seqs = append(seqs, ev.Seq)
+			}
+			if next == 0 {
+				return seqs
+			}
+			q.Cursor = next
+		}
+		t.Fatal("la paginación no termina")
+		return nil
+	}
+	for _, tc := range []struct {
+		name string
+		q    AuditQuery
+		want string
+	}{
+		{"desc", AuditQuery{pageQuery: pageQuery{Limit: 2}}, "[8 7 6 5 4 3 2 1]"},
+		{"asc", AuditQuery{pageQuery: pageQuery{Limit: 3, Asc: true}}, "[1 2 3 4 5 6 7 8]"},
+		{"filtro", AuditQuery{pageQuery: pageQuery{Limit: 2}, Operation: "prepare"}, "[8 4 1]"},
+		{"resultado", AuditQuery{pageQuery: pageQuery{Limit: 1, Asc: true}, Result: auditFailure}, "[7]"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := fmt.Sprint(pages(tc.q)); got != tc.want {
+				t.Errorf("Seq = %s; quiero %s", got, tc.want)
+			}
+		})
+	}
+}
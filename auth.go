@@ -0,0 +1,686 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ============================== Authentication ==============================
+
+// Roles de usuario, de menor a mayor privilegio. Cada rol incluye los
+// permisos de los anteriores.
+const (
+	roleViewer   = "viewer"   // Solo lectura
+	roleDeployer = "deployer" // Crear, publicar y eliminar instancias
+	roleAdmin    = "admin"    // Usuarios, reservas de IP, reconciliación y auditoría
+)
+
+// roles en orden de privilegio.
+var roles = []string{roleViewer, roleDeployer, roleAdmin}
+
+// User es un usuario local. La contraseña se guarda con bcrypt y de los
+// tokens solo el SHA-256.
+type User struct {
+	Username     string     `json:"username"`
+	PasswordHash string     `json:"password_hash"`
+	Role         string     `json:"role"`
+	Tenant       string     `json:"tenant,omitempty"` // Tenant del usuario; vacío = su nombre
+	Tokens       []APIToken `json:"tokens,omitempty"`
+	CreatedAt    string     `json:"created_at"`
+}
+
+// APIToken es un token de API de un usuario (Authorization: Bearer <token>).
+type APIToken struct {
+	ID        string `json:"id"`         // Identificador público, para revocarlo
+	Name      string `json:"name"`       // Descripción (ej: "ci")
+	Hash      string `json:"hash"`       // SHA-256 (hex) del token
+	CreatedAt string `json:"created_at"` // Fecha de creación (RFC3339)
+}
+
+// userView es la vista pública de un User (sin hashes).
+type userView struct {
+	Username  string      `json:"username"`
+	Role      string      `json:"role"`
+	Tenant    string      `json:"tenant"`
+	CreatedAt string      `json:"created_at,omitempty"`
+	Tokens    []tokenView `json:"tokens"`
+}
+
+// tokenView es la vista pública de un APIToken.
+type tokenView struct {
+	ID        string `json:"id"`
+	Name      string `json:"name"`
+	CreatedAt string `json:"created_at"`
+}
+
+// view retorna la vista pública del usuario.
+func (u User) view() userView {
+	v := userView{Username: u.Username, Role: u.Role, Tenant: u.tenantOf(), CreatedAt: u.CreatedAt, Tokens: []tokenView{}}
+	for _, t := range u.Tokens {
+		v.Tokens = append(v.Tokens, tokenView{ID: t.ID, Name: t.Name, CreatedAt: t.CreatedAt})
+	}
+	return v
+}
+
+// session es una sesión de la interfaz web.
+type session struct {
+	username string
+	expires  time.Time
+}
+
+// Errores de autenticación.
+var (
+	errUnauthorized = errors.New("credenciales inválidas")
+	errUserExists   = errors.New("el usuario ya existe")
+	errUserMissing  = errors.New("usuario no encontrado")
+	errLastAdmin    = errors.New("debe quedar al menos un usuario admin")
+)
+
+// sessionCookie nombre de la cookie de sesión de la interfaz web.
+const sessionCookie = "cnp_session"
+
+// minPasswordLen largo mínimo de las contraseñas.
+const minPasswordLen = 8
+
+// usernameRe nombres de usuario válidos.
+var usernameRe = regexp.MustCompile(`^[a-zA-Z0-9._-]{1,64}$`)
+
+// dummyHash se compara cuando el usuario no existe, para que la respuesta
+// tarde lo mismo y no revele qué usuarios existen.
+var dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy-password"), bcrypt.DefaultCost)
+
+var (
+	muUsers  sync.Mutex             // Protege users y sessions
+	users    []User                 // Usuarios cargados de usersPath
+	sessions = map[string]session{} // Sesiones activas (solo en memoria)
+)
+
+// userKey clave de contexto para el usuario autenticado.
+type userKey struct{}
+
+// currentUser retorna el usuario autenticado de la petición, si lo hay.
+func currentUser(ctx context.Context) (User, bool) {
+	u, ok := ctx.Value(userKey{}).(User)
+	return u, ok
+}
+
+// loadUsers carga usersPath. Si no hay usuarios crea el usuario "admin" con
+// la contraseña de auth.admin_password (ADMIN_PASSWORD) o, si no está
+// definida, una aleatoria que se muestra una sola vez por consola.
+func loadUsers() error {
+	if !authEnabled {
+		fmt.Println("ATENCIÓN: autenticación deshabilitada (auth.enabled): cualquiera puede operar como admin")
+		return nil
+	}
+	b, err := os.ReadFile(usersPath)
+	switch {
+	case os.IsNotExist(err):
+		if err := checkQuarantined(usersPath); err != nil {
+			return err
+		}
+	case err != nil:
+		return err
+	default:
+		var list []User
+		if err := json.Unmarshal(b, &list); err != nil {
+			return markCorrupt(usersPath, err, true)
+		}
+		users = list
+	}
+	if len(users) > 0 {
+		return nil
+	}
+	// En solo lectura no se puede guardar el usuario inicial: se crea al
+	// reiniciar después de restaurar los archivos
+	if err := checkWritable(); err != nil {
+		fmt.Println("No se crea el usuario inicial:", err)
+		return nil
+	}
+	password := adminPassword
+	generated := password == ""
+	if generated {
+		password = randomToken(12)
+	}
+	if _, err := createUser("admin", password, roleAdmin, ""); err != nil {
+		return err
+	}
+	if generated {
+		fmt.Printf("Usuario inicial creado: admin / %s (cámbiela con PATCH /users/admin)\n", password)
+	} else {
+		fmt.Println("Usuario inicial creado: admin (contraseña de auth.admin_password)")
+	}
+	return nil
+}
+
+// saveUsersLocked guarda usersPath usando escritura atómica. Debe llamarse
+// con muUsers tomado.
+func saveUsersLocked() error {
+	if err := checkWritable(); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(users, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := usersPath + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, usersPath)
+}
+
+// randomToken retorna n bytes aleatorios en base64 URL.
+func randomToken(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// hashToken retorna el SHA-256 (hex) de un token de API.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// validRole indica si role es un rol conocido.
+func validRole(role string) bool { return slices.Contains(roles, role) }
+
+// hasRole indica si el rol have alcanza el rol mínimo want.
+func hasRole(have, want string) bool {
+	return slices.Index(roles, have) >= slices.Index(roles, want)
+}
+
+// findUserLocked retorna el índice del usuario, o -1. Debe llamarse con muUsers tomado.
+func findUserLocked(username string) int {
+	return slices.IndexFunc(users, func(u User) bool { return strings.EqualFold(u.Username, username) })
+}
+
+// adminsLocked cuenta los usuarios admin. Debe llamarse con muUsers tomado.
+func adminsLocked() int {
+	n := 0
+	for _, u := range users {
+		if u.Role == roleAdmin {
+			n++
+		}
+	}
+	return n
+}
+
+// createUser agrega un usuario con la contraseña hasheada con bcrypt. Si
+// tenant es vacío el usuario tiene su propio tenant.
+func createUser(username, password, role, tenant string) (User, error) {
+	if !usernameRe.MatchString(username) {
+		return User{}, fmt.Errorf("nombre de usuario inválido %q (letras, números, punto, guion y guion bajo)", username)
+	}
+	if !validRole(role) {
+		return User{}, fmt.Errorf("rol desconocido %q (use viewer, deployer o admin)", role)
+	}
+	if tenant != "" && !usernameRe.MatchString(tenant) {
+		return User{}, fmt.Errorf("tenant inválido %q", tenant)
+	}
+	if len(password) < minPasswordLen {
+		return User{}, fmt.Errorf("la contraseña debe tener al menos %d caracteres", minPasswordLen)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return User{}, err
+	}
+	muUsers.Lock()
+	defer muUsers.Unlock()
+	if findUserLocked(username) >= 0 {
+		return User{}, errUserExists
+	}
+	u := User{Username: username, PasswordHash: string(hash), Role: role, Tenant: tenant, CreatedAt: time.Now().UTC().Format(time.RFC3339)}
+	users = append(users, u)
+	if err := saveUsersLocked(); err != nil {
+		users = users[:len(users)-1]
+		return User{}, err
+	}
+	return u, nil
+}
+
+// updateUser cambia la contraseña, el rol y/o el tenant (los vacíos no se
+// cambian). Al cambiar la contraseña se cierran las sesiones del usuario;
+// sus tokens siguen válidos. Las instancias creadas quedan en su tenant.
+func updateUser(username, password, role, tenant string) (User, error) {
+	var hash []byte
+	if password != "" {
+		if len(password) < minPasswordLen {
+			return User{}, fmt.Errorf("la contraseña debe tener al menos %d caracteres", minPasswordLen)
+		}
+		var err error
+		if hash, err = bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost); err != nil {
+			return User{}, err
+		}
+	}
+	if role != "" && !validRole(role) {
+		return User{}, fmt.Errorf("rol desconocido %q (use viewer, deployer o admin)", role)
+	}
+	if tenant != "" && !usernameRe.MatchString(tenant) {
+		return User{}, fmt.Errorf("tenant inválido %q", tenant)
+	}
+	muUsers.Lock()
+	defer muUsers.Unlock()
+	i := findUserLocked(username)
+	if i < 0 {
+		return User{}, errUserMissing
+	}
+	prev := users[i]
+	if role != "" && role != roleAdmin && prev.Role == roleAdmin && adminsLocked() == 1 {
+		return User{}, errLastAdmin
+	}
+	if hash != nil {
+		users[i].PasswordHash = string(hash)
+	}
+	if role != "" {
+		users[i].Role = role
+	}
+	if tenant != "" {
+		users[i].Tenant = tenant
+	}
+	if err := saveUsersLocked(); err != nil {
+		users[i] = prev
+		return User{}, err
+	}
+	if hash != nil {
+		dropSessionsLocked(prev.Username)
+	}
+	return users[i], nil
+}
+
+// deleteUser elimina el usuario, sus tokens y sus sesiones.
+func deleteUser(username string) error {
+	muUsers.Lock()
+	defer muUsers.Unlock()
+	i := findUserLocked(username)
+	if i < 0 {
+		return errUserMissing
+	}
+	if users[i].Role == roleAdmin && adminsLocked() == 1 {
+		return errLastAdmin
+	}
+	prev := slices.Clone(users)
+	name := users[i].Username
+	users = slices.Delete(users, i, i+1)
+	if err := saveUsersLocked(); err != nil {
+		users = prev
+		return err
+	}
+	dropSessionsLocked(name)
+	return nil
+}
+
+// createToken genera un token de API para el usuario. El token en claro se
+// retorna una única vez; solo se guarda su hash.
+func createToken(username, name string) (tokenView, string, error) {
+	token := "cnp_" + randomToken(32)
+	t := APIToken{
+		ID:        randomToken(6),
+		Name:      strings.TrimSpace(name),
+		Hash:      hashToken(token),
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	muUsers.Lock()
+	defer muUsers.Unlock()
+	i := findUserLocked(username)
+	if i < 0 {
+		return tokenView{}, "", errUserMissing
+	}
+	users[i].Tokens = append(users[i].Tokens, t)
+	if err := saveUsersLocked(); err != nil {
+		users[i].Tokens = users[i].Tokens[:len(users[i].Tokens)-1]
+		return tokenView{}, "", err
+	}
+	return tokenView{ID: t.ID, Name: t.Name, CreatedAt: t.CreatedAt}, token, nil
+}
+
+// revokeToken elimina el token id del usuario.
+func revokeToken(username, id string) error {
+	muUsers.Lock()
+	defer muUsers.Unlock()
+	i := findUserLocked(username)
+	if i < 0 {
+		return errUserMissing
+	}
+	prev := users[i].Tokens
+	j := slices.IndexFunc(prev, func(t APIToken) bool { return t.ID == id })
+	if j < 0 {
+		return os.ErrNotExist
+	}
+	users[i].Tokens = slices.Delete(slices.Clone(prev), j, j+1)
+	if err := saveUsersLocked(); err != nil {
+		users[i].Tokens = prev
+		return err
+	}
+	return nil
+}
+
+// login verifica usuario y contraseña y abre una sesión. Retorna el ID de la
+// sesión para la cookie.
+func login(username, password string) (User, string, error) {
+	muUsers.Lock()
+	i := findUserLocked(username)
+	var u User
+	if i >= 0 {
+		u = users[i]
+	}
+	muUsers.Unlock()
+	hash := dummyHash
+	if i >= 0 {
+		hash = []byte(u.PasswordHash)
+	}
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil || i < 0 {
+		return User{}, "", errUnauthorized
+	}
+	id := randomToken(32)
+	muUsers.Lock()
+	sessions[id] = session{username: u.Username, expires: time.Now().Add(sessionTTL)}
+	muUsers.Unlock()
+	return u, id, nil
+}
+
+// dropSessionsLocked cierra las sesiones del usuario. Debe llamarse con
+// muUsers tomado.
+func dropSessionsLocked(username string) {
+	for id, s := range sessions {
+		if s.username == username {
+			delete(sessions, id)
+		}
+	}
+}
+
+// authenticate identifica al usuario de la petición por el token de API
+// (Authorization: Bearer) o por la cookie de sesión. Con la autenticación
+// deshabilitada todas las peticiones son de un admin "anonymous".
+func authenticate(r *http.Request) (User, error) {
+	if !authEnabled {
+		return User{Username: "anonymous", Role: roleAdmin}, nil
+	}
+	muUsers.Lock()
+	defer muUsers.Unlock()
+	if h := r.Header.Get("Authorization"); h != "" {
+		token, ok := strings.CutPrefix(h, "Bearer ")
+		if !ok {
+			return User{}, errUnauthorized
+		}
+		hash := hashToken(strings.TrimSpace(token))
+		for _, u := range users {
+			if slices.ContainsFunc(u.Tokens, func(t APIToken) bool { return t.Hash == hash }) {
+				return u, nil
+			}
+		}
+		return User{}, errUnauthorized
+	}
+	c, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return User{}, errUnauthorized
+	}
+	s, ok := sessions[c.Value]
+	if !ok || time.Now().After(s.expires) {
+		delete(sessions, c.Value)
+		return User{}, errUnauthorized
+	}
+	if i := findUserLocked(s.username); i >= 0 {
+		return users[i], nil
+	}
+	return User{}, errUnauthorized
+}
+
+// methodRoles rol mínimo para cada método HTTP de un endpoint. La clave ""
+// aplica a los métodos que no están; si tampoco está se exige admin.
+type methodRoles map[string]string
+
+// requireRole envuelve h para exigir un usuario autenticado con el rol que
+// methodRoles indica para el método de la petición. El usuario queda en el
+// contexto (currentUser) y como actor de la auditoría.
+func requireRole(rr methodRoles, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		want, ok := rr[r.Method]
+		if !ok {
+			want = rr[""]
+		}
+		if want == "" {
+			want = roleAdmin
+		}
+		u, err := authenticate(r)
+		if err != nil {
+			writeError(w, codeUnauthorized, "autenticación requerida: inicie sesión o envíe Authorization: Bearer <token>")
+			return
+		}
+		if ev := auditFrom(r.Context()); ev != nil {
+			ev.Actor = u.Username
+		}
+		if !hasRole(u.Role, want) {
+			writeError(w, codeForbidden, fmt.Sprintf("el rol %s no permite esta operación (requiere %s)", u.Role, want))
+			return
+		}
+		h(w, r.WithContext(context.WithValue(r.Context(), userKey{}, u)))
+	}
+}
+
+// ============================== Auth Handlers ===============================
+
+// credentials cuerpo de POST /login, POST /users y PATCH /users/{name}.
+type credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Role     string `json:"role"`
+	Tenant   string `json:"tenant"`
+}
+
+// readCredentials lee las credenciales de un cuerpo JSON o de un formulario.
+func readCredentials(r *http.Request) (credentials, error) {
+	var c credentials
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		err := json.NewDecoder(r.Body).Decode(&c)
+		return c, err
+	}
+	if err := r.ParseForm(); err != nil {
+		return c, err
+	}
+	return credentials{Username: r.FormValue("username"), Password: r.FormValue("password"), Role: r.FormValue("role"), Tenant: r.FormValue("tenant")}, nil
+}
+
+// handleLogin maneja POST /login (JSON o formulario con username y password):
+// abre una sesión para la interfaz web y responde con el usuario.
+func handleLogin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	c, err := readCredentials(r)
+	if err != nil {
+		writeError(w, codeBadRequest, "credenciales inválidas: "+err.Error())
+		return
+	}
+	if ev := auditFrom(r.Context()); ev != nil && c.Username != "" {
+		ev.Actor = c.Username
+	}
+	u, id, err := login(c.Username, c.Password)
+	if err != nil {
+		writeError(w, codeUnauthorized, "usuario o contraseña incorrectos")
+		return
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    id,
+		Path:     "/",
+		MaxAge:   int(sessionTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil,
+		SameSite: http.SameSiteStrictMode,
+	})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(u.view())
+}
+
+// handleLogout maneja POST /logout: cierra la sesión de la cookie.
+func handleLogout(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	if c, err := r.Cookie(sessionCookie); err == nil {
+		muUsers.Lock()
+		s, ok := sessions[c.Value]
+		delete(sessions, c.Value)
+		muUsers.Unlock()
+		if ev := auditFrom(r.Context()); ev != nil && ok {
+			ev.Actor = s.username
+		}
+	}
+	http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: "/", MaxAge: -1, HttpOnly: true, SameSite: http.SameSiteStrictMode})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleMe maneja GET /me: usuario autenticado y su rol.
+func handleMe(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	u, _ := currentUser(r.Context())
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(u.view())
+}
+
+// handleUsers maneja la administración de usuarios (solo admin):
+// GET /users, POST /users (username, password, role y tenant), PATCH
+// /users/{name} (password, role y/o tenant) y DELETE /users/{name}.
+func handleUsers(w http.ResponseWriter, r *http.Request) {
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/users"), "/")
+	switch {
+	case r.Method == http.MethodGet && name == "":
+		muUsers.Lock()
+		out := make([]userView, 0, len(users))
+		for _, u := range users {
+			out = append(out, u.view())
+		}
+		muUsers.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(out)
+	case r.Method == http.MethodPost && name == "":
+		c, err := readCredentials(r)
+		if err != nil {
+			writeError(w, codeBadRequest, "JSON inválido: "+err.Error())
+			return
+		}
+		if c.Role == "" {
+			c.Role = roleViewer
+		}
+		u, err := createUser(c.Username, c.Password, c.Role, c.Tenant)
+		switch {
+		case errors.Is(err, errUserExists):
+			writeError(w, codeConflict, err.Error())
+			return
+		case err != nil:
+			writeUserError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(u.view())
+	case r.Method == http.MethodPatch && name != "":
+		c, err := readCredentials(r)
+		if err != nil {
+			writeError(w, codeBadRequest, "JSON inválido: "+err.Error())
+			return
+		}
+		u, err := updateUser(name, c.Password, c.Role, c.Tenant)
+		if err != nil {
+			writeUserError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(u.view())
+	case r.Method == http.MethodDelete && name != "":
+		if err := deleteUser(name); err != nil {
+			writeUserError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, codeMethodNotAllowed, "method not allowed")
+	}
+}
+
+// writeUserError responde el error de una operación sobre un usuario.
+func writeUserError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, errUserMissing):
+		writeError(w, codeNotFound, err.Error())
+	case errors.Is(err, errLastAdmin):
+		writeError(w, codeConflict, err.Error())
+	case errors.Is(err, errStoreCorrupt):
+		writeAPIError(w, toAPIError(err, "guardando usuarios"))
+	default:
+		writeError(w, codeBadRequest, err.Error())
+	}
+}
+
+// handleTokens maneja los tokens de API del usuario autenticado:
+// GET /tokens, POST /tokens ({"name": ...}; un admin puede indicar "user"
+// para crearlo a otro usuario) y DELETE /tokens/{id}. El token en claro solo
+// se muestra en la respuesta de POST.
+func handleTokens(w http.ResponseWriter, r *http.Request) {
+	u, _ := currentUser(r.Context())
+	id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/tokens"), "/")
+	switch {
+	case r.Method == http.MethodGet && id == "":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(u.view().Tokens)
+	case r.Method == http.MethodPost && id == "":
+		var req struct {
+			Name string `json:"name"`
+			User string `json:"user"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, codeBadRequest, "JSON inválido: "+err.Error())
+			return
+		}
+		owner := u.Username
+		if req.User != "" && !strings.EqualFold(req.User, u.Username) {
+			if u.Role != roleAdmin {
+				writeError(w, codeForbidden, "solo un admin puede crear tokens para otro usuario")
+				return
+			}
+			owner = req.User
+		}
+		t, token, err := createToken(owner, req.Name)
+		if err != nil {
+			writeUserError(w, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(map[string]any{"token": token, "info": t, "user": owner})
+	case r.Method == http.MethodDelete && id != "":
+		err := revokeToken(u.Username, id)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			writeError(w, codeNotFound, "token no encontrado")
+			return
+		case err != nil:
+			writeUserError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, codeMethodNotAllowed, "method not allowed")
+	}
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// authTestEnv habilita la autenticación con un usersPath temporal, sin
+// usuarios ni sesiones.
+func authTestEnv(t *testing.T) {
+	t.Helper()
+	prevPath, prevUsers, prevSessions, prevAuth := usersPath, users, sessions, authEnabled
+	usersPath, users, sessions, authEnabled = filepath.Join(t.TempDir(), "users.json"), nil, map[string]session{}, true
+	t.Cleanup(func() { usersPath, users, sessions, authEnabled = prevPath, prevUsers, prevSessions, prevAuth })
+}
+
+// mustUser crea un usuario o termina el test.
+func mustUser(t *testing.T, username, role string) {
+	t.Helper()
+	if _, err := createUser(username, "secreto123", role, ""); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// bearer arma una petición con el token de API.
+func bearer(method, token string) *http.Request {
+	r := httptest.NewRequest(method, "/instances", nil)
+	r.Header.Set("Authorization", "Bearer "+token)
+	return r
+}
+
+func TestSaveUsersReadOnly(t *testing.T) {
+	prevPath, prevUsers := usersPath, users
+	usersPath, users = filepath.Join(t.TempDir(), "users.json"), nil
+	t.Cleanup(func() {
+		usersPath, users = prevPath, prevUsers
+		muProblems.Lock()
+		delete(storageProblems, "hosts.json")
+		muProblems.Unlock()
+	})
+
+	reportProblem(StorageProblem{Path: "hosts.json", Detail: "JSON inválido"}, errors.New("JSON inválido"))
+	if _, err := createUser("ana", "secreto123", roleViewer, ""); !errors.Is(err, errStoreCorrupt) {
+		t.Fatalf("createUser en solo lectura = %v, quiero errStoreCorrupt", err)
+	}
+	if _, err := os.Stat(usersPath); !os.IsNotExist(err) {
+		t.Errorf("se escribió %s en solo lectura", usersPath)
+	}
+}
+
+func TestRequireRole(t *testing.T) {
+	authTestEnv(t)
+	tokens := map[string]string{}
+	for _, role := range roles {
+		mustUser(t, role, role)
+		_, token, err := createToken(role, "test")
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens[role] = token
+	}
+
+	for _, tc := range []struct {
+		name   string
+		rr     methodRoles
+		method string
+		role   string // vacío = sin credenciales
+		want   int
+	}{
+		{"sin credenciales", methodRoles{http.MethodGet: roleViewer}, http.MethodGet, "", http.StatusUnauthorized},
+		{"rol del método", methodRoles{http.MethodGet: roleViewer}, http.MethodGet, roleViewer, http.StatusOK},
+		{"rol insuficiente", methodRoles{http.MethodPost: roleDeployer}, http.MethodPost, roleViewer, http.StatusForbidden},
+		{"rol superior", methodRoles{http.MethodPost: roleDeployer}, http.MethodPost, roleAdmin, http.StatusOK},
+		{"clave vacía para el resto", methodRoles{"": roleDeployer}, http.MethodDelete, roleDeployer, http.StatusOK},
+		{"método sin rol exige admin", methodRoles{http.MethodGet: roleViewer}, http.MethodPost, roleDeployer, http.StatusForbidden},
+		{"método sin rol con admin", methodRoles{http.MethodGet: roleViewer}, http.MethodPost, roleAdmin, http.StatusOK},
+		{"sin roles exige admin", methodRoles{}, http.MethodGet, roleDeployer, http.StatusForbidden},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var got User
+			h := requireRole(tc.rr, func(w http.ResponseWriter, r *http.Request) {
+				got, _ = currentUser(r.Context())
+			})
+			r := httptest.NewRequest(tc.method, "/instances", nil)
+			if tc.role != "" {
+				r = bearer(tc.method, tokens[tc.role])
+			}
+			w := httptest.NewRecorder()
+			h(w, r)
+			if w.Code != tc.want {
+				t.Fatalf("status = %d; quiero %d (%s)", w.Code, tc.want, w.Body)
+			}
+			if tc.want == http.StatusOK && got.Username != tc.role {
+				t.Errorf("currentUser = %q; quiero %q", got.Username, tc.role)
+			}
+		})
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	authTestEnv(t)
+	mustUser(t, "ana", roleDeployer)
+	view, token, err := createToken("ana", " ci ")
+	if err != nil || view.Name != "ci" {
+		t.Fatalf("createToken = %+v, %v", view, err)
+	}
+	if u, err := authenticate(bearer(http.MethodGet, token)); err != nil || u.Username != "ana" {
+		t.Errorf("authenticate con el token = %q, %v; quiero ana", u.Username, err)
+	}
+	for _, h := range []string{"Bearer cnp_otro", "Token " + token, token} {
+		r := httptest.NewRequest(http.MethodGet, "/instances", nil)
+		r.Header.Set("Authorization", h)
+		if _, err := authenticate(r); !errors.Is(err, errUnauthorized) {
+			t.Errorf("authenticate(%q) = %v; quiero errUnauthorized", h, err)
+		}
+	}
+
+	if err := revokeToken("ana", view.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := authenticate(bearer(http.MethodGet, token)); !errors.Is(err, errUnauthorized) {
+		t.Errorf("authenticate con el token revocado = %v; quiero errUnauthorized", err)
+	}
+	if err := revokeToken("ana", view.ID); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("revocar dos veces = %v; quiero os.ErrNotExist", err)
+	}
+	if err := revokeToken("nadie", view.ID); !errors.Is(err, errUserMissing) {
+		t.Errorf("revocar de otro usuario = %v; quiero errUserMissing", err)
+	}
+}
+
+func TestSessions(t *testing.T) {
+	authTestEnv(t)
+	mustUser(t, "ana", roleDeployer)
+	// withSession arma una petición con la cookie de sesión
+	withSession := func(id string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/instances", nil)
+		r.AddCookie(&http.Cookie{Name: sessionCookie, Value: id})
+		return r
+	}
+
+	if _, _, err := login("ana", "otra-clave"); !errors.Is(err, errUnauthorized) {
+		t.Errorf("login con contraseña incorrecta = %v; quiero errUnauthorized", err)
+	}
+	if _, _, err := login("nadie", "secreto123"); !errors.Is(err, errUnauthorized) {
+		t.Errorf("login de usuario inexistente = %v; quiero errUnauthorized", err)
+	}
+	_, id, err := login("ANA", "secreto123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u, err := authenticate(withSession(id)); err != nil || u.Username != "ana" {
+		t.Fatalf("authenticate = %q, %v; quiero ana", u.Username, err)
+	}
+
+	// Vencida: se rechaza y se descarta
+	muUsers.Lock()
+	sessions[id] = session{username: "ana", expires: time.Now().Add(-time.Second)}
+	muUsers.Unlock()
+	if _, err := authenticate(withSession(id)); !errors.Is(err, errUnauthorized) {
+		t.Errorf("authenticate con la sesión vencida = %v; quiero errUnauthorized", err)
+	}
+	if _, ok := sessions[id]; ok {
+		t.Error("la sesión vencida sigue registrada")
+	}
+
+	// Cambiar el rol mantiene la sesión; cambiar la contraseña la cierra
+	_, id, err = login("ana", "secreto123")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, token, err := createToken("ana", "ci")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := updateUser("ana", "", roleViewer, ""); err != nil {
+		t.Fatal(err)
+	}
+	if u, err := authenticate(withSession(id)); err != nil || u.Role != roleViewer {
+		t.Errorf("authenticate tras cambiar el rol = %+v, %v; quiero la sesión con rol viewer", u, err)
+	}
+	if _, err := updateUser("ana", "nueva-clave", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := authenticate(withSession(id)); !errors.Is(err, errUnauthorized) {
+		t.Errorf("authenticate tras cambiar la contraseña = %v; quiero errUnauthorized", err)
+	}
+	if _, err := authenticate(bearer(http.MethodGet, token)); err != nil {
+		t.Errorf("el token dejó de valer tras cambiar la contraseña: %v", err)
+	}
+	if _, _, err := login("ana", "nueva-clave"); err != nil {
+		t.Errorf("login con la contraseña nueva = %v", err)
+	}
+}
+
+func TestLastAdmin(t *testing.T) {
+	authTestEnv(t)
+	mustUser(t, "admin", roleAdmin)
+	mustUser(t, "ana", roleDeployer)
+
+	if _, err := updateUser("admin", "", roleDeployer, ""); !errors.Is(err, errLastAdmin) {
+		t.Errorf("degradar al único admin = %v; quiero errLastAdmin", err)
+	}
+	if err := deleteUser("admin"); !errors.Is(err, errLastAdmin) {
+		t.Errorf("eliminar al único admin = %v; quiero errLastAdmin", err)
+	}
+	if _, err := updateUser("admin", "", roleAdmin, "ops"); err != nil {
+		t.Errorf("cambiar el tenant del único admin = %v", err)
+	}
+
+	// Con otro admin se puede degradar o eliminar a uno de los dos
+	if _, err := updateUser("ana", "", roleAdmin, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := updateUser("admin", "", roleViewer, ""); err != nil {
+		t.Errorf("degradar con otro admin = %v", err)
+	}
+	if err := deleteUser("ana"); !errors.Is(err, errLastAdmin) {
+		t.Errorf("eliminar al nuevo único admin = %v; quiero errLastAdmin", err)
+	}
+	if err := deleteUser("admin"); err != nil {
+		t.Errorf("eliminar un viewer = %v", err)
+	}
+	if err := deleteUser("admin"); !errors.Is(err, errUserMissing) {
+		t.Errorf("eliminar dos veces = %v; quiero errUserMissing", err)
+	}
+}
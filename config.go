@@ -0,0 +1,594 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ============================== Configuration ===============================
+
+// La configuración se arma en capas, de menor a mayor prioridad: los valores
+// por defecto de main.go, el archivo YAML (configPath), las variables de
+// entorno y los flags. Cada valor se identifica por su clave en el archivo
+// (ej: "dns.zone"), que también es el nombre de su flag (-dns.zone).
+
+// configField es un valor configurable.
+type configField struct {
+	Key    string // Clave en el archivo YAML y nombre del flag
+	Env    string // Variable de entorno
+	Value  any    // Puntero a la variable de main.go
+	Secret bool   // No se muestra en GET /config
+	Help   string // Descripción para -help
+}
+
+// Orígenes de un valor de configuración.
+const (
+	sourceDefault = "default"
+	sourceFile    = "file"
+	sourceEnv     = "env"
+	sourceFlag    = "flag"
+)
+
+// configFields todos los valores configurables. Los tipos soportados son
+// string, int, int64, bool, time.Duration, []string (separado por comas en
+// variables de entorno y flags) y map[string]time.Duration ("clave=valor,...").
+var configFields = []configField{
+	{Key: "listen", Env: "LISTEN_ADDR", Value: &listenAddr, Help: "dirección de escucha del servidor HTTP"},
+	{Key: "provisioner", Env: "PROVISIONER", Value: &provisionerName, Help: "backend de aprovisionamiento: batch, vbox o fake"},
+	{Key: "storage", Env: "STORAGE", Value: &storageName, Help: "almacenamiento de instancias y logs DNS: bolt o json"},
+	{Key: "templates_dir", Env: "TEMPLATES_DIR", Value: &templatesDir, Help: "directorio de la interfaz web"},
+	{Key: "scripts_dir", Env: "SCRIPTS_DIR", Value: &scriptsDir, Help: "directorio de los scripts batch"},
+	{Key: "paths.instances", Env: "INSTANCES_PATH", Value: &instancesPath, Help: "instancias del storage json"},
+	{Key: "paths.dns_logs", Env: "DNS_LOGS_PATH", Value: &dnsLogsPath, Help: "historial DNS del storage json"},
+	{Key: "paths.dns_archive", Env: "DNS_ARCHIVE_DIR", Value: &dnsArchiveDir, Help: "segmentos archivados del historial DNS"},
+	{Key: "paths.db", Env: "DB_PATH", Value: &dbPath, Help: "base bbolt"},
+	{Key: "paths.jobs", Env: "JOBS_PATH", Value: &jobsPath, Help: "jobs asíncronos"},
+	{Key: "paths.ipam", Env: "IPAM_PATH", Value: &ipamPath, Help: "pools, reservas y leases de IPs"},
+	{Key: "paths.audit", Env: "AUDIT_PATH", Value: &auditPath, Help: "auditoría (JSON Lines)"},
+	{Key: "paths.audit_archive", Env: "AUDIT_ARCHIVE_DIR", Value: &auditArchiveDir, Help: "segmentos archivados de la auditoría"},
+	{Key: "paths.users", Env: "USERS_PATH", Value: &usersPath, Help: "usuarios y tokens de API"},
+	{Key: "paths.tenants", Env: "TENANTS_PATH", Value: &tenantsPath, Help: "cuotas de los tenants"},
+	{Key: "paths.known_hosts", Env: "KNOWN_HOSTS_PATH", Value: &knownHostsPath, Help: "claves de host SSH registradas (formato OpenSSH)"},
+	{Key: "paths.oplogs", Env: "OPLOGS_DIR", Value: &opLogsDir, Help: "logs de las operaciones"},
+	{Key: "dns.server", Env: "DNS_SERVER", Value: &dnsServerIP, Help: "IP del servidor DNS autoritativo"},
+	{Key: "dns.zone", Env: "DNS_ZONE", Value: &dnsZone, Help: "zona DNS de las instancias"},
+	{Key: "dns.ssh_user", Env: "DNS_SSH_USER", Value: &dnsSSHUser, Help: "usuario SSH del servidor DNS"},
+	{Key: "dns.ssh_fallback", Env: "DNS_SSH_FALLBACK", Value: &dnsSSHFallback, Help: "leer la zona por SSH si falla la transferencia AXFR"},
+	{Key: "dns.tsig_key_path", Env: "TSIG_KEY_PATH", Value: &tsigKeyPath, Help: "clave TSIG en formato BIND"},
+	{Key: "dns.tsig_secret", Env: "TSIG_SECRET", Value: &tsigSecret, Secret: true, Help: "secreto TSIG (base64); reemplaza a tsig_key_path"},
+	{Key: "dns.tsig_key_name", Env: "TSIG_KEY_NAME", Value: &tsigKeyName, Help: "nombre de la clave TSIG de tsig_secret"},
+	{Key: "secrets.path", Env: "SECRETS_PATH", Value: &secretsPath, Help: "archivo cifrado con las claves TSIG y SSH"},
+	{Key: "secrets.passphrase", Env: "SECRETS_PASSPHRASE", Value: &secretsPassphrase, Secret: true, Help: "frase de cifrado del archivo de secretos"},
+	{Key: "secrets.ssh_private_key", Env: "SSH_PRIVATE_KEY", Value: &sshPrivateKey, Secret: true, Help: "clave privada SSH (PEM)"},
+	{Key: "secrets.ssh_key_path", Env: "SSH_KEY_PATH", Value: &sshKeyPath, Help: "archivo con la clave privada SSH, sin cifrar"},
+	{Key: "dns.tsig_algorithm", Env: "TSIG_ALGORITHM", Value: &tsigAlgorithm, Help: "algoritmo de la clave TSIG de tsig_secret"},
+	{Key: "dns.log_rotate_entries", Env: "DNS_LOG_ROTATE_ENTRIES", Value: &dnsLogRotateEntries, Help: "entradas del historial DNS antes de archivarlas"},
+	{Key: "audit.rotate_entries", Env: "AUDIT_ROTATE_ENTRIES", Value: &auditRotateEntries, Help: "eventos de auditoría antes de archivarlos"},
+	{Key: "network.cidr", Env: "HOSTONLY_CIDR", Value: &hostOnlyCIDR, Help: "red host-only de las instancias"},
+	{Key: "network.host_only_adapter", Env: "HOSTONLY_ADAPTER", Value: &hostOnlyAdapter, Help: "adaptador host-only de VirtualBox"},
+	{Key: "network.ip_checks", Env: "IP_CHECKS", Value: &ipChecks, Help: "verificaciones antes de asignar una IP: dns, dhcp, ping o none"},
+	{Key: "vm.ssh_user", Env: "SSH_USER", Value: &vmSSHUser, Help: "usuario SSH dentro de las VMs"},
+	{Key: "vm.ssh_port", Env: "SSH_PORT", Value: &sshPort, Help: "puerto SSH de las VMs y del servidor DNS"},
+	{Key: "vm.template_disk", Env: "TEMPLATE_DISK", Value: &templateDisk, Help: "disco plantilla Apache (multiattach)"},
+	{Key: "vm.infra_vms", Env: "INFRA_VMS", Value: &infraVMs, Help: "VMs de infraestructura (DNS, plantilla) que ignora /drift"},
+	{Key: "vm.controller", Env: "DISK_CONTROLLER", Value: &diskController, Help: "controlador donde se adjunta el disco"},
+	{Key: "vm.memory_mb", Env: "VM_MEMORY_MB", Value: &vmMemoryMB, Help: "memoria de cada VM (MB)"},
+	{Key: "vm.cpus", Env: "VM_CPUS", Value: &vmCPUs, Help: "CPUs de cada VM"},
+	{Key: "vm.boot_wait", Env: "BOOT_WAIT", Value: &bootWait, Help: "espera al arranque de la VM"},
+	{Key: "auth.enabled", Env: "AUTH", Value: &authEnabled, Help: "exigir autenticación en la API"},
+	{Key: "auth.session_ttl", Env: "SESSION_TTL", Value: &sessionTTL, Help: "duración de las sesiones web"},
+	{Key: "auth.admin_password", Env: "ADMIN_PASSWORD", Value: &adminPassword, Secret: true, Help: "contraseña del usuario admin inicial (si no hay usuarios)"},
+	{Key: "quota.max_instances", Env: "QUOTA_MAX_INSTANCES", Value: &defaultQuota.MaxInstances, Help: "instancias por tenant (0 = sin límite)"},
+	{Key: "quota.max_zip_bytes", Env: "QUOTA_MAX_ZIP_BYTES", Value: &defaultQuota.MaxZipBytes, Help: "tamaño máximo del ZIP (0 = sin límite)"},
+	{Key: "quota.subdomains", Env: "QUOTA_SUBDOMAINS", Value: &defaultQuota.Subdomains, Help: "patrones de nombre de host permitidos"},
+	{Key: "step_timeouts", Env: "STEP_TIMEOUTS", Value: &stepTimeouts, Help: "tiempo máximo por paso (ej: ssh=30s,crearVMyDNS=20m)"},
+}
+
+var (
+	configSources  = map[string]string{} // Origen de cada valor cambiado
+	configFlagVals = map[string]string{} // Valores recibidos por flag, aplicados al final
+	configFileUsed string                // Archivo de configuración leído, si lo hubo
+)
+
+// configFlag registra el valor de un flag para aplicarlo después del archivo
+// y de las variables de entorno.
+type configFlag struct{ f *configField }
+
+// String retorna el valor actual (para -help).
+func (c configFlag) String() string {
+	if c.f == nil {
+		return ""
+	}
+	return formatConfigValue(c.f)
+}
+
+// IsBoolFlag permite usar los flags booleanos sin valor (ej: -auth.enabled).
+func (c configFlag) IsBoolFlag() bool {
+	_, ok := c.f.Value.(*bool)
+	return ok
+}
+
+// Set guarda el valor para loadConfig.
+func (c configFlag) Set(v string) error {
+	configFlagVals[c.f.Key] = v
+	return nil
+}
+
+// registerConfigFlags define -config y un flag por cada valor configurable.
+// Debe llamarse antes de flag.Parse.
+func registerConfigFlags() {
+	if v := os.Getenv("CONFIG"); v != "" {
+		configPath = v
+		configSources["config"] = sourceEnv
+	}
+	flag.Func("config", fmt.Sprintf("archivo de configuración YAML (default %q)", configPath), func(v string) error {
+		configPath = v
+		configSources["config"] = sourceFlag
+		return nil
+	})
+	for i := range configFields {
+		f := &configFields[i]
+		help := f.Help
+		if f.Env != "" {
+			help += " (env " + f.Env + ")"
+		}
+		flag.Var(configFlag{f}, f.Key, help)
+	}
+}
+
+// loadConfig aplica el archivo, las variables de entorno y los flags sobre
+// los valores por defecto, y valida el resultado. Debe llamarse después de
+// flag.Parse.
+func loadConfig() error {
+	if err := loadConfigFile(); err != nil {
+		return err
+	}
+	for i := range configFields {
+		f := &configFields[i]
+		v, ok := os.LookupEnv(f.Env)
+		if !ok || f.Env == "" {
+			continue
+		}
+		if err := setConfigValue(f, v); err != nil {
+			return fmt.Errorf("%s: %w", f.Env, err)
+		}
+		configSources[f.Key] = sourceEnv
+	}
+	for i := range configFields {
+		f := &configFields[i]
+		v, ok := configFlagVals[f.Key]
+		if !ok {
+			continue
+		}
+		if err := setConfigValue(f, v); err != nil {
+			return fmt.Errorf("-%s: %w", f.Key, err)
+		}
+		configSources[f.Key] = sourceFlag
+	}
+	// Sin verificaciones configuradas, el provisioner fake no consulta la red
+	if configSources["network.ip_checks"] == "" && provisionerName == "fake" {
+		ipChecks = nil
+	}
+	for i, c := range ipChecks {
+		ipChecks[i] = strings.ToLower(c)
+	}
+	ipChecks = slices.DeleteFunc(ipChecks, func(c string) bool { return c == "none" })
+	return validateConfig()
+}
+
+// makeDataDirs crea los directorios de los archivos de datos (paths.*), que
+// no vienen en el repositorio: se crean en el primer arranque.
+func makeDataDirs() error {
+	for _, f := range configFields {
+		if p, ok := f.Value.(*string); ok && strings.HasPrefix(f.Key, "paths.") {
+			if err := os.MkdirAll(filepath.Dir(*p), 0755); err != nil {
+				return fmt.Errorf("%s: %w", f.Key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// loadConfigFile lee configPath. Si no existe y no se pidió explícitamente
+// se usan los valores por defecto. Las claves desconocidas son un error,
+// para no ignorar en silencio un valor mal escrito.
+func loadConfigFile() error {
+	b, err := os.ReadFile(configPath)
+	if os.IsNotExist(err) && configSources["config"] == "" {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return fmt.Errorf("%s: %w", configPath, err)
+	}
+	if len(doc.Content) == 0 {
+		configFileUsed = configPath
+		return nil
+	}
+	leaves := map[string]*yaml.Node{}
+	if err := flattenConfig(doc.Content[0], "", leaves); err != nil {
+		return fmt.Errorf("%s: %w", configPath, err)
+	}
+	for i := range configFields {
+		f := &configFields[i]
+		n, ok := leaves[f.Key]
+		if !ok {
+			continue
+		}
+		if err := decodeConfigNode(f, n); err != nil {
+			return fmt.Errorf("%s: %s (línea %d): %w", configPath, f.Key, n.Line, err)
+		}
+		configSources[f.Key] = sourceFile
+	}
+	configFileUsed = configPath
+	return nil
+}
+
+// flattenConfig recorre las secciones del YAML y deja en leaves el nodo de
+// cada clave de configFields.
+func flattenConfig(n *yaml.Node, prefix string, leaves map[string]*yaml.Node) error {
+	if n.Kind != yaml.MappingNode {
+		return fmt.Errorf("línea %d: se esperaba una sección con claves", n.Line)
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		key := prefix + n.Content[i].Value
+		val := n.Content[i+1]
+		if configField := findConfigField(key); configField != nil {
+			leaves[key] = val
+			continue
+		}
+		if val.Kind != yaml.MappingNode || !hasConfigSection(key) {
+			return fmt.Errorf("línea %d: clave desconocida %q", n.Content[i].Line, key)
+		}
+		if err := flattenConfig(val, key+".", leaves); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// findConfigField retorna el valor configurable con esa clave, o nil.
+func findConfigField(key string) *configField {
+	for i := range configFields {
+		if configFields[i].Key == key {
+			return &configFields[i]
+		}
+	}
+	return nil
+}
+
+// hasConfigSection indica si alguna clave está dentro de la sección.
+func hasConfigSection(section string) bool {
+	return slices.ContainsFunc(configFields, func(f configField) bool { return strings.HasPrefix(f.Key, section+".") })
+}
+
+// decodeConfigNode decodifica el valor YAML n en la variable de f. Los mapas
+// se suman a los valores por defecto.
+func decodeConfigNode(f *configField, n *yaml.Node) error {
+	if m, ok := f.Value.(*map[string]time.Duration); ok {
+		var add map[string]time.Duration
+		if err := n.Decode(&add); err != nil {
+			return err
+		}
+		for k, d := range add {
+			(*m)[k] = d
+		}
+		return nil
+	}
+	return n.Decode(f.Value)
+}
+
+// setConfigValue interpreta v (variable de entorno o flag) según el tipo de f.
+func setConfigValue(f *configField, v string) error {
+	v = strings.TrimSpace(v)
+	switch p := f.Value.(type) {
+	case *string:
+		*p = v
+	case *int:
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("se esperaba un entero: %q", v)
+		}
+		*p = n
+	case *int64:
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("se esperaba un entero: %q", v)
+		}
+		*p = n
+	case *bool:
+		switch strings.ToLower(v) {
+		case "1", "true", "on", "yes":
+			*p = true
+		case "0", "false", "off", "no":
+			*p = false
+		default:
+			return fmt.Errorf("se esperaba on u off: %q", v)
+		}
+	case *time.Duration:
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("duración inválida %q (ej: 30s, 5m)", v)
+		}
+		*p = d
+	case *[]string:
+		*p = nil
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				*p = append(*p, s)
+			}
+		}
+	case *map[string]time.Duration:
+		for _, kv := range strings.Split(v, ",") {
+			k, dur, ok := strings.Cut(strings.TrimSpace(kv), "=")
+			if !ok {
+				return fmt.Errorf("se esperaba clave=duración en %q", kv)
+			}
+			d, err := time.ParseDuration(strings.TrimSpace(dur))
+			if err != nil {
+				return fmt.Errorf("duración inválida para %s: %q", k, dur)
+			}
+			(*p)[strings.TrimSpace(k)] = d
+		}
+	default:
+		return fmt.Errorf("tipo de configuración no soportado %T", f.Value)
+	}
+	return nil
+}
+
+// formatConfigValue retorna el valor actual de f como texto.
+func formatConfigValue(f *configField) string {
+	switch p := f.Value.(type) {
+	case *[]string:
+		return strings.Join(*p, ",")
+	case *map[string]time.Duration:
+		keys := make([]string, 0, len(*p))
+		for k := range *p {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		for i, k := range keys {
+			keys[i] = k + "=" + (*p)[k].String()
+		}
+		return strings.Join(keys, ",")
+	case *time.Duration:
+		return p.String()
+	case *string:
+		return *p
+	default:
+		return fmt.Sprint(derefConfig(f.Value))
+	}
+}
+
+// derefConfig retorna el valor al que apunta v.
+func derefConfig(v any) any {
+	switch p := v.(type) {
+	case *int:
+		return *p
+	case *int64:
+		return *p
+	case *bool:
+		return *p
+	}
+	return v
+}
+
+// validateConfig verifica los valores efectivos y retorna todos los errores
+// juntos.
+func validateConfig() error {
+	var errs []error
+	bad := func(key, format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%s: "+format, append([]any{key}, args...)...))
+	}
+	if _, _, err := net.SplitHostPort(listenAddr); err != nil {
+		bad("listen", "dirección inválida %q (ej: :8080)", listenAddr)
+	}
+	if !slices.Contains([]string{"batch", "vbox", "fake"}, strings.ToLower(provisionerName)) {
+		bad("provisioner", "desconocido %q (use batch, vbox o fake)", provisionerName)
+	}
+	if !slices.Contains([]string{"bolt", "json"}, strings.ToLower(storageName)) {
+		bad("storage", "desconocido %q (use bolt o json)", storageName)
+	}
+	for _, f := range configFields {
+		if p, ok := f.Value.(*string); ok && strings.HasPrefix(f.Key, "paths.") && *p == "" {
+			bad(f.Key, "no puede ser vacío")
+		}
+	}
+	if ip := net.ParseIP(dnsServerIP); ip == nil || ip.To4() == nil {
+		bad("dns.server", "se esperaba una IPv4: %q", dnsServerIP)
+	}
+	if dnsZone == "" || strings.ContainsAny(dnsZone, " /") {
+		bad("dns.zone", "zona inválida %q", dnsZone)
+	}
+	if dnsLogRotateEntries <= 0 {
+		bad("dns.log_rotate_entries", "debe ser mayor que 0")
+	}
+	if auditRotateEntries <= 0 {
+		bad("audit.rotate_entries", "debe ser mayor que 0")
+	}
+	if _, err := reverseZoneOf(hostOnlyCIDR); err != nil {
+		bad("network.cidr", "%v", err)
+	}
+	for _, c := range ipChecks {
+		if !slices.Contains([]string{"dns", "dhcp", "ping"}, c) {
+			bad("network.ip_checks", "verificación desconocida %q (use dns, dhcp, ping o none)", c)
+		}
+	}
+	if sshPort < 1 || sshPort > 65535 {
+		bad("vm.ssh_port", "puerto inválido %d", sshPort)
+	}
+	if vmMemoryMB < 128 {
+		bad("vm.memory_mb", "se requieren al menos 128 MB")
+	}
+	if vmCPUs < 1 {
+		bad("vm.cpus", "se requiere al menos 1 CPU")
+	}
+	if bootWait < 0 {
+		bad("vm.boot_wait", "no puede ser negativa")
+	}
+	if secretsPath == "" {
+		bad("secrets.path", "no puede ser vacío")
+	}
+	if secretsPassphrase != "" && len(secretsPassphrase) < 12 {
+		bad("secrets.passphrase", "se requieren al menos 12 caracteres")
+	}
+	if sessionTTL <= 0 {
+		bad("auth.session_ttl", "debe ser mayor que 0")
+	}
+	if err := validateQuota(defaultQuota); err != nil {
+		bad("quota", "%v", err)
+	}
+	for step, d := range stepTimeouts {
+		if d <= 0 {
+			bad("step_timeouts", "duración inválida para %s: %s", step, d)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// reverseZoneOf retorna la zona inversa de una red IPv4 cuyo prefijo es
+// múltiplo de 8 (ej: 192.168.56.0/24 -> 56.168.192.in-addr.arpa).
+func reverseZoneOf(cidr string) (string, error) {
+	_, n, err := net.ParseCIDR(cidr)
+	if err != nil || n.IP.To4() == nil {
+		return "", fmt.Errorf("se esperaba una red IPv4: %q", cidr)
+	}
+	bits, _ := n.Mask.Size()
+	if bits == 0 || bits > 24 || bits%8 != 0 {
+		return "", fmt.Errorf("el prefijo de %s debe ser /8, /16 o /24", cidr)
+	}
+	ip := n.IP.To4()
+	labels := []string{"in-addr.arpa"}
+	for i := 0; i < bits/8; i++ {
+		labels = append([]string{strconv.Itoa(int(ip[i]))}, labels...)
+	}
+	return strings.Join(labels, "."), nil
+}
+
+// hostOnlyPrefix retorna la red host-only de hostOnlyCIDR, sin los bits de
+// host (192.168.56.7/24 = 192.168.56.0/24).
+func hostOnlyPrefix() (netip.Prefix, error) {
+	p, err := netip.ParsePrefix(hostOnlyCIDR)
+	if err != nil || !p.Addr().Is4() {
+		return netip.Prefix{}, fmt.Errorf("network.cidr: se esperaba una red IPv4: %q", hostOnlyCIDR)
+	}
+	return p.Masked(), nil
+}
+
+// hostOnlyNetwork retorna la red DHCP de VirtualBox del adaptador host-only.
+func hostOnlyNetwork() string {
+	return "HostInterfaceNetworking-" + hostOnlyAdapter
+}
+
+// ============================== Script Environment ==========================
+
+// scriptEnv retorna la configuración que usan los scripts batch, como
+// variables de entorno. Los scripts conservan sus valores por defecto si
+// alguna no está definida (ej: al ejecutarlos a mano).
+func scriptEnv() ([]string, error) {
+	network, err := hostOnlyPrefix()
+	if err != nil {
+		return nil, err
+	}
+	octets := strings.Split(network.Addr().String(), ".")
+	prefix := strings.Join(octets[:network.Bits()/8], ".") + "."
+	knownHosts, err := filepath.Abs(knownHostsPath)
+	if err != nil {
+		knownHosts = knownHostsPath
+	}
+	return []string{
+		"SSH_USER=" + vmSSHUser,
+		"SSH_PORT=" + strconv.Itoa(sshPort),
+		"APACHE_DISK=" + templateDisk,
+		"CONTROLADOR=" + diskController,
+		"VM_MEMORY=" + strconv.Itoa(vmMemoryMB),
+		"VM_CPUS=" + strconv.Itoa(vmCPUs),
+		"BOOT_WAIT=" + strconv.Itoa(int(bootWait.Seconds())),
+		"HOSTONLY_ADAPTER=" + hostOnlyAdapter,
+		"NETWORK_NAME=" + hostOnlyNetwork(),
+		"IP_PREFIX=" + prefix,
+		"DNS_SERVER=" + dnsServerIP,
+		"DNS_ZONE=" + dnsZone,
+		"KNOWN_HOSTS=" + knownHosts,
+	}, nil
+}
+
+// envKey clave de contexto para las variables de entorno de los comandos.
+type envKey struct{}
+
+// withCmdEnv retorna un contexto cuyos comandos externos (ver runCmd)
+// reciben env además del entorno del servidor.
+func withCmdEnv(ctx context.Context, env []string) context.Context {
+	return context.WithValue(ctx, envKey{}, env)
+}
+
+// cmdEnvFrom retorna las variables de entorno adicionales del contexto.
+func cmdEnvFrom(ctx context.Context) []string {
+	env, _ := ctx.Value(envKey{}).([]string)
+	return env
+}
+
+// ============================== Config Handler ==============================
+
+// configSetting es un elemento de la respuesta de GET /config.
+type configSetting struct {
+	Key    string `json:"key"`
+	Env    string `json:"env,omitempty"`
+	Value  any    `json:"value"`  // Los secretos se muestran como "[oculto]" (o "" si no están)
+	Source string `json:"source"` // default, file, env o flag
+}
+
+// handleConfig maneja GET /config: valores efectivos de la configuración y
+// de dónde salió cada uno. Los secretos nunca se muestran.
+func handleConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	out := struct {
+		File     string          `json:"file,omitempty"`
+		Settings []configSetting `json:"settings"`
+	}{File: configFileUsed}
+	for i := range configFields {
+		f := &configFields[i]
+		s := configSetting{Key: f.Key, Env: f.Env, Source: configSources[f.Key]}
+		if s.Source == "" {
+			s.Source = sourceDefault
+		}
+		switch {
+		case f.Secret && formatConfigValue(f) != "":
+			s.Value = "[oculto]"
+		case f.Secret:
+			s.Value = ""
+		default:
+			switch f.Value.(type) {
+			case *string, *time.Duration, *map[string]time.Duration:
+				s.Value = formatConfigValue(f)
+			case *[]string:
+				s.Value = append([]string{}, *f.Value.(*[]string)...)
+			default:
+				s.Value = derefConfig(f.Value)
+			}
+		}
+		out.Settings = append(out.Settings, s)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
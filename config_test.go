@@ -0,0 +1,194 @@
+package main
+
+import (
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// configTestEnv guarda los valores de configFields y los restaura al
+// terminar, quita las variables de entorno de la configuración y usa un
+// configPath temporal (inexistente hasta que el test lo escriba).
+func configTestEnv(t *testing.T) {
+	t.Helper()
+	saved := make([]reflect.Value, len(configFields))
+	for i, f := range configFields {
+		v := reflect.New(reflect.TypeOf(f.Value).Elem()).Elem()
+		switch p := f.Value.(type) {
+		case *[]string:
+			v.Set(reflect.ValueOf(slices.Clone(*p)))
+		case *map[string]time.Duration:
+			v.Set(reflect.ValueOf(maps.Clone(*p)))
+		default:
+			v.Set(reflect.ValueOf(f.Value).Elem())
+		}
+		saved[i] = v
+		if _, ok := os.LookupEnv(f.Env); ok && f.Env != "" {
+			t.Setenv(f.Env, "")
+			os.Unsetenv(f.Env)
+		}
+	}
+	prevPath, prevSources, prevFlags, prevFile := configPath, configSources, configFlagVals, configFileUsed
+	configPath = filepath.Join(t.TempDir(), "config.yaml")
+	configSources, configFlagVals, configFileUsed = map[string]string{}, map[string]string{}, ""
+	t.Cleanup(func() {
+		for i, f := range configFields {
+			reflect.ValueOf(f.Value).Elem().Set(saved[i])
+		}
+		configPath, configSources, configFlagVals, configFileUsed = prevPath, prevSources, prevFlags, prevFile
+	})
+}
+
+// writeConfig escribe el archivo de configuración del test.
+func writeConfig(t *testing.T, yaml string) {
+	t.Helper()
+	if err := os.WriteFile(configPath, []byte(yaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	configTestEnv(t)
+	defaultPort := sshPort
+	writeConfig(t, `
+listen: ":9000"
+dns:
+  zone: archivo.lab
+vm:
+  cpus: 2
+  memory_mb: 2048
+step_timeouts:
+  ssh: 45s
+`)
+	t.Setenv("DNS_ZONE", "entorno.lab")
+	t.Setenv("VM_CPUS", "3")
+	configFlagVals["dns.zone"] = "flag.lab"
+
+	if err := loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		key    string
+		got    any
+		want   any
+		source string
+	}{
+		{"dns.zone", dnsZone, "flag.lab", sourceFlag},
+		{"vm.cpus", vmCPUs, 3, sourceEnv},
+		{"vm.memory_mb", vmMemoryMB, 2048, sourceFile},
+		{"listen", listenAddr, ":9000", sourceFile},
+		{"vm.ssh_port", sshPort, defaultPort, ""},
+	} {
+		if tc.got != tc.want || configSources[tc.key] != tc.source {
+			t.Errorf("%s = %v (origen %q); quiero %v (%q)", tc.key, tc.got, configSources[tc.key], tc.want, tc.source)
+		}
+	}
+	// Los mapas del archivo se suman a los valores por defecto
+	if stepTimeouts["ssh"] != 45*time.Second || len(stepTimeouts) < 2 {
+		t.Errorf("step_timeouts = %v; quiero ssh=45s más los valores por defecto", stepTimeouts)
+	}
+	if configFileUsed != configPath {
+		t.Errorf("configFileUsed = %q; quiero %q", configFileUsed, configPath)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		yaml  string // vacío = sin archivo
+		env   map[string]string
+		flags map[string]string
+		want  []string // Fragmentos del error
+	}{
+		{name: "clave desconocida", yaml: "dns:\n  zona: grid.lab\n", want: []string{`clave desconocida "dns.zona"`}},
+		{name: "tipo inválido en el archivo", yaml: "vm:\n  cpus: dos\n", want: []string{"vm.cpus", "línea 2"}},
+		{name: "entero inválido en el entorno", env: map[string]string{"VM_CPUS": "dos"}, want: []string{"VM_CPUS", "se esperaba un entero"}},
+		{name: "duración inválida por flag", flags: map[string]string{"vm.boot_wait": "mucho"}, want: []string{"-vm.boot_wait", "duración inválida"}},
+		{
+			name: "validación junta todos los errores",
+			env:  map[string]string{"DNS_SERVER": "dns.grid.lab", "SSH_PORT": "70000", "HOSTONLY_CIDR": "192.168.56.0/25"},
+			want: []string{"dns.server", "vm.ssh_port", "network.cidr"},
+		},
+		{name: "verificación de IP desconocida", env: map[string]string{"IP_CHECKS": "dns,arp"}, want: []string{`verificación desconocida "arp"`}},
+		{name: "ruta vacía", env: map[string]string{"JOBS_PATH": " "}, want: []string{"paths.jobs: no puede ser vacío"}},
+		{name: "frase de secretos corta", env: map[string]string{"SECRETS_PASSPHRASE": "corta"}, want: []string{"secrets.passphrase"}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			configTestEnv(t)
+			if tc.yaml != "" {
+				writeConfig(t, tc.yaml)
+			}
+			for k, v := range tc.env {
+				t.Setenv(k, v)
+			}
+			maps.Copy(configFlagVals, tc.flags)
+			err := loadConfig()
+			if err == nil {
+				t.Fatal("loadConfig no falló")
+			}
+			for _, w := range tc.want {
+				if !strings.Contains(err.Error(), w) {
+					t.Errorf("error %q no menciona %q", err, w)
+				}
+			}
+		})
+	}
+
+	t.Run("archivo pedido inexistente", func(t *testing.T) {
+		configTestEnv(t)
+		configSources["config"] = sourceFlag
+		if err := loadConfig(); !os.IsNotExist(err) {
+			t.Errorf("loadConfig = %v; quiero el error de archivo inexistente", err)
+		}
+	})
+	t.Run("archivo por defecto inexistente", func(t *testing.T) {
+		configTestEnv(t)
+		if err := loadConfig(); err != nil || configFileUsed != "" {
+			t.Errorf("loadConfig = %v, archivo %q; quiero los valores por defecto", err, configFileUsed)
+		}
+	})
+}
+
+func TestHandleConfigHidesSecrets(t *testing.T) {
+	configTestEnv(t)
+	writeConfig(t, "secrets:\n  passphrase: frase-del-archivo-larga\n")
+	t.Setenv("ADMIN_PASSWORD", "secreto-admin-123")
+	t.Setenv("TSIG_SECRET", "c2VjcmV0by10c2ln")
+	if err := loadConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if adminPassword != "secreto-admin-123" {
+		t.Errorf("adminPassword = %q; quiero el valor de ADMIN_PASSWORD", adminPassword)
+	}
+
+	w := httptest.NewRecorder()
+	handleConfig(w, httptest.NewRequest(http.MethodGet, "/config", nil))
+	body := w.Body.String()
+	for _, secret := range []string{"secreto-admin-123", "c2VjcmV0by10c2ln", "frase-del-archivo-larga"} {
+		if strings.Contains(body, secret) {
+			t.Errorf("GET /config muestra el secreto %q", secret)
+		}
+	}
+	for key, want := range map[string]string{
+		"auth.admin_password":     `{"key":"auth.admin_password","env":"ADMIN_PASSWORD","value":"[oculto]","source":"env"}`,
+		"dns.tsig_secret":         `{"key":"dns.tsig_secret","env":"TSIG_SECRET","value":"[oculto]","source":"env"}`,
+		"secrets.passphrase":      `{"key":"secrets.passphrase","env":"SECRETS_PASSPHRASE","value":"[oculto]","source":"file"}`,
+		"secrets.ssh_private_key": `{"key":"secrets.ssh_private_key","env":"SSH_PRIVATE_KEY","value":"","source":"default"}`,
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("GET /config no muestra %s como %s", key, want)
+		}
+	}
+	for _, f := range configFields {
+		if strings.Contains(strings.ToLower(f.Env), "password") && !f.Secret {
+			t.Errorf("%s no está marcado como secreto", f.Key)
+		}
+	}
+}
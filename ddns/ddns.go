@@ -0,0 +1,193 @@
+// Package ddns envía actualizaciones dinámicas de DNS (RFC 2136) firmadas
+// con TSIG (RFC 8945) directamente al servidor autoritativo, sin nsupdate ni SSH.
+package ddns
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// DefaultTimeout tiempo máximo de cada intercambio con el servidor DNS.
+const DefaultTimeout = 10 * time.Second
+
+// Key es una clave TSIG compartida con el servidor DNS.
+type Key struct {
+	Name      string // Nombre de la clave (ej: ddns-key)
+	Algorithm string // Algoritmo (ej: hmac-sha256)
+	Secret    string // Secreto en base64
+}
+
+// String describe la clave sin el secreto, para que nunca llegue a un log.
+func (k Key) String() string {
+	return fmt.Sprintf("%s (%s)", k.Name, k.Algorithm)
+}
+
+// GoString igual que String, también para %#v.
+func (k Key) GoString() string { return k.String() }
+
+// Valid indica si la clave tiene nombre, algoritmo soportado y secreto.
+func (k Key) Valid() error {
+	if k.Name == "" || k.Secret == "" {
+		return errors.New("clave TSIG incompleta: se requieren nombre y secreto")
+	}
+	if algorithmName(k.Algorithm) == "" {
+		return fmt.Errorf("algoritmo TSIG no soportado: %s", k.Algorithm)
+	}
+	return nil
+}
+
+// algorithmName traduce el nombre corto del algoritmo al nombre de dominio
+// usado en el registro TSIG. Retorna "" si no está soportado.
+func algorithmName(alg string) string {
+	switch strings.TrimSuffix(strings.ToLower(alg), ".") {
+	case "", "hmac-sha256":
+		return dns.HmacSHA256
+	case "hmac-sha1":
+		return dns.HmacSHA1
+	case "hmac-sha224":
+		return dns.HmacSHA224
+	case "hmac-sha384":
+		return dns.HmacSHA384
+	case "hmac-sha512":
+		return dns.HmacSHA512
+	}
+	return ""
+}
+
+// RcodeError indica que el servidor rechazó la actualización.
+type RcodeError struct {
+	Zone  string // Zona actualizada
+	Rcode int    // Código de respuesta DNS (ej: dns.RcodeRefused)
+}
+
+// Error implementa la interfaz error.
+func (e *RcodeError) Error() string {
+	return fmt.Sprintf("actualización de %s rechazada: %s", e.Zone, dns.RcodeToString[e.Rcode])
+}
+
+// Client envía mensajes UPDATE a un servidor DNS.
+type Client struct {
+	Server  string        // host:puerto del servidor (puerto 53 si se omite)
+	Key     *Key          // Clave TSIG; nil envía sin firmar
+	Net     string        // "udp" (con reintento TCP si se trunca) o "tcp"
+	Timeout time.Duration // Tiempo máximo por intercambio (DefaultTimeout si 0)
+}
+
+// Change es un conjunto de operaciones sobre una zona.
+type Change struct {
+	Zone    string   // Zona a actualizar (ej: grid.lab)
+	Removes []dns.RR // RRsets a eliminar (sólo importan nombre y tipo)
+	Inserts []dns.RR // Registros a agregar
+}
+
+// Apply envía el cambio como un único mensaje UPDATE y valida la respuesta.
+func (c *Client) Apply(ch Change) error {
+	m := new(dns.Msg)
+	m.SetUpdate(dns.Fqdn(ch.Zone))
+	if len(ch.Removes) > 0 {
+		m.RemoveRRset(ch.Removes)
+	}
+	if len(ch.Inserts) > 0 {
+		m.Insert(ch.Inserts)
+	}
+	in, err := c.exchange(m)
+	if err != nil {
+		return err
+	}
+	if in.Rcode != dns.RcodeSuccess {
+		return &RcodeError{Zone: ch.Zone, Rcode: in.Rcode}
+	}
+	return nil
+}
+
+// exchange firma (si hay clave) y envía el mensaje, reintentando por TCP si la
+// respuesta UDP llega truncada.
+func (c *Client) exchange(m *dns.Msg) (*dns.Msg, error) {
+	server := c.Server
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	cl := &dns.Client{Net: c.Net, Timeout: timeout}
+	if c.Key != nil {
+		if err := c.Key.Valid(); err != nil {
+			return nil, err
+		}
+		name := dns.Fqdn(c.Key.Name)
+		cl.TsigSecret = map[string]string{name: c.Key.Secret}
+		m.SetTsig(name, algorithmName(c.Key.Algorithm), 300, time.Now().Unix())
+	}
+	in, _, err := cl.Exchange(m, server)
+	if err == nil && in.Truncated && cl.Net != "tcp" {
+		cl.Net = "tcp"
+		in, _, err = cl.Exchange(m, server)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("intercambio con %s: %w", server, err)
+	}
+	return in, nil
+}
+
+// ReverseName retorna el nombre PTR absoluto de una IPv4
+// (ej: 13.56.168.192.in-addr.arpa.).
+func ReverseName(ip string) (string, error) {
+	if net.ParseIP(ip).To4() == nil {
+		return "", fmt.Errorf("IP inválida: %s", ip)
+	}
+	return dns.ReverseAddr(ip)
+}
+
+// ReplaceA reemplaza el RRset A de fqdn en zone por ip.
+func (c *Client) ReplaceA(zone, fqdn, ip string, ttl uint32) error {
+	v4 := net.ParseIP(ip).To4()
+	if v4 == nil {
+		return fmt.Errorf("IP inválida: %s", ip)
+	}
+	name := dns.Fqdn(fqdn)
+	return c.Apply(Change{
+		Zone:    zone,
+		Removes: []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET}}},
+		Inserts: []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypeA, Class: dns.ClassINET, Ttl: ttl}, A: v4}},
+	})
+}
+
+// DeleteA elimina el RRset A de fqdn en zone.
+func (c *Client) DeleteA(zone, fqdn string) error {
+	return c.Apply(Change{
+		Zone:    zone,
+		Removes: []dns.RR{&dns.A{Hdr: dns.RR_Header{Name: dns.Fqdn(fqdn), Rrtype: dns.TypeA, Class: dns.ClassINET}}},
+	})
+}
+
+// ReplacePTR reemplaza el RRset PTR de ip en revZone para que apunte a fqdn.
+func (c *Client) ReplacePTR(revZone, ip, fqdn string, ttl uint32) error {
+	name, err := ReverseName(ip)
+	if err != nil {
+		return err
+	}
+	return c.Apply(Change{
+		Zone:    revZone,
+		Removes: []dns.RR{&dns.PTR{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypePTR, Class: dns.ClassINET}}},
+		Inserts: []dns.RR{&dns.PTR{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypePTR, Class: dns.ClassINET, Ttl: ttl}, Ptr: dns.Fqdn(fqdn)}},
+	})
+}
+
+// DeletePTR elimina el RRset PTR de ip en revZone.
+func (c *Client) DeletePTR(revZone, ip string) error {
+	name, err := ReverseName(ip)
+	if err != nil {
+		return err
+	}
+	return c.Apply(Change{
+		Zone:    revZone,
+		Removes: []dns.RR{&dns.PTR{Hdr: dns.RR_Header{Name: name, Rrtype: dns.TypePTR, Class: dns.ClassINET}}},
+	})
+}
@@ -0,0 +1,194 @@
+package ddns
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+const revZone = "56.168.192.in-addr.arpa"
+
+// zonasPrueba son las zonas iniciales de los tests de UPDATE.
+func zonasPrueba() map[string][]string {
+	return map[string][]string{
+		"grid.lab": {
+			"grid.lab. 300 IN NS ns.grid.lab.",
+			"web1.grid.lab. 300 IN A 192.168.56.10",
+			"web1.grid.lab. 300 IN A 192.168.56.11",
+			"web2.grid.lab. 300 IN A 192.168.56.12",
+		},
+		revZone: {
+			"10.56.168.192.in-addr.arpa. 300 IN PTR web1.grid.lab.",
+			"12.56.168.192.in-addr.arpa. 300 IN PTR web2.grid.lab.",
+		},
+	}
+}
+
+func TestReplaceA(t *testing.T) {
+	for _, network := range []string{"udp", "tcp"} {
+		t.Run(network, func(t *testing.T) {
+			s := newTestServer(t, zonasPrueba())
+			c := s.client()
+			c.Net = network
+			if err := c.ReplaceA("grid.lab", "WEB1.grid.lab", "192.168.56.21", 120); err != nil {
+				t.Fatal(err)
+			}
+			if err := c.ReplaceA("grid.lab", "web3.grid.lab.", "192.168.56.23", 300); err != nil {
+				t.Fatal(err)
+			}
+			want := []string{
+				"WEB1.grid.lab.\t120\tIN\tA\t192.168.56.21",
+				"grid.lab.\t300\tIN\tNS\tns.grid.lab.",
+				"web2.grid.lab.\t300\tIN\tA\t192.168.56.12",
+				"web3.grid.lab.\t300\tIN\tA\t192.168.56.23",
+			}
+			if got := s.records("grid.lab"); !slices.Equal(got, want) {
+				t.Errorf("zona =\n%s\nquiero\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+			}
+		})
+	}
+}
+
+func TestDeleteA(t *testing.T) {
+	s := newTestServer(t, zonasPrueba())
+	if err := s.client().DeleteA("grid.lab", "web1.grid.lab"); err != nil {
+		t.Fatal(err)
+	}
+	// Borrar un host que no existe no es un error (RFC 2136, 3.4.2.3)
+	if err := s.client().DeleteA("grid.lab", "nadie.grid.lab"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"grid.lab.\t300\tIN\tNS\tns.grid.lab.", "web2.grid.lab.\t300\tIN\tA\t192.168.56.12"}
+	if got := s.records("grid.lab"); !slices.Equal(got, want) {
+		t.Errorf("zona = %q, quiero %q", got, want)
+	}
+}
+
+func TestReplacePTR(t *testing.T) {
+	s := newTestServer(t, zonasPrueba())
+	c := s.client()
+	if err := c.ReplacePTR(revZone, "192.168.56.10", "nuevo.grid.lab", 300); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.ReplacePTR(revZone, "192.168.56.30", "web30.grid.lab.", 60); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"10.56.168.192.in-addr.arpa.\t300\tIN\tPTR\tnuevo.grid.lab.",
+		"12.56.168.192.in-addr.arpa.\t300\tIN\tPTR\tweb2.grid.lab.",
+		"30.56.168.192.in-addr.arpa.\t60\tIN\tPTR\tweb30.grid.lab.",
+	}
+	if got := s.records(revZone); !slices.Equal(got, want) {
+		t.Errorf("zona inversa =\n%s\nquiero\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+	if err := c.ReplacePTR(revZone, "192.168.56", "x.grid.lab", 60); err == nil {
+		t.Error("ReplacePTR aceptó una IP inválida")
+	}
+}
+
+func TestDeletePTR(t *testing.T) {
+	s := newTestServer(t, zonasPrueba())
+	if err := s.client().DeletePTR(revZone, "192.168.56.12"); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"10.56.168.192.in-addr.arpa.\t300\tIN\tPTR\tweb1.grid.lab."}
+	if got := s.records(revZone); !slices.Equal(got, want) {
+		t.Errorf("zona inversa = %q, quiero %q", got, want)
+	}
+}
+
+func TestApplyRejected(t *testing.T) {
+	tests := []struct {
+		name  string
+		key   *Key
+		rcode int // Código del servidor para los UPDATE (0 = aceptarlos)
+		want  int // Código esperado en el RcodeError (0 = cualquier error)
+	}{
+		{name: "sin firma", key: nil, want: dns.RcodeNotAuth},
+		{name: "secreto incorrecto", key: &Key{Name: testKey.Name, Algorithm: testKey.Algorithm, Secret: "b3Ryb3NlY3JldG9vdHJvc2VjcmV0bw=="}},
+		{name: "otra clave", key: &Key{Name: "otra-key", Algorithm: testKey.Algorithm, Secret: testKey.Secret}},
+		{name: "rechazado", key: &testKey, rcode: dns.RcodeRefused, want: dns.RcodeRefused},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, zonasPrueba())
+			s.setRcode(tt.rcode)
+			c := s.client()
+			c.Key = tt.key
+			err := c.ReplaceA("grid.lab", "web2.grid.lab", "192.168.56.99", 300)
+			if err == nil {
+				t.Fatal("ReplaceA no falló")
+			}
+			var re *RcodeError
+			if tt.want != 0 && (!errors.As(err, &re) || re.Rcode != tt.want) {
+				t.Errorf("ReplaceA = %v, quiero rcode %s", err, dns.RcodeToString[tt.want])
+			}
+			if n := s.applied(); n != 0 {
+				t.Errorf("el servidor aplicó %d UPDATE", n)
+			}
+		})
+	}
+}
+
+func TestApplyInvalidKey(t *testing.T) {
+	c := &Client{Server: "127.0.0.1:1", Key: &Key{Name: "k", Algorithm: "hmac-md5", Secret: "x"}}
+	err := c.DeleteA("grid.lab", "web1.grid.lab")
+	if err == nil || !strings.Contains(err.Error(), "no soportado") {
+		t.Errorf("DeleteA = %v, quiero algoritmo no soportado", err)
+	}
+}
+
+func TestKeyNeverPrintsSecret(t *testing.T) {
+	for _, s := range []string{testKey.String(), fmt.Sprintf("%v %+v %#v", testKey, testKey, testKey), fmt.Sprint(&testKey)} {
+		if strings.Contains(s, testKey.Secret) {
+			t.Errorf("%q contiene el secreto", s)
+		}
+	}
+}
+
+func TestParseKey(t *testing.T) {
+	tests := []struct {
+		name, text string
+		want       Key
+		wantErr    bool
+	}{
+		{name: "tsig-keygen", text: "key \"ddns-key\" {\n\talgorithm hmac-sha256;\n\tsecret \"ab/cd+ef==\";\n};\n",
+			want: Key{Name: "ddns-key", Algorithm: "hmac-sha256", Secret: "ab/cd+ef=="}},
+		{name: "comentarios y sin comillas", text: "# generado\n// otro\nkey ddns-key {\n algorithm \"hmac-sha512\"; secret \"eHh4\"; };",
+			want: Key{Name: "ddns-key", Algorithm: "hmac-sha512", Secret: "eHh4"}},
+		{name: "sin secreto", text: `key "k" { algorithm hmac-sha256; };`, wantErr: true},
+		{name: "algoritmo no soportado", text: `key "k" { algorithm hmac-md5; secret "eHh4"; };`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseKey(tt.text)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("ParseKey = %+v, %v; quiero %+v (error %v)", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+	path := filepath.Join(t.TempDir(), "ddns.key")
+	if err := os.WriteFile(path, []byte(tests[0].text), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if k, err := LoadKeyFile(path); err != nil || k != tests[0].want {
+		t.Errorf("LoadKeyFile = %+v, %v", k, err)
+	}
+}
+
+func TestReverseName(t *testing.T) {
+	if got, err := ReverseName("192.168.56.13"); err != nil || got != "13.56.168.192.in-addr.arpa." {
+		t.Errorf("ReverseName = %q, %v", got, err)
+	}
+	for _, ip := range []string{"", "192.168.56", "::1"} {
+		if _, err := ReverseName(ip); err == nil {
+			t.Errorf("ReverseName(%q) no falló", ip)
+		}
+	}
+}
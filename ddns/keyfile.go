@@ -0,0 +1,59 @@
+package ddns
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// Expresiones para leer un bloque key de BIND:
+//
+//	key "ddns-key" { algorithm hmac-sha256; secret "base64=="; };
+var (
+	keyNameRe   = regexp.MustCompile(`(?s)key\s+"?([^"\s{]+)"?\s*\{`)
+	keyAlgRe    = regexp.MustCompile(`algorithm\s+"?([A-Za-z0-9.-]+)"?\s*;`)
+	keySecretRe = regexp.MustCompile(`secret\s+"([^"]+)"\s*;`)
+)
+
+// ParseKey lee una clave TSIG en el formato de BIND (tsig-keygen / named.conf).
+func ParseKey(text string) (Key, error) {
+	var k Key
+	// Eliminar líneas de comentario (# y //); el secreto en base64 puede
+	// contener "/", por eso no se cortan comentarios a mitad de línea.
+	var b strings.Builder
+	for _, ln := range strings.Split(text, "\n") {
+		t := strings.TrimSpace(ln)
+		if strings.HasPrefix(t, "#") || strings.HasPrefix(t, "//") {
+			continue
+		}
+		b.WriteString(ln + "\n")
+	}
+	text = b.String()
+	if m := keyNameRe.FindStringSubmatch(text); m != nil {
+		k.Name = m[1]
+	}
+	if m := keyAlgRe.FindStringSubmatch(text); m != nil {
+		k.Algorithm = m[1]
+	}
+	if m := keySecretRe.FindStringSubmatch(text); m != nil {
+		k.Secret = m[1]
+	}
+	if err := k.Valid(); err != nil {
+		return Key{}, err
+	}
+	return k, nil
+}
+
+// LoadKeyFile lee una clave TSIG desde un archivo en formato BIND.
+func LoadKeyFile(path string) (Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Key{}, err
+	}
+	k, err := ParseKey(string(data))
+	if err != nil {
+		return Key{}, fmt.Errorf("%s: %w", path, err)
+	}
+	return k, nil
+}
@@ -0,0 +1,264 @@
+package ddns
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// testKey es la clave TSIG que exige testServer.
+var testKey = Key{Name: "ddns-key", Algorithm: "hmac-sha256", Secret: "c2VjcmV0c2VjcmV0c2VjcmV0c2VjcmV0"}
+
+// testServer es un servidor autoritativo en memoria para las pruebas: aplica
+// UPDATE (RFC 2136) y responde AXFR e IXFR, todo firmado con testKey. Escucha
+// por UDP y TCP en el mismo puerto de 127.0.0.1.
+type testServer struct {
+	Addr string // host:puerto
+
+	mu      sync.Mutex
+	zones   map[string]*testZone // Por nombre absoluto de la zona
+	rcode   int                  // Si no es 0, responde los UPDATE con este código
+	updates int                  // UPDATE aceptados
+}
+
+// testZone es el contenido de una zona de testServer.
+type testZone struct {
+	serial  uint32
+	rrs     []dns.RR            // Registros sin el SOA
+	history map[uint32][]dns.RR // Contenido de cada serial anterior, para IXFR
+}
+
+// newTestServer arranca el servidor con las zonas indicadas (serial 1).
+// zones asocia cada zona a sus registros en formato de archivo de zona.
+func newTestServer(t *testing.T, zones map[string][]string) *testServer {
+	t.Helper()
+	s := &testServer{zones: map[string]*testZone{}}
+	for name, lines := range zones {
+		z := &testZone{serial: 1, history: map[uint32][]dns.RR{}}
+		for _, ln := range lines {
+			z.rrs = append(z.rrs, mustRR(t, ln))
+		}
+		s.zones[dns.Fqdn(name)] = z
+	}
+	secret := map[string]string{dns.Fqdn(testKey.Name): testKey.Secret}
+	accept := func(dns.Header) dns.MsgAcceptAction { return dns.MsgAccept }
+	var (
+		pc net.PacketConn
+		ln net.Listener
+	)
+	for i := 0; i < 10 && ln == nil; i++ {
+		var err error
+		if pc, err = net.ListenPacket("udp", "127.0.0.1:0"); err != nil {
+			t.Fatal(err)
+		}
+		if ln, err = net.Listen("tcp", pc.LocalAddr().String()); err != nil {
+			pc.Close()
+		}
+	}
+	if ln == nil {
+		t.Fatal("no se pudo escuchar en el mismo puerto UDP y TCP")
+	}
+	s.Addr = pc.LocalAddr().String()
+	for _, srv := range []*dns.Server{
+		{PacketConn: pc, TsigSecret: secret, MsgAcceptFunc: accept, Handler: dns.HandlerFunc(s.serve)},
+		{Listener: ln, TsigSecret: secret, MsgAcceptFunc: accept, Handler: dns.HandlerFunc(s.serve)},
+	} {
+		started := make(chan struct{})
+		srv.NotifyStartedFunc = func() { close(started) }
+		go srv.ActivateAndServe()
+		<-started
+		t.Cleanup(func() { srv.Shutdown() })
+	}
+	return s
+}
+
+// mustRR interpreta un registro en formato de archivo de zona.
+func mustRR(t *testing.T, s string) dns.RR {
+	t.Helper()
+	rr, err := dns.NewRR(s)
+	if err != nil {
+		t.Fatalf("registro %q: %v", s, err)
+	}
+	return rr
+}
+
+// client retorna un Client contra el servidor firmado con testKey.
+func (s *testServer) client() *Client {
+	k := testKey
+	return &Client{Server: s.Addr, Key: &k, Timeout: 2 * time.Second}
+}
+
+// records retorna los registros de la zona (sin el SOA) en formato de texto,
+// ordenados.
+func (s *testServer) records(zone string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var out []string
+	for _, rr := range s.zones[dns.Fqdn(zone)].rrs {
+		out = append(out, rr.String())
+	}
+	sort.Strings(out)
+	return out
+}
+
+// edit aplica fn al contenido de la zona como una versión nueva (serial + 1),
+// igual que un UPDATE.
+func (s *testServer) edit(zone string, fn func([]dns.RR) []dns.RR) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	z := s.zones[dns.Fqdn(zone)]
+	z.history[z.serial] = append([]dns.RR(nil), z.rrs...)
+	z.rrs = fn(z.rrs)
+	z.serial++
+}
+
+// setRcode hace que el servidor responda los UPDATE con rcode (0 = aplicarlos).
+func (s *testServer) setRcode(rcode int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rcode = rcode
+}
+
+// applied retorna la cantidad de UPDATE aplicados.
+func (s *testServer) applied() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.updates
+}
+
+// soa retorna el SOA de la zona con el serial indicado.
+func soa(zone string, serial uint32) dns.RR {
+	zone = dns.Fqdn(zone)
+	rr, _ := dns.NewRR(fmt.Sprintf("%s 300 IN SOA ns.%s root.%s %d 3600 600 86400 300", zone, zone, zone, serial))
+	return rr
+}
+
+// serve atiende un mensaje: rechaza los que no vienen firmados con testKey.
+func (s *testServer) serve(w dns.ResponseWriter, r *dns.Msg) {
+	m := new(dns.Msg)
+	tsig := r.IsTsig()
+	if tsig == nil || w.TsigStatus() != nil || len(r.Question) != 1 {
+		m.SetRcode(r, dns.RcodeNotAuth)
+		w.WriteMsg(m)
+		return
+	}
+	sign := func(m *dns.Msg) { m.SetTsig(tsig.Hdr.Name, tsig.Algorithm, 300, time.Now().Unix()) }
+	q := r.Question[0]
+	s.mu.Lock()
+	z, ok := s.zones[strings.ToLower(q.Name)]
+	s.mu.Unlock()
+	switch {
+	case !ok:
+		m.SetRcode(r, dns.RcodeNotAuth)
+	case r.Opcode == dns.OpcodeUpdate:
+		m.SetRcode(r, s.update(q.Name, z, r.Ns))
+	case q.Qtype == dns.TypeAXFR || q.Qtype == dns.TypeIXFR:
+		s.transfer(w, r, q.Name, z)
+		return
+	default:
+		m.SetRcode(r, dns.RcodeNotImplemented)
+	}
+	sign(m)
+	w.WriteMsg(m)
+}
+
+// update aplica la sección de actualización de un UPDATE sobre z.
+func (s *testServer) update(zone string, z *testZone, ns []dns.RR) int {
+	s.mu.Lock()
+	rcode := s.rcode
+	s.mu.Unlock()
+	if rcode != 0 {
+		return rcode
+	}
+	s.edit(zone, func(rrs []dns.RR) []dns.RR {
+		for _, u := range ns {
+			h := u.Header()
+			switch h.Class {
+			case dns.ClassANY: // Eliminar el RRset (o todos si el tipo es ANY)
+				rrs = deleteRRs(rrs, func(rr dns.RR) bool {
+					return strings.EqualFold(rr.Header().Name, h.Name) && (h.Rrtype == dns.TypeANY || rr.Header().Rrtype == h.Rrtype)
+				})
+			case dns.ClassNONE: // Eliminar un registro
+				c := dns.Copy(u)
+				c.Header().Class = dns.ClassINET
+				rrs = deleteRRs(rrs, func(rr dns.RR) bool { return dns.IsDuplicate(rr, c) })
+			default:
+				rrs = deleteRRs(rrs, func(rr dns.RR) bool { return dns.IsDuplicate(rr, u) })
+				rrs = append(rrs, u)
+			}
+		}
+		return rrs
+	})
+	s.mu.Lock()
+	s.updates++
+	s.mu.Unlock()
+	return dns.RcodeSuccess
+}
+
+// deleteRRs retorna rrs sin los registros que cumplen del.
+func deleteRRs(rrs []dns.RR, del func(dns.RR) bool) []dns.RR {
+	out := rrs[:0:0]
+	for _, rr := range rrs {
+		if !del(rr) {
+			out = append(out, rr)
+		}
+	}
+	return out
+}
+
+// transfer responde un AXFR o, si se conoce el serial del cliente, un IXFR
+// con la diferencia entre esa versión y la actual.
+func (s *testServer) transfer(w dns.ResponseWriter, r *dns.Msg, zone string, z *testZone) {
+	s.mu.Lock()
+	cur := soa(zone, z.serial)
+	rrs := []dns.RR{cur}
+	var from uint32
+	if r.Question[0].Qtype == dns.TypeIXFR && len(r.Ns) == 1 {
+		if prev, ok := r.Ns[0].(*dns.SOA); ok {
+			from = prev.Serial
+		}
+	}
+	old, ok := z.history[from]
+	switch {
+	case from == z.serial:
+		// Sin cambios: solo el SOA actual
+	case ok:
+		in := func(rr dns.RR, list []dns.RR) bool {
+			for _, x := range list {
+				if x.String() == rr.String() {
+					return true
+				}
+			}
+			return false
+		}
+		rrs = append(rrs, soa(zone, from))
+		for _, rr := range old {
+			if !in(rr, z.rrs) {
+				rrs = append(rrs, rr)
+			}
+		}
+		rrs = append(rrs, cur)
+		for _, rr := range z.rrs {
+			if !in(rr, old) {
+				rrs = append(rrs, rr)
+			}
+		}
+		rrs = append(rrs, cur)
+	default:
+		rrs = append(append(rrs, z.rrs...), cur)
+	}
+	s.mu.Unlock()
+
+	tr := &dns.Transfer{TsigSecret: map[string]string{dns.Fqdn(testKey.Name): testKey.Secret}}
+	ch := make(chan *dns.Envelope, 1)
+	ch <- &dns.Envelope{RR: rrs}
+	close(ch)
+	tr.Out(w, r, ch)
+	w.Close()
+}
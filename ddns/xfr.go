@@ -0,0 +1,196 @@
+package ddns
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// Zone es el contenido de una zona obtenido por transferencia.
+type Zone struct {
+	Name    string   // Zona (ej: grid.lab.)
+	Serial  uint32   // Serial del SOA
+	Records []dns.RR // Registros de la zona, con el SOA primero
+}
+
+// SOA retorna el registro SOA de la zona.
+func (z *Zone) SOA() *dns.SOA {
+	if len(z.Records) == 0 {
+		return nil
+	}
+	soa, _ := z.Records[0].(*dns.SOA)
+	return soa
+}
+
+// TransferError indica que el servidor rechazó o cortó la transferencia.
+type TransferError struct {
+	Zone string // Zona transferida
+	Type string // AXFR o IXFR
+	Err  error
+}
+
+// Error implementa la interfaz error.
+func (e *TransferError) Error() string {
+	return fmt.Sprintf("%s de %s: %v", e.Type, e.Zone, e.Err)
+}
+
+// Unwrap retorna la causa.
+func (e *TransferError) Unwrap() error { return e.Err }
+
+// Transfer obtiene la zona completa del servidor. Si prev no es nil pide solo
+// los cambios desde prev.Serial (IXFR, RFC 1995) y los aplica sobre una copia
+// de prev; el servidor puede responder con la zona completa, que también se
+// acepta. Con Key definida la petición y las respuestas van firmadas.
+func (c *Client) Transfer(zone string, prev *Zone) (*Zone, error) {
+	zone = dns.Fqdn(zone)
+	server := c.Server
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "53")
+	}
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	t := &dns.Transfer{DialTimeout: timeout, ReadTimeout: timeout, WriteTimeout: timeout}
+	m := new(dns.Msg)
+	kind := "AXFR"
+	if soa := prevSOA(prev); soa != nil {
+		kind = "IXFR"
+		m.SetIxfr(zone, soa.Serial, soa.Ns, soa.Mbox)
+	} else {
+		m.SetAxfr(zone)
+		prev = nil
+	}
+	if c.Key != nil {
+		if err := c.Key.Valid(); err != nil {
+			return nil, err
+		}
+		name := dns.Fqdn(c.Key.Name)
+		t.TsigSecret = map[string]string{name: c.Key.Secret}
+		m.SetTsig(name, algorithmName(c.Key.Algorithm), 300, time.Now().Unix())
+	}
+	fail := func(err error) error { return &TransferError{Zone: zone, Type: kind, Err: err} }
+	env, err := t.In(m, server)
+	if err != nil {
+		return nil, fail(fmt.Errorf("intercambio con %s: %w", server, err))
+	}
+	var rrs []dns.RR
+	for e := range env {
+		if e.Error != nil {
+			// Vaciar el canal para que termine la goroutine de lectura
+			for range env {
+			}
+			return nil, fail(e.Error)
+		}
+		rrs = append(rrs, e.RR...)
+	}
+	if len(rrs) == 0 {
+		return nil, fail(errors.New("respuesta vacía"))
+	}
+	soa, ok := rrs[0].(*dns.SOA)
+	if !ok {
+		return nil, fail(errors.New("la respuesta no empieza con el SOA"))
+	}
+	switch {
+	case prev != nil && len(rrs) == 1:
+		// Sin cambios desde prev.Serial
+		return prev, nil
+	case prev != nil && len(rrs) > 1 && isSOA(rrs[1]):
+		z, err := applyIXFR(prev, rrs)
+		if err != nil {
+			return nil, fail(err)
+		}
+		return z, nil
+	}
+	// Zona completa: SOA, registros, SOA
+	if len(rrs) < 2 || !isSOA(rrs[len(rrs)-1]) {
+		return nil, fail(errors.New("transferencia incompleta"))
+	}
+	return &Zone{Name: zone, Serial: soa.Serial, Records: rrs[:len(rrs)-1]}, nil
+}
+
+// prevSOA retorna el SOA de prev, o nil si no hay zona anterior.
+func prevSOA(prev *Zone) *dns.SOA {
+	if prev == nil {
+		return nil
+	}
+	return prev.SOA()
+}
+
+// isSOA indica si rr es un registro SOA.
+func isSOA(rr dns.RR) bool {
+	return rr.Header().Rrtype == dns.TypeSOA
+}
+
+// applyIXFR aplica sobre una copia de prev la respuesta incremental rrs:
+// SOA nuevo, y por cada versión intermedia el SOA anterior seguido de los
+// registros eliminados y el SOA siguiente seguido de los agregados; termina
+// con el SOA nuevo. Los registros se identifican con rrKey, así que un cambio
+// de TTL o de mayúsculas reemplaza el registro en lugar de duplicarlo.
+func applyIXFR(prev *Zone, rrs []dns.RR) (*Zone, error) {
+	last := rrs[0].(*dns.SOA)
+	if !isSOA(rrs[len(rrs)-1]) {
+		return nil, errors.New("transferencia incompleta")
+	}
+	records := make(map[string]dns.RR, len(prev.Records))
+	order := make([]string, 0, len(prev.Records))
+	for _, rr := range prev.Records[1:] {
+		k := rrKey(rr)
+		records[k] = rr
+		order = append(order, k)
+	}
+	serial := prev.Serial
+	deleting, adding := false, false
+	for _, rr := range rrs[1 : len(rrs)-1] {
+		if soa, ok := rr.(*dns.SOA); ok {
+			if deleting {
+				// SOA siguiente: empiezan los agregados
+				serial = soa.Serial
+				deleting, adding = false, true
+				continue
+			}
+			// SOA anterior: empiezan las eliminaciones de otra versión
+			if soa.Serial != serial {
+				return nil, fmt.Errorf("IXFR desde el serial %d, pero la zona guardada tiene %d", soa.Serial, serial)
+			}
+			deleting, adding = true, false
+			continue
+		}
+		k := rrKey(rr)
+		switch {
+		case adding:
+			if _, ok := records[k]; !ok {
+				order = append(order, k)
+			}
+			records[k] = rr
+		case deleting:
+			delete(records, k)
+		default:
+			return nil, errors.New("registro IXFR fuera de secuencia")
+		}
+	}
+	if !adding || serial != last.Serial {
+		return nil, errors.New("secuencia IXFR incompleta")
+	}
+	out := []dns.RR{last}
+	for _, k := range order {
+		if rr, ok := records[k]; ok {
+			out = append(out, rr)
+			delete(records, k) // Evita duplicados si se eliminó y volvió a agregar
+		}
+	}
+	return &Zone{Name: prev.Name, Serial: last.Serial, Records: out}, nil
+}
+
+// rrKey identifica un registro para applyIXFR: nombre, clase, tipo y RDATA en
+// minúsculas, sin el TTL. Un cambio de TTL llega en el IXFR como la
+// eliminación del registro con el TTL anterior y el agregado con el nuevo.
+func rrKey(rr dns.RR) string {
+	h := rr.Header()
+	data := strings.TrimPrefix(rr.String(), h.String())
+	return strings.ToLower(fmt.Sprintf("%s %d %d %s", dns.Fqdn(h.Name), h.Class, h.Rrtype, data))
+}
@@ -0,0 +1,236 @@
+package ddns
+
+import (
+	"errors"
+	"slices"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+// zoneRecords retorna los registros de z sin el SOA, en formato de texto y
+// ordenados, para compararlos con testServer.records.
+func zoneRecords(z *Zone) []string {
+	var out []string
+	for _, rr := range z.Records[1:] {
+		out = append(out, rr.String())
+	}
+	sort.Strings(out)
+	return out
+}
+
+func TestTransferAXFR(t *testing.T) {
+	s := newTestServer(t, zonasPrueba())
+	z, err := s.client().Transfer("grid.lab", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if z.Name != "grid.lab." || z.Serial != 1 || z.SOA() == nil || z.SOA().Serial != 1 {
+		t.Errorf("Zone = %s serial %d, SOA %v", z.Name, z.Serial, z.SOA())
+	}
+	if got, want := zoneRecords(z), s.records("grid.lab"); !slices.Equal(got, want) {
+		t.Errorf("registros =\n%s\nquiero\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestTransferIXFR(t *testing.T) {
+	tests := []struct {
+		name string
+		edit func([]dns.RR) []dns.RR // Cambio en el servidor después del AXFR
+		want []string                // Registros esperados (además de los que no cambian)
+	}{
+		{
+			name: "agregado y eliminación",
+			edit: func(rrs []dns.RR) []dns.RR {
+				rrs = deleteRRs(rrs, func(rr dns.RR) bool { return rr.Header().Name == "web2.grid.lab." })
+				return append(rrs, mustRR(t, "web3.grid.lab. 300 IN A 192.168.56.13"))
+			},
+		},
+		{
+			name: "solo cambia el TTL",
+			edit: func(rrs []dns.RR) []dns.RR {
+				for i, rr := range rrs {
+					if rr.Header().Name == "web2.grid.lab." {
+						rrs[i] = mustRR(t, "web2.grid.lab. 60 IN A 192.168.56.12")
+					}
+				}
+				return rrs
+			},
+			want: []string{"web2.grid.lab.\t60\tIN\tA\t192.168.56.12"},
+		},
+		{
+			name: "solo cambian las mayúsculas",
+			edit: func(rrs []dns.RR) []dns.RR {
+				for i, rr := range rrs {
+					if rr.Header().Name == "web2.grid.lab." {
+						rrs[i] = mustRR(t, "WEB2.grid.lab. 300 IN A 192.168.56.12")
+					}
+				}
+				return rrs
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, zonasPrueba())
+			c := s.client()
+			prev, err := c.Transfer("grid.lab", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.edit("grid.lab", func(rrs []dns.RR) []dns.RR {
+				return tt.edit(append([]dns.RR(nil), rrs...))
+			})
+			z, err := c.Transfer("grid.lab", prev)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if z == prev || z.Serial != 2 || z.SOA().Serial != 2 {
+				t.Fatalf("Zone serial %d, SOA %v; quiero una zona nueva con serial 2", z.Serial, z.SOA())
+			}
+			got := zoneRecords(z)
+			if want := s.records("grid.lab"); len(got) != len(want) {
+				t.Errorf("registros =\n%s\nquiero\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+			}
+			for _, w := range tt.want {
+				if !slices.Contains(got, w) {
+					t.Errorf("falta %q en\n%s", w, strings.Join(got, "\n"))
+				}
+			}
+			if len(prev.Records) != 5 {
+				t.Errorf("el IXFR modificó la zona anterior: %d registros", len(prev.Records))
+			}
+		})
+	}
+}
+
+func TestTransferIXFRUnchanged(t *testing.T) {
+	s := newTestServer(t, zonasPrueba())
+	c := s.client()
+	prev, err := c.Transfer("grid.lab", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	z, err := c.Transfer("grid.lab", prev)
+	if err != nil || z != prev {
+		t.Errorf("Transfer sin cambios = %p, %v; quiero la zona anterior %p", z, err, prev)
+	}
+}
+
+func TestTransferRejected(t *testing.T) {
+	s := newTestServer(t, zonasPrueba())
+	c := s.client()
+	c.Key = &Key{Name: testKey.Name, Algorithm: testKey.Algorithm, Secret: "b3Ryb3NlY3JldG9vdHJvc2VjcmV0bw=="}
+	_, err := c.Transfer("grid.lab", nil)
+	var te *TransferError
+	if !errors.As(err, &te) || te.Type != "AXFR" {
+		t.Errorf("Transfer = %v, quiero *TransferError de AXFR", err)
+	}
+}
+
+func TestApplyIXFR(t *testing.T) {
+	rrs := func(lines ...string) []dns.RR {
+		var out []dns.RR
+		for _, ln := range lines {
+			if n, ok := strings.CutPrefix(ln, "SOA "); ok {
+				out = append(out, soa("grid.lab", uint32(len(n))))
+				continue
+			}
+			out = append(out, mustRR(t, ln))
+		}
+		return out
+	}
+	// "SOA x" es el SOA con serial len(x): SOA 1, SOA 22, SOA 333...
+	prev := &Zone{Name: "grid.lab.", Serial: 1, Records: rrs(
+		"SOA 1",
+		"web1.grid.lab. 300 IN A 192.168.56.21",
+		"web2.grid.lab. 300 IN A 192.168.56.22",
+		`info.grid.lab. 300 IN TXT "Hola"`,
+	)}
+	tests := []struct {
+		name    string
+		ixfr    []dns.RR
+		want    []string
+		wantErr string
+	}{
+		{
+			name: "dos versiones",
+			ixfr: rrs("SOA 333",
+				"SOA 1", "web1.grid.lab. 300 IN A 192.168.56.21",
+				"SOA 22", "web3.grid.lab. 300 IN A 192.168.56.23",
+				"SOA 22", "web3.grid.lab. 300 IN A 192.168.56.23",
+				"SOA 333", "web4.grid.lab. 300 IN A 192.168.56.24",
+				"SOA 333"),
+			want: []string{"web2.grid.lab. 300 IN A 192.168.56.22", `info.grid.lab. 300 IN TXT "Hola"`, "web4.grid.lab. 300 IN A 192.168.56.24"},
+		},
+		{
+			name: "cambio de TTL y mayúsculas",
+			ixfr: rrs("SOA 22",
+				"SOA 1", "WEB1.GRID.LAB. 300 IN A 192.168.56.21", "web2.grid.lab. 300 IN A 192.168.56.22",
+				"SOA 22", "web1.grid.lab. 60 IN A 192.168.56.21", "web2.grid.lab. 120 IN A 192.168.56.22",
+				"SOA 22"),
+			want: []string{"web1.grid.lab. 60 IN A 192.168.56.21", "web2.grid.lab. 120 IN A 192.168.56.22", `info.grid.lab. 300 IN TXT "Hola"`},
+		},
+		{
+			name: "eliminar y volver a agregar",
+			ixfr: rrs("SOA 22",
+				"SOA 1", "web2.grid.lab. 300 IN A 192.168.56.22",
+				"SOA 22", "web2.grid.lab. 300 IN A 192.168.56.22",
+				"SOA 22"),
+			want: []string{"web1.grid.lab. 300 IN A 192.168.56.21", "web2.grid.lab. 300 IN A 192.168.56.22", `info.grid.lab. 300 IN TXT "Hola"`},
+		},
+		{
+			name:    "serial distinto del guardado",
+			ixfr:    rrs("SOA 333", "SOA 22", "SOA 333", "SOA 333"),
+			wantErr: "serial 2",
+		},
+		{
+			name:    "registro antes del primer SOA",
+			ixfr:    rrs("SOA 22", "web9.grid.lab. 300 IN A 192.168.56.29", "SOA 1", "SOA 22", "SOA 22"),
+			wantErr: "fuera de secuencia",
+		},
+		{
+			name:    "termina sin los agregados",
+			ixfr:    rrs("SOA 22", "SOA 1", "web1.grid.lab. 300 IN A 192.168.56.21", "SOA 22"),
+			wantErr: "incompleta",
+		},
+		{
+			name:    "no termina con el SOA",
+			ixfr:    rrs("SOA 22", "SOA 1", "SOA 22", "web9.grid.lab. 300 IN A 192.168.56.29"),
+			wantErr: "incompleta",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			z, err := applyIXFR(prev, tt.ixfr)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("applyIXFR = %v, quiero un error con %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := tt.ixfr[0].(*dns.SOA).Serial; z.Serial != want || z.SOA().Serial != want {
+				t.Errorf("serial %d (SOA %d), quiero %d", z.Serial, z.SOA().Serial, want)
+			}
+			var got []string
+			for _, rr := range z.Records[1:] {
+				got = append(got, rr.String())
+			}
+			var want []string
+			for _, rr := range rrs(tt.want...) {
+				want = append(want, rr.String())
+			}
+			if !slices.Equal(got, want) {
+				t.Errorf("registros =\n%s\nquiero\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+			}
+		})
+	}
+	if len(prev.Records) != 4 || prev.Serial != 1 {
+		t.Errorf("applyIXFR modificó la zona anterior")
+	}
+}
@@ -0,0 +1,161 @@
+package ddns
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// ErrZoneSyntax indica que el texto de la zona no es un archivo de zona válido.
+var ErrZoneSyntax = errors.New("archivo de zona inválido")
+
+// Record es un registro de una zona con sus datos según el tipo. Los nombres
+// son absolutos y sin el punto final (ej: web1.grid.lab).
+type Record struct {
+	Name    string   `json:"name"`              // Dueño del registro
+	TTL     uint32   `json:"ttl"`               // TTL en segundos
+	Class   string   `json:"class"`             // Clase (normalmente IN)
+	Type    string   `json:"type"`              // Tipo (A, AAAA, CNAME, MX, ...)
+	Data    string   `json:"data"`              // RDATA en formato de archivo de zona
+	Address string   `json:"address,omitempty"` // A, AAAA
+	Target  string   `json:"target,omitempty"`  // CNAME, NS, PTR
+	Text    []string `json:"text,omitempty"`    // TXT, una cadena por elemento
+	MX      *MXData  `json:"mx,omitempty"`      // MX
+	SRV     *SRVData `json:"srv,omitempty"`     // SRV
+	SOA     *SOAData `json:"soa,omitempty"`     // SOA
+	RR      dns.RR   `json:"-"`                 // Registro original
+}
+
+// MXData son los datos de un registro MX.
+type MXData struct {
+	Preference uint16 `json:"preference"`
+	Exchange   string `json:"exchange"`
+}
+
+// SRVData son los datos de un registro SRV.
+type SRVData struct {
+	Priority uint16 `json:"priority"`
+	Weight   uint16 `json:"weight"`
+	Port     uint16 `json:"port"`
+	Target   string `json:"target"`
+}
+
+// SOAData son los datos de un registro SOA.
+type SOAData struct {
+	NS      string `json:"ns"`
+	Mbox    string `json:"mbox"`
+	Serial  uint32 `json:"serial"`
+	Refresh uint32 `json:"refresh"`
+	Retry   uint32 `json:"retry"`
+	Expire  uint32 `json:"expire"`
+	Minttl  uint32 `json:"minttl"`
+}
+
+// NewRecord convierte un registro de miekg/dns en un Record.
+func NewRecord(rr dns.RR) Record {
+	h := rr.Header()
+	rec := Record{
+		Name:  trimDot(h.Name),
+		TTL:   h.Ttl,
+		Class: dns.ClassToString[h.Class],
+		Type:  dns.TypeToString[h.Rrtype],
+		Data:  strings.TrimPrefix(rr.String(), h.String()),
+		RR:    rr,
+	}
+	if rec.Type == "" {
+		rec.Type = fmt.Sprintf("TYPE%d", h.Rrtype)
+	}
+	switch v := rr.(type) {
+	case *dns.A:
+		rec.Address = v.A.String()
+	case *dns.AAAA:
+		rec.Address = v.AAAA.String()
+	case *dns.CNAME:
+		rec.Target = trimDot(v.Target)
+	case *dns.NS:
+		rec.Target = trimDot(v.Ns)
+	case *dns.PTR:
+		rec.Target = trimDot(v.Ptr)
+	case *dns.TXT:
+		rec.Text = v.Txt
+	case *dns.MX:
+		rec.MX = &MXData{Preference: v.Preference, Exchange: trimDot(v.Mx)}
+	case *dns.SRV:
+		rec.SRV = &SRVData{Priority: v.Priority, Weight: v.Weight, Port: v.Port, Target: trimDot(v.Target)}
+	case *dns.SOA:
+		rec.SOA = &SOAData{NS: trimDot(v.Ns), Mbox: trimDot(v.Mbox), Serial: v.Serial,
+			Refresh: v.Refresh, Retry: v.Retry, Expire: v.Expire, Minttl: v.Minttl}
+	}
+	return rec
+}
+
+// NewRecords convierte una lista de registros de miekg/dns (ej: los de una
+// Zone) en Records.
+func NewRecords(rrs []dns.RR) []Record {
+	out := make([]Record, 0, len(rrs))
+	for _, rr := range rrs {
+		out = append(out, NewRecord(rr))
+	}
+	return out
+}
+
+// ParseZone lee un archivo de zona (RFC 1035): resuelve los nombres
+// relativos contra origin y $ORIGIN, aplica $TTL y el TTL o la clase
+// heredados de la línea anterior, y admite registros de varias líneas entre
+// paréntesis. No sigue $INCLUDE. Los errores de sintaxis envuelven
+// ErrZoneSyntax e indican la línea.
+//
+// También acepta la salida de "rndc dumpdb -zones", que puede traer varias
+// zonas y directivas $DATE (se ignoran).
+func ParseZone(r io.Reader, origin, file string) ([]Record, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	// Comentar las líneas $DATE conserva la numeración de los errores
+	lines := strings.SplitAfter(string(b), "\n")
+	for i, ln := range lines {
+		if len(ln) >= 5 && strings.EqualFold(ln[:5], "$DATE") {
+			lines[i] = ";" + ln
+		}
+	}
+	zp := dns.NewZoneParser(strings.NewReader(strings.Join(lines, "")), dns.Fqdn(origin), file)
+	var out []Record
+	for rr, ok := zp.Next(); ok; rr, ok = zp.Next() {
+		out = append(out, NewRecord(rr))
+	}
+	if err := zp.Err(); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrZoneSyntax, err)
+	}
+	return out, nil
+}
+
+// trimDot quita el punto final de un nombre absoluto.
+func trimDot(name string) string {
+	if name == "." {
+		return name
+	}
+	return strings.TrimSuffix(name, ".")
+}
+
+// FilterType retorna los registros del tipo indicado (ej: "mx"); "ANY"
+// retorna todos. Falla si el tipo no existe.
+func FilterType(recs []Record, typ string) ([]Record, error) {
+	typ = strings.ToUpper(typ)
+	if typ == "ANY" {
+		return recs, nil
+	}
+	if _, ok := dns.StringToType[typ]; !ok {
+		return nil, fmt.Errorf("tipo de registro desconocido: %s", typ)
+	}
+	out := []Record{}
+	for _, rec := range recs {
+		if rec.Type == typ {
+			out = append(out, rec)
+		}
+	}
+	return out, nil
+}
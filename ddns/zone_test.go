@@ -0,0 +1,227 @@
+package ddns
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseZone(t *testing.T) {
+	tests := []struct {
+		name   string
+		origin string
+		text   string
+		want   []string // Name TTL Class Type Data de cada registro
+	}{
+		{
+			name:   "nombres relativos y $ORIGIN",
+			origin: "grid.lab",
+			text: `$TTL 300
+@	IN	NS	ns
+web1	IN	A	192.168.56.21
+$ORIGIN apps.grid.lab.
+api	IN	A	192.168.56.30
+www	IN	CNAME	api
+`,
+			want: []string{
+				"grid.lab 300 IN NS ns.grid.lab.",
+				"web1.grid.lab 300 IN A 192.168.56.21",
+				"api.apps.grid.lab 300 IN A 192.168.56.30",
+				"www.apps.grid.lab 300 IN CNAME api.apps.grid.lab.",
+			},
+		},
+		{
+			name:   "SOA de varias líneas entre paréntesis",
+			origin: "grid.lab.",
+			text: `$TTL 86400
+@ IN SOA ns.grid.lab. root.grid.lab. (
+		2026101701 ; serial
+		3600       ; refresh
+		600        ; retry
+		86400      ; expire
+		300 )      ; minimum
+`,
+			want: []string{"grid.lab 86400 IN SOA ns.grid.lab. root.grid.lab. 2026101701 3600 600 86400 300"},
+		},
+		{
+			name:   "dueño, TTL y clase heredados de la línea anterior",
+			origin: "grid.lab",
+			text: `web1	120	IN	A	192.168.56.21
+	A	192.168.56.22
+web2	A	192.168.56.23
+`,
+			want: []string{
+				"web1.grid.lab 120 IN A 192.168.56.21",
+				"web1.grid.lab 120 IN A 192.168.56.22",
+				"web2.grid.lab 120 IN A 192.168.56.23",
+			},
+		},
+		{
+			name:   "$TTL tiene prioridad sobre el TTL anterior (RFC 2308)",
+			origin: "grid.lab",
+			text: `$TTL 600
+web1	120	IN	A	192.168.56.21
+	A	192.168.56.22
+web2	IN	A	192.168.56.23
+`,
+			want: []string{
+				"web1.grid.lab 120 IN A 192.168.56.21",
+				"web1.grid.lab 600 IN A 192.168.56.22",
+				"web2.grid.lab 600 IN A 192.168.56.23",
+			},
+		},
+		{
+			name:   "TXT que contiene \" A \"",
+			origin: "grid.lab",
+			text: `$TTL 300
+info	IN	TXT	"host A 192.168.56.99" "otra cadena"
+mail	IN	MX	10 web1
+`,
+			want: []string{
+				`info.grid.lab 300 IN TXT "host A 192.168.56.99" "otra cadena"`,
+				"mail.grid.lab 300 IN MX 10 web1.grid.lab.",
+			},
+		},
+		{
+			name:   "salida de rndc dumpdb con $DATE y dos zonas",
+			origin: "grid.lab",
+			text: `;
+; Cache dump of view '_default' (cache _default)
+;
+$DATE 20261017120000
+; Zone dump of 'grid.lab/IN'
+grid.lab.		300	IN SOA	ns.grid.lab. root.grid.lab. 7 3600 600 86400 300
+web1.grid.lab.		300	IN A	192.168.56.21
+; Zone dump of '56.168.192.in-addr.arpa/IN'
+$DATE 20261017120001
+21.56.168.192.in-addr.arpa. 300	IN PTR	web1.grid.lab.
+`,
+			want: []string{
+				"grid.lab 300 IN SOA ns.grid.lab. root.grid.lab. 7 3600 600 86400 300",
+				"web1.grid.lab 300 IN A 192.168.56.21",
+				"21.56.168.192.in-addr.arpa 300 IN PTR web1.grid.lab.",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recs, err := ParseZone(strings.NewReader(tt.text), tt.origin, "db.test")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, r := range recs {
+				got = append(got, strings.Join([]string{r.Name, strconv.FormatUint(uint64(r.TTL), 10), r.Class, r.Type, strings.Join(strings.Fields(r.Data), " ")}, " "))
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("ParseZone =\n%s\nquiero\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestParseZoneTypedData(t *testing.T) {
+	text := `$ORIGIN grid.lab.
+$TTL 300
+@	IN	SOA	ns root 9 3600 600 86400 300
+web1	IN	A	192.168.56.21
+web1	IN	AAAA	fd00::21
+www	IN	CNAME	web1
+info	IN	TXT	"a b" "c"
+@	IN	MX	10 mail
+_http._tcp	IN	SRV	1 2 80 web1
+`
+	recs, err := ParseZone(strings.NewReader(text), "grid.lab", "db.grid.lab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 7 {
+		t.Fatalf("%d registros, quiero 7", len(recs))
+	}
+	soa, a, aaaa, cname, txt, mx, srv := recs[0], recs[1], recs[2], recs[3], recs[4], recs[5], recs[6]
+	if soa.SOA == nil || *soa.SOA != (SOAData{NS: "ns.grid.lab", Mbox: "root.grid.lab", Serial: 9, Refresh: 3600, Retry: 600, Expire: 86400, Minttl: 300}) {
+		t.Errorf("SOA = %+v", soa.SOA)
+	}
+	if a.Address != "192.168.56.21" || aaaa.Address != "fd00::21" {
+		t.Errorf("Address = %q, %q", a.Address, aaaa.Address)
+	}
+	if cname.Target != "web1.grid.lab" {
+		t.Errorf("CNAME Target = %q", cname.Target)
+	}
+	if !slices.Equal(txt.Text, []string{"a b", "c"}) {
+		t.Errorf("TXT Text = %q", txt.Text)
+	}
+	if mx.MX == nil || *mx.MX != (MXData{Preference: 10, Exchange: "mail.grid.lab"}) {
+		t.Errorf("MX = %+v", mx.MX)
+	}
+	if srv.Name != "_http._tcp.grid.lab" || srv.SRV == nil || *srv.SRV != (SRVData{Priority: 1, Weight: 2, Port: 80, Target: "web1.grid.lab"}) {
+		t.Errorf("SRV = %s %+v", srv.Name, srv.SRV)
+	}
+}
+
+func TestParseZoneSyntaxError(t *testing.T) {
+	text := "$TTL 300\nweb1 IN A 192.168.56.21\nweb2 IN A no-es-una-ip\n"
+	_, err := ParseZone(strings.NewReader(text), "grid.lab", "db.grid.lab")
+	if !errors.Is(err, ErrZoneSyntax) {
+		t.Fatalf("ParseZone = %v, quiero ErrZoneSyntax", err)
+	}
+	if !strings.Contains(err.Error(), "line: 3") {
+		t.Errorf("el error no indica la línea 3: %v", err)
+	}
+	// Comentar $DATE no corre la numeración de las líneas
+	_, err = ParseZone(strings.NewReader("$DATE 20261017120000\n"+text), "grid.lab", "dump")
+	if err == nil || !strings.Contains(err.Error(), "line: 4") {
+		t.Errorf("con $DATE el error = %v, quiero la línea 4", err)
+	}
+}
+
+func TestFilterType(t *testing.T) {
+	text := `$ORIGIN grid.lab.
+$TTL 300
+web1	IN	A	192.168.56.21
+web2	IN	A	192.168.56.22
+web1	IN	AAAA	fd00::21
+info	IN	TXT	"web3 IN A 192.168.56.23"
+@	IN	MX	10 web1
+`
+	recs, err := ParseZone(strings.NewReader(text), "grid.lab", "db.grid.lab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		typ     string
+		want    []string // Nombres de los registros
+		wantErr bool
+	}{
+		{typ: "A", want: []string{"web1.grid.lab", "web2.grid.lab"}},
+		{typ: "aaaa", want: []string{"web1.grid.lab"}},
+		{typ: "TXT", want: []string{"info.grid.lab"}},
+		{typ: "Mx", want: []string{"grid.lab"}},
+		{typ: "CNAME", want: []string{}},
+		{typ: "any", want: []string{"web1.grid.lab", "web2.grid.lab", "web1.grid.lab", "info.grid.lab", "grid.lab"}},
+		{typ: "NOEXISTE", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.typ, func(t *testing.T) {
+			out, err := FilterType(recs, tt.typ)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("FilterType no falló")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, r := range out {
+				got = append(got, r.Name)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("FilterType(%s) = %q, quiero %q", tt.typ, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,353 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================== DNS Logs ====================================
+
+// El historial DNS es un registro de auditoría: nunca se trunca. El store
+// guarda las entradas recientes y, al superar dnsLogRotateEntries, se
+// archivan en un segmento JSON Lines inmutable dentro de dnsArchiveDir
+// (dns-<primera>-<última>.jsonl). Las consultas recorren ambos.
+
+// DNSLogQuery filtros y paginación de GET /dns-logs.
+type DNSLogQuery struct {
+	pageQuery
+	Action string      // "ADD", "DELETE" o "PTR" (reparación del PTR); vacío = todas
+	FQDN   string      // FQDN exacto (sin distinguir mayúsculas); vacío = todos
+	IP     string      // IP exacta; vacío = todas
+	Scope  tenantScope // Hosts visibles para el usuario (ver scopeFor)
+}
+
+var (
+	// muDNSLogs serializa el agregado y la rotación del historial DNS.
+	muDNSLogs sync.Mutex
+	// dnsLogCount entradas del historial en el store, para no listarlas en
+	// cada addDNSLog; -1 = todavía no se contaron. Protegido por muDNSLogs.
+	dnsLogCount = -1
+)
+
+// addDNSLog agrega una nueva entrada al historial DNS y rota el historial si
+// corresponde. Los errores al guardar se informan por consola.
+func addDNSLog(ctx context.Context, action, fqdn, ip string) {
+	muDNSLogs.Lock()
+	defer muDNSLogs.Unlock()
+	err := store.AppendDNSLog(DNSLog{
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+		Action:    action,
+		FQDN:      fqdn,
+		IP:        ip,
+		Actor:     actorFrom(ctx),
+	})
+	if err != nil {
+		fmt.Println("Error guardando log DNS:", err)
+		return
+	}
+	if dnsLogCount < 0 {
+		logs, err := store.ListDNSLogs()
+		if err != nil {
+			fmt.Println("Error contando logs DNS:", err)
+			return
+		}
+		dnsLogCount = len(logs)
+	} else {
+		dnsLogCount++
+	}
+	if dnsLogCount < dnsLogRotateEntries {
+		return
+	}
+	// Si falla se reintenta con la próxima entrada
+	if err := rotateDNSLogs(); err != nil {
+		fmt.Println("Error rotando logs DNS:", err)
+		return
+	}
+	dnsLogCount = 0
+}
+
+// rotateDNSLogs archiva las entradas del store cuando superan
+// dnsLogRotateEntries. El segmento se escribe antes de quitar las entradas
+// del store: si el servidor se detiene entre ambos pasos, la próxima
+// rotación solo quita las que ya estaban archivadas.
+func rotateDNSLogs() error {
+	logs, err := store.ListDNSLogs()
+	if err != nil || len(logs) < dnsLogRotateEntries {
+		return err
+	}
+	last, err := lastArchivedSeq()
+	if err != nil {
+		return err
+	}
+	pending := logs[:0:0]
+	for _, l := range logs {
+		if l.Seq > last {
+			pending = append(pending, l)
+		}
+	}
+	if len(pending) > 0 {
+		if err := writeDNSArchive(pending); err != nil {
+			return err
+		}
+	}
+	return store.TrimDNSLogs(logs[len(logs)-1].Seq)
+}
+
+// logSegment segmento archivado de un registro JSON Lines (historial DNS o
+// auditoría), llamado <prefijo>-<primera>-<última>.jsonl.
+type logSegment struct {
+	path        string
+	first, last uint64 // Seq de la primera y la última entrada
+}
+
+// listSegments retorna los segmentos de dir con el prefijo dado ordenados por Seq.
+func listSegments(dir, prefix string) ([]logSegment, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, prefix+"-*.jsonl"))
+	if err != nil {
+		return nil, err
+	}
+	out := make([]logSegment, 0, len(matches))
+	for _, m := range matches {
+		var a logSegment
+		if _, err := fmt.Sscanf(filepath.Base(m), prefix+"-%d-%d.jsonl", &a.first, &a.last); err != nil {
+			continue
+		}
+		a.path = m
+		out = append(out, a)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].first < out[j].first })
+	return out, nil
+}
+
+// segmentName nombre del segmento con las entradas first a last.
+func segmentName(prefix string, first, last uint64) string {
+	return fmt.Sprintf("%s-%012d-%012d.jsonl", prefix, first, last)
+}
+
+// listDNSArchives retorna los segmentos archivados del historial DNS.
+func listDNSArchives() ([]logSegment, error) {
+	return listSegments(dnsArchiveDir, "dns")
+}
+
+// lastArchivedSeq retorna el Seq de la última entrada archivada, o 0.
+func lastArchivedSeq() (uint64, error) {
+	archives, err := listDNSArchives()
+	if err != nil || len(archives) == 0 {
+		return 0, err
+	}
+	return archives[len(archives)-1].last, nil
+}
+
+// writeDNSArchive escribe logs (en orden de Seq) como un nuevo segmento,
+// usando escritura atómica.
+func writeDNSArchive(logs []DNSLog) error {
+	if err := os.MkdirAll(dnsArchiveDir, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(dnsArchiveDir, segmentName("dns", logs[0].Seq, logs[len(logs)-1].Seq))
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, l := range logs {
+		if err := enc.Encode(l); err != nil {
+			f.Close()
+			os.Remove(tmp)
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// readDNSArchive lee un segmento archivado.
+func readDNSArchive(path string) ([]DNSLog, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var logs []DNSLog
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		var l DNSLog
+		if err := json.Unmarshal(sc.Bytes(), &l); err != nil {
+			return nil, fmt.Errorf("%s línea %d: %w", path, n, err)
+		}
+		logs = append(logs, l)
+	}
+	return logs, sc.Err()
+}
+
+// matches indica si l cumple los filtros de q (sin considerar el cursor).
+func (q DNSLogQuery) matches(l DNSLog) bool {
+	if q.Action != "" && !strings.EqualFold(l.Action, q.Action) {
+		return false
+	}
+	if q.FQDN != "" && !strings.EqualFold(strings.TrimSuffix(l.FQDN, "."), q.FQDN) {
+		return false
+	}
+	if q.IP != "" && l.IP != q.IP {
+		return false
+	}
+	if !q.Scope.host(l.FQDN) {
+		return false
+	}
+	return q.inRange(l.Timestamp)
+}
+
+// queryDNSLogs busca en los segmentos archivados y en el store las entradas
+// que cumplen q, en el orden pedido y a partir del cursor. Retorna la página
+// y el cursor de la siguiente (0 si no hay más). Los segmentos que quedan
+// antes del cursor no se leen.
+func queryDNSLogs(q DNSLogQuery) ([]DNSLog, uint64, error) {
+	archives, err := listDNSArchives()
+	if err != nil {
+		return nil, 0, err
+	}
+	recent, err := store.ListDNSLogs()
+	if err != nil {
+		return nil, 0, err
+	}
+	// Entradas ya archivadas que el store aún no quitó (rotación interrumpida)
+	if len(archives) > 0 {
+		last := archives[len(archives)-1].last
+		i := sort.Search(len(recent), func(i int) bool { return recent[i].Seq > last })
+		recent = recent[i:]
+	}
+
+	out := []DNSLog{}
+	// add agrega las entradas de logs (ya en el orden pedido); retorna true
+	// cuando la página está completa (con una entrada extra para saber si
+	// hay otra página)
+	add := func(logs []DNSLog) bool {
+		for _, l := range logs {
+			if q.afterCursor(l.Seq) && q.matches(l) {
+				out = append(out, l)
+				if len(out) > q.Limit {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	// segment lee un segmento archivado en el orden pedido
+	segment := func(a logSegment) ([]DNSLog, error) {
+		logs, err := readDNSArchive(a.path)
+		if err == nil && !q.Asc {
+			slices.Reverse(logs)
+		}
+		return logs, err
+	}
+
+	full := false
+	if q.Asc {
+		for _, a := range archives {
+			if q.Cursor != 0 && a.last <= q.Cursor {
+				continue
+			}
+			logs, err := segment(a)
+			if err != nil {
+				return nil, 0, err
+			}
+			if full = add(logs); full {
+				break
+			}
+		}
+		if !full {
+			full = add(recent)
+		}
+	} else {
+		slices.Reverse(recent)
+		full = add(recent)
+		for i := len(archives) - 1; i >= 0 && !full; i-- {
+			if q.Cursor != 0 && archives[i].first >= q.Cursor {
+				continue
+			}
+			logs, err := segment(archives[i])
+			if err != nil {
+				return nil, 0, err
+			}
+			full = add(logs)
+		}
+	}
+	if !full {
+		return out, 0, nil
+	}
+	out = out[:q.Limit]
+	return out, out[len(out)-1].Seq, nil
+}
+
+// parseDNSLogQuery lee los filtros de GET /dns-logs: action, fqdn e ip más
+// los de parsePageQuery.
+func parseDNSLogQuery(r *http.Request) (DNSLogQuery, error) {
+	v := r.URL.Query()
+	p, err := parsePageQuery(v)
+	q := DNSLogQuery{
+		pageQuery: p,
+		Action:    strings.ToUpper(strings.TrimSpace(v.Get("action"))),
+		FQDN:      strings.TrimSuffix(strings.TrimSpace(v.Get("fqdn")), "."),
+		IP:        strings.TrimSpace(v.Get("ip")),
+	}
+	if err != nil {
+		return q, err
+	}
+	if q.Action != "" && q.Action != "ADD" && q.Action != "DELETE" && q.Action != "PTR" {
+		return q, fmt.Errorf("action inválida %q (use ADD, DELETE o PTR)", q.Action)
+	}
+	return q, nil
+}
+
+// handleDNSLogs maneja GET /dns-logs: historial DNS, por defecto las 100
+// entradas más recientes primero. Los usuarios que no son admin solo ven las
+// entradas de los hosts de su tenant. Acepta los filtros de parseDNSLogQuery
+// (ej: ?ip=192.168.56.13&since=2026-09-01&until=2026-10-01). Si hay más
+// entradas, el header X-Next-Cursor trae el valor de ?cursor= para pedir la
+// página siguiente. Retorna JSON con un array de logs DNS.
+func handleDNSLogs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	q, err := parseDNSLogQuery(r)
+	if err != nil {
+		writeError(w, codeBadRequest, err.Error())
+		return
+	}
+	if q.Scope, err = scopeFor(r.Context()); err != nil {
+		writeAPIError(w, toAPIError(err, "leyendo instancias"))
+		return
+	}
+	logs, next, err := queryDNSLogs(q)
+	if err != nil {
+		writeAPIError(w, toAPIError(err, "leyendo logs DNS"))
+		return
+	}
+	if next != 0 {
+		w.Header().Set("X-Next-Cursor", strconv.FormatUint(next, 10))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(logs)
+}
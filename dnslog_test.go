@@ -0,0 +1,24 @@
+package main
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+func TestAddDNSLogRotates(t *testing.T) {
+	lifecycleTestEnv(t)
+	prevArchive, prevRotate := dnsArchiveDir, dnsLogRotateEntries
+	dnsArchiveDir, dnsLogRotateEntries, dnsLogCount = filepath.Join(t.TempDir(), "dns-archive"), 3, -1
+	t.Cleanup(func() { dnsArchiveDir, dnsLogRotateEntries, dnsLogCount = prevArchive, prevRotate, -1 })
+
+	for range 7 {
+		addDNSLog(context.Background(), "ADD", "web1.grid.lab", "192.168.56.21")
+	}
+	if logs, err := store.ListDNSLogs(); err != nil || len(logs) != 1 || logs[0].Seq != 7 {
+		t.Errorf("store = %+v, %v; quiero solo la entrada 7", logs, err)
+	}
+	if archives, err := listDNSArchives(); err != nil || len(archives) != 2 {
+		t.Errorf("segmentos = %+v, %v; quiero 2", archives, err)
+	}
+}
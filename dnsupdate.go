@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"computacion-nube-proyecto/ddns"
+)
+
+// ============================== DNS Updates =================================
+
+// dnsUpdater aplica los cambios de registros A y PTR de un host en el DNS.
+type dnsUpdater interface {
+	// AddHost reemplaza el A de fqdn y el PTR de ip por los nuevos valores.
+	AddHost(fqdn, ip string) error
+	// DeleteHost elimina el A de fqdn y el PTR de ip.
+	DeleteHost(fqdn, ip string) error
+}
+
+// rfc2136Updater aplica los cambios enviando mensajes DNS UPDATE firmados con
+// TSIG directamente al servidor DNS (UDP con reintento por TCP).
+type rfc2136Updater struct {
+	client  *ddns.Client // Sin clave: cada cambio usa la clave TSIG en uso (ver currentTSIGKey)
+	zone    string       // Zona directa (ej: grid.lab)
+	revZone string       // Zona inversa
+	ttl     uint32       // TTL de los registros creados
+}
+
+// newRFC2136Updater crea el updater contra dnsServerIP. Si no hay clave TSIG
+// el updater se crea igual y cada cambio retorna el error, para que el
+// servidor arranque aunque el DNS no esté listo; la clave se puede rotar
+// después sin reiniciar.
+func newRFC2136Updater() *rfc2136Updater {
+	reverseZone, _ := reverseZoneOf(hostOnlyCIDR) // Validada en loadConfig
+	u := &rfc2136Updater{
+		client:  &ddns.Client{Server: dnsServerIP, Net: "udp", Timeout: stepTimeout("dns")},
+		zone:    dnsZone,
+		revZone: reverseZone,
+		ttl:     300,
+	}
+	if _, err := currentTSIGKey(); err != nil {
+		fmt.Println("Advertencia:", err)
+	}
+	return u
+}
+
+// signedClient retorna una copia del cliente con la clave TSIG en uso.
+func (u *rfc2136Updater) signedClient() (*ddns.Client, error) {
+	key, err := currentTSIGKey()
+	if err != nil {
+		return nil, err
+	}
+	c := *u.client
+	c.Key = &key
+	return &c, nil
+}
+
+// AddHost reemplaza el A del host en la zona directa y su PTR en la inversa.
+func (u *rfc2136Updater) AddHost(fqdn, ip string) error {
+	client, err := u.signedClient()
+	if err != nil {
+		return err
+	}
+	if err := client.ReplaceA(u.zone, fqdn, ip, u.ttl); err != nil {
+		return &dnsUpdateError{fmt.Errorf("registro A de %s: %w", fqdn, err)}
+	}
+	if err := client.ReplacePTR(u.revZone, ip, fqdn, u.ttl); err != nil {
+		return &dnsUpdateError{fmt.Errorf("registro PTR de %s: %w", ip, err)}
+	}
+	return nil
+}
+
+// SetPTR reemplaza el PTR de ip en la zona inversa para que apunte a fqdn,
+// sin tocar el A (ej: para reparar un PTR que quedó mal).
+func (u *rfc2136Updater) SetPTR(ip, fqdn string) error {
+	client, err := u.signedClient()
+	if err != nil {
+		return err
+	}
+	if err := client.ReplacePTR(u.revZone, ip, fqdn, u.ttl); err != nil {
+		return &dnsUpdateError{fmt.Errorf("registro PTR de %s: %w", ip, err)}
+	}
+	return nil
+}
+
+// DeleteHost elimina el A y el PTR del host. Intenta ambos aunque falle el primero.
+func (u *rfc2136Updater) DeleteHost(fqdn, ip string) error {
+	client, err := u.signedClient()
+	if err != nil {
+		return err
+	}
+	errA := client.DeleteA(u.zone, fqdn)
+	errPTR := client.DeletePTR(u.revZone, ip)
+	if errA != nil {
+		return &dnsUpdateError{fmt.Errorf("registro A de %s: %w", fqdn, errA)}
+	}
+	if errPTR != nil {
+		return &dnsUpdateError{fmt.Errorf("registro PTR de %s: %w", ip, errPTR)}
+	}
+	return nil
+}
+
+// resolverAt retorna un resolver que consulta directamente al servidor DNS
+// indicado, sin pasar por el resolver del sistema.
+func resolverAt(server string) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			d := net.Dialer{Timeout: 5 * time.Second}
+			return d.DialContext(ctx, network, net.JoinHostPort(server, "53"))
+		},
+	}
+}
+
+// lookupHostAt resuelve fqdn consultando directamente al servidor DNS indicado
+// (equivale a "nslookup fqdn server").
+func lookupHostAt(ctx context.Context, server, fqdn string) ([]string, error) {
+	ctx, cancel := stepContext(ctx, "nslookup")
+	defer cancel()
+	return resolverAt(server).LookupHost(ctx, fqdn)
+}
+
+// checkPTRAt verifica que el PTR de ip en el servidor DNS indicado apunte a
+// fqdn (equivale a "nslookup ip server").
+func checkPTRAt(ctx context.Context, server, ip, fqdn string) error {
+	ctx, cancel := stepContext(ctx, "nslookup")
+	defer cancel()
+	names, err := resolverAt(server).LookupAddr(ctx, ip)
+	if err != nil {
+		return fmt.Errorf("PTR de %s: %w", ip, err)
+	}
+	for _, n := range names {
+		if strings.EqualFold(strings.TrimSuffix(n, "."), fqdn) {
+			return nil
+		}
+	}
+	return fmt.Errorf("el PTR de %s apunta a %s y no a %s", ip, strings.Join(names, ", "), fqdn)
+}
+
+// ============================== Zone Transfer ===============================
+
+var (
+	// muDNSZone protege lastDNSZones.
+	muDNSZone sync.Mutex
+	// lastDNSZones última versión transferida de cada zona; la siguiente
+	// lectura pide solo los cambios desde su serial (IXFR).
+	lastDNSZones = map[string]*ddns.Zone{}
+)
+
+// transferDNSZone obtiene zone (ej: dnsZone o la zona inversa) del servidor
+// DNS por transferencia de zona firmada con la clave TSIG en uso, sin acceso
+// por shell al servidor. Pide IXFR desde la última versión leída y, si falla,
+// AXFR. source es "ixfr" o "axfr" según lo que se usó.
+func transferDNSZone(ctx context.Context, zone string) (z *ddns.Zone, source string, err error) {
+	key, err := currentTSIGKey()
+	if err != nil {
+		return nil, "", err
+	}
+	c := &ddns.Client{Server: dnsServerIP, Key: &key, Timeout: stepTimeout("axfr")}
+	muDNSZone.Lock()
+	prev := lastDNSZones[zone]
+	muDNSZone.Unlock()
+	if prev != nil {
+		z, err = transferCtx(ctx, c, zone, prev)
+		source = "ixfr"
+	}
+	if prev == nil || (err != nil && ctx.Err() == nil) {
+		z, err = transferCtx(ctx, c, zone, nil)
+		source = "axfr"
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	muDNSZone.Lock()
+	lastDNSZones[zone] = z
+	muDNSZone.Unlock()
+	return z, source, nil
+}
+
+// transferCtx ejecuta c.Transfer con el timeout del paso "axfr". Si ctx se
+// cancela antes, retorna sin esperar a que termine la transferencia.
+func transferCtx(ctx context.Context, c *ddns.Client, zone string, prev *ddns.Zone) (*ddns.Zone, error) {
+	sctx, cancel := stepContext(ctx, "axfr")
+	defer cancel()
+	type result struct {
+		z   *ddns.Zone
+		err error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		z, err := c.Transfer(zone, prev)
+		ch <- result{z, err}
+	}()
+	select {
+	case r := <-ch:
+		return r.z, stepErr(ctx, sctx, "axfr", r.err)
+	case <-sctx.Done():
+		return nil, stepErr(ctx, sctx, "axfr", sctx.Err())
+	}
+}
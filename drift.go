@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+)
+
+// ============================== Drift & Reconcile ===========================
+
+// Drift es una discrepancia entre las instancias registradas, la zona DNS y
+// las VMs del backend.
+type Drift struct {
+	Kind       string `json:"kind"`                  // Tipo de discrepancia (drift*)
+	Host       string `json:"host"`                  // FQDN afectado
+	IP         string `json:"ip,omitempty"`          // IP de la instancia o del registro
+	InstanceID string `json:"instance_id,omitempty"` // Instancia registrada, si la hay
+	VM         string `json:"vm,omitempty"`          // VM del backend, si la hay
+	Detail     string `json:"detail"`                // Descripción legible
+	Fix        string `json:"fix,omitempty"`         // Corrección de /reconcile; vacío = solo informativo
+}
+
+// Tipos de discrepancia.
+const (
+	driftVMMissing      = "vm_missing"      // Instancia sin VM
+	driftRecordMissing  = "record_missing"  // Instancia sin registro A
+	driftRecordMismatch = "record_mismatch" // Registro A con otra IP que la instancia
+	driftOrphanRecord   = "orphan_record"   // Registro A sin instancia ni VM
+	driftUnregisteredVM = "unregistered_vm" // VM con registro A pero sin instancia
+	driftUntrackedVM    = "untracked_vm"    // VM sin instancia ni registro A
+)
+
+// Correcciones que aplica POST /reconcile.
+const (
+	fixMarkLost     = "mark_lost"     // Marcar la instancia como lost
+	fixDeleteRecord = "delete_record" // Eliminar el registro A (y su PTR)
+	fixAdopt        = "adopt"         // Registrar la VM como instancia
+)
+
+// ReconcileResult es el resultado de aplicar la corrección de un Drift.
+type ReconcileResult struct {
+	Drift
+	Applied bool   `json:"applied"`         // true si la corrección se aplicó
+	Error   string `json:"error,omitempty"` // Error al aplicarla, si lo hubo
+}
+
+// busyStates estados con una operación en curso: sus discrepancias son
+// transitorias y no se informan.
+var busyStates = []string{instPreparing, instPublishing, instDestroying}
+
+// computeDrift compara las instancias, los registros A de la zona y las VMs.
+// infra son las IPs de infraestructura (reservas sin host), cuyos registros
+// no se consideran huérfanos.
+func computeDrift(list []Instance, recs []DNSDirectRecord, vms []VMStatus, infra map[string]bool) []Drift {
+	recsByHost := map[string][]string{}
+	for _, r := range recs {
+		h := strings.ToLower(r.FQDN)
+		recsByHost[h] = append(recsByHost[h], r.IP)
+	}
+	vmByName := map[string]VMStatus{}
+	for _, vm := range vms {
+		vmByName[strings.ToLower(vm.Name)] = vm
+	}
+	hosts := map[string]bool{}
+	vmNames := map[string]bool{}
+	out := []Drift{}
+
+	for _, it := range list {
+		h := strings.ToLower(it.Host)
+		name := strings.ToLower(it.VM)
+		hosts[h] = true
+		vmNames[name] = true
+		if slices.Contains(busyStates, it.State) || it.State == instLost || it.State == instAborted {
+			continue
+		}
+		d := Drift{Host: it.Host, IP: it.IP, InstanceID: it.ID}
+		if vm, ok := vmByName[name]; ok {
+			d.VM = vm.Name
+		} else {
+			d.Kind, d.Fix = driftVMMissing, fixMarkLost
+			d.Detail = fmt.Sprintf("la VM %s no existe en el backend (instancia %s)", it.VM, it.State)
+			out = append(out, d)
+		}
+		ips, ok := recsByHost[h]
+		switch {
+		case !ok:
+			d.Kind, d.Fix = driftRecordMissing, ""
+			d.Detail = "la zona DNS no tiene registro A para el host"
+			out = append(out, d)
+		case !slices.Contains(ips, it.IP):
+			d.Kind, d.Fix = driftRecordMismatch, ""
+			d.Detail = fmt.Sprintf("el registro A apunta a %s y la instancia tiene %s", strings.Join(ips, ", "), it.IP)
+			out = append(out, d)
+		}
+	}
+
+	for _, r := range recs {
+		h := strings.ToLower(r.FQDN)
+		if hosts[h] || infra[r.IP] || strings.EqualFold(r.FQDN, dnsZone) || isInfraVM(vmNameOf(r.FQDN)) {
+			continue
+		}
+		d := Drift{Host: r.FQDN, IP: r.IP}
+		name := strings.ToLower(vmNameOf(r.FQDN))
+		if vm, ok := vmByName[name]; ok && !vmNames[name] {
+			vmNames[name] = true
+			d.Kind, d.Fix, d.VM = driftUnregisteredVM, fixAdopt, vm.Name
+			d.Detail = fmt.Sprintf("la VM %s (%s) tiene registro A pero no está registrada como instancia", vm.Name, vm.State)
+		} else {
+			d.Kind, d.Fix = driftOrphanRecord, fixDeleteRecord
+			d.Detail = "registro A sin instancia ni VM"
+		}
+		out = append(out, d)
+	}
+
+	for _, vm := range vms {
+		if vmNames[strings.ToLower(vm.Name)] || isInfraVM(vm.Name) {
+			continue
+		}
+		out = append(out, Drift{
+			Kind:   driftUntrackedVM,
+			Host:   vm.Name + "." + dnsZone,
+			VM:     vm.Name,
+			Detail: fmt.Sprintf("la VM %s (%s) no tiene instancia ni registro A", vm.Name, vm.State),
+		})
+	}
+
+	sort.SliceStable(out, func(i, j int) bool { return out[i].Host < out[j].Host })
+	return out
+}
+
+// vmNameOf deriva el nombre de la VM del FQDN (primera etiqueta). Se guarda
+// en Instance.VM al registrar la instancia; hostFree impide que dos
+// instancias compartan la VM.
+func vmNameOf(fqdn string) string {
+	return strings.SplitN(fqdn, ".", 2)[0]
+}
+
+// isInfraVM indica si name es una de las VMs de infraestructura (infraVMs).
+func isInfraVM(name string) bool {
+	return slices.ContainsFunc(infraVMs, func(v string) bool { return strings.EqualFold(v, name) })
+}
+
+// detectDrift lee las tres fuentes y calcula las discrepancias. Falla si
+// alguna no responde: un informe parcial reportaría falsos huérfanos.
+func detectDrift(ctx context.Context) ([]Drift, error) {
+	list, err := store.ListInstances()
+	if err != nil {
+		return nil, err
+	}
+	recs, _, err := readDNSZone(ctx, dnsZone)
+	if err != nil {
+		return nil, dnsZoneError(err)
+	}
+	vms, err := prov.ListVMs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("listando VMs: %w", err)
+	}
+	infra, err := infraIPs()
+	if err != nil {
+		return nil, err
+	}
+	return computeDrift(list, directARecords(recs), vms, infra), nil
+}
+
+// applyFix aplica la corrección de d.
+func applyFix(ctx context.Context, d Drift) error {
+	switch d.Fix {
+	case fixMarkLost:
+		_, err := transitionInstance(byID(d.InstanceID), []string{instPrepared, instRunning, instFailed}, instLost, func(it *Instance) {
+			it.Error = d.Detail
+		})
+		return err
+	case fixDeleteRecord:
+		if err := newRFC2136Updater().DeleteHost(d.Host, d.IP); err != nil {
+			return err
+		}
+		addDNSLog(ctx, "DELETE", d.Host, d.IP)
+		return nil
+	case fixAdopt:
+		state := instPrepared
+		if checkHealth(ctx, d.IP, d.Host) == nil {
+			state = instRunning
+		}
+		_, err := adoptInstance(ctx, d.Host, d.IP, state)
+		return err
+	}
+	return fmt.Errorf("%s no tiene corrección automática", d.Kind)
+}
+
+// handleDrift maneja GET /drift: informe de discrepancias entre las instancias,
+// la zona DNS y las VMs. Los usuarios que no son admin solo ven las de las
+// instancias de su tenant. Acepta el filtro opcional ?kind=.
+func handleDrift(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	drift, err := detectDrift(r.Context())
+	if err != nil {
+		writeAPIError(w, toAPIError(err, "detectando drift"))
+		return
+	}
+	scope, err := scopeFor(r.Context())
+	if err != nil {
+		writeAPIError(w, toAPIError(err, "leyendo instancias"))
+		return
+	}
+	kind := r.URL.Query().Get("kind")
+	drift = slices.DeleteFunc(drift, func(d Drift) bool {
+		return kind != "" && d.Kind != kind || !scope.instance(d.InstanceID)
+	})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(drift)
+}
+
+// handleReconcile maneja POST /reconcile: recalcula el drift y aplica las
+// correcciones automáticas. ?fix= limita las correcciones (ej:
+// "mark_lost,adopt"; por defecto todas) y ?dry_run=1 solo informa qué haría.
+// Responde con el resultado de cada discrepancia corregible.
+func handleReconcile(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	fixes := []string{fixMarkLost, fixDeleteRecord, fixAdopt}
+	if v := r.URL.Query().Get("fix"); v != "" {
+		fixes = nil
+		for _, f := range strings.Split(v, ",") {
+			f = strings.TrimSpace(f)
+			if f != fixMarkLost && f != fixDeleteRecord && f != fixAdopt {
+				writeError(w, codeBadRequest, fmt.Sprintf("corrección desconocida %q (use mark_lost, delete_record o adopt)", f))
+				return
+			}
+			fixes = append(fixes, f)
+		}
+	}
+	dryRun := r.URL.Query().Get("dry_run") == "1"
+	drift, err := detectDrift(r.Context())
+	if err != nil {
+		writeAPIError(w, toAPIError(err, "detectando drift"))
+		return
+	}
+	results := []ReconcileResult{}
+	for _, d := range drift {
+		if !slices.Contains(fixes, d.Fix) {
+			continue
+		}
+		res := ReconcileResult{Drift: d}
+		if !dryRun {
+			if err := applyFix(r.Context(), d); err != nil {
+				res.Error = err.Error()
+			} else {
+				res.Applied = true
+			}
+		}
+		results = append(results, res)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(results)
+}
@@ -0,0 +1,16 @@
+package main
+
+import "testing"
+
+func TestComputeDriftSkipsInfraVMs(t *testing.T) {
+	prev := infraVMs
+	infraVMs = []string{"DNS", "APACHE PLANTILLA"}
+	t.Cleanup(func() { infraVMs = prev })
+
+	recs := []DNSDirectRecord{{FQDN: "dns.grid.lab", IP: "192.168.56.30"}}
+	vms := []VMStatus{{Name: "DNS", State: "running"}, {Name: "APACHE PLANTILLA", State: "poweroff"}, {Name: "web9", State: "running"}}
+	drift := computeDrift(nil, recs, vms, map[string]bool{})
+	if len(drift) != 1 || drift[0].Kind != driftUntrackedVM || drift[0].VM != "web9" {
+		t.Errorf("computeDrift = %+v, quiero solo untracked_vm de web9", drift)
+	}
+}
@@ -1,3 +1,20 @@
 module computacion-nube-proyecto
 
-go 1.25.1
+go 1.26.0
+
+require (
+	github.com/miekg/dns v1.1.72
+	github.com/pkg/sftp v1.13.11
+	go.etcd.io/bbolt v1.5.0
+	golang.org/x/crypto v0.57.0
+	gopkg.in/yaml.v3 v3.0.1
+)
+
+require (
+	github.com/kr/fs v0.1.0 // indirect
+	golang.org/x/mod v0.31.0 // indirect
+	golang.org/x/net v0.58.0 // indirect
+	golang.org/x/sync v0.20.0 // indirect
+	golang.org/x/sys v0.48.0 // indirect
+	golang.org/x/tools v0.40.0 // indirect
+)
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ============================== Storage Integrity ===========================
+
+// errStoreCorrupt indica que un archivo de datos no se pudo decodificar. Mientras
+// haya un problema registrado las modificaciones quedan bloqueadas, para no
+// sobrescribir el inventario con una lista vacía o incompleta.
+var errStoreCorrupt = errors.New("almacenamiento corrupto")
+
+// StorageProblem es un archivo de datos corrupto detectado al leerlo.
+type StorageProblem struct {
+	Path       string `json:"path"`                 // Archivo afectado
+	Quarantine string `json:"quarantine,omitempty"` // Copia apartada del archivo corrupto
+	Detail     string `json:"detail"`               // Error de decodificación
+	DetectedAt string `json:"detected_at"`          // Cuándo se detectó (RFC3339)
+}
+
+// corruptError es el error que retorna una lectura sobre un archivo corrupto.
+type corruptError struct {
+	StorageProblem
+	Err error
+}
+
+// Error implementa la interfaz error.
+func (e *corruptError) Error() string {
+	if e.Quarantine != "" {
+		return fmt.Sprintf("%s corrupto (apartado en %s): %v", e.Path, e.Quarantine, e.Err)
+	}
+	return fmt.Sprintf("%s corrupto: %v", e.Path, e.Err)
+}
+
+// Unwrap expone el error de decodificación.
+func (e *corruptError) Unwrap() error { return e.Err }
+
+// Is permite comparar con errors.Is(err, errStoreCorrupt).
+func (e *corruptError) Is(target error) bool { return target == errStoreCorrupt }
+
+var (
+	muProblems      sync.Mutex                    // Protege storageProblems
+	storageProblems = map[string]StorageProblem{} // Problemas pendientes por archivo
+)
+
+// markCorrupt registra que path no se pudo decodificar y retorna el error
+// para la lectura. Si quarantine es true el archivo se renombra a
+// <path>.corrupt-<fecha> para conservarlo y que nada lo sobrescriba.
+func markCorrupt(path string, err error, quarantine bool) error {
+	p := StorageProblem{Path: path, Detail: err.Error(), DetectedAt: time.Now().UTC().Format(time.RFC3339)}
+	if quarantine {
+		dst := path + ".corrupt-" + time.Now().UTC().Format("20060102T150405Z")
+		if rerr := os.Rename(path, dst); rerr != nil {
+			fmt.Printf("Storage: no se pudo apartar %s: %v\n", path, rerr)
+		} else {
+			p.Quarantine = dst
+		}
+	}
+	return reportProblem(p, err)
+}
+
+// checkQuarantined detecta un archivo apartado por corrupto que nadie
+// restauró: si path no existe pero hay copias <path>.corrupt-*, leerlo como
+// vacío perdería el inventario. Retorna nil si no hay copias apartadas.
+func checkQuarantined(path string) error {
+	matches, _ := filepath.Glob(path + ".corrupt-*")
+	if len(matches) == 0 {
+		return nil
+	}
+	sort.Strings(matches)
+	p := StorageProblem{
+		Path:       path,
+		Quarantine: matches[len(matches)-1],
+		Detail:     "el archivo fue apartado por corrupto y no se restauró",
+		DetectedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	return reportProblem(p, errors.New(p.Detail))
+}
+
+// reportProblem registra p y lo informa por consola, salvo que el archivo ya
+// tuviera un problema registrado.
+func reportProblem(p StorageProblem, err error) error {
+	muProblems.Lock()
+	prev, seen := storageProblems[p.Path]
+	if seen {
+		// Se conserva la primera detección, que tiene el error original
+		p = prev
+	} else {
+		storageProblems[p.Path] = p
+	}
+	muProblems.Unlock()
+	if !seen {
+		fmt.Printf("Storage: %s corrupto, modificaciones bloqueadas: %s\n", p.Path, p.Detail)
+		if p.Quarantine != "" {
+			fmt.Printf("Storage: copia del archivo corrupto en %s\n", p.Quarantine)
+		}
+	}
+	return &corruptError{StorageProblem: p, Err: err}
+}
+
+// listProblems retorna los problemas pendientes ordenados por archivo.
+func listProblems() []StorageProblem {
+	muProblems.Lock()
+	defer muProblems.Unlock()
+	out := make([]StorageProblem, 0, len(storageProblems))
+	for _, p := range storageProblems {
+		out = append(out, p)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Path < out[j].Path })
+	return out
+}
+
+// checkWritable retorna un error si hay archivos corruptos pendientes.
+func checkWritable() error {
+	problems := listProblems()
+	if len(problems) == 0 {
+		return nil
+	}
+	p := problems[0]
+	return &corruptError{StorageProblem: p, Err: errors.New("modificaciones bloqueadas hasta restaurar el archivo")}
+}
+
+// checkIntegrity verifica al iniciar (o a pedido) que el almacenamiento y
+// ipam.json se puedan leer completos. Descarta los problemas anteriores y
+// vuelve a registrar los que sigan presentes; retorna los pendientes. Los
+// errores que no son de corrupción (ej: permisos) se retornan aparte.
+func checkIntegrity() ([]StorageProblem, error) {
+	muProblems.Lock()
+	storageProblems = map[string]StorageProblem{}
+	muProblems.Unlock()
+
+	var errs []error
+	if err := store.Check(); err != nil && !errors.Is(err, errStoreCorrupt) {
+		errs = append(errs, err)
+	}
+	muIPAM.Lock()
+	_, _, err := loadIPAM()
+	muIPAM.Unlock()
+	if err != nil && !errors.Is(err, errStoreCorrupt) {
+		errs = append(errs, err)
+	}
+	return listProblems(), errors.Join(errs...)
+}
+
+// printIntegrity informa el resultado de checkIntegrity por consola.
+func printIntegrity(problems []StorageProblem, err error) {
+	if err != nil {
+		fmt.Println("Storage: verificación de integridad incompleta:", err)
+	}
+	if len(problems) == 0 {
+		if err == nil {
+			fmt.Printf("Storage: integridad verificada (%s)\n", storageName)
+		}
+		return
+	}
+	fmt.Println("==================================================================")
+	fmt.Println(" ATENCIÓN: almacenamiento corrupto. Modificaciones bloqueadas.")
+	for _, p := range problems {
+		fmt.Printf("  - %s: %s\n", p.Path, p.Detail)
+		if p.Quarantine != "" {
+			fmt.Printf("    copia apartada: %s\n", p.Quarantine)
+		}
+	}
+	fmt.Println(" Restaure los archivos desde un respaldo (o elimine la copia")
+	fmt.Println(" apartada para empezar de cero) y use POST /storage/check.")
+	fmt.Println("==================================================================")
+}
+
+// guardedStore envuelve un Store y rechaza las modificaciones mientras haya
+// archivos corruptos pendientes.
+type guardedStore struct {
+	Store
+}
+
+// UpdateInstances verifica checkWritable antes de abrir la transacción.
+func (s guardedStore) UpdateInstances(fn func(tx InstanceTx) error) error {
+	if err := checkWritable(); err != nil {
+		return err
+	}
+	return s.Store.UpdateInstances(fn)
+}
+
+// AppendDNSLog verifica checkWritable antes de agregar la entrada.
+func (s guardedStore) AppendDNSLog(l DNSLog) error {
+	if err := checkWritable(); err != nil {
+		return err
+	}
+	return s.Store.AppendDNSLog(l)
+}
+
+// TrimDNSLogs verifica checkWritable antes de quitar las entradas.
+func (s guardedStore) TrimDNSLogs(upTo uint64) error {
+	if err := checkWritable(); err != nil {
+		return err
+	}
+	return s.Store.TrimDNSLogs(upTo)
+}
+
+// storageStatus es la respuesta de /storage.
+type storageStatus struct {
+	Storage  string           `json:"storage"`         // "bolt" o "json"
+	Writable bool             `json:"writable"`        // false si las modificaciones están bloqueadas
+	Problems []StorageProblem `json:"problems"`        // Archivos corruptos pendientes
+	Error    string           `json:"error,omitempty"` // Error de lectura ajeno a la corrupción
+}
+
+// handleStorage maneja GET /storage: estado de integridad del almacenamiento.
+func handleStorage(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	problems := listProblems()
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(storageStatus{Storage: storageName, Writable: len(problems) == 0, Problems: problems})
+}
+
+// handleStorageCheck maneja POST /storage/check: vuelve a verificar la
+// integridad. Si el operador restauró los archivos, desbloquea las
+// modificaciones sin reiniciar el servidor.
+func handleStorageCheck(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	problems, err := checkIntegrity()
+	printIntegrity(problems, err)
+	st := storageStatus{Storage: storageName, Writable: len(problems) == 0, Problems: problems}
+	if err != nil {
+		st.Error = err.Error()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(st)
+}
@@ -0,0 +1,610 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================== IPAM ========================================
+
+// IPPool es un rango de IPs asignables a instancias.
+type IPPool struct {
+	Name    string   `json:"name"`              // Nombre del pool
+	CIDR    string   `json:"cidr"`              // Red del pool (ej: 192.168.56.0/24)
+	Exclude []string `json:"exclude,omitempty"` // IPs o rangos "desde-hasta" que nunca se asignan
+}
+
+// IPReservation aparta una IP: para un host concreto o, sin host, para que
+// nadie la use (ej: el servidor DNS).
+type IPReservation struct {
+	IP   string `json:"ip"`             // Dirección reservada
+	Host string `json:"host,omitempty"` // FQDN que recibe la IP; vacío = IP bloqueada
+	Note string `json:"note,omitempty"` // Motivo de la reserva
+}
+
+// IPLease es una IP asignada a una instancia desde /prepare hasta /destroy.
+type IPLease struct {
+	IP         string   `json:"ip"`                 // Dirección asignada
+	Pool       string   `json:"pool"`               // Pool del que salió
+	Host       string   `json:"host"`               // FQDN de la instancia
+	InstanceID string   `json:"instance_id"`        // ID de la instancia
+	LeasedAt   string   `json:"leased_at"`          // Fecha de asignación en formato RFC3339
+	Skipped    []string `json:"skipped,omitempty"`  // IPs descartadas por conflicto al asignar, con el motivo
+	Warnings   []string `json:"warnings,omitempty"` // Verificaciones que no se pudieron hacer al asignar
+}
+
+// ipamData es el contenido de ipam.json.
+type ipamData struct {
+	Pools        []IPPool        `json:"pools"`
+	Reservations []IPReservation `json:"reservations"`
+	Leases       []IPLease       `json:"leases"`
+}
+
+// PoolUsage resume la ocupación de un pool para GET /ipam.
+type PoolUsage struct {
+	IPPool
+	Size        int     `json:"size"`        // IPs asignables (sin las excluidas)
+	Reserved    int     `json:"reserved"`    // Reservas dentro del pool
+	Leased      int     `json:"leased"`      // Leases activos dentro del pool
+	Free        int     `json:"free"`        // IPs libres para nuevas instancias
+	Utilization float64 `json:"utilization"` // Porcentaje ocupado (reservas + leases)
+}
+
+// ipRange rango inclusivo de direcciones.
+type ipRange struct{ from, to netip.Addr }
+
+// contains indica si addr está dentro del rango.
+func (r ipRange) contains(addr netip.Addr) bool {
+	return addr.Compare(r.from) >= 0 && addr.Compare(r.to) <= 0
+}
+
+// poolRange es un IPPool ya interpretado.
+type poolRange struct {
+	pool    IPPool
+	prefix  netip.Prefix
+	exclude []ipRange
+}
+
+// muIPAM protege ipam.json. Si se toma junto con mu, mu va primero.
+var muIPAM sync.Mutex
+
+var (
+	// errIPReserved indica que la IP está reservada o asignada a otro host.
+	errIPReserved = errors.New("la IP está reservada o asignada a otro host")
+	// errIPConflict indica que la IP reservada para el host está en uso fuera del IPAM.
+	errIPConflict = errors.New("la IP reservada para el host está en uso")
+)
+
+// parsePool interpreta el CIDR y las exclusiones del pool.
+func parsePool(p IPPool) (poolRange, error) {
+	prefix, err := netip.ParsePrefix(p.CIDR)
+	if err != nil || !prefix.Addr().Is4() {
+		return poolRange{}, fmt.Errorf("pool %s: CIDR IPv4 inválido %q", p.Name, p.CIDR)
+	}
+	pr := poolRange{pool: p, prefix: prefix.Masked()}
+	for _, ex := range p.Exclude {
+		from, to, isRange := strings.Cut(ex, "-")
+		r := ipRange{}
+		if r.from, err = netip.ParseAddr(strings.TrimSpace(from)); err != nil {
+			return poolRange{}, fmt.Errorf("pool %s: exclusión inválida %q", p.Name, ex)
+		}
+		r.to = r.from
+		if isRange {
+			if r.to, err = netip.ParseAddr(strings.TrimSpace(to)); err != nil || r.to.Less(r.from) {
+				return poolRange{}, fmt.Errorf("pool %s: exclusión inválida %q", p.Name, ex)
+			}
+		}
+		pr.exclude = append(pr.exclude, r)
+	}
+	return pr, nil
+}
+
+// assignable indica si addr pertenece al pool y no está excluida. La
+// dirección de red y la de broadcast nunca son asignables.
+func (pr poolRange) assignable(addr netip.Addr) bool {
+	if !pr.prefix.Contains(addr) || addr == pr.prefix.Addr() || addr == lastAddr(pr.prefix) {
+		return false
+	}
+	for _, r := range pr.exclude {
+		if r.contains(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// each recorre en orden las IPs asignables del pool hasta que fn retorne false.
+func (pr poolRange) each(fn func(netip.Addr) bool) {
+	for addr := pr.prefix.Addr(); pr.prefix.Contains(addr); addr = addr.Next() {
+		if pr.assignable(addr) && !fn(addr) {
+			return
+		}
+	}
+}
+
+// lastAddr retorna la última dirección (broadcast) de la red.
+func lastAddr(p netip.Prefix) netip.Addr {
+	a := p.Addr().As4()
+	bits := p.Bits()
+	for i := range a {
+		switch {
+		case bits >= 8:
+			bits -= 8
+		default:
+			a[i] |= byte(0xff >> bits)
+			bits = 0
+		}
+	}
+	return netip.AddrFrom4(a)
+}
+
+// Primeras IPs de la red host-only que no se asignan a instancias: las
+// reservedHosts primeras quedan fuera del pool (adaptador del anfitrión y
+// servicios) y la siguiente se reserva por convención del laboratorio.
+const reservedHosts = 9
+
+// defaultIPAM retorna el contenido inicial de ipam.json, derivado de
+// hostOnlyCIDR: un pool con la red host-only sin sus primeras IPs y las IPs
+// de infraestructura reservadas (incluido el servidor DNS).
+func defaultIPAM() (*ipamData, error) {
+	network, err := hostOnlyPrefix()
+	if err != nil {
+		return nil, err
+	}
+	first := network.Addr().Next()
+	last := first
+	for range reservedHosts - 1 {
+		last = last.Next()
+	}
+	return &ipamData{
+		Pools: []IPPool{
+			{Name: "hostonly", CIDR: network.String(), Exclude: []string{first.String() + "-" + last.String()}},
+		},
+		Reservations: []IPReservation{
+			{IP: last.Next().String(), Note: "reservada por convención del laboratorio"},
+			{IP: dnsServerIP, Note: "servidor DNS"},
+		},
+		Leases: []IPLease{},
+	}, nil
+}
+
+// loadIPAM carga ipam.json, o el contenido inicial si el archivo no existe.
+// Los pools se validan en cada carga, por lo que editar el archivo surte
+// efecto sin reiniciar el servidor.
+func loadIPAM() (*ipamData, []poolRange, error) {
+	d, err := defaultIPAM()
+	if err != nil {
+		return nil, nil, err
+	}
+	b, err := os.ReadFile(ipamPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, nil, err
+	}
+	if os.IsNotExist(err) {
+		// Sin ipam.json se usan los pools por defecto, salvo que se haya
+		// apartado por corrupto: los leases perdidos provocarían IPs duplicadas
+		if err := checkQuarantined(ipamPath); err != nil {
+			return nil, nil, err
+		}
+	}
+	if err == nil {
+		d = &ipamData{}
+		if err := json.Unmarshal(b, d); err != nil {
+			return nil, nil, markCorrupt(ipamPath, err, true)
+		}
+	}
+	pools := make([]poolRange, 0, len(d.Pools))
+	for _, p := range d.Pools {
+		pr, err := parsePool(p)
+		if err != nil {
+			return nil, nil, err
+		}
+		pools = append(pools, pr)
+	}
+	return d, pools, nil
+}
+
+// saveIPAM guarda ipam.json usando escritura atómica. Falla si hay archivos
+// corruptos pendientes (ver checkWritable).
+func saveIPAM(d *ipamData) error {
+	if err := checkWritable(); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := ipamPath + ".tmp"
+	if err := os.WriteFile(tmp, append(b, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, ipamPath)
+}
+
+// poolOf retorna el pool que contiene addr como IP asignable.
+func poolOf(pools []poolRange, addr netip.Addr) (poolRange, bool) {
+	for _, pr := range pools {
+		if pr.assignable(addr) {
+			return pr, true
+		}
+	}
+	return poolRange{}, false
+}
+
+// pickIP elige la IP para fqdn sin modificar d: la reservada para el host o,
+// si no tiene, la primera de los pools que no esté asignada, reservada ni en
+// skip. Retorna también los conflictos de skip que se saltaron por el camino.
+func pickIP(d *ipamData, pools []poolRange, fqdn string, skip ipConflicts) (ip, pool string, skipped []string, err error) {
+	taken := make(map[string]bool, len(d.Leases)+len(d.Reservations))
+	for _, l := range d.Leases {
+		if !strings.EqualFold(l.Host, fqdn) {
+			taken[l.IP] = true
+		}
+	}
+	for _, r := range d.Reservations {
+		if r.Host == "" || !strings.EqualFold(r.Host, fqdn) {
+			taken[r.IP] = true
+			continue
+		}
+		if taken[r.IP] {
+			return "", "", nil, fmt.Errorf("%w: %s reservada para %s", errIPReserved, r.IP, fqdn)
+		}
+		if reason, ok := skip[r.IP]; ok {
+			return "", "", nil, fmt.Errorf("%w: %s reservada para %s: %s", errIPConflict, r.IP, fqdn, reason)
+		}
+		if addr, err := netip.ParseAddr(r.IP); err == nil {
+			if pr, ok := poolOf(pools, addr); ok {
+				pool = pr.pool.Name
+			}
+		}
+		return r.IP, pool, nil, nil
+	}
+	for _, pr := range pools {
+		pr.each(func(addr netip.Addr) bool {
+			a := addr.String()
+			if taken[a] {
+				return true
+			}
+			if reason, ok := skip[a]; ok {
+				skipped = append(skipped, a+": "+reason)
+				return true
+			}
+			ip, pool = a, pr.pool.Name
+			return false
+		})
+		if ip != "" {
+			return ip, pool, skipped, nil
+		}
+	}
+	names := make([]string, 0, len(pools))
+	for _, pr := range pools {
+		names = append(names, pr.pool.Name+" "+pr.pool.CIDR)
+	}
+	err = fmt.Errorf("%w en los pools: %s", errNoIPAvailable, strings.Join(names, ", "))
+	if len(skipped) > 0 {
+		err = fmt.Errorf("%w (descartadas por conflicto: %s)", err, strings.Join(skipped, "; "))
+	}
+	return "", "", skipped, err
+}
+
+// candidateIP retorna la IP que leaseIP asignaría a fqdn con los mismos
+// conflictos, sin reservarla.
+func candidateIP(fqdn string, skip ipConflicts) (string, error) {
+	muIPAM.Lock()
+	defer muIPAM.Unlock()
+	d, pools, err := loadIPAM()
+	if err != nil {
+		return "", err
+	}
+	ip, _, _, err := pickIP(d, pools, fqdn, skip)
+	return ip, err
+}
+
+// leaseIP asigna una IP a la instancia de fqdn y la guarda en ipam.json.
+// Si el host tiene una reserva se usa esa IP; si no, la primera libre de los
+// pools en orden. Las IPs de skip se descartan y quedan anotadas en el lease
+// junto con warnings. Si el host ya tenía un lease (de una instancia anterior
+// que no llegó a registrarse) se reutiliza su IP salvo que esté en skip.
+func leaseIP(fqdn, instanceID string, skip ipConflicts, warnings []string) (IPLease, error) {
+	muIPAM.Lock()
+	defer muIPAM.Unlock()
+	d, pools, err := loadIPAM()
+	if err != nil {
+		return IPLease{}, err
+	}
+	for i := range d.Leases {
+		if strings.EqualFold(d.Leases[i].Host, fqdn) {
+			if _, conflict := skip[d.Leases[i].IP]; conflict {
+				d.Leases = slices.Delete(d.Leases, i, i+1)
+				break
+			}
+			d.Leases[i].InstanceID = instanceID
+			return d.Leases[i], saveIPAM(d)
+		}
+	}
+	ip, pool, skipped, err := pickIP(d, pools, fqdn, skip)
+	if err != nil {
+		return IPLease{}, err
+	}
+	l := IPLease{
+		IP:         ip,
+		Pool:       pool,
+		Host:       fqdn,
+		InstanceID: instanceID,
+		LeasedAt:   time.Now().UTC().Format(time.RFC3339),
+		Skipped:    skipped,
+		Warnings:   warnings,
+	}
+	d.Leases = append(d.Leases, l)
+	if err := saveIPAM(d); err != nil {
+		return IPLease{}, err
+	}
+	return l, nil
+}
+
+// claimIP registra el lease de una IP concreta para la instancia (ej: al
+// adoptar una VM existente). Falla si la IP está asignada o reservada para
+// otro host.
+func claimIP(fqdn, instanceID, ip string) error {
+	muIPAM.Lock()
+	defer muIPAM.Unlock()
+	d, pools, err := loadIPAM()
+	if err != nil {
+		return err
+	}
+	for _, l := range d.Leases {
+		if l.IP == ip && !strings.EqualFold(l.Host, fqdn) {
+			return fmt.Errorf("%w: %s asignada a %s", errIPReserved, ip, l.Host)
+		}
+	}
+	for _, r := range d.Reservations {
+		if r.IP == ip && !strings.EqualFold(r.Host, fqdn) {
+			return fmt.Errorf("%w: %s reservada", errIPReserved, ip)
+		}
+	}
+	l := IPLease{IP: ip, Host: fqdn, InstanceID: instanceID, LeasedAt: time.Now().UTC().Format(time.RFC3339)}
+	if addr, err := netip.ParseAddr(ip); err == nil {
+		if pr, ok := poolOf(pools, addr); ok {
+			l.Pool = pr.pool.Name
+		}
+	}
+	d.Leases = slices.DeleteFunc(d.Leases, func(o IPLease) bool { return strings.EqualFold(o.Host, fqdn) })
+	d.Leases = append(d.Leases, l)
+	return saveIPAM(d)
+}
+
+// infraIPs retorna las IPs reservadas sin host (infraestructura, ej: el DNS).
+func infraIPs() (map[string]bool, error) {
+	muIPAM.Lock()
+	defer muIPAM.Unlock()
+	d, _, err := loadIPAM()
+	if err != nil {
+		return nil, err
+	}
+	out := make(map[string]bool, len(d.Reservations))
+	for _, r := range d.Reservations {
+		if r.Host == "" {
+			out[r.IP] = true
+		}
+	}
+	return out, nil
+}
+
+// releaseIP libera el lease de la instancia. No falla si no tenía uno.
+func releaseIP(instanceID string) error {
+	muIPAM.Lock()
+	defer muIPAM.Unlock()
+	d, _, err := loadIPAM()
+	if err != nil {
+		return err
+	}
+	n := len(d.Leases)
+	d.Leases = slices.DeleteFunc(d.Leases, func(l IPLease) bool { return l.InstanceID == instanceID })
+	if len(d.Leases) == n {
+		return nil
+	}
+	return saveIPAM(d)
+}
+
+// syncLeases alinea los leases con las instancias al iniciar: crea el lease de
+// las instancias que no tienen uno (registradas antes del IPAM) y libera los
+// de instancias que ya no existen (ej: el servidor se detuvo entre asignar
+// la IP y guardar la instancia).
+func syncLeases() error {
+	list, err := store.ListInstances()
+	if err != nil {
+		return err
+	}
+	muIPAM.Lock()
+	defer muIPAM.Unlock()
+	d, pools, err := loadIPAM()
+	if err != nil {
+		return err
+	}
+	_, statErr := os.Stat(ipamPath)
+	changed := os.IsNotExist(statErr)
+	ids := make(map[string]bool, len(list))
+	for _, it := range list {
+		ids[it.ID] = true
+		if slices.ContainsFunc(d.Leases, func(l IPLease) bool { return l.InstanceID == it.ID }) {
+			continue
+		}
+		l := IPLease{IP: it.IP, Host: it.Host, InstanceID: it.ID, LeasedAt: it.CreatedAt}
+		if addr, err := netip.ParseAddr(it.IP); err == nil {
+			if pr, ok := poolOf(pools, addr); ok {
+				l.Pool = pr.pool.Name
+			}
+		}
+		d.Leases = append(d.Leases, l)
+		changed = true
+	}
+	n := len(d.Leases)
+	d.Leases = slices.DeleteFunc(d.Leases, func(l IPLease) bool { return !ids[l.InstanceID] })
+	if !changed && len(d.Leases) == n {
+		return nil
+	}
+	return saveIPAM(d)
+}
+
+// ipamUsage calcula la ocupación de cada pool.
+func ipamUsage(d *ipamData, pools []poolRange) []PoolUsage {
+	out := make([]PoolUsage, 0, len(pools))
+	for _, pr := range pools {
+		u := PoolUsage{IPPool: pr.pool}
+		pr.each(func(netip.Addr) bool { u.Size++; return true })
+		inPool := func(ip string) bool {
+			addr, err := netip.ParseAddr(ip)
+			return err == nil && pr.assignable(addr)
+		}
+		busy := map[string]bool{}
+		for _, r := range d.Reservations {
+			if inPool(r.IP) {
+				u.Reserved++
+				busy[r.IP] = true
+			}
+		}
+		for _, l := range d.Leases {
+			if inPool(l.IP) {
+				u.Leased++
+				busy[l.IP] = true
+			}
+		}
+		u.Free = u.Size - len(busy)
+		if u.Size > 0 {
+			u.Utilization = float64(len(busy)*10000/u.Size) / 100
+		}
+		out = append(out, u)
+	}
+	return out
+}
+
+// addReservation agrega o reemplaza la reserva de una IP. La IP debe ser
+// asignable en algún pool y no estar asignada a otro host.
+func addReservation(r IPReservation) (IPReservation, error) {
+	addr, err := netip.ParseAddr(strings.TrimSpace(r.IP))
+	if err != nil || !addr.Is4() {
+		return IPReservation{}, fmt.Errorf("IP inválida: %q", r.IP)
+	}
+	r.IP = addr.String()
+	r.Host = strings.TrimSpace(r.Host)
+	if r.Host != "" && !strings.Contains(r.Host, ".") {
+		r.Host = r.Host + "." + dnsZone
+	}
+	muIPAM.Lock()
+	defer muIPAM.Unlock()
+	d, pools, err := loadIPAM()
+	if err != nil {
+		return IPReservation{}, err
+	}
+	if _, ok := poolOf(pools, addr); !ok {
+		return IPReservation{}, fmt.Errorf("la IP %s no es asignable en ningún pool", r.IP)
+	}
+	for _, l := range d.Leases {
+		if l.IP == r.IP && !strings.EqualFold(l.Host, r.Host) {
+			return IPReservation{}, fmt.Errorf("%w: %s asignada a %s", errIPReserved, r.IP, l.Host)
+		}
+	}
+	for _, other := range d.Reservations {
+		if other.IP != r.IP && r.Host != "" && strings.EqualFold(other.Host, r.Host) {
+			return IPReservation{}, fmt.Errorf("%w: %s ya tiene reservada %s", errIPReserved, r.Host, other.IP)
+		}
+	}
+	d.Reservations = slices.DeleteFunc(d.Reservations, func(o IPReservation) bool { return o.IP == r.IP })
+	d.Reservations = append(d.Reservations, r)
+	return r, saveIPAM(d)
+}
+
+// removeReservation elimina la reserva de la IP.
+func removeReservation(ip string) error {
+	muIPAM.Lock()
+	defer muIPAM.Unlock()
+	d, _, err := loadIPAM()
+	if err != nil {
+		return err
+	}
+	n := len(d.Reservations)
+	d.Reservations = slices.DeleteFunc(d.Reservations, func(r IPReservation) bool { return r.IP == ip })
+	if len(d.Reservations) == n {
+		return os.ErrNotExist
+	}
+	return saveIPAM(d)
+}
+
+// handleIPAM maneja GET /ipam: ocupación de cada pool, reservas y leases.
+// Los usuarios que no son admin solo ven las reservas y los leases de las
+// instancias de su tenant; la ocupación de los pools es la total.
+func handleIPAM(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	scope, err := scopeFor(r.Context())
+	if err != nil {
+		writeAPIError(w, toAPIError(err, "leyendo instancias"))
+		return
+	}
+	muIPAM.Lock()
+	d, pools, err := loadIPAM()
+	muIPAM.Unlock()
+	if err != nil {
+		writeAPIError(w, toAPIError(err, "leyendo IPAM"))
+		return
+	}
+	usage := ipamUsage(d, pools)
+	d.Reservations = slices.DeleteFunc(d.Reservations, func(res IPReservation) bool { return !scope.host(res.Host) })
+	d.Leases = slices.DeleteFunc(d.Leases, func(l IPLease) bool { return !scope.instance(l.InstanceID) })
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]any{
+		"pools":        usage,
+		"reservations": d.Reservations,
+		"leases":       d.Leases,
+	})
+}
+
+// handleIPAMReservations maneja POST /ipam/reservations (cuerpo JSON con ip,
+// host y note) para reservar una IP y DELETE /ipam/reservations/{ip} para
+// liberarla.
+func handleIPAMReservations(w http.ResponseWriter, r *http.Request) {
+	ip := strings.Trim(strings.TrimPrefix(r.URL.Path, "/ipam/reservations"), "/")
+	switch {
+	case r.Method == http.MethodPost && ip == "":
+		var res IPReservation
+		if err := json.NewDecoder(r.Body).Decode(&res); err != nil {
+			writeError(w, codeBadRequest, "JSON inválido: "+err.Error())
+			return
+		}
+		res, err := addReservation(res)
+		switch {
+		case errors.Is(err, errIPReserved), errors.Is(err, errStoreCorrupt):
+			writeAPIError(w, toAPIError(err, "reservando IP"))
+			return
+		case err != nil:
+			writeError(w, codeBadRequest, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(res)
+	case r.Method == http.MethodDelete && ip != "":
+		err := removeReservation(ip)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			writeError(w, codeNotFound, "reserva no encontrada")
+			return
+		case err != nil:
+			writeAPIError(w, toAPIError(err, "liberando reserva"))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, codeMethodNotAllowed, "method not allowed")
+	}
+}
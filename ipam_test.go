@@ -0,0 +1,62 @@
+package main
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestDefaultIPAMFromCIDR(t *testing.T) {
+	prevCIDR, prevDNS := hostOnlyCIDR, dnsServerIP
+	t.Cleanup(func() { hostOnlyCIDR, dnsServerIP = prevCIDR, prevDNS })
+
+	tests := []struct {
+		cidr, dns string
+		pool      IPPool
+		reserved  []string
+		ipPrefix  string
+	}{
+		{
+			cidr: "192.168.56.0/24", dns: "192.168.56.5",
+			pool:     IPPool{Name: "hostonly", CIDR: "192.168.56.0/24", Exclude: []string{"192.168.56.1-192.168.56.9"}},
+			reserved: []string{"192.168.56.10", "192.168.56.5"},
+			ipPrefix: "IP_PREFIX=192.168.56.",
+		},
+		{
+			cidr: "10.20.7.33/16", dns: "10.20.0.2",
+			pool:     IPPool{Name: "hostonly", CIDR: "10.20.0.0/16", Exclude: []string{"10.20.0.1-10.20.0.9"}},
+			reserved: []string{"10.20.0.10", "10.20.0.2"},
+			ipPrefix: "IP_PREFIX=10.20.",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.cidr, func(t *testing.T) {
+			hostOnlyCIDR, dnsServerIP = tt.cidr, tt.dns
+			d, err := defaultIPAM()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(d.Pools) != 1 || d.Pools[0].CIDR != tt.pool.CIDR || !slices.Equal(d.Pools[0].Exclude, tt.pool.Exclude) {
+				t.Errorf("Pools = %+v, quiero %+v", d.Pools, tt.pool)
+			}
+			var reserved []string
+			for _, r := range d.Reservations {
+				reserved = append(reserved, r.IP)
+			}
+			if !slices.Equal(reserved, tt.reserved) {
+				t.Errorf("Reservations = %q, quiero %q", reserved, tt.reserved)
+			}
+			env, err := scriptEnv()
+			if err != nil || !slices.Contains(env, tt.ipPrefix) {
+				t.Errorf("scriptEnv = %q, %v; quiero %s", env, err, tt.ipPrefix)
+			}
+		})
+	}
+
+	hostOnlyCIDR = "no-es-una-red"
+	if _, err := defaultIPAM(); err == nil {
+		t.Error("defaultIPAM aceptó un CIDR inválido")
+	}
+	if _, err := scriptEnv(); err == nil {
+		t.Error("scriptEnv aceptó un CIDR inválido")
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"runtime"
+	"slices"
+	"strings"
+
+	"computacion-nube-proyecto/vbox"
+)
+
+// ============================== IP Conflict Checks ==========================
+
+// ipConflicts IPs en uso fuera del IPAM, con el motivo de cada una.
+type ipConflicts map[string]string
+
+// maxProbes cantidad máxima de IPs candidatas que se sondean por asignación.
+const maxProbes = 10
+
+// macRe reconoce una MAC en la salida de arp (08:00:27:.. o 08-00-27-..).
+var macRe = regexp.MustCompile(`(?i)\b([0-9a-f]{2}[:-]){5}[0-9a-f]{2}\b`)
+
+// collectIPConflicts consulta las fuentes configuradas en ipChecks y retorna
+// las IPs que ya están en uso: registros A de otros hosts en la zona DNS y
+// reservas del DHCP host-only de VirtualBox. Si una fuente no responde se
+// informa en warnings y la asignación sigue sin esa verificación.
+func collectIPConflicts(ctx context.Context, fqdn string) (ipConflicts, []string) {
+	conflicts := ipConflicts{}
+	var warnings []string
+	if slices.Contains(ipChecks, "dns") {
+		recs, _, err := readDNSZone(ctx, dnsZone)
+		if err != nil {
+			warnings = append(warnings, "zona DNS no verificada: "+err.Error())
+		}
+		for _, rec := range directARecords(recs) {
+			if !strings.EqualFold(rec.FQDN, fqdn) {
+				conflicts[rec.IP] = "registro A de " + rec.FQDN + " en la zona DNS"
+			}
+		}
+	}
+	if slices.Contains(ipChecks, "dhcp") {
+		vb := vbox.New()
+		vb.Timeout = stepTimeout("vboxmanage")
+		res, err := vb.DHCPReservations(ctx, hostOnlyNetwork())
+		if err != nil {
+			warnings = append(warnings, "reservas DHCP no verificadas: "+err.Error())
+		}
+		for _, r := range res {
+			if _, ok := conflicts[r.IP]; !ok {
+				conflicts[r.IP] = "reserva DHCP de la MAC " + r.MAC
+			}
+		}
+	}
+	return conflicts, warnings
+}
+
+// probeIP verifica si algo responde en ip: primero con ping y, si no hay
+// respuesta (ej: firewall que descarta ICMP), buscando la IP en la tabla ARP.
+// Retorna el motivo si la IP está en uso o "" si está libre.
+func probeIP(ctx context.Context, ip string) (string, error) {
+	ctx, cancel := stepContext(ctx, "probe")
+	defer cancel()
+	args := []string{"-c", "1", "-W", "1", ip}
+	if runtime.GOOS == "windows" {
+		args = []string{"-n", "1", "-w", "1000", ip}
+	}
+	out, err := commandContext(ctx, "ping", args...).CombinedOutput()
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	// Windows retorna 0 también con "host de destino inaccesible": se exige TTL
+	if err == nil && strings.Contains(strings.ToLower(string(out)), "ttl=") {
+		return "responde a ping", nil
+	}
+	out, err = commandContext(ctx, "arp", "-a", ip).CombinedOutput()
+	if err != nil && len(out) == 0 {
+		return "", fmt.Errorf("arp: %w", err)
+	}
+	if mac := macRe.FindString(string(out)); mac != "" {
+		return "presente en la tabla ARP (" + mac + ")", nil
+	}
+	return "", nil
+}
+
+// probeCandidates sondea las IPs que el IPAM asignaría a fqdn y agrega a
+// conflicts las que responden, hasta encontrar una libre o agotar maxProbes.
+// Retorna la IP aceptada (libre o que no se pudo sondear), o "" si no hubo
+// sondeo o no se encontró ninguna libre.
+func probeCandidates(ctx context.Context, fqdn string, conflicts ipConflicts) (string, []string) {
+	if !slices.Contains(ipChecks, "ping") {
+		return "", nil
+	}
+	for range maxProbes {
+		ip, err := candidateIP(fqdn, conflicts)
+		if err != nil || ip == "" {
+			return "", nil
+		}
+		reason, err := probeIP(ctx, ip)
+		if err != nil {
+			return ip, []string{"sondeo de " + ip + " no realizado: " + err.Error()}
+		}
+		if reason == "" {
+			return ip, nil
+		}
+		conflicts[ip] = reason
+	}
+	return "", []string{fmt.Sprintf("sondeo detenido tras %d IPs en uso", maxProbes)}
+}
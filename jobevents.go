@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ============================== Job Events (SSE) ============================
+
+// maxStreamEvents cantidad de eventos que se guardan por job para reenviarlos
+// a clientes que se conectan tarde o se reconectan.
+const maxStreamEvents = 5000
+
+// jobEvent es un evento de progreso de un job.
+type jobEvent struct {
+	Seq  int    // Número de secuencia (se envía como id SSE)
+	Type string // "log", "step" o "done"
+	Data string // Línea de salida, nombre del paso o job final en JSON
+}
+
+// jobStream guarda el historial de eventos de un job y sus suscriptores.
+type jobStream struct {
+	events []jobEvent
+	next   int // Secuencia del próximo evento
+	subs   map[chan jobEvent]struct{}
+	done   bool
+}
+
+// newJobStream crea un stream vacío.
+func newJobStream() *jobStream {
+	return &jobStream{subs: make(map[chan jobEvent]struct{})}
+}
+
+// publish agrega un evento al stream del job y lo envía a los suscriptores.
+// Un suscriptor que no consume a tiempo se desconecta; al reconectarse
+// recupera los eventos perdidos con Last-Event-ID.
+func (m *jobManager) publish(id, typ, data string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st, ok := m.streams[id]
+	if !ok || st.done {
+		return
+	}
+	ev := jobEvent{Seq: st.next, Type: typ, Data: data}
+	st.next++
+	st.events = append(st.events, ev)
+	if len(st.events) > maxStreamEvents {
+		st.events = st.events[len(st.events)-maxStreamEvents:]
+	}
+	for ch := range st.subs {
+		select {
+		case ch <- ev:
+		default:
+			delete(st.subs, ch)
+			close(ch)
+		}
+	}
+}
+
+// finish publica el evento "done" con el estado final del job y cierra el stream.
+func (m *jobManager) finish(id string) {
+	j, ok := m.Get(id)
+	if !ok {
+		return
+	}
+	auditJob(j)
+	data, _ := json.Marshal(j)
+	m.publish(id, "done", string(data))
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if st, ok := m.streams[id]; ok {
+		st.done = true
+		for ch := range st.subs {
+			close(ch)
+		}
+		st.subs = nil
+	}
+}
+
+// subscribe retorna los eventos con secuencia mayor a after y, si el job sigue
+// en curso, un canal con los eventos siguientes. cancel libera la suscripción.
+// live es false si el job ya no tiene stream en memoria (ej: tras un reinicio).
+func (m *jobManager) subscribe(id string, after int) (backlog []jobEvent, ch chan jobEvent, cancel func(), live bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	st, ok := m.streams[id]
+	if !ok {
+		return nil, nil, func() {}, false
+	}
+	for _, ev := range st.events {
+		if ev.Seq > after {
+			backlog = append(backlog, ev)
+		}
+	}
+	if st.done {
+		return backlog, nil, func() {}, true
+	}
+	ch = make(chan jobEvent, 256)
+	st.subs[ch] = struct{}{}
+	cancel = func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if _, ok := st.subs[ch]; ok {
+			delete(st.subs, ch)
+			close(ch)
+		}
+	}
+	return backlog, ch, cancel, true
+}
+
+// writeSSE escribe un evento en formato text/event-stream.
+func writeSSE(w http.ResponseWriter, ev jobEvent) {
+	fmt.Fprintf(w, "id: %d\nevent: %s\n", ev.Seq, ev.Type)
+	for _, ln := range strings.Split(ev.Data, "\n") {
+		fmt.Fprintf(w, "data: %s\n", ln)
+	}
+	fmt.Fprint(w, "\n")
+}
+
+// handleJobEvents maneja GET /jobs/{id}/events: transmite por Server-Sent
+// Events los pasos ("step"), cada línea de salida ("log") y el job final
+// ("done"). Soporta reconexión con el header Last-Event-ID.
+func handleJobEvents(w http.ResponseWriter, r *http.Request, id string) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, codeInternal, "streaming no soportado")
+		return
+	}
+	j, found := jobs.Get(id)
+	if !found || !canAccess(r.Context(), j.Tenant) {
+		writeError(w, codeNotFound, "job no encontrado")
+		return
+	}
+	after := -1
+	if v := r.Header.Get("Last-Event-ID"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			after = n
+		}
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	backlog, ch, cancel, live := jobs.subscribe(id, after)
+	defer cancel()
+	if !live {
+		// Sin historial en memoria: sólo se informa el estado final conocido
+		data, _ := json.Marshal(j)
+		writeSSE(w, jobEvent{Seq: 0, Type: "done", Data: string(data)})
+		flusher.Flush()
+		return
+	}
+	for _, ev := range backlog {
+		writeSSE(w, ev)
+	}
+	flusher.Flush()
+	if ch == nil {
+		return
+	}
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case ev, ok := <-ch:
+			if !ok {
+				return
+			}
+			writeSSE(w, ev)
+			flusher.Flush()
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
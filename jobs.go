@@ -0,0 +1,491 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================== Jobs ========================================
+
+// Job representa una operación asíncrona (prepare, publish o destroy) y su progreso.
+type Job struct {
+	ID         string    `json:"id"`                    // Identificador del job
+	Kind       string    `json:"kind"`                  // "prepare", "publish" o "destroy"
+	Host       string    `json:"host"`                  // FQDN sobre el que opera
+	Actor      string    `json:"actor,omitempty"`       // Quién lo pidió (ver AuditEvent)
+	Tenant     string    `json:"tenant,omitempty"`      // Tenant de quien lo pidió
+	State      string    `json:"state"`                 // queued, running, succeeded, failed o canceled
+	Step       string    `json:"step,omitempty"`        // Paso actual en curso
+	CreatedAt  string    `json:"created_at"`            // Encolado (RFC3339)
+	StartedAt  string    `json:"started_at,omitempty"`  // Inicio de ejecución (RFC3339)
+	FinishedAt string    `json:"finished_at,omitempty"` // Fin de ejecución (RFC3339)
+	Error      string    `json:"error,omitempty"`       // Mensaje de error si falló
+	ErrorInfo  *APIError `json:"error_info,omitempty"`  // Error tipado (código, paso, reintentable)
+	Result     any       `json:"result,omitempty"`      // Resultado de la operación si terminó bien
+
+	started time.Time // Inicio de ejecución con precisión completa, para la auditoría
+}
+
+// Estados de un Job.
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+	jobCanceled  = "canceled"
+)
+
+// errJobFinished indica que el job ya terminó y no se puede cancelar.
+var errJobFinished = errors.New("el job ya terminó")
+
+// errQueueFull indica que la cola de jobs está llena.
+var errQueueFull = errors.New("cola de jobs llena")
+
+// maxJobs cantidad de jobs terminados que se conservan en jobsPath.
+const maxJobs = 500
+
+// jobTask es el trabajo a ejecutar para un job. step permite informar el paso
+// actual; la salida de los comandos se escribe en la salida del contexto.
+type jobTask func(ctx context.Context, step func(string)) (any, error)
+
+// jobManager mantiene los jobs en memoria, los persiste en jobsPath y los
+// ejecuta con un conjunto fijo de workers.
+type jobManager struct {
+	mu      sync.Mutex
+	jobs    map[string]*Job
+	streams map[string]*jobStream         // Eventos en vivo de cada job (sólo en memoria)
+	cancels map[string]context.CancelFunc // Cancelación de los jobs en ejecución
+	aborts  map[string]func(error)        // Limpieza de los jobs cancelados en cola
+	queue   chan queuedJob
+}
+
+// queuedJob asocia un job encolado con su tarea.
+type queuedJob struct {
+	id   string
+	task jobTask
+}
+
+// jobs administrador global de jobs, inicializado en main.
+var jobs *jobManager
+
+// jobIDKey clave de contexto para el ID del job en ejecución.
+type jobIDKey struct{}
+
+// jobIDFrom retorna el ID del job que ejecuta la operación, o "" si no
+// corre dentro de un job.
+func jobIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(jobIDKey{}).(string)
+	return id
+}
+
+// newJobManager carga los jobs persistidos y arranca workers goroutines.
+// Los jobs que quedaron en cola o en ejecución al detenerse el servidor se
+// marcan como fallidos, porque su tarea no sobrevive al reinicio.
+func newJobManager(workers int) (*jobManager, error) {
+	m := &jobManager{
+		jobs:    make(map[string]*Job),
+		streams: make(map[string]*jobStream),
+		cancels: make(map[string]context.CancelFunc),
+		aborts:  make(map[string]func(error)),
+		queue:   make(chan queuedJob, 100),
+	}
+	// Con jobs.json corrupto se arranca sin historial y en solo lectura
+	// (ver markCorrupt): los jobs no son necesarios para leer el inventario.
+	// Un jobs.json restaurado se carga al reiniciar
+	list, err := loadJobs()
+	if err != nil && !errors.Is(err, errStoreCorrupt) {
+		return nil, err
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	interrupted := false
+	for i := range list {
+		j := list[i]
+		if j.State == jobQueued || j.State == jobRunning {
+			j.State = jobFailed
+			j.Error = "interrumpido por reinicio del servidor"
+			j.FinishedAt = now
+			interrupted = true
+			auditJob(j)
+		}
+		m.jobs[j.ID] = &j
+	}
+	if interrupted {
+		// En solo lectura quedan en memoria hasta el próximo guardado
+		if err := m.persistLocked(); errors.Is(err, errStoreCorrupt) {
+			fmt.Println("Jobs interrumpidos sin guardar:", err)
+		} else if err != nil {
+			return nil, err
+		}
+	}
+	for i := 0; i < workers; i++ {
+		go m.worker()
+	}
+	return m, nil
+}
+
+// Submit crea un job en estado queued y lo encola para su ejecución. ctx es
+// el de la petición: solo se usa para registrar el actor. abort, si no es
+// nil, se llama si el job se cancela antes de empezar a ejecutarse. Si la
+// cola está llena no espera: descarta el job y retorna errQueueFull.
+func (m *jobManager) Submit(ctx context.Context, kind, host string, task jobTask, abort func(error)) (Job, error) {
+	j := &Job{
+		ID:        fmt.Sprintf("%s-%d", kind, time.Now().UnixNano()),
+		Kind:      kind,
+		Host:      host,
+		Actor:     actorFrom(ctx),
+		Tenant:    tenantFrom(ctx),
+		State:     jobQueued,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+	}
+	m.mu.Lock()
+	m.jobs[j.ID] = j
+	m.streams[j.ID] = newJobStream()
+	if abort != nil {
+		m.aborts[j.ID] = abort
+	}
+	if err := m.persistLocked(); err != nil {
+		m.mu.Unlock()
+		return Job{}, err
+	}
+	select {
+	case m.queue <- queuedJob{id: j.ID, task: task}:
+	default:
+		// No bloquear la petición: el job se descarta y el cliente reintenta
+		delete(m.jobs, j.ID)
+		delete(m.streams, j.ID)
+		delete(m.aborts, j.ID)
+		if err := m.persistLocked(); err != nil {
+			fmt.Println("Error guardando jobs:", err)
+		}
+		m.mu.Unlock()
+		return Job{}, fmt.Errorf("%w (%d jobs en espera)", errQueueFull, cap(m.queue))
+	}
+	snapshot := *j
+	m.mu.Unlock()
+	return snapshot, nil
+}
+
+// Get retorna una copia del job con ese ID.
+func (m *jobManager) Get(id string) (Job, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[id]
+	if !ok {
+		return Job{}, false
+	}
+	return *j, true
+}
+
+// List retorna copias de todos los jobs, más recientes primero.
+func (m *jobManager) List() []Job {
+	m.mu.Lock()
+	out := make([]Job, 0, len(m.jobs))
+	for _, j := range m.jobs {
+		out = append(out, *j)
+	}
+	m.mu.Unlock()
+	sort.Slice(out, func(a, b int) bool {
+		if out[a].CreatedAt != out[b].CreatedAt {
+			return out[a].CreatedAt > out[b].CreatedAt
+		}
+		return out[a].ID > out[b].ID
+	})
+	return out
+}
+
+// worker ejecuta los jobs de la cola uno a la vez. La salida de cada job se
+// publica línea por línea como eventos "log" y también se copia a la consola.
+// Los jobs cancelados mientras esperaban en la cola se descartan.
+func (m *jobManager) worker() {
+	for q := range m.queue {
+		ctx, cancel := context.WithCancel(context.Background())
+		m.mu.Lock()
+		j, ok := m.jobs[q.id]
+		if !ok || j.State != jobQueued {
+			m.mu.Unlock()
+			cancel()
+			continue
+		}
+		j.State = jobRunning
+		j.started = time.Now()
+		j.StartedAt = j.started.UTC().Format(time.RFC3339)
+		m.cancels[q.id] = cancel
+		delete(m.aborts, q.id)
+		if err := m.persistLocked(); err != nil {
+			fmt.Println("Error guardando jobs:", err)
+		}
+		m.mu.Unlock()
+
+		lw := newLineWriter(func(line string) { m.publish(q.id, "log", line) })
+		ctx = withOutput(ctx, io.MultiWriter(os.Stdout, lw))
+		ctx = context.WithValue(ctx, jobIDKey{}, q.id)
+		result, err := q.task(ctx, func(step string) {
+			m.update(q.id, func(j *Job) { j.Step = step })
+			m.publish(q.id, "step", step)
+		})
+		lw.Flush()
+		canceled := ctx.Err() != nil
+		m.mu.Lock()
+		delete(m.cancels, q.id)
+		m.mu.Unlock()
+		cancel()
+		m.update(q.id, func(j *Job) {
+			j.FinishedAt = time.Now().UTC().Format(time.RFC3339)
+			switch {
+			case canceled:
+				if err == nil {
+					err = context.Canceled
+				}
+				j.State = jobCanceled
+				j.Error = err.Error()
+				j.ErrorInfo = toAPIError(err, j.Step)
+			case err != nil:
+				j.State = jobFailed
+				j.Error = err.Error()
+				j.ErrorInfo = toAPIError(err, j.Step)
+			default:
+				j.State = jobSucceeded
+				j.Result = result
+			}
+		})
+		m.finish(q.id)
+	}
+}
+
+// Cancel cancela un job. Si está en cola se marca cancelado de inmediato; si
+// está en ejecución se cancela su contexto (matando los comandos en curso) y
+// el worker lo marca cancelado al terminar. Retorna errJobFinished si ya
+// había terminado.
+func (m *jobManager) Cancel(id string) (Job, error) {
+	m.mu.Lock()
+	j, ok := m.jobs[id]
+	if !ok {
+		m.mu.Unlock()
+		return Job{}, os.ErrNotExist
+	}
+	switch j.State {
+	case jobQueued:
+		err := context.Canceled
+		j.State = jobCanceled
+		j.FinishedAt = time.Now().UTC().Format(time.RFC3339)
+		j.Error = err.Error()
+		j.ErrorInfo = toAPIError(err, "")
+		if perr := m.persistLocked(); perr != nil {
+			fmt.Println("Error guardando jobs:", perr)
+		}
+		snapshot := *j
+		abort := m.aborts[id]
+		delete(m.aborts, id)
+		m.mu.Unlock()
+		if abort != nil {
+			abort(err)
+		}
+		m.finish(id)
+		return snapshot, nil
+	case jobRunning:
+		if cancel, ok := m.cancels[id]; ok {
+			cancel()
+		}
+		snapshot := *j
+		m.mu.Unlock()
+		m.publish(id, "step", "cancelando")
+		return snapshot, nil
+	}
+	snapshot := *j
+	m.mu.Unlock()
+	return snapshot, errJobFinished
+}
+
+// update aplica fn al job y persiste el cambio. Los errores de escritura se
+// informan por consola: el estado en memoria sigue siendo válido.
+func (m *jobManager) update(id string, fn func(*Job)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	j, ok := m.jobs[id]
+	if !ok {
+		return
+	}
+	fn(j)
+	if err := m.persistLocked(); err != nil {
+		fmt.Println("Error guardando jobs:", err)
+	}
+}
+
+// persistLocked guarda los jobs en disco descartando los terminados más
+// antiguos si se supera maxJobs. Debe llamarse con m.mu tomado.
+func (m *jobManager) persistLocked() error {
+	list := make([]Job, 0, len(m.jobs))
+	for _, j := range m.jobs {
+		list = append(list, *j)
+	}
+	sort.Slice(list, func(a, b int) bool {
+		if list[a].CreatedAt != list[b].CreatedAt {
+			return list[a].CreatedAt < list[b].CreatedAt
+		}
+		return list[a].ID < list[b].ID
+	})
+	if extra := len(list) - maxJobs; extra > 0 {
+		kept := list[:0]
+		for _, j := range list {
+			done := j.State == jobSucceeded || j.State == jobFailed || j.State == jobCanceled
+			if extra > 0 && done {
+				delete(m.jobs, j.ID)
+				delete(m.streams, j.ID)
+				extra--
+				continue
+			}
+			kept = append(kept, j)
+		}
+		list = kept
+	}
+	return saveJobs(list)
+}
+
+// ============================== Jobs Storage ================================
+
+// loadJobs carga los jobs desde el archivo JSON. Retorna una lista vacía si
+// el archivo no existe, salvo que haya sido apartado por corrupto. Si no se
+// puede decodificar se aparta con markCorrupt.
+func loadJobs() ([]Job, error) {
+	b, err := os.ReadFile(jobsPath)
+	if os.IsNotExist(err) {
+		if err := checkQuarantined(jobsPath); err != nil {
+			return nil, err
+		}
+		return []Job{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []Job
+	if err := json.Unmarshal(b, &list); err != nil {
+		return nil, markCorrupt(jobsPath, err, true)
+	}
+	return list, nil
+}
+
+// saveJobs guarda los jobs en el archivo JSON usando escritura atómica.
+func saveJobs(list []Job) error {
+	if err := checkWritable(); err != nil {
+		return err
+	}
+	tmp := jobsPath + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(list); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, jobsPath)
+}
+
+// ============================== Jobs Handlers ===============================
+
+// writeJobAccepted responde 202 Accepted con el job encolado y su URL de estado.
+func writeJobAccepted(w http.ResponseWriter, j Job) {
+	if aw, ok := w.(*auditWriter); ok {
+		aw.ev.JobID = j.ID
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Location", "/jobs/"+j.ID)
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]any{
+		"job_id":     j.ID,
+		"status_url": "/jobs/" + j.ID,
+		"job":        j,
+	})
+}
+
+// handleJobs maneja GET /jobs para listar los jobs del tenant del usuario
+// (todos para un admin), más recientes primero. Acepta los filtros
+// opcionales ?state= y ?kind=.
+func handleJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	state := r.URL.Query().Get("state")
+	kind := r.URL.Query().Get("kind")
+	out := []Job{}
+	for _, j := range jobs.List() {
+		if canAccess(r.Context(), j.Tenant) && (state == "" || j.State == state) && (kind == "" || j.Kind == kind) {
+			out = append(out, j)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+// handleJob maneja GET /jobs/{id} para consultar el estado de un job,
+// GET /jobs/{id}/events para seguir su progreso en vivo (SSE) y
+// POST /jobs/{id}/cancel para cancelarlo.
+func handleJob(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimSpace(strings.TrimPrefix(r.URL.Path, "/jobs/"))
+	if rest, ok := strings.CutSuffix(id, "/cancel"); ok {
+		handleJobCancel(w, r, rest)
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeError(w, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	if id == "" {
+		handleJobs(w, r)
+		return
+	}
+	if rest, ok := strings.CutSuffix(id, "/events"); ok {
+		handleJobEvents(w, r, rest)
+		return
+	}
+	j, ok := jobs.Get(id)
+	if !ok || !canAccess(r.Context(), j.Tenant) {
+		writeError(w, codeNotFound, "job no encontrado")
+		return
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(j)
+}
+
+// handleJobCancel maneja POST /jobs/{id}/cancel. Responde 202 con el job si
+// la cancelación se aplicó o quedó en curso, o 409 si el job ya terminó.
+func handleJobCancel(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodPost {
+		writeError(w, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	if j, ok := jobs.Get(id); ok && !canAccess(r.Context(), j.Tenant) {
+		writeError(w, codeNotFound, "job no encontrado")
+		return
+	}
+	j, err := jobs.Cancel(id)
+	if errors.Is(err, os.ErrNotExist) {
+		writeError(w, codeNotFound, "job no encontrado")
+		return
+	}
+	if errors.Is(err, errJobFinished) {
+		writeError(w, codeConflict, "el job ya terminó ("+j.State+")")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(j)
+}
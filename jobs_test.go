@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSubmitQueueFull(t *testing.T) {
+	prev := jobsPath
+	jobsPath = filepath.Join(t.TempDir(), "jobs.json")
+	t.Cleanup(func() { jobsPath = prev })
+
+	// Sin workers: la cola de 100 se llena y el siguiente Submit no espera
+	m, err := newJobManager(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := func(context.Context, func(string)) (any, error) { return nil, nil }
+	for i := 0; i < cap(m.queue); i++ {
+		if _, err := m.Submit(context.Background(), "prepare", "web.grid.lab", task, nil); err != nil {
+			t.Fatalf("Submit %d: %v", i, err)
+		}
+	}
+	_, err = m.Submit(context.Background(), "prepare", "web.grid.lab", task, nil)
+	if !errors.Is(err, errQueueFull) {
+		t.Fatalf("Submit con la cola llena = %v, quiero errQueueFull", err)
+	}
+	if e := toAPIError(err, ""); e.Code != codeUnavailable || e.Status != 503 || !e.Retryable {
+		t.Errorf("APIError = %s %d retryable %v, quiero %s 503 reintentable", e.Code, e.Status, e.Retryable, codeUnavailable)
+	}
+	if n := len(m.List()); n != cap(m.queue) {
+		t.Errorf("%d jobs registrados, quiero %d (el rechazado no queda)", n, cap(m.queue))
+	}
+	loaded, err := loadJobs()
+	if err != nil || len(loaded) != cap(m.queue) {
+		t.Errorf("jobs persistidos = %d, %v; quiero %d", len(loaded), err, cap(m.queue))
+	}
+}
+
+func TestLoadJobsCorrupt(t *testing.T) {
+	prev := jobsPath
+	jobsPath = filepath.Join(t.TempDir(), "jobs.json")
+	resetProblems := func() {
+		muProblems.Lock()
+		storageProblems = map[string]StorageProblem{}
+		muProblems.Unlock()
+	}
+	t.Cleanup(func() {
+		jobsPath = prev
+		resetProblems()
+	})
+	if err := os.WriteFile(jobsPath, []byte(`[{"id": "prepare-1", "state": `), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// El servidor arranca sin historial, con el archivo apartado y en solo lectura
+	m, err := newJobManager(0)
+	if err != nil {
+		t.Fatalf("newJobManager = %v", err)
+	}
+	if n := len(m.List()); n != 0 {
+		t.Errorf("%d jobs cargados, quiero 0", n)
+	}
+	if _, err := os.Stat(jobsPath); !os.IsNotExist(err) {
+		t.Errorf("%s no se apartó", jobsPath)
+	}
+	if copies, _ := filepath.Glob(jobsPath + ".corrupt-*"); len(copies) != 1 {
+		t.Errorf("copias apartadas = %v, quiero 1", copies)
+	}
+	task := func(context.Context, func(string)) (any, error) { return nil, nil }
+	if _, err := m.Submit(context.Background(), "prepare", "web.grid.lab", task, nil); !errors.Is(err, errStoreCorrupt) {
+		t.Errorf("Submit en solo lectura = %v, quiero errStoreCorrupt", err)
+	}
+	if _, err := os.Stat(jobsPath); !os.IsNotExist(err) {
+		t.Error("se escribió jobs.json en solo lectura")
+	}
+
+	// Al reiniciar sin restaurar el archivo sigue en solo lectura
+	resetProblems()
+	if _, err := newJobManager(0); err != nil {
+		t.Fatalf("newJobManager tras reiniciar = %v", err)
+	}
+	if err := checkWritable(); !errors.Is(err, errStoreCorrupt) {
+		t.Errorf("checkWritable = %v, quiero errStoreCorrupt por la copia apartada", err)
+	}
+}
@@ -0,0 +1,185 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// ============================== Known Hosts =================================
+
+// knownHostsPath está en el formato de OpenSSH, así que los scripts batch lo
+// usan también (UserKnownHostsFile). La primera conexión a un host registra
+// su clave (trust on first use) y las siguientes la verifican. Al crear o
+// eliminar una VM se olvida la clave de su IP, porque la próxima VM con esa
+// IP tendrá otra.
+
+// hostKeyError indica que la clave de un host no coincide con la registrada.
+type hostKeyError struct {
+	Host string // Host normalizado (ej: 192.168.56.21 o [host]:2222)
+	Want string // Huella registrada
+	Got  string // Huella recibida
+}
+
+// Error implementa la interfaz error.
+func (e *hostKeyError) Error() string {
+	return fmt.Sprintf("la clave SSH de %s cambió (registrada %s, recibida %s); si el host se reinstaló, quite la entrada con DELETE /known-hosts/%s",
+		e.Host, e.Want, e.Got, e.Host)
+}
+
+// Unwrap permite reconocerlo con errors.Is(err, errHostKeyMismatch).
+func (e *hostKeyError) Unwrap() error { return errHostKeyMismatch }
+
+// muKnownHosts serializa las lecturas y escrituras de knownHostsPath.
+var muKnownHosts sync.Mutex
+
+// checkHostKey es el HostKeyCallback de las conexiones SSH. Se vuelve a leer
+// knownHostsPath en cada conexión para ver también lo que agregan los scripts.
+func checkHostKey(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	muKnownHosts.Lock()
+	defer muKnownHosts.Unlock()
+	cb, err := knownhosts.New(knownHostsPath)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return fmt.Errorf("%s: %w", knownHostsPath, err)
+	default:
+		err := cb(hostname, remote, key)
+		var ke *knownhosts.KeyError
+		if !errors.As(err, &ke) {
+			return err
+		}
+		if len(ke.Want) > 0 {
+			return &hostKeyError{
+				Host: knownhosts.Normalize(hostname),
+				Want: ssh.FingerprintSHA256(ke.Want[0].Key),
+				Got:  ssh.FingerprintSHA256(key),
+			}
+		}
+	}
+	f, err := os.OpenFile(knownHostsPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := fmt.Fprintln(f, knownhosts.Line([]string{hostname}, key)); err != nil {
+		return err
+	}
+	fmt.Printf("SSH: clave de %s registrada (%s)\n", knownhosts.Normalize(hostname), ssh.FingerprintSHA256(key))
+	return nil
+}
+
+// knownHost es una entrada de knownHostsPath.
+type knownHost struct {
+	Hosts       []string `json:"hosts"`
+	Type        string   `json:"type"`
+	Fingerprint string   `json:"fingerprint"`
+}
+
+// readKnownHosts retorna las entradas de knownHostsPath y las líneas del
+// archivo a las que corresponden.
+func readKnownHosts() ([]knownHost, [][]byte, error) {
+	b, err := os.ReadFile(knownHostsPath)
+	if os.IsNotExist(err) {
+		return []knownHost{}, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	list := []knownHost{}
+	lines := bytes.SplitAfter(b, []byte("\n"))
+	for _, ln := range lines {
+		_, hosts, key, _, _, err := ssh.ParseKnownHosts(ln)
+		if err != nil {
+			list = append(list, knownHost{}) // Comentario o línea vacía
+			continue
+		}
+		list = append(list, knownHost{Hosts: hosts, Type: key.Type(), Fingerprint: ssh.FingerprintSHA256(key)})
+	}
+	return list, lines, nil
+}
+
+// forgetHostKey quita de knownHostsPath las claves del host (ej: una IP que
+// pasa a otra VM). Retorna os.ErrNotExist si no había ninguna.
+func forgetHostKey(host string) error {
+	muKnownHosts.Lock()
+	defer muKnownHosts.Unlock()
+	host = knownhosts.Normalize(host)
+	list, lines, err := readKnownHosts()
+	if err != nil {
+		return err
+	}
+	var out bytes.Buffer
+	found := false
+	for i, h := range list {
+		if h.Type != "" && len(h.Hosts) == 1 && knownhosts.Normalize(h.Hosts[0]) == host {
+			found = true
+			continue
+		}
+		out.Write(lines[i])
+	}
+	if !found {
+		return os.ErrNotExist
+	}
+	tmp := knownHostsPath + ".tmp"
+	if err := os.WriteFile(tmp, out.Bytes(), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, knownHostsPath)
+}
+
+// forgetVMHostKey olvida la clave de la IP de una VM que se crea o elimina.
+// No encontrarla es lo normal.
+func forgetVMHostKey(ip string) {
+	if err := forgetHostKey(net.JoinHostPort(ip, strconv.Itoa(sshPort))); err != nil && !os.IsNotExist(err) {
+		fmt.Println("Advertencia: known_hosts:", err)
+	}
+}
+
+// handleKnownHosts maneja GET /known-hosts (claves registradas) y
+// DELETE /known-hosts/{host} (olvidar la clave de un host para volver a
+// registrarla en la próxima conexión).
+func handleKnownHosts(w http.ResponseWriter, r *http.Request) {
+	host := strings.Trim(strings.TrimPrefix(r.URL.Path, "/known-hosts"), "/")
+	switch {
+	case r.Method == http.MethodGet && host == "":
+		muKnownHosts.Lock()
+		list, _, err := readKnownHosts()
+		muKnownHosts.Unlock()
+		if err != nil {
+			writeError(w, codeInternal, "error leyendo known_hosts: "+err.Error())
+			return
+		}
+		out := []knownHost{}
+		for _, h := range list {
+			if h.Type != "" {
+				out = append(out, h)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(out)
+	case r.Method == http.MethodDelete && host != "":
+		auditTarget(r.Context(), host, "")
+		err := forgetHostKey(host)
+		switch {
+		case os.IsNotExist(err):
+			writeError(w, codeNotFound, "no hay claves registradas para "+host)
+		case err != nil:
+			writeError(w, codeInternal, "error guardando known_hosts: "+err.Error())
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	default:
+		writeError(w, codeMethodNotAllowed, "method not allowed")
+	}
+}
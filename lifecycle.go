@@ -0,0 +1,400 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================== Instance Lifecycle ==========================
+
+// Estados de una Instance. Una instancia se registra en preparing al iniciar
+// /prepare y queda en el Store hasta que /destroy la elimina.
+const (
+	instPreparing  = "preparing"  // Creando VM y DNS
+	instPrepared   = "prepared"   // VM y DNS listos, sin contenido publicado
+	instPublishing = "publishing" // Desplegando contenido
+	instRunning    = "running"    // Sitio publicado y respondiendo
+	instFailed     = "failed"     // La última operación falló (ver Error)
+	instDestroying = "destroying" // Eliminando VM y DNS
+	instLost       = "lost"       // La VM ya no existe en el backend (ver /drift)
+	instAborted    = "aborted"    // La preparación falló: no hay VM lista para publicar (ver Error)
+)
+
+// Estados desde los que se permite cada operación.
+var (
+	publishableStates  = []string{instPrepared, instRunning, instFailed}
+	destroyableStates  = []string{instPrepared, instRunning, instFailed, instLost, instAborted}
+	interruptedStates  = []string{instPreparing, instPublishing, instDestroying}
+	errInstanceExists  = errors.New("ya existe una instancia para el host")
+	errHostInUse       = errors.New("host en uso")
+	errInstanceBusy    = errors.New("la instancia no admite la operación en su estado actual")
+	errInstanceMissing = errors.New("instancia no encontrada")
+	errInvalidHost     = errors.New("hostname inválido")
+)
+
+// hostLabelRe es una etiqueta DNS según RFC 1123: letras, dígitos y guiones,
+// sin guion al principio ni al final, hasta 63 caracteres.
+var hostLabelRe = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
+// normalizeHost valida el hostname pedido y retorna su FQDN en minúsculas. Un
+// nombre sin punto se completa con dnsZone; el resto debe estar bajo dnsZone.
+// El nombre llega a VBoxManage, a los scripts batch y a comandos remotos, así
+// que solo se aceptan etiquetas RFC 1123.
+func normalizeHost(name string) (string, error) {
+	fqdn := strings.ToLower(strings.TrimSuffix(strings.TrimSpace(name), "."))
+	if !strings.Contains(fqdn, ".") {
+		fqdn = fqdn + "." + dnsZone
+	}
+	sub, ok := strings.CutSuffix(fqdn, "."+strings.ToLower(dnsZone))
+	if !ok || sub == "" {
+		return "", fmt.Errorf("%w: %q no está bajo %s", errInvalidHost, name, dnsZone)
+	}
+	if len(fqdn) > 253 {
+		return "", fmt.Errorf("%w: %q supera 253 caracteres", errInvalidHost, name)
+	}
+	for _, label := range strings.Split(sub, ".") {
+		if !hostLabelRe.MatchString(label) {
+			return "", fmt.Errorf("%w: %q (etiqueta %q: use letras, dígitos y guiones)", errInvalidHost, name, label)
+		}
+	}
+	return fqdn, nil
+}
+
+// muAlloc serializa la elección y el registro de IPs e instancias nuevas
+// (verificación de host y cuota, lease e inserción en el Store). Las
+// consultas a la zona DNS, al DHCP y el sondeo de la red se hacen antes, sin
+// tomarlo.
+var muAlloc sync.Mutex
+
+// createInstance registra una instancia nueva en estado preparing con una IP
+// del IPAM, a nombre del tenant del usuario de ctx. Antes de asignarla se
+// descartan las IPs en uso según ipChecks (registros A, reservas DHCP,
+// ping/ARP). Como la asignación se serializa con muAlloc, dos /prepare
+// concurrentes nunca reciben la misma IP ni superan juntos la cuota.
+// Retorna también los avisos de las verificaciones que no se pudieron hacer.
+func createInstance(ctx context.Context, fqdn string) (Instance, []string, error) {
+	tenant := tenantFrom(ctx)
+	if err := checkNewInstance(ctx, fqdn, tenant); err != nil {
+		return Instance{}, nil, err
+	}
+	conflicts, warnings := collectIPConflicts(ctx, fqdn)
+	probed, w := probeCandidates(ctx, fqdn, conflicts)
+	warnings = append(warnings, w...)
+	for i := 0; ; i++ {
+		if i == maxProbes {
+			warnings = append(warnings, fmt.Sprintf("sondeo detenido tras %d IPs en uso", maxProbes))
+			probed = ""
+		}
+		inst, next, err := allocInstance(ctx, fqdn, tenant, conflicts, probed, warnings)
+		if err != nil || next == "" {
+			return inst, warnings, err
+		}
+		// Otra asignación tomó la IP sondeada: se sondea la nueva candidata
+		// sin muAlloc y se vuelve a intentar
+		reason, err := probeIP(ctx, next)
+		switch {
+		case err != nil:
+			warnings = append(warnings, "sondeo de "+next+" no realizado: "+err.Error())
+			probed = next
+		case reason != "":
+			conflicts[next] = reason
+		default:
+			probed = next
+		}
+	}
+}
+
+// checkNewInstance verifica que fqdn no tenga instancia y que el tenant no
+// haya alcanzado su cuota.
+func checkNewInstance(ctx context.Context, fqdn, tenant string) error {
+	return store.ViewInstances(func(tx InstanceTx) error {
+		if err := hostFree(ctx, tx, fqdn); err != nil {
+			return err
+		}
+		return checkInstanceQuota(tx, tenant)
+	})
+}
+
+// allocInstance vuelve a verificar host y cuota, toma el lease y registra la
+// instancia, todo con muAlloc. probed es la IP que el sondeo encontró libre
+// ("" si no hubo sondeo): si el IPAM elegiría otra, no asigna nada y la
+// retorna en next para que se sondee.
+func allocInstance(ctx context.Context, fqdn, tenant string, conflicts ipConflicts, probed string, warnings []string) (inst Instance, next string, err error) {
+	muAlloc.Lock()
+	defer muAlloc.Unlock()
+	if err := checkNewInstance(ctx, fqdn, tenant); err != nil {
+		return Instance{}, "", err
+	}
+	if probed != "" {
+		ip, err := candidateIP(fqdn, conflicts)
+		if err != nil {
+			return Instance{}, "", fmt.Errorf("asignación de IP: %w", err)
+		}
+		if ip != "" && ip != probed {
+			return Instance{}, ip, nil
+		}
+	}
+	// El lease (ipam.json) se escribe fuera de la transacción del Store para
+	// no retenerla durante la E/S; si la transacción falla se libera.
+	id := fmt.Sprintf("inst-%d", time.Now().UnixNano())
+	lease, err := leaseIP(fqdn, id, conflicts, warnings)
+	if err != nil {
+		return Instance{}, "", fmt.Errorf("asignación de IP: %w", err)
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	inst = Instance{
+		ID:        id,
+		URL:       "http://" + fqdn,
+		IP:        lease.IP,
+		Host:      fqdn,
+		VM:        vmNameOf(fqdn),
+		State:     instPreparing,
+		Tenant:    tenant,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	err = store.UpdateInstances(func(tx InstanceTx) error {
+		if err := hostFree(ctx, tx, fqdn); err != nil {
+			return err
+		}
+		return tx.Put(inst)
+	})
+	if err != nil {
+		if rerr := releaseIP(id); rerr != nil {
+			fmt.Println("Error liberando IP:", rerr)
+		}
+		return Instance{}, "", err
+	}
+	return inst, "", nil
+}
+
+// hostFree retorna errInstanceExists si la transacción tiene una instancia para
+// fqdn, y errHostInUse si otra instancia ya usa su VM (la primera etiqueta:
+// web.a.<zona> y web.b.<zona> serían la misma VM). Si la instancia es de otro
+// tenant el error no da su estado ni su host, para no revelar qué hosts tienen
+// los demás (ver ownedBy).
+func hostFree(ctx context.Context, tx InstanceTx, fqdn string) error {
+	it, ok, err := tx.ByHost(fqdn)
+	if err != nil {
+		return err
+	}
+	switch {
+	case ok && canAccess(ctx, it.Tenant):
+		return fmt.Errorf("%w: %s (%s)", errInstanceExists, fqdn, it.State)
+	case ok:
+		return fmt.Errorf("%w: %s", errHostInUse, fqdn)
+	}
+	list, err := tx.List()
+	if err != nil {
+		return err
+	}
+	vm := vmNameOf(fqdn)
+	for _, it := range list {
+		if !strings.EqualFold(it.VM, vm) {
+			continue
+		}
+		if canAccess(ctx, it.Tenant) {
+			return fmt.Errorf("%w: la VM %s ya es de %s", errHostInUse, vm, it.Host)
+		}
+		return fmt.Errorf("%w: la VM %s ya es de otra instancia", errHostInUse, vm)
+	}
+	return nil
+}
+
+// adoptInstance registra una instancia para una VM y un registro A que ya
+// existen fuera del registro de instancias, tomando la IP del registro en el IPAM.
+// La instancia queda en el tenant del usuario que la adopta (/reconcile exige admin).
+func adoptInstance(ctx context.Context, fqdn, ip, state string) (Instance, error) {
+	muAlloc.Lock()
+	defer muAlloc.Unlock()
+	err := store.ViewInstances(func(tx InstanceTx) error {
+		return hostFree(ctx, tx, fqdn)
+	})
+	if err != nil {
+		return Instance{}, err
+	}
+	// Como en createInstance, el lease se toma fuera de la transacción
+	id := fmt.Sprintf("inst-%d", time.Now().UnixNano())
+	if err := claimIP(fqdn, id, ip); err != nil {
+		return Instance{}, err
+	}
+	now := time.Now().UTC().Format(time.RFC3339)
+	inst := Instance{
+		ID:        id,
+		URL:       "http://" + fqdn,
+		IP:        ip,
+		Host:      fqdn,
+		VM:        vmNameOf(fqdn),
+		State:     state,
+		Tenant:    tenantFrom(ctx),
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	err = store.UpdateInstances(func(tx InstanceTx) error {
+		if err := hostFree(ctx, tx, fqdn); err != nil {
+			return err
+		}
+		return tx.Put(inst)
+	})
+	if err != nil {
+		if rerr := releaseIP(id); rerr != nil {
+			fmt.Println("Error liberando IP:", rerr)
+		}
+		return Instance{}, err
+	}
+	return inst, nil
+}
+
+// instanceMatcher selecciona una instancia dentro de una transacción.
+type instanceMatcher func(tx InstanceTx) (Instance, bool, error)
+
+// transitionInstance pasa la instancia que selecciona match al estado to si
+// su estado actual está en from (nil = cualquiera). fn, si no es nil, ajusta
+// otros campos. Retorna la instancia actualizada.
+func transitionInstance(match instanceMatcher, from []string, to string, fn func(*Instance)) (Instance, error) {
+	var out Instance
+	err := store.UpdateInstances(func(tx InstanceTx) error {
+		it, ok, err := match(tx)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errInstanceMissing
+		}
+		if from != nil && !slices.Contains(from, it.State) {
+			out = it
+			return fmt.Errorf("%w: %s está %s", errInstanceBusy, it.Host, it.State)
+		}
+		it.State = to
+		it.UpdatedAt = time.Now().UTC().Format(time.RFC3339)
+		if to != instFailed && to != instAborted {
+			it.Error = ""
+		}
+		if fn != nil {
+			fn(&it)
+		}
+		out = it
+		return tx.Put(it)
+	})
+	return out, err
+}
+
+// byID selecciona la instancia con ese ID.
+func byID(id string) instanceMatcher {
+	return func(tx InstanceTx) (Instance, bool, error) { return tx.Get(id) }
+}
+
+// byHost selecciona la instancia de ese FQDN.
+func byHost(fqdn string) instanceMatcher {
+	return func(tx InstanceTx) (Instance, bool, error) { return tx.ByHost(fqdn) }
+}
+
+// keepPrev envuelve match y guarda en prev la instancia tal como estaba antes
+// de la transición, para poder restaurar su estado si la operación no llega
+// a ejecutarse.
+func keepPrev(match instanceMatcher, prev *Instance) instanceMatcher {
+	return func(tx InstanceTx) (Instance, bool, error) {
+		it, ok, err := match(tx)
+		*prev = it
+		return it, ok, err
+	}
+}
+
+// failInstance marca la instancia como failed guardando el error. Los errores
+// de escritura se informan por consola.
+func failInstance(id string, cause error) {
+	markFailed(id, instFailed, cause)
+}
+
+// abortInstance marca como aborted una instancia cuya preparación falló. La
+// VM no llegó a quedar lista (el rollback la eliminó o quedó a medio crear),
+// así que la instancia no se puede publicar: solo destruir, lo que libera la
+// IP y limpia lo que haya quedado.
+func abortInstance(id string, cause error) {
+	markFailed(id, instAborted, cause)
+}
+
+// markFailed pasa la instancia a state (failed o aborted) guardando el error.
+func markFailed(id, state string, cause error) {
+	_, err := transitionInstance(byID(id), nil, state, func(it *Instance) {
+		it.Error = cause.Error()
+	})
+	if err != nil {
+		fmt.Println("Error actualizando instancia:", err)
+	}
+}
+
+// restoreInstance devuelve la instancia al estado prev (ver keepPrev) si
+// sigue en from: la operación que la pasó a from no llegó a ejecutarse o se
+// revirtió por completo. Los
+// errores de escritura se informan por consola.
+func restoreInstance(prev Instance, from string) {
+	_, err := transitionInstance(byID(prev.ID), []string{from}, prev.State, func(it *Instance) {
+		it.Error = prev.Error
+	})
+	if err != nil {
+		fmt.Println("Error actualizando instancia:", err)
+	}
+}
+
+// removeInstance quita la instancia del registro y libera su IP.
+func removeInstance(id string) error {
+	err := store.UpdateInstances(func(tx InstanceTx) error {
+		return tx.Delete(id)
+	})
+	if err != nil {
+		return err
+	}
+	return releaseIP(id)
+}
+
+// setInstanceJob anota en la instancia el job que la está procesando.
+func setInstanceJob(ctx context.Context, id string) {
+	jobID := jobIDFrom(ctx)
+	err := store.UpdateInstances(func(tx InstanceTx) error {
+		it, ok, err := tx.Get(id)
+		if err != nil || !ok {
+			return err
+		}
+		it.JobID = jobID
+		return tx.Put(it)
+	})
+	if err != nil {
+		fmt.Println("Error actualizando instancia:", err)
+	}
+}
+
+// recoverInstances marca como failed las instancias que quedaron con una
+// operación en curso al detenerse el servidor: su job no sobrevive al reinicio.
+// Una preparación interrumpida queda aborted, como si hubiera fallado.
+func recoverInstances() error {
+	now := time.Now().UTC().Format(time.RFC3339)
+	return store.UpdateInstances(func(tx InstanceTx) error {
+		list, err := tx.List()
+		if err != nil {
+			return err
+		}
+		for _, it := range list {
+			if !slices.Contains(interruptedStates, it.State) {
+				continue
+			}
+			it.Error = "interrumpido por reinicio del servidor durante " + it.State
+			if it.State == instPreparing {
+				it.State = instAborted
+			} else {
+				it.State = instFailed
+			}
+			it.UpdatedAt = now
+			if err := tx.Put(it); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// lifecycleTestEnv apunta el Store y el IPAM a una base y un ipam.json
+// temporales, sin verificaciones de IP, y los restaura al terminar.
+func lifecycleTestEnv(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	prevStore, prevIPAM, prevChecks, prevCIDR := store, ipamPath, ipChecks, hostOnlyCIDR
+	st, err := openBoltStore(filepath.Join(dir, "data.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, ipamPath, ipChecks, hostOnlyCIDR = st, filepath.Join(dir, "ipam.json"), nil, "192.168.56.0/24"
+	t.Cleanup(func() {
+		st.Close()
+		store, ipamPath, ipChecks, hostOnlyCIDR = prevStore, prevIPAM, prevChecks, prevCIDR
+	})
+}
+
+func TestAllocInstanceReprobe(t *testing.T) {
+	lifecycleTestEnv(t)
+	ctx := context.Background()
+	want, err := candidateIP("web1.grid.lab", ipConflicts{})
+	if err != nil || want == "" {
+		t.Fatalf("candidateIP = %q, %v", want, err)
+	}
+
+	// El sondeo aprobó otra IP: no se asigna nada y se pide sondear la candidata
+	_, next, err := allocInstance(ctx, "web1.grid.lab", "", ipConflicts{}, "192.168.56.200", nil)
+	if err != nil || next != want {
+		t.Fatalf("allocInstance = next %q, %v; quiero %q", next, err, want)
+	}
+	if list, _ := store.ListInstances(); len(list) != 0 {
+		t.Fatalf("se registraron %d instancias sin sondear la IP", len(list))
+	}
+	if d, _, _ := loadIPAM(); len(d.Leases) != 0 {
+		t.Fatalf("se tomó un lease sin sondear la IP: %+v", d.Leases)
+	}
+
+	inst, next, err := allocInstance(ctx, "web1.grid.lab", "", ipConflicts{}, want, []string{"aviso"})
+	if err != nil || next != "" || inst.IP != want || inst.State != instPreparing {
+		t.Fatalf("allocInstance = %+v, next %q, %v; quiero la IP %s", inst, next, err, want)
+	}
+	if _, _, err := allocInstance(ctx, "web1.grid.lab", "", ipConflicts{}, "", nil); err == nil {
+		t.Error("allocInstance registró dos veces el mismo host")
+	}
+}
+
+func TestCreateInstanceConcurrent(t *testing.T) {
+	lifecycleTestEnv(t)
+	const n = 20
+	var wg sync.WaitGroup
+	ips := make([]string, n)
+	errs := make([]error, n)
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			inst, _, err := createInstance(context.Background(), fmt.Sprintf("web%d.grid.lab", i))
+			ips[i], errs[i] = inst.IP, err
+		}()
+	}
+	wg.Wait()
+	seen := map[string]bool{}
+	for i, ip := range ips {
+		if errs[i] != nil {
+			t.Fatalf("createInstance %d: %v", i, errs[i])
+		}
+		if seen[ip] {
+			t.Errorf("IP %s asignada dos veces", ip)
+		}
+		seen[ip] = true
+	}
+}
+
+func TestAdoptInstanceTenant(t *testing.T) {
+	lifecycleTestEnv(t)
+	prevAuth := authEnabled
+	authEnabled = true
+	t.Cleanup(func() { authEnabled = prevAuth })
+
+	ctx := context.WithValue(context.Background(), userKey{}, User{Username: "ana", Role: roleAdmin, Tenant: "lab"})
+	inst, err := adoptInstance(ctx, "web1.grid.lab", "192.168.56.50", instPrepared)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inst.Tenant != "lab" {
+		t.Errorf("Tenant = %q, quiero %q", inst.Tenant, "lab")
+	}
+	if got, _ := store.GetInstance(inst.ID); got.Tenant != "lab" {
+		t.Errorf("Tenant guardado = %q, quiero %q", got.Tenant, "lab")
+	}
+}
+
+func TestCreateInstanceSharedVMName(t *testing.T) {
+	lifecycleTestEnv(t)
+	prevAuth := authEnabled
+	authEnabled = true
+	t.Cleanup(func() { authEnabled = prevAuth })
+	ana := context.WithValue(context.Background(), userKey{}, User{Username: "ana", Role: roleDeployer})
+	beto := context.WithValue(context.Background(), userKey{}, User{Username: "beto", Role: roleDeployer})
+
+	first, _, err := createInstance(ana, "web.a.grid.lab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.VM != "web" {
+		t.Errorf("VM = %q, quiero web", first.VM)
+	}
+	// Otro host con la misma primera etiqueta usaría (y al destruirse borraría) la misma VM
+	_, _, err = createInstance(ana, "web.b.grid.lab")
+	if !errors.Is(err, errHostInUse) || !strings.Contains(err.Error(), "web.a.grid.lab") {
+		t.Errorf("createInstance del mismo tenant = %v, quiero errHostInUse con el host que la usa", err)
+	}
+	_, _, err = createInstance(beto, "web.c.grid.lab")
+	if !errors.Is(err, errHostInUse) || strings.Contains(err.Error(), "web.a.grid.lab") {
+		t.Errorf("createInstance de otro tenant = %v, quiero errHostInUse sin revelar el host", err)
+	}
+	if list, _ := store.ListInstances(); len(list) != 1 {
+		t.Errorf("hay %d instancias, quiero 1", len(list))
+	}
+}
@@ -2,40 +2,51 @@ package main
 
 // ============================== Imports =====================================
 import (
-	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"sort"
+	"slices"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
+
+	"computacion-nube-proyecto/ddns"
 )
 
 // ============================== Types =======================================
 
-// Instance representa una instancia de aplicación web desplegada.
+// Instance representa una instancia de aplicación web, desde que se empieza
+// a preparar hasta que se elimina.
 type Instance struct {
-	ID        string `json:"id"`         // Identificador único de la instancia
-	URL       string `json:"url"`        // URL completa de acceso (http://fqdn)
-	IP        string `json:"ip"`         // Dirección IP asignada
-	Host      string `json:"host"`       // FQDN completo del host
-	CreatedAt string `json:"created_at"` // Fecha de creación en formato RFC3339
+	ID        string `json:"id"`                   // Identificador único de la instancia
+	URL       string `json:"url"`                  // URL completa de acceso (http://fqdn)
+	IP        string `json:"ip"`                   // Dirección IP asignada
+	Host      string `json:"host"`                 // FQDN completo del host
+	VM        string `json:"vm,omitempty"`         // Nombre de la VM en el backend, único entre las instancias (ver vmNameOf)
+	State     string `json:"state"`                // preparing, prepared, publishing, running, failed, destroying, lost o aborted
+	Error     string `json:"error,omitempty"`      // Error de la última operación si State es failed o aborted
+	JobID     string `json:"job_id,omitempty"`     // Último job que operó sobre la instancia
+	Tenant    string `json:"tenant,omitempty"`     // Tenant dueño; vacío = anterior a los tenants (solo admin)
+	CreatedAt string `json:"created_at"`           // Fecha de creación en formato RFC3339
+	UpdatedAt string `json:"updated_at,omitempty"` // Último cambio de estado en formato RFC3339
 }
 
 // DNSLog registra una operación DNS (agregado o eliminación de registro).
 type DNSLog struct {
-	Timestamp string `json:"timestamp"` // Timestamp UTC en formato RFC3339
-	Action    string `json:"action"`    // "ADD" o "DELETE"
-	FQDN      string `json:"fqdn"`      // Nombre de dominio completo
-	IP        string `json:"ip"`        // Dirección IP asociada
+	Seq       uint64 `json:"seq"`             // Número de secuencia creciente; cursor de paginación
+	Timestamp string `json:"timestamp"`       // Timestamp UTC en formato RFC3339
+	Action    string `json:"action"`          // "ADD", "DELETE" o "PTR" (reparación del PTR, ver handleInstancePTR)
+	FQDN      string `json:"fqdn"`            // Nombre de dominio completo
+	IP        string `json:"ip"`              // Dirección IP asociada
+	Actor     string `json:"actor,omitempty"` // Quién hizo el cambio (ver AuditEvent); vacío en los logs anteriores
 }
 
 // DNSDirectRecord representa un registro A directo leído del archivo de zona DNS.
@@ -45,434 +56,536 @@ type DNSDirectRecord struct {
 }
 
 // ============================== Config ======================================
+
+// Valores por defecto de la configuración. Se pueden cambiar con el archivo
+// config.yaml, variables de entorno o flags (ver configFields en config.go).
 var (
-	// instancesPath ruta al archivo JSON que almacena las instancias desplegadas.
+	// configPath archivo de configuración YAML. Se puede cambiar con la
+	// variable de entorno CONFIG o el flag -config; si no existe se usan los
+	// valores por defecto.
+	configPath = "config.yaml"
+	// listenAddr dirección en la que escucha el servidor HTTP.
+	listenAddr = ":8080"
+	// templatesDir directorio con la interfaz web (archivos estáticos).
+	templatesDir = filepath.FromSlash("./templates")
+	// instancesPath ruta al archivo JSON con las instancias del storage json.
+	// Con el storage bolt se importa al crear la base.
 	instancesPath = filepath.FromSlash("./services/hosts.json")
-	// dnsLogsPath ruta al archivo JSON que almacena los logs de operaciones DNS.
+	// dnsLogsPath ruta al archivo JSON con los logs DNS del storage json.
+	// Con el storage bolt se importa al crear la base.
 	dnsLogsPath = filepath.FromSlash("./services/dns-logs.json")
+	// dnsArchiveDir directorio con los segmentos archivados del historial DNS.
+	dnsArchiveDir = filepath.FromSlash("./services/dns-archive")
+	// dnsLogRotateEntries entradas del historial DNS que se guardan en el
+	// storage antes de archivarlas en un segmento de dnsArchiveDir.
+	dnsLogRotateEntries = 5000
+	// auditPath archivo JSON Lines con la auditoría de las operaciones.
+	auditPath = filepath.FromSlash("./services/audit.jsonl")
+	// auditArchiveDir directorio con los segmentos archivados de la auditoría.
+	auditArchiveDir = filepath.FromSlash("./services/audit-archive")
+	// auditRotateEntries eventos que se acumulan en auditPath antes de
+	// archivarlo como un segmento de auditArchiveDir.
+	auditRotateEntries = 5000
+	// usersPath archivo JSON con los usuarios locales y sus tokens de API.
+	usersPath = filepath.FromSlash("./services/users.json")
+	// authEnabled exige autenticación en la API. Se puede deshabilitar para
+	// desarrollo (ej: variable de entorno AUTH=off).
+	authEnabled = true
+	// sessionTTL duración de las sesiones de la interfaz web.
+	sessionTTL = 12 * time.Hour
+	// adminPassword contraseña del usuario "admin" que se crea cuando no hay
+	// usuarios. Vacía = una aleatoria que se muestra una vez por consola.
+	adminPassword = ""
+	// tenantsPath archivo JSON con las cuotas configuradas de cada tenant.
+	tenantsPath = filepath.FromSlash("./services/tenants.json")
+	// defaultQuota cuota de los tenants sin una cuota configurada (ver
+	// PUT /tenants/{name}). Los valores cero no limitan.
+	defaultQuota = Quota{MaxInstances: 10, MaxZipBytes: 64 << 20}
+	// jobsPath ruta al archivo JSON que almacena los jobs asíncronos.
+	jobsPath = filepath.FromSlash("./services/jobs.json")
+	// dbPath ruta a la base bbolt con las instancias y el historial DNS.
+	dbPath = filepath.FromSlash("./services/data.db")
+	// storageName almacenamiento de instancias y logs DNS ("bolt" o "json").
+	storageName = "bolt"
+	// ipamPath ruta al archivo JSON con los pools, reservas y leases de IPs.
+	ipamPath = filepath.FromSlash("./services/ipam.json")
+	// opLogsDir directorio con los logs capturados de cada operación, uno por instancia.
+	opLogsDir = filepath.FromSlash("./services/oplogs")
 	// scriptsDir directorio que contiene los scripts batch de automatización.
 	scriptsDir = filepath.FromSlash("./scripts")
 	// dnsServerIP dirección IP del servidor DNS autoritativo.
 	dnsServerIP = "192.168.56.11"
 	// dnsZone zona DNS bajo la cual se crean los registros (ej: grid.lab).
 	dnsZone = "grid.lab"
+	// dnsSSHUser usuario SSH del servidor DNS (lectura de la zona si falla la
+	// transferencia).
+	dnsSSHUser = "unix"
+	// dnsSSHFallback lee la zona por SSH (rndc dumpdb) cuando el servidor DNS
+	// no permite la transferencia.
+	dnsSSHFallback = true
+	// tsigKeyPath archivo con la clave TSIG (formato BIND) para las actualizaciones DNS.
+	// Se ignora si tsigSecret está definido.
+	tsigKeyPath = filepath.FromSlash("./services/tsig.key")
+	// tsigSecret secreto TSIG en base64, alternativa a tsigKeyPath, con
+	// tsigKeyName y tsigAlgorithm. Nunca se muestra en GET /config.
+	tsigSecret    = ""
+	tsigKeyName   = "ddns-key"
+	tsigAlgorithm = "hmac-sha256"
+	// secretsPath archivo cifrado con la clave TSIG y la clave SSH (ver secrets.go).
+	secretsPath = filepath.FromSlash("./services/secrets.enc")
+	// secretsPassphrase frase de la que se deriva la clave de secretsPath.
+	// Sin ella no se puede leer ni rotar secretsPath.
+	secretsPassphrase = ""
+	// sshPrivateKey clave privada SSH (PEM u OpenSSH) de las VMs y del
+	// servidor DNS, alternativa a sshKeyPath. Con ambas vacías ssh usa las
+	// claves del usuario que ejecuta el servidor.
+	sshPrivateKey = ""
+	// sshKeyPath archivo con la clave privada SSH, sin cifrar.
+	sshKeyPath = ""
+	// knownHostsPath claves de host SSH registradas de las VMs y del servidor
+	// DNS, en formato known_hosts de OpenSSH (ver knownhosts.go).
+	knownHostsPath = filepath.FromSlash("./services/known_hosts")
+	// hostOnlyCIDR red host-only de las instancias. Define la zona inversa de
+	// los PTR y la red que validan los scripts.
+	hostOnlyCIDR = "192.168.56.0/24"
+	// hostOnlyAdapter adaptador host-only de VirtualBox de la NIC 1 de las VMs.
+	hostOnlyAdapter = "VirtualBox Host-Only Ethernet Adapter"
+	// vmSSHUser usuario SSH dentro de las VMs.
+	vmSSHUser = "unix"
+	// sshPort puerto SSH de las VMs y del servidor DNS.
+	sshPort = 22
+	// templateDisk disco plantilla Apache (multiattach) que se adjunta a cada VM.
+	templateDisk = `C:\Users\mirao\VirtualBox VMs\Discos\APACHE PLANTILLA.vdi`
+	// infraVMs VMs de la infraestructura del laboratorio (servidor DNS, VM de la
+	// plantilla) que no son instancias; /drift no las reporta.
+	infraVMs = []string{"DNS", "APACHE PLANTILLA"}
+	// diskController controlador de almacenamiento donde se adjunta el disco.
+	diskController = "SATA"
+	// vmMemoryMB memoria de cada VM.
+	vmMemoryMB = 1024
+	// vmCPUs CPUs de cada VM.
+	vmCPUs = 1
+	// bootWait espera al arranque de la VM antes de configurarla por SSH.
+	bootWait = 25 * time.Second
+	// ipChecks verificaciones que se hacen antes de asignar una IP: "dns" (registros
+	// A de la zona), "dhcp" (reservas de VirtualBox) y "ping" (ping y tabla ARP).
+	// Con el provisioner fake, si no se configura, no se hace ninguna.
+	ipChecks = []string{"dns", "dhcp"}
+	// provisionerName backend de aprovisionamiento ("batch", "vbox" o "fake").
+	provisionerName = "batch"
+	// stepTimeouts tiempo máximo de cada paso externo. Los valores
+	// configurados se suman a estos (ej: STEP_TIMEOUTS="ssh=30s,crearVMyDNS=20m").
+	stepTimeouts = map[string]time.Duration{
+		"crearVMyDNS":       15 * time.Minute, // crearVMyDNS.bat completo
+		"desplegarSitio":    5 * time.Minute,  // desplegarSitio.bat completo
+		"eliminarInstancia": 5 * time.Minute,  // eliminarInstancia.bat completo
+		"vboxmanage":        2 * time.Minute,  // Cada invocación de VBoxManage
+		"ssh":               2 * time.Minute,  // Cada comando remoto por ssh
+		"scp":               5 * time.Minute,  // Transferencia del ZIP por scp
+		"nslookup":          15 * time.Second, // Consultas DNS de validación
+		"dns":               10 * time.Second, // Cada actualización RFC 2136
+		"axfr":              15 * time.Second, // Transferencia de la zona (AXFR/IXFR)
+		"health":            10 * time.Second, // GET /health.txt
+		"probe":             5 * time.Second,  // Sondeo ping/ARP de una IP candidata
+		"rollback":          10 * time.Minute, // Deshacer una operación fallida
+	}
 )
 
 var (
-	// mu protege el acceso concurrente a la lista de instancias.
-	mu sync.Mutex
-	// muLogs protege el acceso concurrente a los logs DNS.
-	muLogs sync.Mutex
+	// prov backend de aprovisionamiento seleccionado al iniciar el servidor.
+	prov Provisioner
 )
 
-// ============================== Storage =====================================
+// ============================== Utilities ===================================
 
-// loadInstances carga la lista de instancias desde el archivo JSON.
-// Retorna una lista vacía si el archivo no existe o está corrupto.
-func loadInstances() ([]Instance, error) {
-	f, err := os.Open(instancesPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return []Instance{}, nil
-		}
-		return nil, err
-	}
-	defer f.Close()
-	var list []Instance
-	dec := json.NewDecoder(f)
-	if err := dec.Decode(&list); err != nil {
-		return []Instance{}, nil
-	}
-	return list, nil
+// run ejecuta un comando externo y redirige stdout y stderr a la salida de la
+// operación del contexto (o a los streams del proceso actual si no tiene una).
+// Si la operación se está registrando, la invocación queda en su log.
+// op es el paso (clave de stepTimeouts) que fija el tiempo máximo del comando;
+// al cancelarse ctx o vencer el timeout se mata el comando y sus hijos.
+func run(ctx context.Context, op, name string, args ...string) error {
+	return runCmd(ctx, op, nil, name, args...)
 }
 
-// saveInstances guarda la lista de instancias en el archivo JSON usando escritura atómica.
-// Escribe primero a un archivo temporal y luego lo renombra para evitar corrupción.
-func saveInstances(list []Instance) error {
-	tmp := instancesPath + ".tmp"
-	f, err := os.Create(tmp)
+// resolveIPv4 resuelve el FQDN y retorna la primera dirección IPv4 encontrada.
+// Retorna un error si no se puede resolver o no hay direcciones IPv4.
+func resolveIPv4(ctx context.Context, host string) (string, error) {
+	ctx, cancel := stepContext(ctx, "nslookup")
+	defer cancel()
+	ips, err := net.DefaultResolver.LookupIP(ctx, "ip", host)
 	if err != nil {
-		return err
-	}
-	enc := json.NewEncoder(f)
-	enc.SetIndent("", "  ")
-	if err := enc.Encode(list); err != nil {
-		f.Close()
-		os.Remove(tmp)
-		return err
+		return "", fmt.Errorf("no se pudo resolver %s: %w", host, err)
 	}
-	if err := f.Close(); err != nil {
-		os.Remove(tmp)
-		return err
+	for _, ip := range ips {
+		if v4 := ip.To4(); v4 != nil {
+			return v4.String(), nil
+		}
 	}
-	return os.Rename(tmp, instancesPath)
+	return "", fmt.Errorf("no se encontró IPv4 para %s", host)
 }
 
-// ============================== DNS Logs Storage ============================
+// pageQuery rango de fechas y paginación por cursor de los listados de
+// auditoría (GET /dns-logs y GET /audit).
+type pageQuery struct {
+	Since  time.Time // Desde (inclusive); cero = sin límite
+	Until  time.Time // Hasta (exclusive); cero = sin límite
+	Cursor uint64    // Seq de la última entrada de la página anterior; 0 = desde el inicio
+	Limit  int       // Entradas por página
+	Asc    bool      // true = más antiguas primero
+}
 
-// loadDNSLogs carga los logs DNS desde el archivo JSON.
-// Retorna una lista vacía si el archivo no existe o está corrupto.
-func loadDNSLogs() ([]DNSLog, error) {
-	muLogs.Lock()
-	defer muLogs.Unlock()
-	f, err := os.Open(dnsLogsPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return []DNSLog{}, nil
+// Límites de entradas por página de los listados de auditoría.
+const (
+	defaultPageLimit = 100
+	maxPageLimit     = 1000
+)
+
+// parsePageQuery lee since y until (RFC3339 o AAAA-MM-DD), cursor, limit y
+// order (asc o desc; por defecto desc).
+func parsePageQuery(v url.Values) (pageQuery, error) {
+	p := pageQuery{Limit: defaultPageLimit}
+	var err error
+	for _, t := range []struct {
+		name string
+		dst  *time.Time
+	}{{"since", &p.Since}, {"until", &p.Until}} {
+		s := v.Get(t.name)
+		if s == "" {
+			continue
+		}
+		if *t.dst, err = time.Parse(time.RFC3339, s); err != nil {
+			if *t.dst, err = time.Parse("2006-01-02", s); err != nil {
+				return p, fmt.Errorf("%s inválido %q (use RFC3339 o AAAA-MM-DD)", t.name, s)
+			}
 		}
-		return nil, err
-	}
-	defer f.Close()
-	var logs []DNSLog
-	dec := json.NewDecoder(f)
-	if err := dec.Decode(&logs); err != nil {
-		return []DNSLog{}, nil
 	}
-	return logs, nil
-}
-
-// saveDNSLogs guarda los logs DNS en el archivo JSON usando escritura atómica.
-func saveDNSLogs(logs []DNSLog) error {
-	muLogs.Lock()
-	defer muLogs.Unlock()
-	tmp := dnsLogsPath + ".tmp"
-	f, err := os.Create(tmp)
-	if err != nil {
-		return err
+	if s := v.Get("cursor"); s != "" {
+		if p.Cursor, err = strconv.ParseUint(s, 10, 64); err != nil {
+			return p, fmt.Errorf("cursor inválido %q", s)
+		}
 	}
-	enc := json.NewEncoder(f)
-	enc.SetIndent("", "  ")
-	if err := enc.Encode(logs); err != nil {
-		f.Close()
-		os.Remove(tmp)
-		return err
+	if s := v.Get("limit"); s != "" {
+		if p.Limit, err = strconv.Atoi(s); err != nil || p.Limit < 1 || p.Limit > maxPageLimit {
+			return p, fmt.Errorf("limit inválido %q (entre 1 y %d)", s, maxPageLimit)
+		}
 	}
-	if err := f.Close(); err != nil {
-		os.Remove(tmp)
-		return err
+	switch v.Get("order") {
+	case "", "desc":
+	case "asc":
+		p.Asc = true
+	default:
+		return p, fmt.Errorf("order inválido %q (use asc o desc)", v.Get("order"))
 	}
-	return os.Rename(tmp, dnsLogsPath)
-}
-
-// addDNSLog agrega una nueva entrada al log DNS y mantiene solo los últimos 100 registros.
-// Si hay errores al guardar, los ignora silenciosamente.
-func addDNSLog(action, fqdn, ip string) {
-	logs, _ := loadDNSLogs()
-	newLog := DNSLog{
-		Timestamp: time.Now().UTC().Format(time.RFC3339),
-		Action:    action,
-		FQDN:      fqdn,
-		IP:        ip,
-	}
-	logs = append(logs, newLog)
-	// Mantener solo los últimos 100 logs
-	if len(logs) > 100 {
-		logs = logs[len(logs)-100:]
-	}
-	_ = saveDNSLogs(logs)
+	return p, nil
 }
 
-// ============================== Utilities ===================================
-
-// nextIP asigna la próxima IP disponible en el rango 192.168.56.12-254,
-// excluyendo las IPs ya usadas y las reservadas (.10 y .11).
-// Retorna un error si no hay IPs disponibles.
-func nextIP(used []string) (string, error) {
-	usedSet := make(map[string]struct{}, len(used))
-	for _, ip := range used {
-		usedSet[ip] = struct{}{}
-	}
-	// Saltar 10 y 11 directamente en el loop
-	for last := 12; last <= 254; last++ {
-		ip := fmt.Sprintf("192.168.56.%d", last)
-		if _, ok := usedSet[ip]; !ok {
-			return ip, nil
-		}
+// inRange indica si el timestamp RFC3339 ts está dentro de [Since, Until).
+func (p pageQuery) inRange(ts string) bool {
+	if p.Since.IsZero() && p.Until.IsZero() {
+		return true
 	}
-	return "", errors.New("sin IP disponible en 192.168.56.12-254")
-}
-
-// run ejecuta un comando externo y redirige stdout y stderr a los streams del proceso actual.
-// El parámetro op es ignorado (mantenido por compatibilidad con llamadas existentes).
-func run(op, name string, args ...string) error {
-	cmd := exec.Command(name, args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
-}
-
-// resolveIPv4 resuelve el FQDN y retorna la primera dirección IPv4 encontrada.
-// Retorna un error si no se puede resolver o no hay direcciones IPv4.
-func resolveIPv4(host string) (string, error) {
-	ips, err := net.LookupIP(host)
+	t, err := time.Parse(time.RFC3339, ts)
 	if err != nil {
-		return "", fmt.Errorf("no se pudo resolver %s: %w", host, err)
+		return false
 	}
-	for _, ip := range ips {
-		if v4 := ip.To4(); v4 != nil {
-			return v4.String(), nil
-		}
+	return (p.Since.IsZero() || !t.Before(p.Since)) && (p.Until.IsZero() || t.Before(p.Until))
+}
+
+// afterCursor indica si la entrada seq va después del cursor en el orden pedido.
+func (p pageQuery) afterCursor(seq uint64) bool {
+	switch {
+	case p.Cursor == 0:
+		return true
+	case p.Asc:
+		return seq > p.Cursor
+	default:
+		return seq < p.Cursor
 	}
-	return "", fmt.Errorf("no se encontró IPv4 para %s", host)
 }
 
 // ============================== Prepare Step ================================
 
-// prepareSync realiza la preparación inicial: asigna una IP, crea la VM y configura DNS.
-// Retorna la IP asignada, el nombre de la VM y un error si algo falla.
-func prepareSync(fqdn string) (ip string, vmName string, err error) {
-	// Asignar IP disponible
-	mu.Lock()
-	list, _ := loadInstances()
-	used := make([]string, 0, len(list))
-	for _, it := range list {
-		used = append(used, it.IP)
-	}
-	ip, err = nextIP(used)
-	mu.Unlock()
+// prepareSync realiza la preparación inicial de una instancia ya registrada
+// (con IP asignada): crea la VM y configura DNS.
+// step recibe el nombre de cada paso a medida que avanza. Si la creación falla
+// a mitad de camino, se deshacen los pasos completados (VM, reserva DHCP, DNS)
+// y el error informa el resultado del rollback.
+func prepareSync(ctx context.Context, inst Instance, step func(string)) error {
+	step("creando VM y DNS")
+	err := withRollback(ctx, func(ctx context.Context) error {
+		return prov.CreateVM(ctx, inst.VM, inst.IP, inst.Host)
+	})
 	if err != nil {
-		return "", "", fmt.Errorf("asignación de IP: %w", err)
-	}
-
-	vmName = strings.SplitN(fqdn, ".", 2)[0]
-	crear := filepath.Join(scriptsDir, "crearVMyDNS.bat")
-	if err := run("prepare", crear, vmName, ip, fqdn); err != nil {
-		return "", "", fmt.Errorf("crear VM falló: %w", err)
-	}
-	// Validar que el registro DNS A está disponible
-	if err := run("nslookupA", "nslookup", fqdn, dnsServerIP); err != nil {
-		return "", "", fmt.Errorf("DNS A no disponible para %s", fqdn)
+		return err
 	}
 	// Registrar log de DNS agregado
-	addDNSLog("ADD", fqdn, ip)
-	return ip, vmName, nil
+	addDNSLog(ctx, "ADD", inst.Host, inst.IP)
+	return nil
 }
 
 // ============================== Publish Step ================================
 
 // publishSync despliega el contenido ZIP en la VM ya preparada y valida el servicio.
-// Retorna la instancia creada o un error si el despliegue o validación falla.
-func publishSync(fqdn string, zipPath string) (Instance, error) {
-	var zero Instance
-	// Resolver IP ya preparada (vía DNS)
-	ipStr, err := resolveIPv4(fqdn)
-	if err != nil {
-		return zero, fmt.Errorf("resolver IP para %s: %w", fqdn, err)
-	}
-
-	// Desplegar usando script
-	depl := filepath.Join(scriptsDir, "desplegarSitio.bat")
-	if err := run("publish", depl, ipStr, fqdn, zipPath); err != nil {
-		return zero, fmt.Errorf("despliegue falló: %w", err)
-	}
-	// Validación de PTR opcional: si falla, no bloquea
-	_ = run("nslookupPTR", "nslookup", ipStr, dnsServerIP)
-
-	// Validar que el servicio web responde
-	client := &http.Client{Timeout: 10 * time.Second}
-	// Intentar por FQDN primero
-	resp, err := client.Get("http://" + fqdn + "/health.txt")
-	if err != nil || resp == nil || resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		if resp != nil {
-			resp.Body.Close()
-		}
-		// Fallback: por IP con Host header para que coincida el VirtualHost
-		req, err := http.NewRequest("GET", "http://"+ipStr+"/health.txt", nil)
-		if err != nil {
-			return zero, fmt.Errorf("crear request HTTP: %w", err)
-		}
-		req.Host = fqdn
-		resp2, err2 := client.Do(req)
-		if err2 != nil {
-			return zero, fmt.Errorf("servicio web no responde en %s: %w", ipStr, err2)
-		}
-		defer resp2.Body.Close()
-		if resp2.StatusCode < 200 || resp2.StatusCode >= 300 {
-			return zero, fmt.Errorf("servicio web HTTP %d en %s", resp2.StatusCode, ipStr)
-		}
-	} else {
-		defer resp.Body.Close()
-	}
-
-	// Crear y guardar instancia
-	inst := Instance{
-		ID:        fmt.Sprintf("job-%d", time.Now().UnixNano()),
-		URL:       "http://" + fqdn,
-		IP:        ipStr,
-		Host:      fqdn,
-		CreatedAt: time.Now().UTC().Format(time.RFC3339),
-	}
-	mu.Lock()
-	list, _ := loadInstances()
-	list = append(list, inst)
-	_ = saveInstances(list)
-	mu.Unlock()
-	return inst, nil
+// step recibe el nombre de cada paso a medida que avanza. Si el despliegue
+// falla se restauran el sitio y los vhosts que había en la VM (ver
+// backupSite), y el error informa el resultado del rollback.
+func publishSync(ctx context.Context, inst Instance, zipPath string, step func(string)) error {
+	// Desplegar y validar que el servicio web responde
+	step("desplegando sitio")
+	return withRollback(ctx, func(ctx context.Context) error {
+		return prov.Deploy(ctx, inst.IP, inst.Host, zipPath)
+	})
 }
 
 // ============================== HTTP Handlers ===============================
 
 // handlePrepare maneja POST /prepare para preparar una nueva instancia (crear VM y DNS).
 // Espera un form field "hostname" (opcional, se auto-genera si está vacío).
-// Retorna JSON con {"fqdn": "...", "ip": "..."} o un error.
+// Registra la instancia en estado preparing con su IP, encola un job y
+// responde 202 con su ID; al terminar, el resultado del job es la instancia
+// en estado prepared (o aborted si algo falló: solo se puede destruir).
 func handlePrepare(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, codeMethodNotAllowed, "method not allowed")
 		return
 	}
 	if err := r.ParseMultipartForm(64 << 20); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, codeBadRequest, err.Error())
 		return
 	}
-	fqdn := strings.TrimSpace(r.FormValue("hostname"))
-	if fqdn == "" {
-		fqdn = fmt.Sprintf("app-%d.%s", time.Now().Unix(), dnsZone)
+	name := strings.TrimSpace(r.FormValue("hostname"))
+	if name == "" {
+		name = fmt.Sprintf("app-%d", time.Now().Unix())
 	}
-	if !strings.Contains(fqdn, ".") {
-		fqdn = fqdn + "." + dnsZone
+	auditTarget(r.Context(), name, "")
+	fqdn, err := normalizeHost(name)
+	if err != nil {
+		writeAPIError(w, toAPIError(err, ""))
+		return
+	}
+	auditTarget(r.Context(), fqdn, "")
+	if err := checkHostAllowed(tenantFrom(r.Context()), fqdn); err != nil {
+		writeAPIError(w, toAPIError(err, ""))
+		return
 	}
-	ip, _, err := prepareSync(fqdn)
+	inst, warnings, err := createInstance(r.Context(), fqdn)
 	if err != nil {
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadGateway)
-		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		writeAPIError(w, toAPIError(err, "asignando IP"))
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"fqdn": fqdn, "ip": ip})
+	auditTarget(r.Context(), inst.Host, inst.ID)
+	j, err := jobs.Submit(r.Context(), "prepare", fqdn, func(ctx context.Context, step func(string)) (any, error) {
+		setInstanceJob(ctx, inst.ID)
+		err := recordOperation(ctx, "prepare", inst, func(ctx context.Context) error {
+			for _, w := range warnings {
+				opLogf(ctx, "Aviso asignando IP %s: %s", inst.IP, w)
+			}
+			return prepareSync(ctx, inst, step)
+		})
+		if err != nil {
+			abortInstance(inst.ID, err)
+			return nil, err
+		}
+		return transitionInstance(byID(inst.ID), []string{instPreparing}, instPrepared, nil)
+	}, func(err error) { abortInstance(inst.ID, err) })
+	if err != nil {
+		// No se hizo nada todavía: se descarta la instancia y se libera la IP
+		if rerr := removeInstance(inst.ID); rerr != nil {
+			fmt.Println("Error eliminando instancia:", rerr)
+		}
+		writeAPIError(w, toAPIError(err, "encolando job"))
+		return
+	}
+	writeJobAccepted(w, j)
 }
 
 // handlePublish maneja POST /publish para desplegar contenido en una instancia preparada.
-// Espera form fields "hostname" (requerido) y "file" (archivo ZIP).
-// Retorna JSON con la instancia creada o un error.
+// Espera form fields "hostname" (requerido) y "file" (archivo ZIP, hasta el
+// máximo de la cuota del tenant). La instancia debe ser del tenant del
+// usuario, existir y estar prepared, running o failed; pasa a
+// publishing, se encola un job y se responde 202 con su ID. Al terminar, el
+// resultado del job es la instancia en estado running. Si el despliegue
+// falla se restaura el sitio anterior y una instancia que estaba running
+// sigue running cuando el rollback se completó.
 func handlePublish(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, codeMethodNotAllowed, "method not allowed")
 		return
 	}
+	tenant := tenantFrom(r.Context())
+	if q, _ := quotaFor(tenant); q.MaxZipBytes > 0 {
+		// Corta la subida apenas supera la cuota (con margen para los demás campos)
+		r.Body = http.MaxBytesReader(w, r.Body, q.MaxZipBytes+1<<20)
+	}
 	if err := r.ParseMultipartForm(64 << 20); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeAPIError(w, toAPIError(fmt.Errorf("%w: la subida supera %d bytes", errZipTooLarge, tooLarge.Limit), ""))
+			return
+		}
+		writeError(w, codeBadRequest, err.Error())
 		return
 	}
-	fqdn := strings.TrimSpace(r.FormValue("hostname"))
-	if fqdn == "" {
-		http.Error(w, "hostname requerido", http.StatusBadRequest)
+	name := strings.TrimSpace(r.FormValue("hostname"))
+	if name == "" {
+		writeError(w, codeBadRequest, "hostname requerido")
 		return
 	}
-	if !strings.Contains(fqdn, ".") {
-		fqdn = fqdn + "." + dnsZone
+	auditTarget(r.Context(), name, "")
+	fqdn, err := normalizeHost(name)
+	if err != nil {
+		writeAPIError(w, toAPIError(err, ""))
+		return
 	}
+	auditTarget(r.Context(), fqdn, "")
 	file, header, err := r.FormFile("file")
 	if err != nil {
-		http.Error(w, "archivo .zip requerido", http.StatusBadRequest)
+		writeError(w, codeBadRequest, "archivo .zip requerido")
 		return
 	}
 	defer file.Close()
 	if !strings.HasSuffix(strings.ToLower(header.Filename), ".zip") {
-		http.Error(w, "el archivo debe ser .zip", http.StatusBadRequest)
+		writeError(w, codeBadRequest, "el archivo debe ser .zip")
+		return
+	}
+	if err := checkZipQuota(tenant, header.Size); err != nil {
+		writeAPIError(w, toAPIError(err, ""))
 		return
 	}
 
 	// Guardar archivo temporal
 	tmpDir := filepath.Join(os.TempDir(), "uploads")
 	if err := os.MkdirAll(tmpDir, 0755); err != nil {
-		http.Error(w, "error creando directorio temporal", http.StatusInternalServerError)
+		writeError(w, codeInternal, "error creando directorio temporal")
 		return
 	}
-	tmpZip := filepath.Join(tmpDir, fmt.Sprintf("%d_%s", time.Now().UnixNano(), header.Filename))
+	// El nombre del archivo subido no se usa: la ruta llega a los scripts
+	tmpZip := filepath.Join(tmpDir, fmt.Sprintf("%d.zip", time.Now().UnixNano()))
 	out, err := os.Create(tmpZip)
 	if err != nil {
-		http.Error(w, "error creando archivo temporal", http.StatusInternalServerError)
+		writeError(w, codeInternal, "error creando archivo temporal")
 		return
 	}
 	if _, err := io.Copy(out, file); err != nil {
 		out.Close()
 		os.Remove(tmpZip)
-		http.Error(w, "error guardando archivo", http.StatusInternalServerError)
+		writeError(w, codeInternal, "error guardando archivo")
 		return
 	}
 	if err := out.Close(); err != nil {
 		os.Remove(tmpZip)
-		http.Error(w, "error cerrando archivo", http.StatusInternalServerError)
+		writeError(w, codeInternal, "error cerrando archivo")
 		return
 	}
 
-	inst, err := publishSync(fqdn, tmpZip)
+	var prev Instance
+	inst, err := transitionInstance(keepPrev(ownedBy(r.Context(), byHost(fqdn)), &prev), publishableStates, instPublishing, nil)
 	if err != nil {
 		os.Remove(tmpZip)
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusBadGateway)
-		json.NewEncoder(w).Encode(map[string]string{"error": err.Error()})
+		writeAPIError(w, toAPIError(err, ""))
 		return
 	}
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(inst)
+	auditTarget(r.Context(), inst.Host, inst.ID)
+	j, err := jobs.Submit(r.Context(), "publish", fqdn, func(ctx context.Context, step func(string)) (any, error) {
+		setInstanceJob(ctx, inst.ID)
+		err := recordOperation(ctx, "publish", inst, func(ctx context.Context) error {
+			return publishSync(ctx, inst, tmpZip, step)
+		})
+		os.Remove(tmpZip)
+		if err != nil {
+			if prev.State == instRunning && rolledBack(err) {
+				// Se restauró el sitio anterior: la instancia sigue publicada
+				restoreInstance(prev, instPublishing)
+			} else {
+				failInstance(inst.ID, err)
+			}
+			return nil, err
+		}
+		step("registrando instancia")
+		return transitionInstance(byID(inst.ID), []string{instPublishing}, instRunning, nil)
+	}, func(err error) {
+		os.Remove(tmpZip)
+		failInstance(inst.ID, err)
+	})
+	if err != nil {
+		// El despliegue no empezó: la instancia vuelve a su estado anterior
+		os.Remove(tmpZip)
+		restoreInstance(prev, instPublishing)
+		writeAPIError(w, toAPIError(err, "encolando job"))
+		return
+	}
+	writeJobAccepted(w, j)
 }
 
-// handleInstances maneja GET /instances para listar todas las instancias desplegadas.
+// handleInstances maneja GET /instances para listar las instancias del
+// tenant del usuario (todas para un admin), en cualquier estado del ciclo de
+// vida. Acepta los filtros opcionales ?state= y ?tenant= (admin).
 // Retorna JSON con un array de instancias.
 func handleInstances(w http.ResponseWriter, r *http.Request) {
-	list, _ := loadInstances()
+	list, err := store.ListInstances()
+	if err != nil {
+		writeAPIError(w, toAPIError(err, "leyendo instancias"))
+		return
+	}
+	state := r.URL.Query().Get("state")
+	_, byTenant := r.URL.Query()["tenant"]
+	tenant := r.URL.Query().Get("tenant")
+	filtered := []Instance{}
+	for _, it := range list {
+		if !canAccess(r.Context(), it.Tenant) || (state != "" && it.State != state) || (byTenant && it.Tenant != tenant) {
+			continue
+		}
+		filtered = append(filtered, it)
+	}
+	list = filtered
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(list)
 }
 
-// handleDNSLogs maneja GET /dns-logs para obtener los logs de operaciones DNS.
-// Los logs están ordenados por timestamp descendente (más recientes primero).
-// Retorna JSON con un array de logs DNS.
-func handleDNSLogs(w http.ResponseWriter, r *http.Request) {
-	logs, _ := loadDNSLogs()
-	// Ordenar por timestamp descendente (más recientes primero)
-	sort.Slice(logs, func(i, j int) bool {
-		return logs[i].Timestamp > logs[j].Timestamp
-	})
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(logs)
-}
-
 // ============================== DNS Direct (read zone file) =================
 
-// readDNSZoneRaw lee el estado actual de la zona DNS desde el servidor DNS remoto.
-// Intenta primero leer desde named_dump.db (estado en memoria), con fallback al archivo de zona.
-// Retorna el contenido completo de la zona o un error si falla la conexión SSH.
-func readDNSZoneRaw() (string, error) {
-	sshUser := "unix"
-	remoteCmd := "sudo rndc dumpdb -zones >/dev/null 2>&1 && sudo cat /var/cache/bind/named_dump.db || sudo cat /var/lib/bind/db.grid.lab"
-	args := []string{
-		"-o", "StrictHostKeyChecking=no",
-		"-o", "UserKnownHostsFile=/dev/null",
-		"-o", "BatchMode=yes",
-		"-o", "ConnectTimeout=10",
-		sshUser + "@" + dnsServerIP,
-		remoteCmd,
-	}
-	cmd := exec.Command("ssh", args...)
-	var stderr bytes.Buffer
-	cmd.Stderr = &stderr
-	out, err := cmd.Output()
+// readDNSZone lee los registros actuales de zone (dnsZone o la zona inversa)
+// desde el servidor DNS. Usa una transferencia de zona firmada con TSIG (ver
+// transferDNSZone) y, si el servidor no la permite y dnsSSHFallback está
+// activo, la lectura por SSH. source indica de dónde se leyó la zona
+// ("axfr", "ixfr" o "ssh").
+func readDNSZone(ctx context.Context, zone string) (recs []ddns.Record, source string, err error) {
+	z, source, xerr := transferDNSZone(ctx, zone)
+	if xerr == nil {
+		return ddns.NewRecords(z.Records), source, nil
+	}
+	if !dnsSSHFallback || ctx.Err() != nil {
+		return nil, "", xerr
+	}
+	fmt.Printf("Advertencia: %v; se lee la zona por SSH\n", xerr)
+	txt, err := readDNSZoneSSH(ctx, zone)
 	if err != nil {
-		errMsg := err.Error()
-		stderrStr := strings.TrimSpace(stderr.String())
-
-		// Proporcionar mensajes más descriptivos según el tipo de error
-		if strings.Contains(errMsg, "Connection timed out") || strings.Contains(errMsg, "timeout") {
-			return "", fmt.Errorf("no se puede conectar al servidor DNS (%s); verifica que: la VM DNS esté iniciada, el servicio SSH esté activo, la IP %s sea accesible desde esta máquina", dnsServerIP, dnsServerIP)
-		}
-		if strings.Contains(errMsg, "Connection refused") {
-			return "", fmt.Errorf("conexión rechazada al servidor DNS (%s); el servicio SSH puede no estar corriendo", dnsServerIP)
-		}
-		if strings.Contains(errMsg, "Host key verification failed") || strings.Contains(errMsg, "authenticity") {
-			return "", fmt.Errorf("error de autenticación SSH; verifica la configuración de claves")
-		}
-		if strings.Contains(errMsg, "Permission denied") || strings.Contains(stderrStr, "Permission denied") {
-			return "", fmt.Errorf("permisos insuficientes; verifica que el usuario '%s' tenga permisos sudo sin contraseña para rndc y lectura de archivos de zona", sshUser)
+		return nil, "", fmt.Errorf("%w (antes falló la transferencia: %v)", err, xerr)
+	}
+	all, err := ddns.ParseZone(strings.NewReader(txt), zone, dnsServerIP+":"+zone)
+	if err != nil {
+		return nil, "", err
+	}
+	// El volcado de BIND trae todas las zonas del servidor
+	for _, rec := range all {
+		if inZone(rec.Name, zone) {
+			recs = append(recs, rec)
 		}
+	}
+	return recs, "ssh", nil
+}
 
-		// Error genérico con detalles
-		if stderrStr != "" {
-			errMsg += ": " + stderrStr
-		}
-		return "", fmt.Errorf("error SSH a %s@%s: %s", sshUser, dnsServerIP, errMsg)
+// readDNSZoneSSH lee la zona por SSH, con rndc dumpdb (estado en memoria) o
+// el archivo de zona. Requiere sudo sin contraseña en el servidor DNS.
+func readDNSZoneSSH(ctx context.Context, zone string) (string, error) {
+	remoteCmd := "sudo rndc dumpdb -zones >/dev/null 2>&1 && sudo cat /var/cache/bind/named_dump.db || sudo cat /var/lib/bind/db." + zone
+	out, err := dnsSSH.run(ctx, dnsSSHUser, dnsServerIP, remoteCmd)
+	var ee *sshExitError
+	if errors.As(err, &ee) {
+		return "", fmt.Errorf("no se pudo leer la zona; verifica que el usuario '%s' tenga permisos sudo sin contraseña para rndc y lectura de archivos de zona: %w", dnsSSHUser, err)
+	}
+	if err != nil {
+		return "", err
 	}
 	if len(out) == 0 {
 		return "", fmt.Errorf("comando SSH ejecutado pero no retornó datos; verifica que el archivo de zona exista")
@@ -480,154 +593,275 @@ func readDNSZoneRaw() (string, error) {
 	return string(out), nil
 }
 
-// parseDirectARecords parsea el contenido de una zona DNS y extrae solo los registros A (IPv4).
-// Ignora comentarios, directivas TTL y registros de zonas inversas.
-// Retorna una lista de registros A con FQDN e IP normalizados.
-func parseDirectARecords(zoneContent string) []DNSDirectRecord {
-	lines := strings.Split(zoneContent, "\n")
-	var out []DNSDirectRecord
-	for _, ln := range lines {
-		s := strings.TrimSpace(ln)
-		if s == "" || strings.HasPrefix(s, ";") || strings.HasPrefix(strings.ToUpper(s), "$TTL") {
-			continue
-		}
-		if strings.Contains(strings.ToLower(s), "in-addr.arpa") {
-			continue
-		}
-		f := strings.Fields(s)
-		// Buscar índice del token "A"
-		ai := -1
-		for i := 0; i < len(f); i++ {
-			if strings.ToUpper(f[i]) == "A" {
-				ai = i
-				break
-			}
-		}
-		if ai == -1 || ai+1 >= len(f) {
-			continue
-		}
-		// owner es el primer token no numérico y distinto de "IN" antes de "A"
-		owner := ""
-		for k := 0; k < ai; k++ {
-			tk := f[k]
-			tku := strings.ToUpper(tk)
-			if tku == "IN" {
-				continue
-			}
-			// Verificar si es numérico (TTL)
-			if _, err := strconv.Atoi(tk); err == nil {
-				continue
-			}
-			owner = tk
-			break
-		}
-		if owner == "" && len(f) > 0 {
-			owner = f[0]
-		}
-		ip := f[ai+1]
-		fqdn := owner
-		if fqdn == "@" {
-			fqdn = dnsZone
-		}
-		fqdn = strings.TrimSuffix(fqdn, ".")
-		if !strings.HasSuffix(fqdn, dnsZone) && !strings.Contains(fqdn, ".") {
-			fqdn = fqdn + "." + dnsZone
-		}
-		if v4 := net.ParseIP(ip).To4(); v4 != nil {
-			out = append(out, DNSDirectRecord{FQDN: fqdn, IP: v4.String()})
+// dnsZoneError clasifica un error de readDNSZone. Los fallos de
+// autenticación, de clave de host, la falta de clave TSIG o una zona que no
+// se puede interpretar no se arreglan reintentando, así que conservan su
+// código; el resto se informa como DNS inaccesible.
+func dnsZoneError(err error) *APIError {
+	if errors.Is(err, errSSHAuth) || errors.Is(err, errHostKeyMismatch) || errors.Is(err, errDNSNotConfigured) || errors.Is(err, ddns.ErrZoneSyntax) {
+		return toAPIError(err, "leyendo zona DNS")
+	}
+	return newAPIError(codeDNSUnreachable, "leyendo zona DNS", err)
+}
+
+// inZone indica si name es zone o un nombre bajo ella.
+func inZone(name, zone string) bool {
+	name, zone = strings.ToLower(name), strings.ToLower(zone)
+	return name == zone || strings.HasSuffix(name, "."+zone)
+}
+
+// directARecords extrae de recs los registros A de nombres bajo dnsZone.
+func directARecords(recs []ddns.Record) []DNSDirectRecord {
+	out := []DNSDirectRecord{}
+	for _, rec := range recs {
+		if rec.Type == "A" && inZone(rec.Name, dnsZone) {
+			out = append(out, DNSDirectRecord{FQDN: strings.ToLower(rec.Name), IP: rec.Address})
 		}
 	}
 	return out
 }
 
 // handleDNSDirect maneja GET /dns-direct para obtener el estado actual de los registros A.
-// Lee directamente del archivo de zona DNS del servidor remoto.
-// Retorna JSON con un array de registros DNS directos o un objeto de error.
+// Lee la zona directamente del servidor DNS (ver readDNSZone); el header
+// X-DNS-Source indica si se obtuvo por axfr, ixfr o ssh.
+// Con ?type=MX (o AAAA, CNAME, TXT, PTR, NS, SOA, SRV, ANY...) retorna los
+// registros de ese tipo con todos sus datos (ddns.Record).
+// Los usuarios que no son admin solo ven los registros de los hosts de su
+// tenant.
+// Retorna JSON con un array de registros DNS directos, o 503 con un APIError
+// si no se puede leer la zona.
 func handleDNSDirect(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Cache-Control", "no-store, no-cache, must-revalidate")
 	w.Header().Set("Pragma", "no-cache")
 	w.Header().Set("Content-Type", "application/json")
 
-	txt, err := readDNSZoneRaw()
+	typ := r.URL.Query().Get("type")
+	scope, err := scopeFor(r.Context())
 	if err != nil {
-		w.WriteHeader(http.StatusBadGateway)
-		json.NewEncoder(w).Encode(map[string]string{
-			"error": "Error leyendo zona DNS: " + err.Error(),
-		})
+		writeAPIError(w, toAPIError(err, "leyendo instancias"))
+		return
+	}
+	recs, source, err := readDNSZone(r.Context(), dnsZone)
+	if err != nil {
+		writeAPIError(w, dnsZoneError(err))
+		return
+	}
+	w.Header().Set("X-DNS-Source", source)
+	recs = slices.DeleteFunc(recs, func(rec ddns.Record) bool { return !scope.host(rec.Name) })
+	if typ == "" {
+		json.NewEncoder(w).Encode(directARecords(recs))
+		return
+	}
+	recs, err = ddns.FilterType(recs, typ)
+	if err != nil {
+		writeError(w, codeBadRequest, err.Error())
 		return
 	}
-	recs := parseDirectARecords(txt)
 	json.NewEncoder(w).Encode(recs)
 }
 
-// handleDestroy maneja DELETE /destroy/{id} para eliminar una instancia.
-// Ejecuta el script de eliminación, limpia DNS y remueve la instancia del registro.
-// Retorna 204 No Content en éxito o un error.
+// handleDestroy maneja DELETE /destroy/{id} para eliminar una instancia en
+// estado prepared, running, failed, lost o aborted. La instancia pasa a
+// destroying, se encola un job y se responde 202 con su ID. El job elimina
+// la VM y el DNS y luego remueve la instancia del registro (o la deja
+// failed; aborted si ya lo estaba). Como corre en el job, la eliminación
+// continúa aunque el cliente se desconecte.
 func handleDestroy(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodDelete {
-		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		writeError(w, codeMethodNotAllowed, "method not allowed")
 		return
 	}
 	// Extraer ID de la ruta
 	path := strings.TrimPrefix(r.URL.Path, "/destroy/")
 	id := strings.TrimSpace(path)
 	if id == "" {
-		http.Error(w, "id requerido", http.StatusBadRequest)
+		writeError(w, codeBadRequest, "id requerido")
 		return
 	}
-	mu.Lock()
-	list, _ := loadInstances()
-	var target *Instance
-	var idx int = -1
-	for i := range list {
-		if list[i].ID == id {
-			target = &list[i]
-			idx = i
-			break
-		}
-	}
-	mu.Unlock()
-	if target == nil {
-		http.Error(w, "instancia no encontrada", http.StatusNotFound)
+	// Marcar como destroying; falla si tiene otra operación en curso
+	var prev Instance
+	target, err := transitionInstance(keepPrev(ownedBy(r.Context(), byID(id)), &prev), destroyableStates, instDestroying, nil)
+	if err != nil {
+		writeAPIError(w, toAPIError(err, ""))
 		return
 	}
-	// Derivar vmName del FQDN
+	auditTarget(r.Context(), target.Host, target.ID)
 	fqdn := target.Host
-	vmName := strings.SplitN(fqdn, ".", 2)[0]
+	vmName := target.VM
 	ip := target.IP
-	delScript := filepath.Join(scriptsDir, "eliminarInstancia.bat")
-	if err := run("destroy", delScript, vmName, ip, fqdn); err != nil {
-		http.Error(w, "error eliminando instancia", http.StatusBadGateway)
+	// Una instancia aborted sigue sin VM publicable aunque falle la eliminación
+	fail := failInstance
+	if prev.State == instAborted {
+		fail = abortInstance
+	}
+	j, err := jobs.Submit(r.Context(), "destroy", fqdn, func(ctx context.Context, step func(string)) (any, error) {
+		setInstanceJob(ctx, id)
+		step("eliminando VM y DNS")
+		err := recordOperation(ctx, "destroy", target, func(ctx context.Context) error {
+			return prov.Destroy(ctx, vmName, ip, fqdn)
+		})
+		if err != nil {
+			fail(id, err)
+			return nil, err
+		}
+		// Registrar log de DNS eliminado
+		addDNSLog(ctx, "DELETE", fqdn, ip)
+		step("eliminando instancia del registro")
+		// Remover del registro de instancias
+		if err := removeInstance(id); err != nil {
+			return nil, fmt.Errorf("error guardando instancias: %w", err)
+		}
+		return target, nil
+	}, func(err error) { fail(id, err) })
+	if err != nil {
+		// La eliminación no empezó: la instancia vuelve a su estado anterior
+		restoreInstance(prev, instDestroying)
+		writeAPIError(w, toAPIError(err, "encolando job"))
 		return
 	}
-	// Registrar log de DNS eliminado
-	addDNSLog("DELETE", fqdn, ip)
-	// Remover del hosts.json
-	mu.Lock()
-	list, _ = loadInstances()
-	if idx >= 0 && idx < len(list) {
-		list = append(list[:idx], list[idx+1:]...)
-		_ = saveInstances(list)
-	}
-	mu.Unlock()
-	w.WriteHeader(http.StatusNoContent)
+	writeJobAccepted(w, j)
 }
 
 // ============================== Server ======================================
 
 // main inicia el servidor HTTP y registra todas las rutas de la API.
-// El servidor escucha en el puerto 8080 y sirve archivos estáticos desde ./templates.
+// La configuración se lee de config.yaml, variables de entorno y flags (ver
+// loadConfig); por defecto escucha en :8080 y sirve archivos estáticos desde ./templates.
 func main() {
-	http.Handle("/", http.FileServer(http.Dir("./templates")))
-	http.HandleFunc("/prepare", handlePrepare)
-	http.HandleFunc("/publish", handlePublish)
-	http.HandleFunc("/instances", handleInstances)
-	http.HandleFunc("/destroy/", handleDestroy)
-	http.HandleFunc("/dns-logs", handleDNSLogs)
-	http.HandleFunc("/dns-direct", handleDNSDirect)
-
-	fmt.Println("Servidor web en http://localhost:8080")
-	if err := http.ListenAndServe(":8080", nil); err != nil {
+	registerConfigFlags()
+	importOnly := flag.Bool("import-json", false, "importar hosts.json y dns-logs.json al almacenamiento y salir")
+	flag.Parse()
+	if err := loadConfig(); err != nil {
+		fmt.Println("Error de configuración:")
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err := makeDataDirs(); err != nil {
+		fmt.Println("Error creando directorios de datos:", err)
+		os.Exit(1)
+	}
+	if err := loadSecrets(); err != nil {
+		fmt.Println("Error cargando secretos:", err)
+		os.Exit(1)
+	}
+	printSecrets()
+	if err := removeStaleIdentities(); err != nil {
+		fmt.Println("Error borrando claves SSH temporales:", err)
+	}
+	p, err := newProvisioner(provisionerName)
+	if err != nil {
+		fmt.Println("Error de configuración:", err)
+		os.Exit(1)
+	}
+	prov = p
+	st, err := openStore(storageName)
+	if err != nil {
+		fmt.Println("Error abriendo almacenamiento:", err)
+		os.Exit(1)
+	}
+	defer st.Close()
+	store = st
+	if *importOnly {
+		n, m, err := importJSON(store, newJSONStore())
+		if err != nil {
+			fmt.Println("Error importando JSON:", err)
+			os.Exit(1)
+		}
+		fmt.Printf("Importadas %d instancias y %d logs DNS\n", n, m)
+		return
+	}
+	// Con archivos corruptos el servidor arranca en solo lectura: recuperar
+	// instancias o sincronizar leases escribiría sobre datos incompletos
+	problems, err := checkIntegrity()
+	printIntegrity(problems, err)
+	if len(problems) == 0 {
+		if err := recoverInstances(); err != nil {
+			fmt.Println("Error recuperando instancias:", err)
+			os.Exit(1)
+		}
+		if err := syncLeases(); err != nil {
+			fmt.Println("Error sincronizando IPAM:", err)
+			os.Exit(1)
+		}
+		if err := migrateOpLogs(); err != nil {
+			fmt.Println("Error migrando logs de operaciones:", err)
+		}
+	}
+	if err := loadUsers(); err != nil {
+		fmt.Println("Error cargando usuarios:", err)
+		os.Exit(1)
+	}
+	if err := loadTenants(); err != nil {
+		fmt.Println("Error cargando tenants:", err)
+		os.Exit(1)
+	}
+	if err := loadAudit(); err != nil {
+		fmt.Println("Error leyendo auditoría:", err)
+		os.Exit(1)
+	}
+	jm, err := newJobManager(2)
+	if err != nil {
+		fmt.Println("Error cargando jobs:", err)
+		os.Exit(1)
+	}
+	jobs = jm
+
+	// Roles mínimos de cada endpoint (ver requireRole). Los endpoints de
+	// consulta rechazan por sí mismos los métodos que no son GET.
+	viewer := methodRoles{"": roleViewer}
+	deployer := methodRoles{"": roleDeployer}
+	admin := methodRoles{"": roleAdmin}
+	jobRoles := methodRoles{"": roleViewer, http.MethodPost: roleDeployer}
+	instanceRoles := methodRoles{"": roleViewer, http.MethodPatch: roleAdmin, http.MethodPost: roleAdmin}
+
+	http.Handle("/", http.FileServer(http.Dir(templatesDir)))
+	http.HandleFunc("/login", audited(auditOps{http.MethodPost: "login"}, handleLogin))
+	http.HandleFunc("/logout", audited(auditOps{http.MethodPost: "logout"}, handleLogout))
+	http.HandleFunc("/me", requireRole(viewer, handleMe))
+	http.HandleFunc("/prepare", audited(auditOps{http.MethodPost: "prepare"}, requireRole(deployer, handlePrepare)))
+	http.HandleFunc("/publish", audited(auditOps{http.MethodPost: "publish"}, requireRole(deployer, handlePublish)))
+	http.HandleFunc("/instances", requireRole(viewer, handleInstances))
+	http.HandleFunc("/instances/", audited(auditOps{http.MethodPatch: "instance.update", http.MethodPost: "instance.ptr_repair"}, requireRole(instanceRoles, handleInstance)))
+	http.HandleFunc("/destroy/", audited(auditOps{http.MethodDelete: "destroy"}, requireRole(deployer, handleDestroy)))
+	http.HandleFunc("/dns-logs", requireRole(viewer, handleDNSLogs))
+	http.HandleFunc("/dns-direct", requireRole(viewer, handleDNSDirect))
+	http.HandleFunc("/dns-reverse", requireRole(viewer, handleDNSReverse))
+	http.HandleFunc("/jobs", requireRole(viewer, handleJobs))
+	http.HandleFunc("/jobs/", audited(auditOps{http.MethodPost: "job.cancel"}, requireRole(jobRoles, handleJob)))
+	http.HandleFunc("/ipam", requireRole(viewer, handleIPAM))
+	http.HandleFunc("/drift", requireRole(viewer, handleDrift))
+	http.HandleFunc("/reconcile", audited(auditOps{http.MethodPost: "reconcile"}, requireRole(admin, handleReconcile)))
+	reservationOps := auditOps{http.MethodPost: "ipam.reserve", http.MethodDelete: "ipam.release"}
+	http.HandleFunc("/ipam/reservations", audited(reservationOps, requireRole(admin, handleIPAMReservations)))
+	http.HandleFunc("/ipam/reservations/", audited(reservationOps, requireRole(admin, handleIPAMReservations)))
+	http.HandleFunc("/storage", requireRole(viewer, handleStorage))
+	http.HandleFunc("/storage/check", audited(auditOps{http.MethodPost: "storage.check"}, requireRole(admin, handleStorageCheck)))
+	http.HandleFunc("/audit", requireRole(admin, handleAudit))
+	http.HandleFunc("/audit/export", requireRole(admin, handleAuditExport))
+	userOps := auditOps{http.MethodPost: "user.create", http.MethodPatch: "user.update", http.MethodDelete: "user.delete"}
+	http.HandleFunc("/users", audited(userOps, requireRole(admin, handleUsers)))
+	http.HandleFunc("/users/", audited(userOps, requireRole(admin, handleUsers)))
+	tenantOps := auditOps{http.MethodPut: "tenant.quota", http.MethodDelete: "tenant.quota_reset"}
+	tenantRoles := methodRoles{"": roleAdmin, http.MethodGet: roleViewer}
+	http.HandleFunc("/tenants", audited(tenantOps, requireRole(tenantRoles, handleTenants)))
+	http.HandleFunc("/tenants/", audited(tenantOps, requireRole(tenantRoles, handleTenants)))
+	tokenOps := auditOps{http.MethodPost: "token.create", http.MethodDelete: "token.revoke"}
+	http.HandleFunc("/tokens", audited(tokenOps, requireRole(viewer, handleTokens)))
+	http.HandleFunc("/tokens/", audited(tokenOps, requireRole(viewer, handleTokens)))
+	http.HandleFunc("/config", requireRole(admin, handleConfig))
+	knownHostOps := auditOps{http.MethodDelete: "known_host.forget"}
+	http.HandleFunc("/known-hosts", audited(knownHostOps, requireRole(admin, handleKnownHosts)))
+	http.HandleFunc("/known-hosts/", audited(knownHostOps, requireRole(admin, handleKnownHosts)))
+	secretOps := auditOps{http.MethodPut: "secret.rotate", http.MethodDelete: "secret.delete", http.MethodPost: "secret.reload"}
+	http.HandleFunc("/secrets", audited(secretOps, requireRole(admin, handleSecrets)))
+	http.HandleFunc("/secrets/", audited(secretOps, requireRole(admin, handleSecrets)))
+
+	host, port, _ := net.SplitHostPort(listenAddr)
+	if host == "" {
+		host = "localhost"
+	}
+	fmt.Printf("Servidor web en http://%s (provisioner: %s)\n", net.JoinHostPort(host, port), provisionerName)
+	if err := http.ListenAndServe(listenAddr, nil); err != nil {
 		fmt.Println("Error al iniciar el servidor:", err)
 	}
 }
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ============================== Operation Logs ==============================
+
+// maxCapture bytes máximos guardados por cada stream capturado (stdout,
+// stderr o salida combinada de la operación).
+const maxCapture = 256 << 10
+
+// CommandLog registra una invocación de un comando externo.
+type CommandLog struct {
+	Name       string   `json:"name"`            // Ejecutable invocado
+	Args       []string `json:"args"`            // Argumentos
+	StartedAt  string   `json:"started_at"`      // Inicio (RFC3339)
+	DurationMs int64    `json:"duration_ms"`     // Duración en milisegundos
+	ExitCode   int      `json:"exit_code"`       // Código de salida (-1 si no arrancó)
+	Stdout     string   `json:"stdout"`          // Salida estándar capturada
+	Stderr     string   `json:"stderr"`          // Salida de error capturada
+	Error      string   `json:"error,omitempty"` // Error al ejecutar, si lo hubo
+}
+
+// OpLog registra una operación (prepare, publish o destroy) sobre una
+// instancia, con todos los comandos que ejecutó y su salida combinada.
+type OpLog struct {
+	ID         string       `json:"id"`                    // Identificador de la operación
+	JobID      string       `json:"job_id,omitempty"`      // Job asociado, si corrió en segundo plano
+	InstanceID string       `json:"instance_id,omitempty"` // Instancia; vacío en los logs anteriores (ver migrateOpLogs)
+	Operation  string       `json:"operation"`             // "prepare", "publish" o "destroy"
+	Host       string       `json:"host"`                  // FQDN del host
+	StartedAt  string       `json:"started_at"`            // Inicio (RFC3339)
+	FinishedAt string       `json:"finished_at"`           // Fin (RFC3339)
+	DurationMs int64        `json:"duration_ms"`           // Duración total en milisegundos
+	Error      string       `json:"error,omitempty"`       // Error final, si falló
+	Output     string       `json:"output"`                // Salida combinada (progreso y comandos)
+	Commands   []CommandLog `json:"commands"`              // Comandos ejecutados en orden
+}
+
+// opRecorder acumula los comandos y la salida de una operación en curso.
+type opRecorder struct {
+	mu       sync.Mutex
+	commands []CommandLog
+	output   cappedBuffer
+}
+
+// recorderKey clave de contexto para el opRecorder de la operación.
+type recorderKey struct{}
+
+// opRecorderFrom retorna el opRecorder del contexto, o nil si no hay uno.
+func opRecorderFrom(ctx context.Context) *opRecorder {
+	rec, _ := ctx.Value(recorderKey{}).(*opRecorder)
+	return rec
+}
+
+// addCommand agrega un comando ejecutado al registro.
+func (r *opRecorder) addCommand(c CommandLog) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.commands = append(r.commands, c)
+}
+
+// Write agrega salida combinada de la operación.
+func (r *opRecorder) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.output.Write(p)
+}
+
+// cappedBuffer es un buffer que descarta lo que exceda maxCapture bytes.
+type cappedBuffer struct {
+	buf       bytes.Buffer
+	truncated bool
+}
+
+// Write guarda p hasta completar maxCapture bytes; siempre reporta éxito.
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if room := maxCapture - b.buf.Len(); room < len(p) {
+		if room > 0 {
+			b.buf.Write(p[:room])
+		}
+		b.truncated = true
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+// String retorna el contenido, marcando si fue truncado.
+func (b *cappedBuffer) String() string {
+	if b.truncated {
+		return b.buf.String() + "\n[... salida truncada ...]\n"
+	}
+	return b.buf.String()
+}
+
+// truncateCapture recorta s a maxCapture bytes como lo haría cappedBuffer.
+func truncateCapture(s string) string {
+	var b cappedBuffer
+	b.Write([]byte(s))
+	return b.String()
+}
+
+// recordOperation ejecuta fn registrando la salida y los comandos que lance
+// (vía runCmd) y guarda el OpLog resultante en el directorio de la instancia
+// inst. Retorna el error de fn.
+func recordOperation(ctx context.Context, op string, inst Instance, fn func(ctx context.Context) error) error {
+	rec := &opRecorder{}
+	stdout, _ := opWriters(ctx)
+	ctx = withOutput(ctx, io.MultiWriter(stdout, rec))
+	ctx = context.WithValue(ctx, recorderKey{}, rec)
+
+	start := time.Now()
+	err := fn(ctx)
+	end := time.Now()
+
+	rec.mu.Lock()
+	l := OpLog{
+		ID:         fmt.Sprintf("%s-%d", op, start.UnixNano()),
+		JobID:      jobIDFrom(ctx),
+		InstanceID: inst.ID,
+		Operation:  op,
+		Host:       inst.Host,
+		StartedAt:  start.UTC().Format(time.RFC3339),
+		FinishedAt: end.UTC().Format(time.RFC3339),
+		DurationMs: end.Sub(start).Milliseconds(),
+		Output:     rec.output.String(),
+		Commands:   rec.commands,
+	}
+	rec.mu.Unlock()
+	if l.Commands == nil {
+		l.Commands = []CommandLog{}
+	}
+	if err != nil {
+		l.Error = err.Error()
+	}
+	if serr := saveOpLog(l); serr != nil {
+		fmt.Println("Error guardando log de operación:", serr)
+	}
+	return err
+}
+
+// runCmd ejecuta name con el timeout del paso step, enviando su salida a la
+// salida de la operación. Si stdin no es nil, se usa como entrada estándar.
+// Si el contexto tiene un opRecorder, registra argumentos, salida, código y
+// duración de la invocación.
+func runCmd(ctx context.Context, step string, stdin io.Reader, name string, args ...string) error {
+	sctx, cancel := stepContext(ctx, step)
+	defer cancel()
+	cmd := commandContext(sctx, name, args...)
+	cmd.Stdin = stdin
+	if env := cmdEnvFrom(ctx); env != nil {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	stdout, stderr := opWriters(ctx)
+	rec := opRecorderFrom(ctx)
+	if rec == nil {
+		cmd.Stdout, cmd.Stderr = stdout, stderr
+		return stepErr(ctx, sctx, step, cmd.Run())
+	}
+	var outBuf, errBuf cappedBuffer
+	cmd.Stdout = io.MultiWriter(stdout, &outBuf)
+	cmd.Stderr = io.MultiWriter(stderr, &errBuf)
+	start := time.Now()
+	err := stepErr(ctx, sctx, step, cmd.Run())
+	c := CommandLog{
+		Name:       filepath.Base(name),
+		Args:       args,
+		StartedAt:  start.UTC().Format(time.RFC3339),
+		DurationMs: time.Since(start).Milliseconds(),
+		ExitCode:   exitCode(err),
+		Stdout:     outBuf.String(),
+		Stderr:     errBuf.String(),
+	}
+	if err != nil {
+		c.Error = err.Error()
+	}
+	rec.addCommand(c)
+	return err
+}
+
+// exitCode retorna el código de salida de un error de os/exec
+// (0 si err es nil, -1 si el proceso no llegó a ejecutarse).
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	}
+	return -1
+}
+
+// ============================== Operation Logs Storage ======================
+
+// opLogDir retorna el directorio de logs de una instancia. Se usa el ID y no
+// el host: una instancia nueva con el mismo host no hereda los logs de otra.
+func opLogDir(instanceID string) string {
+	return filepath.Join(opLogsDir, safeDirName(instanceID))
+}
+
+// safeDirName reemplaza los caracteres de name que no son seguros en un
+// nombre de directorio.
+func safeDirName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '-', r == '_':
+			return r
+		}
+		return '_'
+	}, strings.ToLower(name))
+}
+
+// saveOpLog guarda el log de una operación en su propio archivo JSON.
+func saveOpLog(l OpLog) error {
+	dir := opLogDir(l.InstanceID)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, l.ID+".json")
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(l); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// loadOpLogs carga los logs de operaciones de una instancia, más recientes primero.
+func loadOpLogs(instanceID string) ([]OpLog, error) {
+	entries, err := os.ReadDir(opLogDir(instanceID))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []OpLog{}, nil
+		}
+		return nil, err
+	}
+	out := []OpLog{}
+	for _, e := range entries {
+		if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(opLogDir(instanceID), e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		var l OpLog
+		if err := json.Unmarshal(data, &l); err != nil {
+			return nil, fmt.Errorf("log %s corrupto: %w", e.Name(), err)
+		}
+		out = append(out, l)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].StartedAt != out[j].StartedAt {
+			return out[i].StartedAt > out[j].StartedAt
+		}
+		return out[i].ID > out[j].ID
+	})
+	return out, nil
+}
+
+// migrateOpLogs mueve los logs de los directorios por host (formato anterior)
+// al directorio de la instancia actual de ese host, si la operación empezó
+// después de crearla. Los que no corresponden a ninguna instancia quedan donde
+// están y ya no se muestran.
+func migrateOpLogs() error {
+	list, err := store.ListInstances()
+	if err != nil {
+		return err
+	}
+	byHost := map[string]Instance{}
+	for _, it := range list {
+		byHost[safeDirName(it.Host)] = it
+	}
+	for dir, it := range byHost {
+		entries, err := os.ReadDir(filepath.Join(opLogsDir, dir))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		for _, e := range entries {
+			if e.IsDir() || !strings.HasSuffix(e.Name(), ".json") {
+				continue
+			}
+			src := filepath.Join(opLogsDir, dir, e.Name())
+			data, err := os.ReadFile(src)
+			if err != nil {
+				return err
+			}
+			var l OpLog
+			if json.Unmarshal(data, &l) != nil || l.InstanceID != "" || l.StartedAt < it.CreatedAt {
+				continue
+			}
+			l.InstanceID = it.ID
+			if err := saveOpLog(l); err != nil {
+				return err
+			}
+			if err := os.Remove(src); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// formatOpLogText arma una versión en texto plano de los logs para descargar.
+func formatOpLogText(logs []OpLog) string {
+	var b strings.Builder
+	for _, l := range logs {
+		result := "OK"
+		if l.Error != "" {
+			result = "ERROR: " + l.Error
+		}
+		fmt.Fprintf(&b, "===== %s %s (%s) =====\n", l.Operation, l.ID, l.Host)
+		fmt.Fprintf(&b, "inicio: %s  fin: %s  duración: %dms\nresultado: %s\n\n", l.StartedAt, l.FinishedAt, l.DurationMs, result)
+		for i, c := range l.Commands {
+			fmt.Fprintf(&b, "--- [%d] $ %s %s\n", i+1, c.Name, strings.Join(c.Args, " "))
+			fmt.Fprintf(&b, "    exit %d, %dms, %s\n", c.ExitCode, c.DurationMs, c.StartedAt)
+			if c.Stdout != "" {
+				fmt.Fprintf(&b, "    stdout:\n%s\n", indent(c.Stdout))
+			}
+			if c.Stderr != "" {
+				fmt.Fprintf(&b, "    stderr:\n%s\n", indent(c.Stderr))
+			}
+		}
+		fmt.Fprintf(&b, "--- salida combinada:\n%s\n\n", indent(l.Output))
+	}
+	return b.String()
+}
+
+// indent antepone ocho espacios a cada línea de s.
+func indent(s string) string {
+	lines := strings.Split(strings.TrimRight(s, "\r\n"), "\n")
+	for i, ln := range lines {
+		lines[i] = "        " + strings.TrimRight(ln, "\r")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// ============================== Operation Logs Handlers =====================
+
+// handleInstanceLogs maneja GET /instances/{id}/logs con los logs de todas las
+// operaciones de la instancia. Parámetros opcionales:
+// ?op=<id> filtra una operación y ?format=text descarga los logs como texto.
+func handleInstanceLogs(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodGet {
+		writeError(w, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	target, err := store.GetInstance(id)
+	if err == nil && !canAccess(r.Context(), target.Tenant) {
+		err = errInstanceMissing
+	}
+	if err != nil {
+		writeAPIError(w, toAPIError(err, ""))
+		return
+	}
+	logs, err := loadOpLogs(target.ID)
+	if err != nil {
+		writeError(w, codeInternal, "error leyendo logs: "+err.Error())
+		return
+	}
+	if op := r.URL.Query().Get("op"); op != "" {
+		filtered := []OpLog{}
+		for _, l := range logs {
+			if l.ID == op || l.JobID == op {
+				filtered = append(filtered, l)
+			}
+		}
+		logs = filtered
+	}
+	if r.URL.Query().Get("format") == "text" {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", target.Host+"-logs.txt"))
+		io.WriteString(w, formatOpLogText(logs))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(logs)
+}
+
+// handleInstance enruta /instances/{id} y sus subrutas /instances/{id}/...
+func handleInstance(w http.ResponseWriter, r *http.Request) {
+	rest := strings.Trim(strings.TrimPrefix(r.URL.Path, "/instances/"), "/")
+	if rest == "" {
+		handleInstances(w, r)
+		return
+	}
+	id, sub, _ := strings.Cut(rest, "/")
+	switch sub {
+	case "":
+		handleInstanceByID(w, r, id)
+	case "logs":
+		handleInstanceLogs(w, r, id)
+	case "ptr":
+		handleInstancePTR(w, r, id)
+	default:
+		http.NotFound(w, r)
+	}
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestOpLogsByInstance(t *testing.T) {
+	lifecycleTestEnv(t)
+	prev := opLogsDir
+	opLogsDir = filepath.Join(t.TempDir(), "oplogs")
+	t.Cleanup(func() { opLogsDir = prev })
+
+	// Un log en el formato anterior, por host, de una instancia ya eliminada
+	old := OpLog{ID: "destroy-1", Operation: "destroy", Host: "web1.grid.lab", StartedAt: "2026-01-01T00:00:00Z"}
+	if err := os.MkdirAll(filepath.Join(opLogsDir, "web1.grid.lab"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(opLogsDir, "web1.grid.lab", old.ID+".json"), []byte(`{"id":"destroy-1","operation":"destroy","host":"web1.grid.lab","started_at":"2026-01-01T00:00:00Z"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	inst, _, err := createInstance(context.Background(), "web1.grid.lab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateOpLogs(); err != nil {
+		t.Fatal(err)
+	}
+	if err := recordOperation(context.Background(), "prepare", inst, func(context.Context) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	logs, err := loadOpLogs(inst.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(logs) != 1 || logs[0].Operation != "prepare" || logs[0].InstanceID != inst.ID {
+		t.Errorf("logs de %s = %+v, quiero solo su prepare", inst.ID, logs)
+	}
+}
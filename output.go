@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+// ============================== Operation Output ============================
+
+// outputKey clave de contexto para el destino de la salida de una operación.
+type outputKey struct{}
+
+// withOutput retorna un contexto cuya salida de comandos y progreso se
+// escribe en w (además de la consola del servidor si w la incluye).
+func withOutput(ctx context.Context, w io.Writer) context.Context {
+	return context.WithValue(ctx, outputKey{}, w)
+}
+
+// opOutput retorna el destino de salida de la operación en curso, o nil si el
+// contexto no tiene uno (en ese caso se usa la consola del servidor).
+func opOutput(ctx context.Context) io.Writer {
+	w, _ := ctx.Value(outputKey{}).(io.Writer)
+	return w
+}
+
+// lineWriter es un io.Writer que corta la salida en líneas y entrega cada
+// una a emit, sin el salto de línea final (\n o \r\n).
+type lineWriter struct {
+	mu   sync.Mutex
+	buf  []byte
+	emit func(string)
+}
+
+// newLineWriter crea un lineWriter que llama a emit por cada línea completa.
+func newLineWriter(emit func(string)) *lineWriter {
+	return &lineWriter{emit: emit}
+}
+
+// Write acumula p y emite las líneas completas.
+func (lw *lineWriter) Write(p []byte) (int, error) {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	lw.buf = append(lw.buf, p...)
+	for {
+		i := bytes.IndexByte(lw.buf, '\n')
+		if i < 0 {
+			break
+		}
+		lw.emit(strings.TrimRight(string(lw.buf[:i]), "\r"))
+		lw.buf = lw.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+// Flush emite el resto pendiente como una última línea, si lo hay.
+func (lw *lineWriter) Flush() {
+	lw.mu.Lock()
+	defer lw.mu.Unlock()
+	if len(lw.buf) > 0 {
+		lw.emit(strings.TrimRight(string(lw.buf), "\r"))
+		lw.buf = nil
+	}
+}
+
+// opWriters retorna los destinos de stdout y stderr para un comando externo:
+// la salida de la operación si el contexto la tiene, o la consola del servidor.
+func opWriters(ctx context.Context) (stdout, stderr io.Writer) {
+	if w := opOutput(ctx); w != nil {
+		return w, w
+	}
+	return os.Stdout, os.Stderr
+}
+
+// opLogf escribe una línea de progreso en la salida de la operación.
+func opLogf(ctx context.Context, format string, args ...any) {
+	w, _ := opWriters(ctx)
+	fmt.Fprintf(w, format+"\n", args...)
+}
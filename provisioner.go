@@ -0,0 +1,447 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"computacion-nube-proyecto/vbox"
+)
+
+// ============================== Provisioner =================================
+
+// Provisioner abstrae el backend que crea, despliega y elimina las VMs.
+// Permite cambiar la implementación (scripts batch, simulada, etc.) sin tocar
+// los handlers HTTP. El progreso y la salida de los comandos se escriben en la
+// salida de la operación del contexto (ver withOutput).
+type Provisioner interface {
+	// CreateVM crea la VM, reserva la IP y registra el DNS (A y PTR) del host.
+	CreateVM(ctx context.Context, vmName, ip, fqdn string) error
+	// Deploy despliega el ZIP en la VM ya preparada y valida que el sitio
+	// responda. Registra cómo restaurar el sitio anterior (ver publishSync).
+	Deploy(ctx context.Context, ip, fqdn, zipPath string) error
+	// Destroy elimina la VM, su reserva DHCP y sus registros DNS.
+	Destroy(ctx context.Context, vmName, ip, fqdn string) error
+	// Status retorna el estado actual de la VM asociada al FQDN.
+	Status(ctx context.Context, fqdn string) (VMStatus, error)
+	// ListVMs retorna todas las VMs del backend con su estado (sin host ni IP).
+	ListVMs(ctx context.Context) ([]VMStatus, error)
+}
+
+// VMStatus describe el estado de una VM según el backend de aprovisionamiento.
+type VMStatus struct {
+	Name  string `json:"name"`  // Nombre de la VM
+	Host  string `json:"host"`  // FQDN del host
+	IP    string `json:"ip"`    // Dirección IP resuelta
+	State string `json:"state"` // "running", "poweroff", "notfound" o "unknown"
+}
+
+// Estados posibles de una VM reportados por Status.
+const (
+	vmStateRunning  = "running"
+	vmStatePowerOff = "poweroff"
+	vmStateNotFound = "notfound"
+	vmStateUnknown  = "unknown"
+)
+
+// newProvisioner construye el backend de aprovisionamiento según su nombre.
+// Valores soportados: "batch" (scripts .bat de Windows), "vbox" (VBoxManage
+// desde Go) y "fake" (en memoria).
+func newProvisioner(name string) (Provisioner, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", "batch":
+		return &batchProvisioner{scriptsDir: scriptsDir, dns: newRFC2136Updater(), dnsServer: dnsServerIP}, nil
+	case "vbox":
+		return newVBoxProvisioner()
+	case "fake":
+		return newFakeProvisioner(), nil
+	default:
+		return nil, fmt.Errorf("provisioner desconocido %q (use batch, vbox o fake)", name)
+	}
+}
+
+// ============================== Batch Provisioner ===========================
+
+// batchProvisioner implementa Provisioner invocando los scripts batch de
+// scriptsDir (crearVMyDNS.bat, desplegarSitio.bat, eliminarInstancia.bat).
+// Requiere Windows con VirtualBox. Los registros DNS se aplican desde Go.
+type batchProvisioner struct {
+	scriptsDir string     // Directorio de los scripts .bat
+	dns        dnsUpdater // Aplica los registros A y PTR
+	dnsServer  string     // IP del servidor DNS usado para validar registros
+}
+
+// batchUnsafe son los caracteres que cmd.exe interpreta aunque el argumento
+// vaya entre comillas.
+const batchUnsafe = "\"%!^&|<>\r\n"
+
+// script ejecuta name.bat de scriptsDir como el paso name, pasándole la
+// configuración en variables de entorno (ver scriptEnv). Los hostnames ya
+// vienen validados (ver normalizeHost); igual se rechaza cualquier argumento
+// que cmd.exe pueda interpretar.
+func (p *batchProvisioner) script(ctx context.Context, name string, args ...string) error {
+	for _, a := range args {
+		if strings.ContainsAny(a, batchUnsafe) {
+			return fmt.Errorf("%s: argumento no permitido %q", name, a)
+		}
+	}
+	identity, cleanup, err := sshIdentity()
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+	env, err := scriptEnv()
+	if err != nil {
+		return err
+	}
+	env = append(env, identity...)
+	return run(withCmdEnv(ctx, env), name, filepath.Join(p.scriptsDir, name+".bat"), args...)
+}
+
+// CreateVM ejecuta crearVMyDNS.bat, registra el A y el PTR del host y valida
+// que el registro A quede resoluble. Registra como deshacer la VM (con
+// eliminarInstancia.bat) y el DNS para el rollback de la operación.
+func (p *batchProvisioner) CreateVM(ctx context.Context, vmName, ip, fqdn string) error {
+	// La VM nueva tendrá otra clave de host que la anterior con esta IP
+	forgetVMHostKey(ip)
+	// Se guarda la salida para recuperar el código de UnirMaquinaDisco.bat
+	var out bytes.Buffer
+	stdout, _ := opWriters(ctx)
+	sctx := withOutput(ctx, io.MultiWriter(stdout, &out))
+	err := p.script(sctx, "crearVMyDNS", vmName, ip, fqdn)
+	// Con código 1 no se creó nada y con 2 la VM es de otra instancia; en los
+	// demás casos (incluido timeout) la VM y su reserva DHCP pueden existir.
+	if code := exitCode(err); code != 1 && code != 2 {
+		onRollback(ctx, "eliminar VM y reserva DHCP", func(ctx context.Context) error {
+			return p.script(ctx, "eliminarInstancia", vmName, ip, fqdn)
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("crear VM falló: %w", &scriptError{
+			Script:     "crearVMyDNS.bat",
+			ExitCode:   exitCode(err),
+			AttachCode: attachExitCode(out.String()),
+			Err:        err,
+		})
+	}
+	err = p.dns.AddHost(fqdn, ip)
+	if !errors.Is(err, errDNSNotConfigured) {
+		onRollback(ctx, "eliminar registros DNS", func(ctx context.Context) error {
+			return p.dns.DeleteHost(fqdn, ip)
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("crear VM falló: DNS: %w", err)
+	}
+	// Validar que el registro DNS A está disponible
+	if err := run(ctx, "nslookup", "nslookup", fqdn, p.dnsServer); err != nil {
+		return fmt.Errorf("DNS A no disponible para %s: %w", fqdn, errDNSNotApplied)
+	}
+	return nil
+}
+
+// attachCodeRe reconoce la línea con la que crearVMyDNS.bat informa el código
+// de salida de UnirMaquinaDisco.bat.
+var attachCodeRe = regexp.MustCompile(`UnirMaquinaDisco codigo (\d+)`)
+
+// attachExitCode extrae el código de UnirMaquinaDisco.bat de la salida de
+// crearVMyDNS.bat, o 0 si no aparece.
+func attachExitCode(out string) int {
+	m := attachCodeRe.FindStringSubmatch(out)
+	if m == nil {
+		return 0
+	}
+	n, _ := strconv.Atoi(m[1])
+	return n
+}
+
+// Deploy respalda el sitio publicado, ejecuta desplegarSitio.bat y verifica
+// que /health.txt responda. Registra la restauración del respaldo para el
+// rollback de la publicación.
+func (p *batchProvisioner) Deploy(ctx context.Context, ip, fqdn, zipPath string) error {
+	if err := backupSite(ctx, vmSSHUser, ip, fqdn); err != nil {
+		return err
+	}
+	if err := p.script(ctx, "desplegarSitio", ip, fqdn, zipPath); err != nil {
+		return fmt.Errorf("despliegue falló: %w", &scriptError{Script: "desplegarSitio.bat", ExitCode: exitCode(err), Err: err})
+	}
+	// El PTR no bloquea el despliegue: GET /dns-reverse lo informa y
+	// POST /instances/{id}/ptr lo repara
+	if err := checkPTRAt(ctx, p.dnsServer, ip, fqdn); err != nil {
+		opLogf(ctx, "Advertencia: %v", err)
+	}
+	if err := checkHealth(ctx, ip, fqdn); err != nil {
+		return err
+	}
+	dropSiteBackup(ctx, vmSSHUser, ip, fqdn)
+	return nil
+}
+
+// Destroy ejecuta eliminarInstancia.bat (VM y reserva DHCP) y limpia el DNS.
+func (p *batchProvisioner) Destroy(ctx context.Context, vmName, ip, fqdn string) error {
+	if err := p.script(ctx, "eliminarInstancia", vmName, ip, fqdn); err != nil {
+		return &scriptError{Script: "eliminarInstancia.bat", ExitCode: exitCode(err), Err: err}
+	}
+	forgetVMHostKey(ip)
+	return p.dns.DeleteHost(fqdn, ip)
+}
+
+// Status consulta VBoxManage por el estado de la VM y resuelve su IP vía DNS.
+func (p *batchProvisioner) Status(ctx context.Context, fqdn string) (VMStatus, error) {
+	st := VMStatus{Name: strings.SplitN(fqdn, ".", 2)[0], Host: fqdn, State: vmStateUnknown}
+	ip, err := resolveIPv4(ctx, fqdn)
+	if err != nil {
+		return st, err
+	}
+	st.IP = ip
+	vb := vbox.New()
+	vb.Timeout = stepTimeout("vboxmanage")
+	info, err := vb.VMInfo(ctx, st.Name)
+	switch {
+	case errors.Is(err, vbox.ErrVMNotFound):
+		st.State = vmStateNotFound
+	case err == nil:
+		st.State = info.State()
+	}
+	return st, nil
+}
+
+// ListVMs lista las VMs registradas en VirtualBox.
+func (p *batchProvisioner) ListVMs(ctx context.Context) ([]VMStatus, error) {
+	vb := vbox.New()
+	vb.Timeout = stepTimeout("vboxmanage")
+	return listVBoxVMs(ctx, vb)
+}
+
+// listVBoxVMs arma el inventario de VMs con "list vms" y "list runningvms".
+func listVBoxVMs(ctx context.Context, vb *vbox.Manager) ([]VMStatus, error) {
+	all, err := vb.ListVMs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	running, err := vb.ListRunningVMs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]VMStatus, 0, len(all))
+	for _, name := range all {
+		st := VMStatus{Name: name, State: vmStatePowerOff}
+		if slices.Contains(running, name) {
+			st.State = vmStateRunning
+		}
+		out = append(out, st)
+	}
+	return out, nil
+}
+
+// checkHealth valida que el servicio web responda en /health.txt.
+// Intenta primero por FQDN y luego por IP enviando el Host header del sitio.
+func checkHealth(ctx context.Context, ip, fqdn string) error {
+	client := &http.Client{Timeout: stepTimeout("health")}
+	// Intentar por FQDN primero
+	req, err := http.NewRequestWithContext(ctx, "GET", "http://"+fqdn+"/health.txt", nil)
+	if err != nil {
+		return fmt.Errorf("crear request HTTP: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err == nil && resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		resp.Body.Close()
+		return nil
+	}
+	if resp != nil {
+		resp.Body.Close()
+	}
+	// Fallback: por IP con Host header para que coincida el VirtualHost
+	req, err = http.NewRequestWithContext(ctx, "GET", "http://"+ip+"/health.txt", nil)
+	if err != nil {
+		return fmt.Errorf("crear request HTTP: %w", err)
+	}
+	req.Host = fqdn
+	resp2, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: servicio web no responde en %s: %w", errHealthCheck, ip, err)
+	}
+	defer resp2.Body.Close()
+	if resp2.StatusCode < 200 || resp2.StatusCode >= 300 {
+		return fmt.Errorf("%w: servicio web HTTP %d en %s", errHealthCheck, resp2.StatusCode, ip)
+	}
+	return nil
+}
+
+// ============================== Site Backup =================================
+
+// siteBackupCmd guarda en la VM la configuración de Apache y el DocumentRoot
+// del sitio $1 antes de desplegar, para que siteRestoreCmd los recupere si el
+// despliegue falla. Sin vhost previo (primera publicación) no guarda nada.
+// Solo se respalda un DocumentRoot bajo /var/www/: la restauración lo borra.
+const siteBackupCmd = `b="/var/tmp/cnp-site-$1"; c="/etc/apache2/sites-available/$1.conf"; ` +
+	`sudo rm -f "$b.tgz" "$b.root"; [ -f "$c" ] || exit 0; ` +
+	`root=$(sed -n 's/^[[:space:]]*DocumentRoot[[:space:]]*"\{0,1\}\([^"[:space:]]*\).*/\1/p' "$c" | head -n 1); ` +
+	`case "$root" in /var/www/?*) ;; *) root= ;; esac; ` +
+	`echo "$root" | sudo tee "$b.root" >/dev/null && ` +
+	`sudo tar czf "$b.tgz" -C / etc/apache2/sites-available etc/apache2/sites-enabled ${root:+"${root#/}"}`
+
+// siteRestoreCmd deja el sitio $1 y los vhosts de Apache como los guardó
+// siteBackupCmd. Si no había respaldo (primera publicación) vuelve a dejar
+// 000-default como sitio por defecto.
+const siteRestoreCmd = `b="/var/tmp/cnp-site-$1"; ` +
+	`if [ -f "$b.tgz" ]; then ` +
+	`root=$(cat "$b.root"); case "$root" in /var/www/?*) sudo rm -rf "$root" ;; esac; ` +
+	`sudo rm -rf /etc/apache2/sites-available /etc/apache2/sites-enabled && sudo tar xzf "$b.tgz" -C / || exit 1; ` +
+	`else sudo a2dissite "000-$1.conf" >/dev/null 2>&1; sudo a2ensite 000-default.conf >/dev/null 2>&1; fi; ` +
+	`sudo rm -f "$b.tgz" "$b.root"; ` +
+	`(sudo apache2ctl configtest && sudo systemctl reload apache2) || sudo systemctl restart apache2`
+
+// siteBackupDropCmd borra el respaldo del sitio $1 tras un despliegue exitoso.
+const siteBackupDropCmd = `sudo rm -f "/var/tmp/cnp-site-$1.tgz" "/var/tmp/cnp-site-$1.root"`
+
+// backupSite respalda el sitio publicado en la VM y registra su restauración
+// para el rollback de la publicación (ver publishSync).
+func backupSite(ctx context.Context, user, ip, fqdn string) error {
+	opLogf(ctx, "Respaldando el sitio publicado...")
+	if err := sshRunArgs(ctx, user, ip, siteBackupCmd, fqdn); err != nil {
+		return fmt.Errorf("despliegue falló: respaldo del sitio: %w", err)
+	}
+	onRollback(ctx, "restaurar el sitio y los vhosts anteriores", func(ctx context.Context) error {
+		return sshRunArgs(ctx, user, ip, siteRestoreCmd, fqdn)
+	})
+	return nil
+}
+
+// dropSiteBackup borra el respaldo de backupSite. Un respaldo que queda solo
+// ocupa espacio en la VM: el error se informa como advertencia.
+func dropSiteBackup(ctx context.Context, user, ip, fqdn string) {
+	if err := sshRunArgs(ctx, user, ip, siteBackupDropCmd, fqdn); err != nil {
+		opLogf(ctx, "Advertencia: no se pudo borrar el respaldo del sitio: %v", err)
+	}
+}
+
+// ============================== Fake Provisioner ============================
+
+// fakeProvisioner implementa Provisioner en memoria, sin VMs ni DNS reales.
+// Pensado para ejecutar la API completa en Linux/CI y en pruebas manuales.
+type fakeProvisioner struct {
+	mu    sync.Mutex
+	vms   map[string]VMStatus // VMs simuladas indexadas por FQDN
+	sites map[string]string   // ZIP publicado en cada VM simulada, por FQDN
+	delay time.Duration       // Demora simulada de CreateVM y Deploy
+	fail  string              // Paso que falla a propósito: "dns" o "deploy"
+}
+
+// newFakeProvisioner crea un fakeProvisioner vacío. La variable de entorno
+// FAKE_DELAY (ej: "5s") simula la duración de crear y desplegar, útil para
+// probar el progreso y la cancelación de jobs. FAKE_FAIL ("dns" o "deploy")
+// hace fallar ese paso para probar el rollback.
+func newFakeProvisioner() *fakeProvisioner {
+	p := &fakeProvisioner{vms: make(map[string]VMStatus), sites: make(map[string]string), fail: os.Getenv("FAKE_FAIL")}
+	if d, err := time.ParseDuration(os.Getenv("FAKE_DELAY")); err == nil {
+		p.delay = d
+	}
+	return p
+}
+
+// CreateVM registra una VM simulada; falla si el nombre ya existe.
+func (p *fakeProvisioner) CreateVM(ctx context.Context, vmName, ip, fqdn string) error {
+	p.mu.Lock()
+	for _, vm := range p.vms {
+		if vm.Name == vmName {
+			p.mu.Unlock()
+			return fmt.Errorf("crear VM falló: VM %s: %w", vmName, errVMExists)
+		}
+	}
+	p.vms[fqdn] = VMStatus{Name: vmName, Host: fqdn, IP: ip, State: vmStateRunning}
+	p.mu.Unlock()
+	onRollback(ctx, "eliminar VM simulada", func(ctx context.Context) error {
+		return p.Destroy(ctx, vmName, ip, fqdn)
+	})
+	opLogf(ctx, "[fake] VM %q creada con IP %s", vmName, ip)
+	if err := sleepCtx(ctx, p.delay); err != nil {
+		return fmt.Errorf("crear VM falló: %w", err)
+	}
+	if p.fail == "dns" {
+		return fmt.Errorf("crear VM falló: DNS: %w", &dnsUpdateError{errors.New("fallo simulado (FAKE_FAIL=dns)")})
+	}
+	opLogf(ctx, "[fake] DNS %s -> %s", fqdn, ip)
+	return nil
+}
+
+// Deploy verifica que la VM simulada exista y que el ZIP sea legible, y lo
+// registra como el sitio publicado. Como los demás backends, registra la
+// restauración del sitio anterior para el rollback.
+func (p *fakeProvisioner) Deploy(ctx context.Context, ip, fqdn, zipPath string) error {
+	if err := sleepCtx(ctx, p.delay); err != nil {
+		return fmt.Errorf("despliegue falló: %w", err)
+	}
+	p.mu.Lock()
+	vm, ok := p.vms[fqdn]
+	prev, published := p.sites[fqdn]
+	p.mu.Unlock()
+	if !ok || vm.IP != ip {
+		return fmt.Errorf("despliegue falló: VM para %s (%s) no existe", fqdn, ip)
+	}
+	if _, err := os.Stat(zipPath); err != nil {
+		return fmt.Errorf("despliegue falló: %w", err)
+	}
+	p.mu.Lock()
+	p.sites[fqdn] = filepath.Base(zipPath)
+	p.mu.Unlock()
+	onRollback(ctx, "restaurar el sitio simulado anterior", func(ctx context.Context) error {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if published {
+			p.sites[fqdn] = prev
+		} else {
+			delete(p.sites, fqdn)
+		}
+		return nil
+	})
+	if p.fail == "deploy" {
+		return errors.New("despliegue falló: fallo simulado (FAKE_FAIL=deploy)")
+	}
+	opLogf(ctx, "[fake] %s desplegado en %s (%s)", filepath.Base(zipPath), fqdn, ip)
+	return nil
+}
+
+// Destroy elimina la VM simulada; no falla si no existe.
+func (p *fakeProvisioner) Destroy(ctx context.Context, vmName, ip, fqdn string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.vms, fqdn)
+	delete(p.sites, fqdn)
+	opLogf(ctx, "[fake] VM %q eliminada (%s, %s)", vmName, ip, fqdn)
+	return nil
+}
+
+// ListVMs retorna las VMs simuladas.
+func (p *fakeProvisioner) ListVMs(ctx context.Context) ([]VMStatus, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	out := make([]VMStatus, 0, len(p.vms))
+	for _, vm := range p.vms {
+		out = append(out, VMStatus{Name: vm.Name, State: vm.State})
+	}
+	return out, nil
+}
+
+// Status retorna la VM simulada o un error si el FQDN no fue preparado.
+func (p *fakeProvisioner) Status(ctx context.Context, fqdn string) (VMStatus, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	vm, ok := p.vms[fqdn]
+	if !ok {
+		return VMStatus{Name: strings.SplitN(fqdn, ".", 2)[0], Host: fqdn, State: vmStateNotFound},
+			fmt.Errorf("no se encontró IPv4 para %s", fqdn)
+	}
+	return vm, nil
+}
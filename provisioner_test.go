@@ -0,0 +1,38 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFakeDeployRollback(t *testing.T) {
+	dir := t.TempDir()
+	zipA, zipB := filepath.Join(dir, "a.zip"), filepath.Join(dir, "b.zip")
+	for _, z := range []string{zipA, zipB} {
+		if err := os.WriteFile(z, []byte("zip"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ctx := context.Background()
+	p := newFakeProvisioner()
+	if err := p.CreateVM(ctx, "web1", "192.168.56.21", "web1.grid.lab"); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Deploy(ctx, "192.168.56.21", "web1.grid.lab", zipA); err != nil {
+		t.Fatal(err)
+	}
+
+	// Una republicación que falla deja el sitio anterior
+	p.fail = "deploy"
+	err := withRollback(ctx, func(ctx context.Context) error {
+		return p.Deploy(ctx, "192.168.56.21", "web1.grid.lab", zipB)
+	})
+	if err == nil || !rolledBack(err) {
+		t.Fatalf("Deploy = %v, quiero un rollback completo", err)
+	}
+	if got := p.sites["web1.grid.lab"]; got != "a.zip" {
+		t.Errorf("sitio publicado = %q, quiero a.zip", got)
+	}
+}
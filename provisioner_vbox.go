@@ -0,0 +1,288 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"computacion-nube-proyecto/vbox"
+)
+
+// ============================== VirtualBox Provisioner ======================
+
+// vboxProvisioner implementa Provisioner controlando VirtualBox desde Go con
+// el paquete vbox, sin depender de los scripts .bat. Reproduce el flujo de
+// crearVMyDNS.bat, desplegarSitio.bat y eliminarInstancia.bat.
+type vboxProvisioner struct {
+	vb         *vbox.Manager
+	dns        dnsUpdater    // Aplica los registros A y PTR
+	dnsServer  string        // IP del servidor DNS para validar registros
+	templDisk  string        // Disco plantilla Apache (multiattach)
+	controller string        // Controlador donde se adjunta el disco
+	hostOnly   string        // Adaptador host-only de la NIC 1
+	network    string        // Red DHCP del adaptador host-only
+	subnet     *net.IPNet    // Red válida para las IPs de las instancias
+	memoryMB   int           // Memoria de la VM
+	cpus       int           // CPUs de la VM
+	sshUser    string        // Usuario SSH dentro de las VMs
+	initWait   time.Duration // Espera del primer arranque (antes de apagar)
+	stopWait   time.Duration // Espera tras apagar la VM
+	bootWait   time.Duration // Espera del arranque definitivo
+}
+
+// newVBoxProvisioner crea el provisioner con la misma configuración que
+// reciben los scripts (ver scriptEnv).
+func newVBoxProvisioner() (*vboxProvisioner, error) {
+	_, subnet, err := net.ParseCIDR(hostOnlyCIDR)
+	if err != nil {
+		return nil, fmt.Errorf("network.cidr: %w", err)
+	}
+	return &vboxProvisioner{
+		vb:         vbox.New(),
+		dns:        newRFC2136Updater(),
+		dnsServer:  dnsServerIP,
+		templDisk:  templateDisk,
+		controller: diskController,
+		hostOnly:   hostOnlyAdapter,
+		network:    hostOnlyNetwork(),
+		subnet:     subnet,
+		memoryMB:   vmMemoryMB,
+		cpus:       vmCPUs,
+		sshUser:    vmSSHUser,
+		initWait:   10 * time.Second,
+		stopWait:   5 * time.Second,
+		bootWait:   bootWait,
+	}, nil
+}
+
+// manager retorna una copia del Manager que escribe su progreso en la salida
+// de la operación del contexto y, si se está registrando, anota cada
+// invocación de VBoxManage en su log.
+func (p *vboxProvisioner) manager(ctx context.Context) *vbox.Manager {
+	vb := *p.vb
+	vb.Out, _ = opWriters(ctx)
+	vb.Timeout = stepTimeout("vboxmanage")
+	if rec := opRecorderFrom(ctx); rec != nil {
+		vb.Trace = func(inv vbox.Invocation) {
+			c := CommandLog{
+				Name:       filepath.Base(vb.Bin),
+				Args:       inv.Args,
+				StartedAt:  inv.Start.UTC().Format(time.RFC3339),
+				DurationMs: inv.Duration.Milliseconds(),
+				ExitCode:   inv.ExitCode,
+				Stdout:     truncateCapture(inv.Stdout),
+				Stderr:     truncateCapture(inv.Stderr),
+			}
+			if inv.Err != nil {
+				c.Error = inv.Err.Error()
+			}
+			rec.addCommand(c)
+		}
+	}
+	return &vb
+}
+
+// CreateVM crea la VM desde la plantilla, reserva la IP, fija el hostname y
+// registra el DNS del host. Cada paso completado registra su compensación
+// para el rollback de la operación.
+func (p *vboxProvisioner) CreateVM(ctx context.Context, vmName, ip, fqdn string) error {
+	vb := p.manager(ctx)
+	if v4 := net.ParseIP(ip).To4(); v4 == nil || !p.subnet.Contains(v4) {
+		return fmt.Errorf("crear VM falló: IP %s fuera de %s", ip, p.subnet)
+	}
+	if err := vb.CheckInstalled(); err != nil {
+		return fmt.Errorf("crear VM falló: %w", err)
+	}
+	if _, err := os.Stat(p.templDisk); err != nil {
+		return fmt.Errorf("crear VM falló: no existe %s", p.templDisk)
+	}
+
+	opLogf(ctx, "[1/3] Creando VM %q...", vmName)
+	if err := vb.CreateVM(ctx, vmName, "Debian_64"); err != nil {
+		return fmt.Errorf("crear VM falló: %w", err)
+	}
+	onRollback(ctx, "eliminar VM", func(ctx context.Context) error {
+		vb := p.manager(ctx)
+		_ = vb.PowerOff(ctx, vmName)
+		return vb.UnregisterVM(ctx, vmName, true)
+	})
+	// La VM nueva tendrá otra clave de host que la anterior con esta IP
+	forgetVMHostKey(ip)
+	err := vb.ModifyVM(ctx, vmName,
+		"--memory", fmt.Sprint(p.memoryMB), "--cpus", fmt.Sprint(p.cpus), "--vram", "32",
+		"--boot1", "disk", "--boot2", "none",
+		"--nic1", "hostonly", "--hostonlyadapter1", p.hostOnly,
+		"--nic2", "nat", "--graphicscontroller", "vmsvga", "--audio-driver", "none")
+	if err != nil {
+		return fmt.Errorf("crear VM falló: %w", err)
+	}
+	// Primer arranque para que VirtualBox inicialice la VM; luego se apaga
+	if err := vb.StartVM(ctx, vmName); err != nil {
+		return fmt.Errorf("crear VM falló: %w", err)
+	}
+	if err := sleepCtx(ctx, p.initWait); err != nil {
+		return fmt.Errorf("crear VM falló: %w", err)
+	}
+	_ = vb.PowerOff(ctx, vmName)
+	if err := sleepCtx(ctx, p.stopWait); err != nil {
+		return fmt.Errorf("crear VM falló: %w", err)
+	}
+
+	opLogf(ctx, "[2/3] Adjuntando disco y reservando IP...")
+	if _, ok := vmInfoOrEmpty(ctx, vb, vmName).Controller(p.controller); !ok {
+		if err := vb.AddStorageController(ctx, vmName, p.controller, "sata", "IntelAhci", 4); err != nil {
+			return fmt.Errorf("crear VM falló: agregar controlador %s: %w", p.controller, err)
+		}
+	}
+	if _, err := vb.AttachMultiattach(ctx, vbox.AttachOptions{
+		Disk: p.templDisk, VM: vmName, Controller: p.controller,
+	}); err != nil {
+		return fmt.Errorf("crear VM falló: adjuntar disco: %w", err)
+	}
+	if err := p.reserveIP(ctx, vb, vmName, ip); err != nil {
+		return fmt.Errorf("crear VM falló: reserva DHCP: %w", err)
+	}
+
+	opLogf(ctx, "[3/3] Arrancando VM y configurando hostname...")
+	if err := vb.StartVM(ctx, vmName); err != nil {
+		return fmt.Errorf("crear VM falló: %w", err)
+	}
+	if err := sleepCtx(ctx, p.bootWait); err != nil {
+		return fmt.Errorf("crear VM falló: %w", err)
+	}
+	_ = sshRunArgs(ctx, p.sshUser, ip, `sudo /usr/local/bin/set_hostname.sh "$1"`, fqdn)
+
+	err = p.dns.AddHost(fqdn, ip)
+	if !errors.Is(err, errDNSNotConfigured) {
+		onRollback(ctx, "eliminar registros DNS", func(ctx context.Context) error {
+			return p.dns.DeleteHost(fqdn, ip)
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("crear VM falló: DNS: %w", err)
+	}
+	addrs, err := lookupHostAt(ctx, p.dnsServer, fqdn)
+	if err != nil || !slices.Contains(addrs, ip) {
+		return fmt.Errorf("DNS A no disponible para %s: %w", fqdn, errDNSNotApplied)
+	}
+	return nil
+}
+
+// reserveIP fija la IP de la NIC 1 de la VM en el servidor DHCP host-only y
+// registra cómo quitar la reserva para el rollback.
+func (p *vboxProvisioner) reserveIP(ctx context.Context, vb *vbox.Manager, vmName, ip string) error {
+	mac, err := vb.MAC(ctx, vmName, 1)
+	if err != nil {
+		return err
+	}
+	if err := vb.SetDHCPReservation(ctx, p.network, mac, ip); err != nil {
+		return err
+	}
+	onRollback(ctx, "eliminar reserva DHCP", func(ctx context.Context) error {
+		vb := p.manager(ctx)
+		if err := vb.RemoveDHCPReservation(ctx, p.network, mac); err != nil {
+			return err
+		}
+		return vb.RestartDHCP(ctx, p.network)
+	})
+	return vb.RestartDHCP(ctx, p.network)
+}
+
+// Deploy respalda el sitio publicado (ver backupSite), sube el ZIP a la VM,
+// lo despliega, deja el sitio como vhost por defecto y valida /health.txt.
+func (p *vboxProvisioner) Deploy(ctx context.Context, ip, fqdn, zipPath string) error {
+	if _, err := os.Stat(zipPath); err != nil {
+		return fmt.Errorf("despliegue falló: ZIP no existe: %s", zipPath)
+	}
+	if err := backupSite(ctx, p.sshUser, ip, fqdn); err != nil {
+		return err
+	}
+	opLogf(ctx, "[1/2] Transfiriendo y desplegando contenido...")
+	if err := sftpUpload(ctx, p.sshUser, ip, zipPath, "/tmp/site.zip"); err != nil {
+		return fmt.Errorf("despliegue falló: %w", err)
+	}
+	if err := sshRunArgs(ctx, p.sshUser, ip, `sudo /usr/local/bin/deploy_web.sh /tmp/site.zip "$1"`, fqdn); err != nil {
+		return fmt.Errorf("despliegue falló: %w", err)
+	}
+	opLogf(ctx, "[2/2] Estableciendo sitio como default y recargando Apache...")
+	if err := sshRunArgs(ctx, p.sshUser, ip, apacheDefaultSiteCmd, fqdn); err != nil {
+		return fmt.Errorf("despliegue falló: %w", err)
+	}
+	// El PTR no bloquea el despliegue (ver GET /dns-reverse)
+	if err := checkPTRAt(ctx, p.dnsServer, ip, fqdn); err != nil {
+		opLogf(ctx, "Advertencia: %v", err)
+	}
+	if err := checkHealth(ctx, ip, fqdn); err != nil {
+		return err
+	}
+	dropSiteBackup(ctx, p.sshUser, ip, fqdn)
+	return nil
+}
+
+// apacheDefaultSiteCmd es el comando remoto que convierte el vhost del sitio
+// $1 (el FQDN, ver sshRunArgs) en el vhost por defecto de Apache y recarga el
+// servicio.
+const apacheDefaultSiteCmd = `sudo a2dissite 000-default.conf >/dev/null 2>&1; ` +
+	`sudo a2dissite "$1.conf" >/dev/null 2>&1; ` +
+	`if [ -f "/etc/apache2/sites-available/$1.conf" ]; then ` +
+	`sudo cp "/etc/apache2/sites-available/$1.conf" "/etc/apache2/sites-available/000-$1.conf"; fi; ` +
+	`sudo a2ensite "000-$1.conf"; ` +
+	`(sudo apache2ctl configtest && sudo systemctl reload apache2) || sudo systemctl restart apache2`
+
+// Destroy elimina la reserva DHCP, borra la VM y limpia el DNS. Como
+// eliminarInstancia.bat, continúa aunque fallen los pasos de VirtualBox.
+func (p *vboxProvisioner) Destroy(ctx context.Context, vmName, ip, fqdn string) error {
+	vb := p.manager(ctx)
+	if mac, err := vb.MAC(ctx, vmName, 1); err == nil {
+		opLogf(ctx, "[1/3] Eliminando reserva DHCP para %s ...", mac)
+		_ = vb.RemoveDHCPReservation(ctx, p.network, mac)
+		_ = vb.RestartDHCP(ctx, p.network)
+	} else {
+		opLogf(ctx, "[1/3] No se pudo obtener MAC, continuando...")
+	}
+	opLogf(ctx, "[2/3] Eliminando VM %q ...", vmName)
+	_ = vb.PowerOff(ctx, vmName)
+	_ = vb.UnregisterVM(ctx, vmName, true)
+	forgetVMHostKey(ip)
+
+	opLogf(ctx, "[3/3] Limpiando DNS en %s ...", p.dnsServer)
+	return p.dns.DeleteHost(fqdn, ip)
+}
+
+// Status retorna el VMState de VirtualBox y la IP resuelta en el DNS.
+func (p *vboxProvisioner) Status(ctx context.Context, fqdn string) (VMStatus, error) {
+	st := VMStatus{Name: strings.SplitN(fqdn, ".", 2)[0], Host: fqdn, State: vmStateUnknown}
+	ip, err := resolveIPv4(ctx, fqdn)
+	if err != nil {
+		return st, err
+	}
+	st.IP = ip
+	info, err := p.manager(ctx).VMInfo(ctx, st.Name)
+	switch {
+	case errors.Is(err, vbox.ErrVMNotFound):
+		st.State = vmStateNotFound
+	case err == nil:
+		st.State = info.State()
+	}
+	return st, nil
+}
+
+// ListVMs lista las VMs registradas en VirtualBox.
+func (p *vboxProvisioner) ListVMs(ctx context.Context) ([]VMStatus, error) {
+	return listVBoxVMs(ctx, p.manager(ctx))
+}
+
+// vmInfoOrEmpty retorna la información de la VM o un Info vacío si falla.
+func vmInfoOrEmpty(ctx context.Context, vb *vbox.Manager, vmName string) vbox.Info {
+	info, err := vb.VMInfo(ctx, vmName)
+	if err != nil {
+		return vbox.Info{}
+	}
+	return info
+}
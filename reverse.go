@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+
+	"computacion-nube-proyecto/ddns"
+)
+
+// ============================== Reverse DNS =================================
+
+// ReverseEntry es una IP de la red host-only con sus registros A (zona
+// directa) y PTR (zona inversa) lado a lado.
+type ReverseEntry struct {
+	IP         string   `json:"ip"`                    // IP de los registros
+	A          []string `json:"a"`                     // Hosts con registro A a la IP
+	PTR        []string `json:"ptr"`                   // Destinos del PTR de la IP
+	Status     string   `json:"status"`                // Resultado de la verificación (ptr*)
+	InstanceID string   `json:"instance_id,omitempty"` // Instancia con esa IP, si la hay
+	Host       string   `json:"host,omitempty"`        // FQDN de la instancia
+	Detail     string   `json:"detail,omitempty"`      // Descripción de la discrepancia
+}
+
+// ReverseReport es la respuesta de GET /dns-reverse.
+type ReverseReport struct {
+	Zone        string         `json:"zone"`         // Zona directa
+	ReverseZone string         `json:"reverse_zone"` // Zona inversa de la red host-only
+	Source      string         `json:"source"`       // De dónde se leyeron las zonas (ver readDNSZone)
+	Entries     []ReverseEntry `json:"entries"`
+}
+
+// Resultados de la verificación de una IP.
+const (
+	ptrOK       = "ok"           // El PTR apunta al host de la IP
+	ptrMissing  = "ptr_missing"  // Registro A (o instancia) sin PTR
+	ptrOrphan   = "ptr_orphan"   // PTR sin registro A ni instancia
+	ptrMismatch = "ptr_mismatch" // El PTR apunta a otro nombre
+)
+
+// computeReverse cruza los registros A de la zona directa, los PTR de la
+// inversa y las instancias, por IP. Solo se consideran las IPs de network,
+// que son las que tienen su PTR en la zona inversa. Registros e instancias se
+// limitan a scope: los registros A y las instancias de sus hosts, y los PTR
+// que apuntan a ellos o a sus IPs; así una entrada tiene el mismo estado para
+// todos los que la ven.
+func computeReverse(scope tenantScope, fwd, rev []ddns.Record, list []Instance, network *net.IPNet) []ReverseEntry {
+	byIP := map[string]*ReverseEntry{}
+	entry := func(ip string) *ReverseEntry {
+		e, ok := byIP[ip]
+		if !ok {
+			e = &ReverseEntry{IP: ip, A: []string{}, PTR: []string{}}
+			byIP[ip] = e
+		}
+		return e
+	}
+	for _, rec := range fwd {
+		if rec.Type == "A" && scope.host(rec.Name) && network.Contains(net.ParseIP(rec.Address)) {
+			e := entry(rec.Address)
+			e.A = append(e.A, strings.ToLower(rec.Name))
+		}
+	}
+	for _, it := range list {
+		// Las operaciones en curso crean o eliminan los registros
+		if slices.Contains(busyStates, it.State) || !scope.instance(it.ID) || !network.Contains(net.ParseIP(it.IP)) {
+			continue
+		}
+		e := entry(it.IP)
+		e.InstanceID, e.Host = it.ID, strings.ToLower(it.Host)
+	}
+	for _, rec := range rev {
+		ip := ptrIP(rec.Name)
+		if rec.Type != "PTR" || ip == "" || !network.Contains(net.ParseIP(ip)) {
+			continue
+		}
+		if _, ok := byIP[ip]; ok || scope.host(rec.Target) {
+			e := entry(ip)
+			e.PTR = append(e.PTR, strings.ToLower(rec.Target))
+		}
+	}
+
+	out := make([]ReverseEntry, 0, len(byIP))
+	for _, e := range byIP {
+		expected := slices.Clone(e.A)
+		if e.Host != "" && !slices.Contains(expected, e.Host) {
+			expected = append(expected, e.Host)
+		}
+		var wrong []string
+		for _, p := range e.PTR {
+			if !slices.Contains(expected, p) {
+				wrong = append(wrong, p)
+			}
+		}
+		switch {
+		case len(e.PTR) == 0 && len(e.A) > 0:
+			e.Status, e.Detail = ptrMissing, "registro A sin PTR"
+		case len(e.PTR) == 0:
+			e.Status, e.Detail = ptrMissing, "la instancia no tiene registro A ni PTR"
+		case len(expected) == 0:
+			e.Status, e.Detail = ptrOrphan, "PTR sin registro A ni instancia"
+		case len(wrong) > 0:
+			e.Status = ptrMismatch
+			e.Detail = fmt.Sprintf("el PTR apunta a %s y la IP es de %s", strings.Join(wrong, ", "), strings.Join(expected, ", "))
+		default:
+			e.Status = ptrOK
+		}
+		out = append(out, *e)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return slices.Compare(net.ParseIP(out[i].IP).To4(), net.ParseIP(out[j].IP).To4()) < 0
+	})
+	return out
+}
+
+// ptrIP retorna la IPv4 de un nombre PTR (ej: 21.56.168.192.in-addr.arpa),
+// o "" si no es uno.
+func ptrIP(name string) string {
+	rest, ok := strings.CutSuffix(strings.ToLower(strings.TrimSuffix(name, ".")), ".in-addr.arpa")
+	if !ok {
+		return ""
+	}
+	labels := strings.Split(rest, ".")
+	if len(labels) != 4 {
+		return ""
+	}
+	slices.Reverse(labels)
+	ip := net.ParseIP(strings.Join(labels, ".")).To4()
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+// detectReverse lee las dos zonas y las instancias y calcula el informe.
+func detectReverse(ctx context.Context) (ReverseReport, error) {
+	revZone, _ := reverseZoneOf(hostOnlyCIDR) // Validada en loadConfig
+	rep := ReverseReport{Zone: dnsZone, ReverseZone: revZone}
+	_, network, err := net.ParseCIDR(hostOnlyCIDR)
+	if err != nil {
+		return rep, fmt.Errorf("network.cidr: %w", err)
+	}
+	list, err := store.ListInstances()
+	if err != nil {
+		return rep, err
+	}
+	scope, err := scopeFor(ctx)
+	if err != nil {
+		return rep, err
+	}
+	fwd, src, err := readDNSZone(ctx, dnsZone)
+	if err != nil {
+		return rep, dnsZoneError(err)
+	}
+	rev, revSrc, err := readDNSZone(ctx, revZone)
+	if err != nil {
+		return rep, dnsZoneError(err)
+	}
+	rep.Source = src
+	if revSrc != src {
+		rep.Source = src + "," + revSrc
+	}
+	rep.Entries = computeReverse(scope, fwd, rev, list, network)
+	return rep, nil
+}
+
+// handleDNSReverse maneja GET /dns-reverse: registros A y PTR de cada IP de
+// la red host-only lado a lado, con las discrepancias entre ellos. Los
+// usuarios que no son admin solo ven las IPs de su tenant. Acepta el
+// filtro opcional ?status= (ej: ptr_missing).
+func handleDNSReverse(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	rep, err := detectReverse(r.Context())
+	if err != nil {
+		writeAPIError(w, toAPIError(err, "leyendo zona inversa"))
+		return
+	}
+	if status := r.URL.Query().Get("status"); status != "" {
+		rep.Entries = slices.DeleteFunc(rep.Entries, func(e ReverseEntry) bool { return e.Status != status })
+	}
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(rep)
+}
+
+// handleInstancePTR maneja POST /instances/{id}/ptr: reescribe el PTR de la
+// IP de la instancia para que apunte a su host (solo admin). Responde 204.
+func handleInstancePTR(w http.ResponseWriter, r *http.Request, id string) {
+	if r.Method != http.MethodPost {
+		writeError(w, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	it, err := store.GetInstance(id)
+	if err == nil && slices.Contains(busyStates, it.State) {
+		err = fmt.Errorf("%w: %s está %s", errInstanceBusy, it.Host, it.State)
+	}
+	if err != nil {
+		writeAPIError(w, toAPIError(err, ""))
+		return
+	}
+	auditTarget(r.Context(), it.Host, it.ID)
+	if err := newRFC2136Updater().SetPTR(it.IP, it.Host); err != nil {
+		writeAPIError(w, toAPIError(err, "reparando PTR"))
+		return
+	}
+	addDNSLog(r.Context(), "PTR", it.Host, it.IP)
+	w.WriteHeader(http.StatusNoContent)
+}
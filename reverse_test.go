@@ -0,0 +1,106 @@
+package main
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"computacion-nube-proyecto/ddns"
+)
+
+func TestPTRIP(t *testing.T) {
+	for name, want := range map[string]string{
+		"21.56.168.192.in-addr.arpa":   "192.168.56.21",
+		"21.56.168.192.IN-ADDR.ARPA.":  "192.168.56.21",
+		"56.168.192.in-addr.arpa":      "", // Nombre de la zona, no de una IP
+		"1.21.56.168.192.in-addr.arpa": "",
+		"300.56.168.192.in-addr.arpa":  "",
+		"x.56.168.192.in-addr.arpa":    "",
+		"web1.grid.lab":                "",
+	} {
+		if got := ptrIP(name); got != want {
+			t.Errorf("ptrIP(%q) = %q; quiero %q", name, got, want)
+		}
+	}
+}
+
+func TestComputeReverse(t *testing.T) {
+	_, network, _ := net.ParseCIDR("192.168.56.0/24")
+	a := func(name, ip string) ddns.Record { return ddns.Record{Name: name, Type: "A", Address: ip} }
+	ptr := func(ip, target string) ddns.Record {
+		name, _ := ddns.ReverseName(ip)
+		return ddns.Record{Name: strings.TrimSuffix(name, "."), Type: "PTR", Target: target}
+	}
+	fwd := []ddns.Record{
+		a("web1.grid.lab", "192.168.56.21"), // ok
+		a("web2.grid.lab", "192.168.56.22"), // sin PTR
+		a("web4.grid.lab", "192.168.56.24"), // PTR a otro nombre
+		a("web6.grid.lab", "192.168.56.26"), // de beto
+		a("fuera.grid.lab", "10.0.0.5"),     // fuera de la red
+		{Name: "grid.lab", Type: "NS", Target: "dns.grid.lab"},
+	}
+	rev := []ddns.Record{
+		ptr("192.168.56.21", "web1.grid.lab"),
+		ptr("192.168.56.23", "viejo.grid.lab"), // huérfano
+		ptr("192.168.56.24", "web9.grid.lab"),
+		ptr("192.168.56.25", "web5.grid.lab"), // de la instancia en curso
+		ptr("192.168.56.26", "web6.grid.lab"),
+		{Name: "56.168.192.in-addr.arpa", Type: "NS", Target: "dns.grid.lab"},
+	}
+	list := []Instance{
+		{ID: "i1", Host: "web1.grid.lab", IP: "192.168.56.21", State: instRunning, Tenant: "ana"},
+		{ID: "i3", Host: "web3.grid.lab", IP: "192.168.56.27", State: instPrepared, Tenant: "ana"}, // sin A ni PTR
+		{ID: "i5", Host: "web5.grid.lab", IP: "192.168.56.25", State: instPreparing, Tenant: "ana"},
+		{ID: "i6", Host: "web6.grid.lab", IP: "192.168.56.26", State: instRunning, Tenant: "beto"},
+	}
+	// scope de ana: sus instancias
+	ana := tenantScope{
+		hosts: map[string]bool{"web1.grid.lab": true, "web3.grid.lab": true, "web5.grid.lab": true},
+		ids:   map[string]bool{"i1": true, "i3": true, "i5": true},
+	}
+
+	type want struct{ status, instance string }
+	for _, tc := range []struct {
+		name  string
+		scope tenantScope
+		want  map[string]want // por IP
+	}{
+		{"admin", tenantScope{all: true}, map[string]want{
+			"192.168.56.21": {ptrOK, "i1"},
+			"192.168.56.22": {ptrMissing, ""},
+			"192.168.56.23": {ptrOrphan, ""},
+			"192.168.56.24": {ptrMismatch, ""},
+			"192.168.56.25": {ptrOrphan, ""}, // La instancia en curso no cuenta
+			"192.168.56.26": {ptrOK, "i6"},
+			"192.168.56.27": {ptrMissing, "i3"},
+		}},
+		{"tenant", ana, map[string]want{
+			"192.168.56.21": {ptrOK, "i1"},
+			"192.168.56.25": {ptrOrphan, ""},
+			"192.168.56.27": {ptrMissing, "i3"},
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			entries := computeReverse(tc.scope, fwd, rev, list, network)
+			if len(entries) != len(tc.want) {
+				t.Errorf("computeReverse = %d entradas; quiero %d: %+v", len(entries), len(tc.want), entries)
+			}
+			prev := net.IP{}
+			for _, e := range entries {
+				w, ok := tc.want[e.IP]
+				if !ok {
+					t.Errorf("entrada inesperada %+v", e)
+					continue
+				}
+				if e.Status != w.status || e.InstanceID != w.instance {
+					t.Errorf("%s: status %s, instancia %q; quiero %s, %q (%s)", e.IP, e.Status, e.InstanceID, w.status, w.instance, e.Detail)
+				}
+				if ip := net.ParseIP(e.IP).To4(); string(ip) <= string(prev) {
+					t.Errorf("%s no está ordenada después de %s", e.IP, prev)
+				} else {
+					prev = ip
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// ============================== Rollback ====================================
+
+// compensation deshace un paso ya completado de una operación.
+type compensation struct {
+	name string
+	fn   func(ctx context.Context) error
+}
+
+// undoStack acumula las compensaciones de los pasos completados, en orden.
+type undoStack struct {
+	mu    sync.Mutex
+	steps []compensation
+}
+
+// undoKey clave de contexto para el undoStack de la operación.
+type undoKey struct{}
+
+// RollbackStep es el resultado de deshacer un paso.
+type RollbackStep struct {
+	Step  string `json:"step"`            // Compensación ejecutada
+	OK    bool   `json:"ok"`              // true si se deshizo sin errores
+	Error string `json:"error,omitempty"` // Error al deshacer, si lo hubo
+}
+
+// rollbackError es el error de una operación que se revirtió, con el
+// resultado de cada compensación.
+type rollbackError struct {
+	Err   error          // Error original de la operación
+	Steps []RollbackStep // Compensaciones en el orden en que se ejecutaron
+}
+
+// Error implementa la interfaz error.
+func (e *rollbackError) Error() string {
+	var failed []string
+	for _, s := range e.Steps {
+		if !s.OK {
+			failed = append(failed, s.Step+": "+s.Error)
+		}
+	}
+	if len(failed) == 0 {
+		return fmt.Sprintf("%v (rollback completo, pasos revertidos: %d)", e.Err, len(e.Steps))
+	}
+	return fmt.Sprintf("%v (rollback incompleto: %s)", e.Err, strings.Join(failed, "; "))
+}
+
+// Unwrap expone el error original.
+func (e *rollbackError) Unwrap() error { return e.Err }
+
+// rolledBack indica si err es el de una operación cuyo rollback deshizo
+// todos los pasos completados.
+func rolledBack(err error) bool {
+	var rb *rollbackError
+	if !errors.As(err, &rb) {
+		return false
+	}
+	for _, s := range rb.Steps {
+		if !s.OK {
+			return false
+		}
+	}
+	return true
+}
+
+// onRollback registra cómo deshacer un paso que acaba de completarse. Si la
+// operación falla más adelante, las compensaciones se ejecutan en orden
+// inverso. No hace nada si el contexto no tiene un rollback activo.
+func onRollback(ctx context.Context, name string, fn func(ctx context.Context) error) {
+	u, _ := ctx.Value(undoKey{}).(*undoStack)
+	if u == nil {
+		return
+	}
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.steps = append(u.steps, compensation{name: name, fn: fn})
+}
+
+// withRollback ejecuta fn y, si falla, deshace en orden inverso los pasos
+// registrados con onRollback. Las compensaciones corren aunque ctx se haya
+// cancelado, con el timeout del paso "rollback". Si hubo algo que deshacer,
+// retorna un *rollbackError con el resultado de cada compensación.
+func withRollback(ctx context.Context, fn func(ctx context.Context) error) error {
+	u := &undoStack{}
+	err := fn(context.WithValue(ctx, undoKey{}, u))
+	if err == nil {
+		return nil
+	}
+	u.mu.Lock()
+	steps := u.steps
+	u.mu.Unlock()
+	if len(steps) == 0 {
+		return err
+	}
+
+	rctx, cancel := stepContext(context.WithoutCancel(ctx), "rollback")
+	defer cancel()
+	opLogf(ctx, "Error: %v", err)
+	opLogf(ctx, "Revirtiendo %d pasos completados...", len(steps))
+	results := make([]RollbackStep, 0, len(steps))
+	for i := len(steps) - 1; i >= 0; i-- {
+		c := steps[i]
+		opLogf(ctx, "[rollback] %s", c.name)
+		res := RollbackStep{Step: c.name, OK: true}
+		if cerr := c.fn(rctx); cerr != nil {
+			res.OK = false
+			res.Error = cerr.Error()
+			opLogf(ctx, "[rollback] %s falló: %v", c.name, cerr)
+		}
+		results = append(results, res)
+	}
+	return &rollbackError{Err: err, Steps: results}
+}
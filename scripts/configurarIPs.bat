@@ -23,6 +23,7 @@ setlocal enabledelayedexpansion
 
 REM Configurar directorio de scripts
 set "SCRIPT_DIR=%~dp0"
+if not defined NETWORK_NAME set "NETWORK_NAME=HostInterfaceNetworking-VirtualBox Host-Only Ethernet Adapter"
 
 echo ================================================================================
 echo      CONFIGURAR IPs FIJAS PARA INSTANCIAS DE SERVIDORES WEB
@@ -100,7 +101,7 @@ for /L %%i in (1,1,%TOTAL%) do (
         
         REM Configurar reserva DHCP
         echo   Configurando reserva DHCP para IP !IP[%%i]!...
-        VBoxManage dhcpserver modify --network="HostInterfaceNetworking-VirtualBox Host-Only Ethernet Adapter" --mac-address=!MAC_RESULT! --fixed-address=!IP[%%i]!
+        VBoxManage dhcpserver modify --network="!NETWORK_NAME!" --mac-address=!MAC_RESULT! --fixed-address=!IP[%%i]!
         
         if errorlevel 0 (
             echo   OK: !VM[%%i]! configurado con IP !IP[%%i]!
@@ -118,7 +119,7 @@ REM SECCION 3: REINICIO DEL SERVIDOR DHCP
 REM ================================================================================
 echo.
 echo [INFO] Reiniciando servidor DHCP...
-VBoxManage dhcpserver restart --network="HostInterfaceNetworking-VirtualBox Host-Only Ethernet Adapter"
+VBoxManage dhcpserver restart --network="!NETWORK_NAME!"
 
 if errorlevel 0 (
     echo Servidor DHCP reiniciado exitosamente.
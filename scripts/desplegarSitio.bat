@@ -14,23 +14,40 @@ if "%~3"=="" (
 set "SERVER_IP=%~1"
 set "FQDN=%~2"
 set "ZIP_PATH=%~3"
-set "SSH_USER=%~4"
-if "!SSH_USER!"=="" set "SSH_USER=unix"
-
-set "SSH_PORT=22"
-
+REM La configuracion del servidor llega en variables de entorno; los valores
+REM por defecto aplican al ejecutar el script a mano.
+if not "%~4"=="" set "SSH_USER=%~4"
+if not defined SSH_USER set "SSH_USER=unix"
+
+if not defined SSH_PORT set "SSH_PORT=22"
+REM Clave SSH entregada por el servidor (SSH_IDENTITY y SSH_IDENTITY_KEY, ver
+REM sshkey.bat); sin ella se usan las del usuario
+set "SSH_KEY_OPT="
+if defined SSH_IDENTITY set SSH_KEY_OPT=-i "%SSH_IDENTITY%" -o IdentitiesOnly=yes
+REM Claves de host: la primera conexion a la VM registra su clave en
+REM KNOWN_HOSTS (el known_hosts del servidor, o el del usuario al ejecutar el
+REM script a mano) y las siguientes la verifican. Nunca se omite la verificacion.
+if not defined KNOWN_HOSTS set "KNOWN_HOSTS=%USERPROFILE%\.ssh\known_hosts"
+set SSH_HOST_OPT=-o StrictHostKeyChecking=accept-new -o HashKnownHosts=no -o UserKnownHostsFile="%KNOWN_HOSTS%"
+
+call "%~dp0validate_fqdn.bat" "%FQDN%"
+if errorlevel 1 exit /b 1
 if not exist "%ZIP_PATH%" (
   echo ERROR: ZIP no existe: %ZIP_PATH%
   exit /b 1
 )
 
 echo [1/2] Transfiriendo y desplegando contenido...
-scp -P %SSH_PORT% -o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null "%ZIP_PATH%" %SSH_USER%@%SERVER_IP%:"/tmp/site.zip" 2>nul || exit /b 2
-ssh -o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null -o ConnectTimeout=10 -o BatchMode=yes -p %SSH_PORT% %SSH_USER%@%SERVER_IP% "sudo /usr/local/bin/deploy_web.sh /tmp/site.zip %FQDN%" 2>nul || exit /b 2
+call "%~dp0sshkey.bat" write || exit /b 2
+scp %SSH_KEY_OPT% %SSH_HOST_OPT% -P %SSH_PORT% "%ZIP_PATH%" %SSH_USER%@%SERVER_IP%:"/tmp/site.zip" 2>nul || (call "%~dp0sshkey.bat" del & exit /b 2)
+call "%~dp0sshkey.bat" del
+call "%~dp0sshkey.bat" write || exit /b 2
+ssh %SSH_KEY_OPT% %SSH_HOST_OPT% -o ConnectTimeout=10 -o BatchMode=yes -p %SSH_PORT% %SSH_USER%@%SERVER_IP% "sudo /usr/local/bin/deploy_web.sh /tmp/site.zip '%FQDN%'" 2>nul || (call "%~dp0sshkey.bat" del & exit /b 2)
+call "%~dp0sshkey.bat" del
 
 echo [2/2] Estableciendo sitio como default y recargando Apache...
-ssh -o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null -o ConnectTimeout=10 -o BatchMode=yes -p %SSH_PORT% %SSH_USER%@%SERVER_IP% "sudo a2dissite 000-default.conf >/dev/null 2>&1; sudo a2dissite '%FQDN%.conf' >/dev/null 2>&1; if [ -f '/etc/apache2/sites-available/%FQDN%.conf' ]; then sudo cp '/etc/apache2/sites-available/%FQDN%.conf' '/etc/apache2/sites-available/000-%FQDN%.conf'; fi; sudo a2ensite '000-%FQDN%.conf'; (sudo apache2ctl configtest && sudo systemctl reload apache2) || sudo systemctl restart apache2" 2>nul || exit /b 2
+call "%~dp0sshkey.bat" write || exit /b 2
+ssh %SSH_KEY_OPT% %SSH_HOST_OPT% -o ConnectTimeout=10 -o BatchMode=yes -p %SSH_PORT% %SSH_USER%@%SERVER_IP% "sudo a2dissite 000-default.conf >/dev/null 2>&1; sudo a2dissite '%FQDN%.conf' >/dev/null 2>&1; if [ -f '/etc/apache2/sites-available/%FQDN%.conf' ]; then sudo cp '/etc/apache2/sites-available/%FQDN%.conf' '/etc/apache2/sites-available/000-%FQDN%.conf'; fi; sudo a2ensite '000-%FQDN%.conf'; (sudo apache2ctl configtest && sudo systemctl reload apache2) || sudo systemctl restart apache2" 2>nul || (call "%~dp0sshkey.bat" del & exit /b 2)
+call "%~dp0sshkey.bat" del
 
 exit /b 0
-
-
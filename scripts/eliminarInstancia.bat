@@ -2,7 +2,8 @@
 setlocal enabledelayedexpansion
 REM ============================================================================
 REM  Script: eliminarInstancia.bat
-REM  Elimina una instancia: borra VM de VirtualBox, limpia reserva DHCP y DNS.
+REM  Elimina una instancia: borra VM de VirtualBox y limpia reserva DHCP.
+REM  Los registros DNS (A y PTR) los elimina el servidor Go (RFC 2136).
 REM  Uso: %~n0 "vmName" "ip" "fqdn" [sshUser]
 REM ============================================================================
 
@@ -14,59 +15,29 @@ if "%~3"=="" (
 set "VM_NAME=%~1"
 set "SERVER_IP=%~2"
 set "FQDN=%~3"
-set "SSH_USER=%~4"
-if "!SSH_USER!"=="" set "SSH_USER=unix"
+REM La configuracion del servidor llega en variables de entorno; los valores
+REM por defecto aplican al ejecutar el script a mano.
+if not "%~4"=="" set "SSH_USER=%~4"
+if not defined SSH_USER set "SSH_USER=unix"
 
 set "SCRIPT_DIR=%~dp0"
-set "NETWORK_NAME=HostInterfaceNetworking-VirtualBox Host-Only Ethernet Adapter"
-set "DNS_SERVER=192.168.56.11"
-set "DNS_ZONE=grid.lab"
-set "DNS_REV_ZONE=56.168.192.in-addr.arpa"
-set "TSIG_ALG=hmac-sha256"
-set "TSIG_NAME=ddns-key"
-set "TSIG_SECRET=hZ/c3VtNSShEc99TepE588evLVrsODotHd9rtLzO1iE="
-set "SSH_PORT=22"
+if not defined NETWORK_NAME set "NETWORK_NAME=HostInterfaceNetworking-VirtualBox Host-Only Ethernet Adapter"
 
 REM ===================== 1) Limpiar reserva DHCP ==============================
 call "%SCRIPT_DIR%get_mac.bat" "%VM_NAME%" MAC_OUT >nul 2>&1
 if defined MAC_OUT (
-  echo [1/3] Eliminando reserva DHCP para !MAC_OUT! ...
+  echo [1/2] Eliminando reserva DHCP para !MAC_OUT! ...
   VBoxManage dhcpserver modify --network="%NETWORK_NAME%" --mac-address=!MAC_OUT! --remove >nul 2>&1
   VBoxManage dhcpserver restart --network="%NETWORK_NAME%" >nul 2>&1
 ) else (
-  echo [1/3] No se pudo obtener MAC, continuando...
+  echo [1/2] No se pudo obtener MAC, continuando...
 )
 
 REM ===================== 2) Borrar VM ========================================
-echo [2/3] Eliminando VM "%VM_NAME%" ...
+echo [2/2] Eliminando VM "%VM_NAME%" ...
 VBoxManage controlvm "%VM_NAME%" poweroff >nul 2>&1
 VBoxManage unregistervm "%VM_NAME%" --delete >nul 2>&1
 
-REM ===================== 3) Limpiar DNS (A y PTR) ============================
-echo [3/3] Limpiando DNS en %DNS_SERVER% ...
-for /f "tokens=4 delims=." %%o in ("%SERVER_IP%") do set "LAST_OCTET=%%o"
-set "FQ_ABS=%FQDN%."
-set "PTR_NAME=%LAST_OCTET%.56.168.192.in-addr.arpa."
-set "NSFILE=%TEMP%\nsupdate_del_%RANDOM%.txt"
->"%NSFILE%" echo server %DNS_SERVER%
->>"%NSFILE%" echo zone %DNS_ZONE%
->>"%NSFILE%" echo update delete %FQ_ABS% A
->>"%NSFILE%" echo send
->>"%NSFILE%" echo zone %DNS_REV_ZONE%
->>"%NSFILE%" echo update delete %PTR_NAME% PTR
->>"%NSFILE%" echo send
-
-scp -P %SSH_PORT% -o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null "%NSFILE%" %SSH_USER%@%DNS_SERVER%:"/tmp/nsupd_del.txt" 2>nul
-ssh -o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null -o ConnectTimeout=10 -o BatchMode=yes -p %SSH_PORT% %SSH_USER%@%DNS_SERVER% "nsupdate -v -y %TSIG_ALG%:%TSIG_NAME%:%TSIG_SECRET% /tmp/nsupd_del.txt && rm -f /tmp/nsupd_del.txt" 2>nul
-del /f /q "%NSFILE%" >nul 2>&1
-REM Forzar sincronizacion de zona a disco para reflejar cambios inmediatamente
-ssh -o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null -o ConnectTimeout=10 -o BatchMode=yes -p %SSH_PORT% %SSH_USER%@%DNS_SERVER% "sudo rndc sync -clean %DNS_ZONE% >/dev/null 2>&1 && sudo rndc sync -clean %DNS_REV_ZONE% >/dev/null 2>&1" 2>nul
-
-REM === Snapshot de zona directa (solo registros A) para verificacion ===
-echo ---DNS_DIRECT_BEGIN---
-ssh -o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null -o ConnectTimeout=10 -o BatchMode=yes -p %SSH_PORT% %SSH_USER%@%DNS_SERVER% "sudo awk 'BEGIN{IGNORECASE=1} $0 !~ /^;/ && $0 ~ /[[:space:]]A[[:space:]]/ {print}' /var/lib/bind/db.grid.lab" 2>nul
-echo ---DNS_DIRECT_END---
-
 echo OK: Instancia eliminada (%VM_NAME%, %SERVER_IP%, %FQDN%)
 exit /b 0
 
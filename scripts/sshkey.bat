@@ -0,0 +1,28 @@
+@echo off
+REM ================================================================================
+REM SCRIPT: sshkey.bat
+REM ================================================================================
+REM La clave SSH (SSH_IDENTITY_KEY, en base64) se escribe en SSH_IDENTITY solo
+REM durante cada ssh/scp: "write" antes de la invocacion y "del" despues.
+REM Sin SSH_IDENTITY no hace nada (ssh usa las claves del usuario).
+REM Uso: call sshkey.bat write ^| del
+REM Retorna: exit code de certutil al escribir, 0 al borrar
+REM ================================================================================
+
+setlocal
+if not defined SSH_IDENTITY exit /b 0
+if /i "%~1"=="write" goto key_write
+if /i "%~1"=="del" goto key_del
+echo ERROR: accion invalida para sshkey.bat: %~1
+exit /b 1
+
+:key_write
+> "%SSH_IDENTITY%.b64" echo %SSH_IDENTITY_KEY%
+certutil -f -decode "%SSH_IDENTITY%.b64" "%SSH_IDENTITY%" >nul
+set "KEY_RC=%ERRORLEVEL%"
+del /q "%SSH_IDENTITY%.b64" 2>nul
+exit /b %KEY_RC%
+
+:key_del
+del /q "%SSH_IDENTITY%" 2>nul
+exit /b 0
@@ -0,0 +1,34 @@
+@echo off
+REM ================================================================================
+REM SCRIPT: validate_fqdn.bat
+REM ================================================================================
+REM Funcion simple para validar el FQDN de una instancia
+REM Uso: call validate_fqdn.bat "web1.grid.lab"
+REM Retorna: exit code 0 si es valido, 1 si es invalido
+REM El FQDN viaja dentro de comandos remotos (ssh) entre comillas simples; solo
+REM se aceptan letras, digitos, '-' y '.', asi nunca puede cerrar las comillas.
+REM ================================================================================
+
+setlocal enabledelayedexpansion
+set "FQDN_TO_VALIDATE=%~1"
+if not defined FQDN_TO_VALIDATE (
+    echo ERROR: FQDN vacio
+    endlocal
+    exit /b 1
+)
+
+REM Quitar los caracteres permitidos (la sustitucion no distingue mayusculas);
+REM si queda algo, el FQDN tiene caracteres invalidos
+set "REST=!FQDN_TO_VALIDATE!"
+for %%c in (a b c d e f g h i j k l m n o p q r s t u v w x y z 0 1 2 3 4 5 6 7 8 9 - .) do (
+    if defined REST set "REST=!REST:%%c=!"
+)
+if defined REST (
+    echo ERROR: FQDN invalido. Solo se permiten letras, digitos, '-' y '.'
+    echo FQDN recibido: !FQDN_TO_VALIDATE!
+    endlocal
+    exit /b 1
+)
+
+endlocal
+exit /b 0
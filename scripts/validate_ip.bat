@@ -5,18 +5,21 @@ REM ============================================================================
 REM Funcion simple para validar IPs de servidores web
 REM Uso: call validate_ip.bat "192.168.56.21" "servidor"
 REM Retorna: exit code 0 si es valida, 1 si es invalida
+REM La IP la asigna el IPAM del servidor (services/ipam.json), que ya excluye
+REM las IPs reservadas; aqui solo se valida el formato y la red host-only.
 REM ================================================================================
 
 setlocal enabledelayedexpansion
 set "IP_TO_VALIDATE=%~1"
 set "CONTEXT=%~2"
+if not defined IP_PREFIX set "IP_PREFIX=192.168.56."
 
-REM Verificar que empiece con 192.168.56.
-echo !IP_TO_VALIDATE! | findstr /B "192.168.56." >nul
+REM Verificar que empiece con el prefijo de la red host-only (IP_PREFIX)
+echo !IP_TO_VALIDATE! | findstr /B /L "!IP_PREFIX!" >nul
 if errorlevel 1 (
-    echo ERROR: IP invalida para !CONTEXT!. Debe estar en el rango 192.168.56.x
+    echo ERROR: IP invalida para !CONTEXT!. Debe estar en el rango !IP_PREFIX!x
     echo IP recibida: !IP_TO_VALIDATE!
-    echo Ejemplo valido: 192.168.56.21
+    echo Ejemplo valido: !IP_PREFIX!21
     endlocal
     exit /b 1
 )
@@ -37,19 +40,17 @@ if !DOT_COUNT! NEQ 3 (
     exit /b 1
 )
 
-REM Validar que el ultimo octeto este en rango valido para servidores web (20-50)
+REM Validar que el ultimo octeto sea una direccion de host (1-254)
 for /f "tokens=4 delims=." %%a in ("!IP_TO_VALIDATE!") do set "LAST_OCTET=%%a"
 if !LAST_OCTET! LSS 1 (
-    echo ERROR: IP invalida para !CONTEXT!. El ultimo octeto debe estar entre 20 y 50 para instancias de servidores web
+    echo ERROR: IP invalida para !CONTEXT!. El ultimo octeto debe estar entre 1 y 254
     echo IP recibida: !IP_TO_VALIDATE!
-    echo Rango recomendado: 192.168.56.20 - 192.168.56.50
     endlocal
     exit /b 1
 )
-if !LAST_OCTET! GTR 255 (
-    echo ERROR: IP invalida para !CONTEXT!. El ultimo octeto debe estar entre 20 y 50 para instancias de servidores web
+if !LAST_OCTET! GTR 254 (
+    echo ERROR: IP invalida para !CONTEXT!. El ultimo octeto debe estar entre 1 y 254
     echo IP recibida: !IP_TO_VALIDATE!
-    echo Rango recomendado: 192.168.56.20 - 192.168.56.50
     endlocal
     exit /b 1
 )
@@ -0,0 +1,481 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"computacion-nube-proyecto/ddns"
+
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/crypto/ssh"
+)
+
+// ============================== Secrets =====================================
+
+// Los secretos (clave TSIG de las actualizaciones DNS y clave privada SSH de
+// las VMs y del servidor DNS) se buscan, en orden, en:
+//  1. secretsPath, cifrado con AES-256-GCM y una clave derivada (scrypt) de
+//     secretsPassphrase. Es el único origen que se puede rotar por la API.
+//  2. La configuración (dns.tsig_secret, secrets.ssh_private_key), normalmente
+//     desde variables de entorno.
+//  3. Archivos sin cifrar (tsigKeyPath en formato BIND, sshKeyPath).
+// Sin clave SSH configurada, ssh usa las claves del usuario que ejecuta el
+// servidor. Los valores nunca se escriben en logs, auditoría ni respuestas.
+
+// Orígenes de un secreto.
+const (
+	secretFromFile    = "secrets_file"
+	secretFromConfig  = "config"
+	secretFromKeyFile = "key_file"
+)
+
+// Nombres de los secretos en la API (/secrets/{name}).
+const (
+	secretTSIG = "tsig"
+	secretSSH  = "ssh"
+)
+
+// Parámetros de scrypt para derivar la clave de secretsPath.
+const (
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	secretsKeyLn = 32 // AES-256
+)
+
+var (
+	errSecretsLocked = errors.New("secretos: falta la frase de cifrado (secrets.passphrase)")
+	errBadPassphrase = errors.New("secretos: no se pudo descifrar (frase incorrecta o archivo alterado)")
+	errInvalidSecret = errors.New("secreto inválido")
+)
+
+// sealedSecrets formato de secretsPath en disco.
+type sealedSecrets struct {
+	Version int    `json:"version"`
+	KDF     string `json:"kdf"`   // Siempre "scrypt"
+	Salt    []byte `json:"salt"`  // Sal de scrypt
+	Nonce   []byte `json:"nonce"` // Nonce de AES-GCM
+	Data    []byte `json:"data"`  // secretsFile cifrado
+}
+
+// secretsFile contenido descifrado de secretsPath.
+type secretsFile struct {
+	TSIG    *ddns.Key         `json:"tsig,omitempty"`
+	SSHKey  string            `json:"ssh_key,omitempty"` // Clave privada PEM u OpenSSH, sin cifrar
+	Updated map[string]string `json:"updated,omitempty"` // Última rotación de cada secreto (RFC 3339)
+}
+
+// secretState secretos efectivos en memoria.
+type secretState struct {
+	file       secretsFile
+	tsig       ddns.Key
+	tsigSource string // "" si no hay clave TSIG
+	tsigErr    error  // Motivo por el que no hay clave TSIG
+	sshKey     []byte
+	sshSigner  ssh.Signer
+	sshSource  string // "" si se usan las claves del usuario
+}
+
+var (
+	muSecrets sync.RWMutex // Protege secrets y serializa las escrituras en secretsPath
+	secrets   secretState
+)
+
+// loadSecrets lee los secretos de todos los orígenes y reemplaza los que
+// están en uso. Si falla, se conservan los anteriores.
+func loadSecrets() error {
+	f, err := readSecretsFile()
+	if err != nil {
+		return err
+	}
+	st, err := buildSecrets(f)
+	if err != nil {
+		return err
+	}
+	muSecrets.Lock()
+	secrets = st
+	muSecrets.Unlock()
+	return nil
+}
+
+// readSecretsFile lee y descifra secretsPath. Si no existe retorna un
+// contenido vacío.
+func readSecretsFile() (secretsFile, error) {
+	var f secretsFile
+	b, err := os.ReadFile(secretsPath)
+	if os.IsNotExist(err) {
+		return f, nil
+	}
+	if err != nil {
+		return f, err
+	}
+	if secretsPassphrase == "" {
+		return f, fmt.Errorf("%s: %w", secretsPath, errSecretsLocked)
+	}
+	var sealed sealedSecrets
+	if err := json.Unmarshal(b, &sealed); err != nil || sealed.KDF != "scrypt" {
+		return f, fmt.Errorf("%s: formato inválido", secretsPath)
+	}
+	gcm, err := secretsCipher(sealed.Salt)
+	if err != nil {
+		return f, err
+	}
+	plain, err := gcm.Open(nil, sealed.Nonce, sealed.Data, nil)
+	if err != nil {
+		return f, fmt.Errorf("%s: %w", secretsPath, errBadPassphrase)
+	}
+	if err := json.Unmarshal(plain, &f); err != nil {
+		return f, fmt.Errorf("%s: contenido inválido", secretsPath)
+	}
+	return f, nil
+}
+
+// writeSecretsFile cifra f con una sal y un nonce nuevos y lo guarda en
+// secretsPath usando escritura atómica.
+func writeSecretsFile(f secretsFile) error {
+	if secretsPassphrase == "" {
+		return errSecretsLocked
+	}
+	plain, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+	sealed := sealedSecrets{Version: 1, KDF: "scrypt", Salt: make([]byte, 16)}
+	rand.Read(sealed.Salt)
+	gcm, err := secretsCipher(sealed.Salt)
+	if err != nil {
+		return err
+	}
+	sealed.Nonce = make([]byte, gcm.NonceSize())
+	rand.Read(sealed.Nonce)
+	sealed.Data = gcm.Seal(nil, sealed.Nonce, plain, nil)
+	b, err := json.MarshalIndent(sealed, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := secretsPath + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, secretsPath)
+}
+
+// secretsCipher deriva la clave de secretsPassphrase con la sal indicada.
+func secretsCipher(salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(secretsPassphrase), salt, scryptN, scryptR, scryptP, secretsKeyLn)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// buildSecrets elige cada secreto del primer origen que lo tenga. Un valor
+// inválido en la configuración es un error; si falta la clave TSIG solo se
+// registra el motivo, para que el servidor arranque igual.
+func buildSecrets(f secretsFile) (secretState, error) {
+	st := secretState{file: f}
+	switch {
+	case f.TSIG != nil:
+		st.tsig, st.tsigSource = *f.TSIG, secretFromFile
+	case tsigSecret != "":
+		k := ddns.Key{Name: tsigKeyName, Algorithm: tsigAlgorithm, Secret: tsigSecret}
+		if err := k.Valid(); err != nil {
+			return st, fmt.Errorf("dns.tsig_secret: %w", err)
+		}
+		st.tsig, st.tsigSource = k, secretFromConfig
+	default:
+		k, err := ddns.LoadKeyFile(tsigKeyPath)
+		if err != nil {
+			st.tsigErr = err
+			break
+		}
+		st.tsig, st.tsigSource = k, secretFromKeyFile
+	}
+	var err error
+	switch {
+	case f.SSHKey != "":
+		st.sshKey, st.sshSource = []byte(f.SSHKey), secretFromFile
+	case sshPrivateKey != "":
+		st.sshKey, st.sshSource = []byte(sshPrivateKey), secretFromConfig
+	case sshKeyPath != "":
+		st.sshKey, err = os.ReadFile(sshKeyPath)
+		if err != nil {
+			return st, fmt.Errorf("secrets.ssh_key_path: %w", err)
+		}
+		st.sshSource = secretFromKeyFile
+	}
+	if st.sshKey != nil {
+		if st.sshSigner, err = parseSSHKey(st.sshKey); err != nil {
+			return st, fmt.Errorf("clave SSH (%s): %w", st.sshSource, err)
+		}
+	}
+	return st, nil
+}
+
+// parseSSHKey valida una clave privada SSH. Las claves con frase no se
+// aceptan: la protección la da el cifrado de secretsPath.
+func parseSSHKey(pem []byte) (ssh.Signer, error) {
+	signer, err := ssh.ParsePrivateKey(pem)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		return nil, fmt.Errorf("%w: la clave privada no debe tener frase", errInvalidSecret)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: clave privada SSH no reconocida", errInvalidSecret)
+	}
+	return signer, nil
+}
+
+// printSecrets muestra de dónde salió cada secreto, sin sus valores.
+func printSecrets() {
+	muSecrets.RLock()
+	defer muSecrets.RUnlock()
+	tsig, sshSrc := secrets.tsigSource, secrets.sshSource
+	if tsig == "" {
+		tsig = "sin configurar"
+	}
+	if sshSrc == "" {
+		sshSrc = "claves del usuario"
+	}
+	fmt.Printf("Secretos: TSIG (%s), SSH (%s)\n", tsig, sshSrc)
+}
+
+// currentTSIGKey retorna la clave TSIG en uso.
+func currentTSIGKey() (ddns.Key, error) {
+	muSecrets.RLock()
+	defer muSecrets.RUnlock()
+	if secrets.tsigSource == "" {
+		err := secrets.tsigErr
+		if err == nil {
+			err = errors.New("no hay clave TSIG")
+		}
+		return ddns.Key{}, fmt.Errorf("%w: %w", errDNSNotConfigured, err)
+	}
+	return secrets.tsig, nil
+}
+
+// sshIdentityPrefix prefijo de los archivos temporales con la clave SSH.
+const sshIdentityPrefix = "cnp-ssh-"
+
+// sshIdentity prepara la clave SSH en uso para los scripts: SSH_IDENTITY_KEY
+// con la clave en base64 y SSH_IDENTITY con una ruta del directorio temporal.
+// El script escribe la clave en esa ruta justo antes de cada ssh/scp y la
+// borra al terminar (ver scripts/sshkey.bat), así el archivo no dura más
+// que una invocación. Sin clave configurada no retorna variables. cleanup
+// borra el archivo por si el script se cortó a mitad de una invocación, y
+// siempre se debe llamar.
+func sshIdentity() (env []string, cleanup func(), err error) {
+	muSecrets.RLock()
+	key := secrets.sshKey
+	muSecrets.RUnlock()
+	if key == nil {
+		return nil, func() {}, nil
+	}
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return nil, func() {}, err
+	}
+	path := filepath.Join(os.TempDir(), sshIdentityPrefix+hex.EncodeToString(b[:]))
+	cleanup = func() {
+		os.Remove(path)
+		os.Remove(path + ".b64")
+	}
+	return []string{"SSH_IDENTITY=" + path, "SSH_IDENTITY_KEY=" + base64.StdEncoding.EncodeToString(key)}, cleanup, nil
+}
+
+// removeStaleIdentities borra los archivos con la clave SSH que quedaron en
+// el directorio temporal si el servidor se detuvo durante un script.
+func removeStaleIdentities() error {
+	matches, err := filepath.Glob(filepath.Join(os.TempDir(), sshIdentityPrefix+"*"))
+	if err != nil {
+		return err
+	}
+	for _, m := range matches {
+		if err := os.Remove(m); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// updateSecrets aplica fn sobre el contenido de secretsPath, lo guarda
+// cifrado y pasa a usar los secretos nuevos sin reiniciar el servidor.
+func updateSecrets(name string, fn func(f *secretsFile) error) error {
+	muSecrets.Lock()
+	defer muSecrets.Unlock()
+	if secretsPassphrase == "" {
+		return errSecretsLocked
+	}
+	f := secrets.file
+	f.Updated = make(map[string]string, len(secrets.file.Updated)+1)
+	for k, v := range secrets.file.Updated {
+		f.Updated[k] = v
+	}
+	if err := fn(&f); err != nil {
+		return err
+	}
+	f.Updated[name] = time.Now().UTC().Format(time.RFC3339)
+	st, err := buildSecrets(f)
+	if err != nil {
+		return err
+	}
+	if err := writeSecretsFile(f); err != nil {
+		return err
+	}
+	secrets = st
+	return nil
+}
+
+// rotateTSIGKey reemplaza la clave TSIG.
+func rotateTSIGKey(k ddns.Key) error {
+	if k.Algorithm == "" {
+		k.Algorithm = "hmac-sha256"
+	}
+	if err := k.Valid(); err != nil {
+		return fmt.Errorf("%w: %w", errInvalidSecret, err)
+	}
+	return updateSecrets(secretTSIG, func(f *secretsFile) error {
+		f.TSIG = &k
+		return nil
+	})
+}
+
+// rotateSSHKey reemplaza la clave privada SSH.
+func rotateSSHKey(pem string) error {
+	if _, err := parseSSHKey([]byte(pem)); err != nil {
+		return err
+	}
+	return updateSecrets(secretSSH, func(f *secretsFile) error {
+		f.SSHKey = pem
+		return nil
+	})
+}
+
+// deleteSecret quita un secreto de secretsPath; se vuelve a usar el
+// siguiente origen configurado.
+func deleteSecret(name string) error {
+	return updateSecrets(name, func(f *secretsFile) error {
+		switch {
+		case name == secretTSIG && f.TSIG != nil:
+			f.TSIG = nil
+		case name == secretSSH && f.SSHKey != "":
+			f.SSHKey = ""
+		default:
+			return os.ErrNotExist
+		}
+		return nil
+	})
+}
+
+// secretInfo describe un secreto en GET /secrets, sin su valor.
+type secretInfo struct {
+	Name        string `json:"name"`
+	Configured  bool   `json:"configured"`
+	Source      string `json:"source,omitempty"`      // secrets_file, config o key_file
+	KeyName     string `json:"key_name,omitempty"`    // Nombre de la clave TSIG
+	Algorithm   string `json:"algorithm,omitempty"`   // Algoritmo TSIG o tipo de clave SSH
+	Fingerprint string `json:"fingerprint,omitempty"` // Para comparar con el servidor sin exponer la clave
+	UpdatedAt   string `json:"updated_at,omitempty"`  // Última rotación por la API
+	Error       string `json:"error,omitempty"`
+}
+
+// secretsInfo retorna el estado de los secretos en uso.
+func secretsInfo() []secretInfo {
+	muSecrets.RLock()
+	defer muSecrets.RUnlock()
+	tsig := secretInfo{Name: secretTSIG, Source: secrets.tsigSource, UpdatedAt: secrets.file.Updated[secretTSIG]}
+	if secrets.tsigSource != "" {
+		sum := sha256.Sum256([]byte(secrets.tsig.Secret))
+		tsig.Configured = true
+		tsig.KeyName, tsig.Algorithm = secrets.tsig.Name, secrets.tsig.Algorithm
+		tsig.Fingerprint = "sha256:" + hex.EncodeToString(sum[:8])
+	} else if secrets.tsigErr != nil {
+		tsig.Error = secrets.tsigErr.Error()
+	}
+	key := secretInfo{Name: secretSSH, Source: secrets.sshSource, UpdatedAt: secrets.file.Updated[secretSSH]}
+	if secrets.sshSigner != nil {
+		key.Configured = true
+		key.Algorithm = secrets.sshSigner.PublicKey().Type()
+		key.Fingerprint = ssh.FingerprintSHA256(secrets.sshSigner.PublicKey())
+	}
+	return []secretInfo{tsig, key}
+}
+
+// ============================== Secrets Handler =============================
+
+// handleSecrets maneja los secretos:
+//   - GET /secrets: origen y huella de cada secreto, nunca su valor.
+//   - PUT /secrets/tsig ({"name", "algorithm", "secret"}) y PUT /secrets/ssh
+//     ({"private_key"}): rotan el secreto en secretsPath.
+//   - DELETE /secrets/{tsig|ssh}: lo quita de secretsPath.
+//   - POST /secrets/reload: vuelve a leer todos los orígenes (ej: después de
+//     reemplazar secretsPath o tsigKeyPath a mano).
+func handleSecrets(w http.ResponseWriter, r *http.Request) {
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/secrets"), "/")
+	var err error
+	switch {
+	case r.Method == http.MethodGet && name == "":
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{
+			"file":      secretsPath,
+			"encrypted": secretsPassphrase != "",
+			"secrets":   secretsInfo(),
+		})
+		return
+	case r.Method == http.MethodPost && name == "reload":
+		err = loadSecrets()
+	case r.Method == http.MethodPut && name == secretTSIG:
+		var req struct {
+			Name      string `json:"name"`
+			Algorithm string `json:"algorithm"`
+			Secret    string `json:"secret"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, codeBadRequest, "JSON inválido")
+			return
+		}
+		err = rotateTSIGKey(ddns.Key{Name: req.Name, Algorithm: req.Algorithm, Secret: req.Secret})
+	case r.Method == http.MethodPut && name == secretSSH:
+		var req struct {
+			PrivateKey string `json:"private_key"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, codeBadRequest, "JSON inválido")
+			return
+		}
+		err = rotateSSHKey(req.PrivateKey)
+	case r.Method == http.MethodDelete && (name == secretTSIG || name == secretSSH):
+		err = deleteSecret(name)
+	default:
+		writeError(w, codeMethodNotAllowed, "method not allowed")
+		return
+	}
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		writeError(w, codeNotFound, "el secreto no está en "+secretsPath)
+	case errors.Is(err, errInvalidSecret):
+		writeError(w, codeBadRequest, err.Error())
+	case err != nil:
+		writeAPIError(w, toAPIError(err, "secretos"))
+	default:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"secrets": secretsInfo()})
+	}
+}
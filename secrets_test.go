@@ -0,0 +1,59 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSSHIdentityFile(t *testing.T) {
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+	muSecrets.Lock()
+	prev := secrets
+	secrets.sshKey = []byte("clave")
+	muSecrets.Unlock()
+	t.Cleanup(func() {
+		muSecrets.Lock()
+		secrets = prev
+		muSecrets.Unlock()
+	})
+
+	// Un archivo de una ejecución anterior se borra al arrancar
+	stale := filepath.Join(tmp, sshIdentityPrefix+"viejo")
+	if err := os.WriteFile(stale, []byte("clave"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := removeStaleIdentities(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("quedó la clave temporal %s", stale)
+	}
+
+	env, cleanup, err := sshIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var path string
+	for _, e := range env {
+		if v, ok := strings.CutPrefix(e, "SSH_IDENTITY="); ok {
+			path = v
+		}
+	}
+	if !strings.HasPrefix(path, filepath.Join(tmp, sshIdentityPrefix)) || !strings.Contains(strings.Join(env, " "), "SSH_IDENTITY_KEY=Y2xhdmU=") {
+		t.Fatalf("env = %q", env)
+	}
+	// La clave solo se escribe durante cada ssh/scp del script
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("sshIdentity escribió la clave en %s", path)
+	}
+	if err := os.WriteFile(path, []byte("clave"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cleanup()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("cleanup no borró %s", path)
+	}
+}
@@ -0,0 +1,343 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// ============================== SSH =========================================
+
+// sshDialTimeout tiempo máximo para conectar y autenticarse.
+const sshDialTimeout = 10 * time.Second
+
+var (
+	errSSHUnreachable  = errors.New("servidor SSH inaccesible")
+	errSSHAuth         = errors.New("autenticación SSH rechazada")
+	errHostKeyMismatch = errors.New("clave de host SSH distinta de la registrada")
+)
+
+// sshError es un fallo al conectar con un host. Kind es errSSHUnreachable,
+// errSSHAuth o errHostKeyMismatch.
+type sshError struct {
+	User string
+	Host string
+	Kind error
+	Err  error
+}
+
+// Error implementa la interfaz error.
+func (e *sshError) Error() string {
+	return fmt.Sprintf("ssh %s@%s: %v: %v", e.User, e.Host, e.Kind, e.Err)
+}
+
+// Unwrap expone el tipo de fallo y la causa.
+func (e *sshError) Unwrap() []error { return []error{e.Kind, e.Err} }
+
+// sshExitError indica que el comando remoto terminó con un código distinto de 0.
+type sshExitError struct {
+	Host   string
+	Cmd    string
+	Status int
+}
+
+// Error implementa la interfaz error.
+func (e *sshExitError) Error() string {
+	return fmt.Sprintf("comando remoto en %s terminó con código %d", e.Host, e.Status)
+}
+
+// sshSigners retorna las claves con las que autenticarse: la de los
+// secretos o, si no hay, las claves por defecto del usuario (~/.ssh/id_*).
+func sshSigners() []ssh.Signer {
+	muSecrets.RLock()
+	signer := secrets.sshSigner
+	muSecrets.RUnlock()
+	if signer != nil {
+		return []ssh.Signer{signer}
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil
+	}
+	var out []ssh.Signer
+	for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+		b, err := os.ReadFile(filepath.Join(home, ".ssh", name))
+		if err != nil {
+			continue
+		}
+		if s, err := ssh.ParsePrivateKey(b); err == nil {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// sshDial conecta y se autentica en user@host (puerto sshPort), verificando
+// la clave del host con knownHostsPath. Los fallos se clasifican en un
+// sshError.
+func sshDial(ctx context.Context, user, host string) (*ssh.Client, error) {
+	addr := net.JoinHostPort(host, strconv.Itoa(sshPort))
+	fail := func(kind, err error) error { return &sshError{User: user, Host: host, Kind: kind, Err: err} }
+	d := net.Dialer{Timeout: sshDialTimeout}
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fail(errSSHUnreachable, err)
+	}
+	// Si la clave del host se verificó, el handshake solo puede fallar
+	// después por la autenticación o porque se cortó la conexión
+	hostKeyOK := false
+	cfg := &ssh.ClientConfig{
+		User: user,
+		Auth: []ssh.AuthMethod{ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+			signers := sshSigners()
+			if len(signers) == 0 {
+				return nil, errors.New("no hay clave SSH configurada")
+			}
+			return signers, nil
+		})},
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			if err := checkHostKey(hostname, remote, key); err != nil {
+				return err
+			}
+			hostKeyOK = true
+			return nil
+		},
+		Timeout: sshDialTimeout,
+	}
+	conn.SetDeadline(time.Now().Add(sshDialTimeout))
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, cfg)
+	stop()
+	if err != nil {
+		conn.Close()
+		var ne net.Error
+		switch {
+		case ctx.Err() != nil:
+			return nil, ctx.Err()
+		case errors.Is(err, errHostKeyMismatch):
+			return nil, fail(errHostKeyMismatch, err)
+		case hostKeyOK && !errors.As(err, &ne) && !errors.Is(err, io.EOF):
+			return nil, fail(errSSHAuth, err)
+		}
+		return nil, fail(errSSHUnreachable, err)
+	}
+	conn.SetDeadline(time.Time{})
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// sshExec ejecuta remoteCmd en una sesión nueva de c con el timeout del paso
+// step. Si el contexto se cancela se cierra la sesión. Registra la
+// invocación en el log de la operación, como runCmd.
+func sshExec(ctx context.Context, c *ssh.Client, step, target, remoteCmd string, stdin io.Reader, stdout, stderr io.Writer) error {
+	sctx, cancel := stepContext(ctx, step)
+	defer cancel()
+	sess, err := c.NewSession()
+	if err != nil {
+		return err
+	}
+	defer sess.Close()
+	var outBuf, errBuf cappedBuffer
+	rec := opRecorderFrom(ctx)
+	if rec != nil {
+		stdout, stderr = io.MultiWriter(stdout, &outBuf), io.MultiWriter(stderr, &errBuf)
+	}
+	sess.Stdin, sess.Stdout, sess.Stderr = stdin, stdout, stderr
+	stop := context.AfterFunc(sctx, func() { sess.Close() })
+	start := time.Now()
+	err = sess.Run(remoteCmd)
+	stop()
+	code := 0
+	var ee *ssh.ExitError
+	if errors.As(err, &ee) {
+		code = ee.ExitStatus()
+		err = &sshExitError{Host: target, Cmd: remoteCmd, Status: code}
+	} else if err != nil {
+		code = -1
+	}
+	err = stepErr(ctx, sctx, step, err)
+	if rec != nil {
+		cl := CommandLog{
+			Name:       "ssh",
+			Args:       []string{target, remoteCmd},
+			StartedAt:  start.UTC().Format(time.RFC3339),
+			DurationMs: time.Since(start).Milliseconds(),
+			ExitCode:   code,
+			Stdout:     outBuf.String(),
+			Stderr:     errBuf.String(),
+		}
+		if err != nil {
+			cl.Error = err.Error()
+		}
+		rec.addCommand(cl)
+	}
+	return err
+}
+
+// sshRun ejecuta remoteCmd en user@host con una conexión propia, enviando la
+// salida a la de la operación. Si stdin no es nil, se envía como entrada
+// estándar del comando remoto.
+func sshRun(ctx context.Context, user, host string, stdin io.Reader, remoteCmd string) error {
+	c, err := sshDial(ctx, user, host)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	stdout, stderr := opWriters(ctx)
+	return sshExec(ctx, c, "ssh", user+"@"+host, remoteCmd, stdin, stdout, stderr)
+}
+
+// sshRunArgs ejecuta remoteCmd en user@host como sshRun, con args como sus
+// parámetros posicionales ($1, $2, ...). Los argumentos viajan por la entrada
+// estándar, una línea cada uno, y se leen con read -r: nunca forman parte del
+// texto que interpreta el shell remoto. remoteCmd debe usarlos entre comillas
+// dobles ("$1") y no leer la entrada estándar.
+func sshRunArgs(ctx context.Context, user, host, remoteCmd string, args ...string) error {
+	var in strings.Builder
+	vars := make([]string, len(args))
+	reads := make([]string, len(args))
+	for i, a := range args {
+		if strings.ContainsAny(a, "\x00\r\n") {
+			return fmt.Errorf("argumento remoto no permitido: %q", a)
+		}
+		in.WriteString(a + "\n")
+		vars[i] = fmt.Sprintf(`"$a%d"`, i+1)
+		reads[i] = fmt.Sprintf("IFS= read -r a%d", i+1)
+	}
+	if len(args) > 0 {
+		remoteCmd = strings.Join(reads, " && ") + " && set -- " + strings.Join(vars, " ") + " && { " + remoteCmd + "; }"
+	}
+	return sshRun(ctx, user, host, strings.NewReader(in.String()), remoteCmd)
+}
+
+// sftpUpload copia el archivo local a user@host:remote por SFTP, con el
+// timeout del paso "scp".
+func sftpUpload(ctx context.Context, user, host, local, remote string) error {
+	sctx, cancel := stepContext(ctx, "scp")
+	defer cancel()
+	start := time.Now()
+	err := func() error {
+		src, err := os.Open(local)
+		if err != nil {
+			return err
+		}
+		defer src.Close()
+		c, err := sshDial(sctx, user, host)
+		if err != nil {
+			return err
+		}
+		defer c.Close()
+		stop := context.AfterFunc(sctx, func() { c.Close() })
+		defer stop()
+		sc, err := sftp.NewClient(c)
+		if err != nil {
+			return err
+		}
+		defer sc.Close()
+		dst, err := sc.Create(remote)
+		if err != nil {
+			return fmt.Errorf("sftp %s: %w", remote, err)
+		}
+		if _, err := dst.ReadFrom(src); err != nil {
+			dst.Close()
+			return fmt.Errorf("sftp %s: %w", remote, err)
+		}
+		return dst.Close()
+	}()
+	err = stepErr(ctx, sctx, "scp", err)
+	if rec := opRecorderFrom(ctx); rec != nil {
+		cl := CommandLog{
+			Name:       "sftp",
+			Args:       []string{local, user + "@" + host + ":" + remote},
+			StartedAt:  start.UTC().Format(time.RFC3339),
+			DurationMs: time.Since(start).Milliseconds(),
+		}
+		if err != nil {
+			cl.ExitCode, cl.Error = -1, err.Error()
+		}
+		rec.addCommand(cl)
+	}
+	return err
+}
+
+// ============================== SSH Pool ====================================
+
+// sshPool mantiene abierta una conexión por destino para los hosts a los que
+// se conecta seguido (el servidor DNS). Una conexión que deja de responder
+// se descarta y se vuelve a abrir.
+type sshPool struct {
+	mu      sync.Mutex
+	clients map[string]*ssh.Client
+}
+
+// dnsSSH conexiones al servidor DNS.
+var dnsSSH = &sshPool{clients: map[string]*ssh.Client{}}
+
+// get retorna la conexión abierta a user@host o abre una nueva.
+func (p *sshPool) get(ctx context.Context, user, host string) (*ssh.Client, error) {
+	key := user + "@" + host
+	p.mu.Lock()
+	c := p.clients[key]
+	p.mu.Unlock()
+	if c != nil {
+		return c, nil
+	}
+	c, err := sshDial(ctx, user, host)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if old := p.clients[key]; old != nil {
+		c.Close()
+		return old, nil
+	}
+	p.clients[key] = c
+	return c, nil
+}
+
+// drop cierra la conexión c si sigue siendo la de user@host.
+func (p *sshPool) drop(user, host string, c *ssh.Client) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.clients[user+"@"+host] == c {
+		delete(p.clients, user+"@"+host)
+	}
+	c.Close()
+}
+
+// run ejecuta remoteCmd en user@host y retorna su salida estándar. Si la
+// conexión guardada ya no sirve, reintenta una vez con una nueva.
+func (p *sshPool) run(ctx context.Context, user, host, remoteCmd string) ([]byte, error) {
+	for attempt := 0; ; attempt++ {
+		c, err := p.get(ctx, user, host)
+		if err != nil {
+			return nil, err
+		}
+		var stdout, stderr bytes.Buffer
+		err = sshExec(ctx, c, "ssh", user+"@"+host, remoteCmd, nil, &stdout, &stderr)
+		var ee *sshExitError
+		if err == nil || errors.As(err, &ee) || errors.Is(err, context.DeadlineExceeded) || ctx.Err() != nil {
+			if ee != nil && stderr.Len() > 0 {
+				err = fmt.Errorf("%w: %s", err, bytes.TrimSpace(stderr.Bytes()))
+			}
+			return stdout.Bytes(), err
+		}
+		// Fallo de la conexión, no del comando
+		p.drop(user, host, c)
+		if attempt > 0 {
+			return nil, &sshError{User: user, Host: host, Kind: errSSHUnreachable, Err: err}
+		}
+	}
+}
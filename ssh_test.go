@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// newTestSigner genera una clave ed25519.
+func newTestSigner(t *testing.T) ssh.Signer {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// sshTestEnv apunta sshPort, knownHostsPath y la clave SSH de los secretos
+// a valores de prueba y los restaura al terminar. Retorna la clave del
+// cliente.
+func sshTestEnv(t *testing.T) ssh.Signer {
+	t.Helper()
+	client := newTestSigner(t)
+	port, kh := sshPort, knownHostsPath
+	muSecrets.Lock()
+	prev := secrets
+	secrets.sshSigner = client
+	muSecrets.Unlock()
+	knownHostsPath = filepath.Join(t.TempDir(), "known_hosts")
+	t.Cleanup(func() {
+		sshPort, knownHostsPath = port, kh
+		muSecrets.Lock()
+		secrets = prev
+		muSecrets.Unlock()
+	})
+	return client
+}
+
+// startSSHServer arranca en 127.0.0.1:port (0 = cualquiera) un servidor SSH
+// con la clave de host hostKey que solo acepta la clave authorized. Ejecuta
+// los comandos con sh y atiende el subsistema sftp. Deja sshPort apuntando a
+// él.
+func startSSHServer(t *testing.T, hostKey ssh.Signer, authorized ssh.PublicKey, port int) net.Listener {
+	t.Helper()
+	cfg := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) != string(authorized.Marshal()) {
+				return nil, errors.New("clave no autorizada")
+			}
+			return nil, nil
+		},
+	}
+	cfg.AddHostKey(hostKey)
+	ln, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(port)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	sshPort = ln.Addr().(*net.TCPAddr).Port
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go serveSSHConn(conn, cfg)
+		}
+	}()
+	return ln
+}
+
+// serveSSHConn atiende una conexión del servidor de prueba.
+func serveSSHConn(conn net.Conn, cfg *ssh.ServerConfig) {
+	defer conn.Close()
+	_, chans, reqs, err := ssh.NewServerConn(conn, cfg)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for nc := range chans {
+		if nc.ChannelType() != "session" {
+			nc.Reject(ssh.UnknownChannelType, "solo session")
+			continue
+		}
+		ch, chReqs, err := nc.Accept()
+		if err != nil {
+			return
+		}
+		go serveSSHSession(ch, chReqs)
+	}
+}
+
+// serveSSHSession atiende los pedidos exec y subsystem sftp de una sesión.
+func serveSSHSession(ch ssh.Channel, reqs <-chan *ssh.Request) {
+	defer ch.Close()
+	for req := range reqs {
+		var payload struct{ Value string }
+		ssh.Unmarshal(req.Payload, &payload)
+		switch {
+		case req.Type == "exec":
+			req.Reply(true, nil)
+			cmd := exec.Command("sh", "-c", payload.Value)
+			cmd.Stdin, cmd.Stdout, cmd.Stderr = ch, ch, ch.Stderr()
+			status := uint32(0)
+			if err := cmd.Run(); err != nil {
+				status = 1
+				var ee *exec.ExitError
+				if errors.As(err, &ee) {
+					status = uint32(ee.ExitCode())
+				}
+			}
+			ch.SendRequest("exit-status", false, binary.BigEndian.AppendUint32(nil, status))
+			return
+		case req.Type == "subsystem" && payload.Value == "sftp":
+			req.Reply(true, nil)
+			srv, err := sftp.NewServer(ch)
+			if err == nil {
+				srv.Serve()
+			}
+			return
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
+
+func TestSSHTrustOnFirstUse(t *testing.T) {
+	client := sshTestEnv(t)
+	hostKey := newTestSigner(t)
+	startSSHServer(t, hostKey, client.PublicKey(), 0)
+
+	c, err := sshDial(context.Background(), "deploy", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	list, _, err := readKnownHosts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) == 0 || list[0].Fingerprint != ssh.FingerprintSHA256(hostKey.PublicKey()) {
+		t.Fatalf("known_hosts = %+v, quiero la clave %s", list, ssh.FingerprintSHA256(hostKey.PublicKey()))
+	}
+	if want := "[127.0.0.1]:"; !strings.HasPrefix(list[0].Hosts[0], want) {
+		t.Errorf("host registrado %q, quiero %s<puerto>", list[0].Hosts[0], want)
+	}
+	before, _ := os.ReadFile(knownHostsPath)
+
+	// La segunda conexión verifica la clave registrada sin agregarla de nuevo
+	c, err = sshDial(context.Background(), "deploy", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	if after, _ := os.ReadFile(knownHostsPath); string(after) != string(before) {
+		t.Errorf("known_hosts cambió en la segunda conexión:\n%s", after)
+	}
+}
+
+func TestSSHHostKeyMismatch(t *testing.T) {
+	client := sshTestEnv(t)
+	registered, actual := newTestSigner(t), newTestSigner(t)
+	ln := startSSHServer(t, registered, client.PublicKey(), 0)
+	c, err := sshDial(context.Background(), "deploy", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+
+	// El host se reinstaló con otra clave en el mismo puerto
+	ln.Close()
+	startSSHServer(t, actual, client.PublicKey(), sshPort)
+	_, err = sshDial(context.Background(), "deploy", "127.0.0.1")
+	if !errors.Is(err, errHostKeyMismatch) {
+		t.Fatalf("sshDial = %v, quiero errHostKeyMismatch", err)
+	}
+	var he *hostKeyError
+	if !errors.As(err, &he) {
+		t.Fatalf("sshDial = %v, quiero *hostKeyError", err)
+	}
+	if he.Want != ssh.FingerprintSHA256(registered.PublicKey()) || he.Got != ssh.FingerprintSHA256(actual.PublicKey()) {
+		t.Errorf("hostKeyError = %+v", he)
+	}
+	if e := toAPIError(err, ""); e.Code != codeSSHHostKey {
+		t.Errorf("código %s, quiero %s", e.Code, codeSSHHostKey)
+	}
+
+	// Olvidar la clave permite volver a registrarla
+	if err := forgetHostKey(he.Host); err != nil {
+		t.Fatal(err)
+	}
+	c, err = sshDial(context.Background(), "deploy", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("sshDial tras olvidar la clave = %v", err)
+	}
+	c.Close()
+}
+
+func TestSSHAuthRejected(t *testing.T) {
+	sshTestEnv(t)
+	startSSHServer(t, newTestSigner(t), newTestSigner(t).PublicKey(), 0)
+	_, err := sshDial(context.Background(), "deploy", "127.0.0.1")
+	if !errors.Is(err, errSSHAuth) {
+		t.Errorf("sshDial = %v, quiero errSSHAuth", err)
+	}
+}
+
+func TestSSHUnreachable(t *testing.T) {
+	sshTestEnv(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sshPort = ln.Addr().(*net.TCPAddr).Port
+	ln.Close()
+	if _, err := sshDial(context.Background(), "deploy", "127.0.0.1"); !errors.Is(err, errSSHUnreachable) {
+		t.Errorf("sshDial = %v, quiero errSSHUnreachable", err)
+	}
+}
+
+func TestSSHRunArgs(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("el servidor de prueba ejecuta los comandos con sh")
+	}
+	client := sshTestEnv(t)
+	startSSHServer(t, newTestSigner(t), client.PublicKey(), 0)
+	dir := t.TempDir()
+	out := filepath.Join(dir, "out")
+	host := `x;touch ` + filepath.Join(dir, "pwned") + ` $(id) "'`
+	remote := `printf '%s|%s' "$1" "$2" > ` + out
+	if err := sshRunArgs(context.Background(), "deploy", "127.0.0.1", remote, host, "b c"); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := host + "|b c"; string(got) != want {
+		t.Errorf("argumentos recibidos %q, quiero %q", got, want)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "pwned")); err == nil {
+		t.Error("el shell remoto interpretó un argumento")
+	}
+	if err := sshRunArgs(context.Background(), "deploy", "127.0.0.1", "true", "a\nb"); err == nil {
+		t.Error("sshRunArgs aceptó un argumento con salto de línea")
+	}
+
+	err = sshRun(context.Background(), "deploy", "127.0.0.1", nil, "exit 3")
+	var ee *sshExitError
+	if !errors.As(err, &ee) || ee.Status != 3 {
+		t.Errorf("sshRun = %v, quiero código 3", err)
+	}
+}
+
+func TestSFTPUpload(t *testing.T) {
+	client := sshTestEnv(t)
+	startSSHServer(t, newTestSigner(t), client.PublicKey(), 0)
+	dir := t.TempDir()
+	local := filepath.Join(dir, "site.zip")
+	data := []byte(strings.Repeat("contenido del sitio\n", 5000))
+	if err := os.WriteFile(local, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	remote := filepath.ToSlash(filepath.Join(dir, "subido.zip"))
+	if err := sftpUpload(context.Background(), "deploy", "127.0.0.1", local, remote); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(remote)
+	if err != nil || string(got) != string(data) {
+		t.Errorf("archivo subido: %d bytes, %v; quiero %d bytes", len(got), err, len(data))
+	}
+	if err := sftpUpload(context.Background(), "deploy", "127.0.0.1", local, filepath.Join(dir, "no", "existe")); err == nil {
+		t.Error("sftpUpload a un directorio inexistente no falló")
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ============================== Store =======================================
+
+// Store persiste las instancias y el historial DNS. Las modificaciones de
+// instancias se hacen dentro de una transacción (UpdateInstances): o se
+// aplican todas o ninguna.
+type Store interface {
+	// ListInstances retorna todas las instancias en orden de creación.
+	ListInstances() ([]Instance, error)
+	// GetInstance busca una instancia por ID; retorna errInstanceMissing si no existe.
+	GetInstance(id string) (Instance, error)
+	// ViewInstances ejecuta fn en una transacción de solo lectura.
+	ViewInstances(fn func(tx InstanceTx) error) error
+	// UpdateInstances ejecuta fn en una transacción de escritura. Si fn
+	// retorna error no se guarda ningún cambio.
+	UpdateInstances(fn func(tx InstanceTx) error) error
+	// AppendDNSLog agrega una entrada al historial DNS y le asigna el Seq
+	// siguiente (mayor que el de las entradas archivadas).
+	AppendDNSLog(l DNSLog) error
+	// ListDNSLogs retorna las entradas del historial DNS que aún no se
+	// archivaron, en orden de Seq.
+	ListDNSLogs() ([]DNSLog, error)
+	// TrimDNSLogs quita las entradas con Seq hasta upTo, ya archivadas.
+	TrimDNSLogs(upTo uint64) error
+	// Check lee todos los datos y verifica su integridad. Los archivos
+	// corruptos se registran con markCorrupt.
+	Check() error
+	// Close libera el almacenamiento.
+	Close() error
+}
+
+// InstanceTx es la vista de las instancias dentro de una transacción.
+type InstanceTx interface {
+	// List retorna todas las instancias en orden de creación.
+	List() ([]Instance, error)
+	// Get busca por ID.
+	Get(id string) (Instance, bool, error)
+	// ByHost busca por FQDN (sin distinguir mayúsculas).
+	ByHost(fqdn string) (Instance, bool, error)
+	// ByIP busca por dirección IP.
+	ByIP(ip string) (Instance, bool, error)
+	// Put crea o reemplaza la instancia con su ID.
+	Put(inst Instance) error
+	// Delete elimina la instancia; no falla si no existe.
+	Delete(id string) error
+}
+
+// store almacenamiento seleccionado al iniciar el servidor.
+var store Store
+
+// errDuplicateHost indica que otra instancia ya usa el FQDN o la IP.
+var errDuplicateHost = errors.New("el host o la IP ya pertenecen a otra instancia")
+
+// openStore abre el almacenamiento indicado ("bolt" o "json"). Al crear la
+// base bolt por primera vez importa hosts.json y dns-logs.json; si alguno
+// está corrupto la base nueva se descarta, para no arrancar con un
+// inventario vacío que luego nadie vuelva a importar. Las modificaciones
+// del store retornado se bloquean mientras haya archivos corruptos.
+func openStore(kind string) (Store, error) {
+	switch strings.ToLower(strings.TrimSpace(kind)) {
+	case "", "bolt":
+		s, err := openBoltStore(dbPath)
+		if err != nil {
+			return nil, err
+		}
+		// Base nueva: se importan los datos del almacenamiento JSON anterior
+		if s.fresh {
+			n, m, err := importJSON(s, newJSONStore())
+			if err != nil {
+				s.Close()
+				if errors.Is(err, errStoreCorrupt) {
+					os.Remove(dbPath)
+				}
+				return nil, fmt.Errorf("importando JSON: %w", err)
+			}
+			if n+m > 0 {
+				fmt.Printf("Storage: importadas %d instancias y %d logs DNS desde JSON\n", n, m)
+			}
+		}
+		return guardedStore{s}, nil
+	case "json":
+		return guardedStore{newJSONStore()}, nil
+	default:
+		return nil, fmt.Errorf("storage desconocido %q (use bolt o json)", kind)
+	}
+}
+
+// normalizeInstance completa los campos de instancias guardadas por versiones
+// anteriores. Las instancias anteriores al ciclo de vida sólo se guardaban
+// publicadas.
+func normalizeInstance(inst *Instance) {
+	if inst.State == "" {
+		inst.State = instRunning
+	}
+	if inst.VM == "" {
+		inst.VM = vmNameOf(inst.Host)
+	}
+}
+
+// importJSON copia las instancias y el historial DNS de los archivos JSON
+// al almacenamiento st. Las instancias cuyo ID ya existe y los logs que ya
+// están en st o en el archivo de logs rotados se omiten, por lo que se puede
+// ejecutar más de una vez. Retorna cuántas instancias y logs
+// se importaron.
+func importJSON(st Store, src *jsonStore) (int, int, error) {
+	list, err := src.ListInstances()
+	if err != nil {
+		return 0, 0, err
+	}
+	imported := 0
+	err = st.UpdateInstances(func(tx InstanceTx) error {
+		for _, inst := range list {
+			if _, ok, err := tx.Get(inst.ID); err != nil || ok {
+				if err != nil {
+					return err
+				}
+				continue
+			}
+			if err := tx.Put(inst); err != nil {
+				return fmt.Errorf("importando %s: %w", inst.Host, err)
+			}
+			imported++
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	logs, err := src.ListDNSLogs()
+	if err != nil {
+		return imported, 0, err
+	}
+	existing, err := st.ListDNSLogs()
+	if err != nil {
+		return imported, 0, err
+	}
+	// Los logs ya rotados al archivo también cuentan como importados (ver
+	// rotateDNSLogs)
+	archives, err := listDNSArchives()
+	if err != nil {
+		return imported, 0, err
+	}
+	for _, a := range archives {
+		logs, err := readDNSArchive(a.path)
+		if err != nil {
+			return imported, 0, err
+		}
+		existing = append(existing, logs...)
+	}
+	// El Seq lo asigna cada almacenamiento: se compara el resto de los campos
+	seen := make(map[DNSLog]bool, len(existing))
+	for _, l := range existing {
+		l.Seq = 0
+		seen[l] = true
+	}
+	logsImported := 0
+	for _, l := range logs {
+		l.Seq = 0
+		if seen[l] {
+			continue
+		}
+		if err := st.AppendDNSLog(l); err != nil {
+			return imported, logsImported, err
+		}
+		logsImported++
+	}
+	return imported, logsImported, nil
+}
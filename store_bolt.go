@@ -0,0 +1,429 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// ============================== Bolt Store ==================================
+
+// Buckets de la base bbolt.
+var (
+	bucketMeta      = []byte("meta")      // Versión del esquema
+	bucketInstances = []byte("instances") // ID -> Instance (JSON)
+	bucketByHost    = []byte("idx_host")  // FQDN en minúsculas -> ID
+	bucketByIP      = []byte("idx_ip")    // IP -> ID
+	bucketDNSLogs   = []byte("dns_logs")  // Secuencia (uint64 big endian) -> DNSLog (JSON)
+	bucketOrder     = []byte("idx_order") // CreatedAt + ID -> ID, para listar en orden de creación
+	keySchema       = []byte("schema_version")
+)
+
+// boltMigration lleva el esquema de la versión anterior a version.
+type boltMigration struct {
+	version int
+	name    string
+	fn      func(tx *bolt.Tx) error
+}
+
+// boltMigrations migraciones del esquema en orden. Se agregan al final; una
+// migración ya publicada no se modifica.
+var boltMigrations = []boltMigration{
+	{1, "buckets de instancias, índices por host e IP e historial DNS", func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{bucketInstances, bucketByHost, bucketByIP, bucketDNSLogs} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	}},
+	{2, "índice por fecha de creación", func(tx *bolt.Tx) error {
+		idx, err := tx.CreateBucketIfNotExists(bucketOrder)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(bucketInstances).ForEach(func(k, v []byte) error {
+			var inst Instance
+			if err := json.Unmarshal(v, &inst); err != nil {
+				return fmt.Errorf("instancia %s: %w", k, err)
+			}
+			return idx.Put(orderKey(inst), k)
+		})
+	}},
+}
+
+// boltStore implementa Store sobre una base bbolt embebida. Cada cambio se
+// escribe en una transacción y los índices por host e IP se mantienen en la
+// misma transacción que la instancia.
+type boltStore struct {
+	db    *bolt.DB
+	fresh bool // true si la base se creó al abrirla (sin datos previos)
+}
+
+// openBoltStore abre (o crea) la base en path y aplica las migraciones pendientes.
+func openBoltStore(path string) (*boltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("abriendo %s: %w", path, err)
+	}
+	s := &boltStore{db: db}
+	from, err := s.migrate()
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrando %s: %w", path, err)
+	}
+	s.fresh = from == 0
+	return s, nil
+}
+
+// migrate aplica cada migración pendiente en su propia transacción y
+// registra la versión alcanzada. Retorna la versión que tenía la base.
+func (s *boltStore) migrate() (int, error) {
+	from := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(bucketMeta)
+		if err != nil {
+			return err
+		}
+		if v := meta.Get(keySchema); v != nil {
+			from, err = strconv.Atoi(string(v))
+		}
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	latest := boltMigrations[len(boltMigrations)-1].version
+	if from > latest {
+		return from, fmt.Errorf("esquema versión %d más nuevo que el soportado (%d)", from, latest)
+	}
+	for _, m := range boltMigrations {
+		if m.version <= from {
+			continue
+		}
+		err := s.db.Update(func(tx *bolt.Tx) error {
+			if err := m.fn(tx); err != nil {
+				return err
+			}
+			return tx.Bucket(bucketMeta).Put(keySchema, []byte(strconv.Itoa(m.version)))
+		})
+		if err != nil {
+			return from, fmt.Errorf("migración %d (%s): %w", m.version, m.name, err)
+		}
+		if from > 0 {
+			fmt.Printf("Storage: migración %d aplicada (%s)\n", m.version, m.name)
+		}
+	}
+	return from, nil
+}
+
+// orderKey clave del índice por fecha de creación.
+func orderKey(inst Instance) []byte {
+	return []byte(inst.CreatedAt + "\x00" + inst.ID)
+}
+
+// ListInstances retorna todas las instancias en orden de creación.
+func (s *boltStore) ListInstances() ([]Instance, error) {
+	var list []Instance
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		list, err = (&boltTx{tx: tx}).List()
+		return err
+	})
+	return list, err
+}
+
+// GetInstance busca una instancia por ID.
+func (s *boltStore) GetInstance(id string) (Instance, error) {
+	var inst Instance
+	var ok bool
+	err := s.db.View(func(tx *bolt.Tx) error {
+		var err error
+		inst, ok, err = (&boltTx{tx: tx}).Get(id)
+		return err
+	})
+	if err == nil && !ok {
+		err = errInstanceMissing
+	}
+	return inst, err
+}
+
+// ViewInstances ejecuta fn en una transacción de lectura de bbolt.
+func (s *boltStore) ViewInstances(fn func(tx InstanceTx) error) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		return fn(&boltTx{tx: tx})
+	})
+}
+
+// UpdateInstances ejecuta fn en una transacción de escritura de bbolt.
+func (s *boltStore) UpdateInstances(fn func(tx InstanceTx) error) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return fn(&boltTx{tx: tx})
+	})
+}
+
+// AppendDNSLog agrega una entrada al historial DNS. El Seq es la secuencia
+// del bucket, que también es la clave.
+func (s *boltStore) AppendDNSLog(l DNSLog) error {
+	archived, err := lastArchivedSeq()
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bk := tx.Bucket(bucketDNSLogs)
+		// Segmentos archivados por otro almacenamiento (ej: antes de migrar desde json)
+		if bk.Sequence() < archived {
+			if err := bk.SetSequence(archived); err != nil {
+				return err
+			}
+		}
+		seq, err := bk.NextSequence()
+		if err != nil {
+			return err
+		}
+		l.Seq = seq
+		b, err := json.Marshal(l)
+		if err != nil {
+			return err
+		}
+		return bk.Put(seqKey(seq), b)
+	})
+}
+
+// ListDNSLogs retorna las entradas no archivadas en orden de Seq.
+func (s *boltStore) ListDNSLogs() ([]DNSLog, error) {
+	logs := []DNSLog{}
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(bucketDNSLogs).ForEach(func(k, v []byte) error {
+			var l DNSLog
+			if err := json.Unmarshal(v, &l); err != nil {
+				return markCorrupt(s.db.Path(), fmt.Errorf("log DNS %d: %w", binary.BigEndian.Uint64(k), err), false)
+			}
+			// Las entradas anteriores al Seq solo lo tienen en la clave
+			l.Seq = binary.BigEndian.Uint64(k)
+			logs = append(logs, l)
+			return nil
+		})
+	})
+	return logs, err
+}
+
+// TrimDNSLogs borra las entradas con Seq hasta upTo.
+func (s *boltStore) TrimDNSLogs(upTo uint64) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		c := tx.Bucket(bucketDNSLogs).Cursor()
+		for k, _ := c.First(); k != nil && binary.BigEndian.Uint64(k) <= upTo; k, _ = c.First() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// seqKey clave de una entrada del historial DNS (uint64 big endian, para que
+// el orden de las claves sea el de Seq).
+func seqKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
+
+// Check verifica las páginas de la base (tx.Check de bbolt), decodifica
+// cada instancia y log DNS y comprueba que los índices coincidan con las
+// instancias. Si encuentra problemas guarda una copia de la base en
+// <path>.corrupt-<fecha> (la base abierta no se puede renombrar) y los
+// registra con markCorrupt.
+func (s *boltStore) Check() error {
+	var errs []error
+	err := s.db.View(func(tx *bolt.Tx) error {
+		for err := range tx.Check() {
+			errs = append(errs, err)
+		}
+		// Con páginas dañadas recorrer los buckets puede fallar de otra forma
+		if len(errs) == 0 {
+			errs = checkBoltData(tx)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	path := s.db.Path()
+	details := make([]string, len(errs))
+	for i, err := range errs {
+		details[i] = err.Error()
+	}
+	p := StorageProblem{Path: path, Detail: strings.Join(details, "; "), DetectedAt: time.Now().UTC().Format(time.RFC3339)}
+	dst := path + ".corrupt-" + time.Now().UTC().Format("20060102T150405Z")
+	if err := s.db.View(func(tx *bolt.Tx) error { return tx.CopyFile(dst, 0600) }); err != nil {
+		fmt.Printf("Storage: no se pudo copiar %s: %v\n", path, err)
+	} else {
+		p.Quarantine = dst
+	}
+	return reportProblem(p, errors.Join(errs...))
+}
+
+// checkBoltData decodifica las instancias y los logs DNS y verifica los
+// índices en ambos sentidos.
+func checkBoltData(tx *bolt.Tx) []error {
+	var errs []error
+	instances := tx.Bucket(bucketInstances)
+	indexes := []struct {
+		bucket []byte
+		key    func(Instance) string
+	}{
+		{bucketByHost, func(inst Instance) string { return strings.ToLower(inst.Host) }},
+		{bucketByIP, func(inst Instance) string { return inst.IP }},
+		{bucketOrder, func(inst Instance) string { return string(orderKey(inst)) }},
+	}
+	instances.ForEach(func(k, v []byte) error {
+		var inst Instance
+		if err := json.Unmarshal(v, &inst); err != nil {
+			errs = append(errs, fmt.Errorf("instancia %s: %w", k, err))
+			return nil
+		}
+		for _, idx := range indexes {
+			if id := tx.Bucket(idx.bucket).Get([]byte(idx.key(inst))); string(id) != string(k) {
+				errs = append(errs, fmt.Errorf("%s: falta la entrada de la instancia %s", idx.bucket, k))
+			}
+		}
+		return nil
+	})
+	for _, idx := range indexes {
+		tx.Bucket(idx.bucket).ForEach(func(key, id []byte) error {
+			if instances.Get(id) == nil {
+				errs = append(errs, fmt.Errorf("%s: %q apunta a la instancia inexistente %s", idx.bucket, key, id))
+			}
+			return nil
+		})
+	}
+	tx.Bucket(bucketDNSLogs).ForEach(func(k, v []byte) error {
+		var l DNSLog
+		if err := json.Unmarshal(v, &l); err != nil {
+			errs = append(errs, fmt.Errorf("log DNS %d: %w", binary.BigEndian.Uint64(k), err))
+		}
+		return nil
+	})
+	return errs
+}
+
+// Close cierra la base.
+func (s *boltStore) Close() error { return s.db.Close() }
+
+// boltTx es una transacción de boltStore.
+type boltTx struct {
+	tx *bolt.Tx
+}
+
+// List recorre el índice por fecha de creación.
+func (t *boltTx) List() ([]Instance, error) {
+	list := []Instance{}
+	err := t.tx.Bucket(bucketOrder).ForEach(func(_, id []byte) error {
+		inst, ok, err := t.Get(string(id))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("índice por fecha: instancia %s inexistente", id)
+		}
+		list = append(list, inst)
+		return nil
+	})
+	return list, err
+}
+
+// Get busca por ID.
+func (t *boltTx) Get(id string) (Instance, bool, error) {
+	v := t.tx.Bucket(bucketInstances).Get([]byte(id))
+	if v == nil {
+		return Instance{}, false, nil
+	}
+	var inst Instance
+	if err := json.Unmarshal(v, &inst); err != nil {
+		return Instance{}, false, markCorrupt(t.tx.DB().Path(), fmt.Errorf("instancia %s: %w", id, err), false)
+	}
+	normalizeInstance(&inst)
+	return inst, true, nil
+}
+
+// ByHost busca por FQDN usando el índice idx_host.
+func (t *boltTx) ByHost(fqdn string) (Instance, bool, error) {
+	return t.byIndex(bucketByHost, strings.ToLower(fqdn))
+}
+
+// ByIP busca por IP usando el índice idx_ip.
+func (t *boltTx) ByIP(ip string) (Instance, bool, error) {
+	return t.byIndex(bucketByIP, ip)
+}
+
+// byIndex resuelve key en el índice y carga la instancia.
+func (t *boltTx) byIndex(bucket []byte, key string) (Instance, bool, error) {
+	id := t.tx.Bucket(bucket).Get([]byte(key))
+	if id == nil {
+		return Instance{}, false, nil
+	}
+	return t.Get(string(id))
+}
+
+// Put guarda la instancia y actualiza los índices. Falla si otra instancia
+// usa el mismo FQDN o IP.
+func (t *boltTx) Put(inst Instance) error {
+	for _, idx := range []struct {
+		bucket []byte
+		key    string
+	}{{bucketByHost, strings.ToLower(inst.Host)}, {bucketByIP, inst.IP}} {
+		if id := t.tx.Bucket(idx.bucket).Get([]byte(idx.key)); id != nil && string(id) != inst.ID {
+			return fmt.Errorf("%w: %s (instancia %s)", errDuplicateHost, idx.key, id)
+		}
+	}
+	if err := t.Delete(inst.ID); err != nil {
+		return err
+	}
+	b, err := json.Marshal(inst)
+	if err != nil {
+		return err
+	}
+	id := []byte(inst.ID)
+	if err := t.tx.Bucket(bucketInstances).Put(id, b); err != nil {
+		return err
+	}
+	if err := t.tx.Bucket(bucketByHost).Put([]byte(strings.ToLower(inst.Host)), id); err != nil {
+		return err
+	}
+	if err := t.tx.Bucket(bucketByIP).Put([]byte(inst.IP), id); err != nil {
+		return err
+	}
+	return t.tx.Bucket(bucketOrder).Put(orderKey(inst), id)
+}
+
+// Delete elimina la instancia y sus entradas en los índices.
+func (t *boltTx) Delete(id string) error {
+	old, ok, err := t.Get(id)
+	if err != nil || !ok {
+		return err
+	}
+	for _, del := range []struct {
+		bucket []byte
+		key    string
+	}{
+		{bucketInstances, id},
+		{bucketByHost, strings.ToLower(old.Host)},
+		{bucketByIP, old.IP},
+		{bucketOrder, string(orderKey(old))},
+	} {
+		if err := t.tx.Bucket(del.bucket).Delete([]byte(del.key)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,259 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// ============================== JSON Store ==================================
+
+// jsonStore implementa Store sobre hosts.json y dns-logs.json (el formato
+// original). Cada cambio reescribe el archivo completo con escritura
+// atómica, por eso el historial DNS se archiva (ver rotateDNSLogs).
+type jsonStore struct {
+	mu            sync.Mutex // Serializa las transacciones sobre hosts.json
+	muLogs        sync.Mutex // Serializa el acceso a dns-logs.json
+	instancesPath string
+	dnsLogsPath   string
+}
+
+// newJSONStore crea el jsonStore sobre los archivos de la configuración.
+func newJSONStore() *jsonStore {
+	return &jsonStore{instancesPath: instancesPath, dnsLogsPath: dnsLogsPath}
+}
+
+// ListInstances carga la lista de instancias desde el archivo JSON.
+func (s *jsonStore) ListInstances() ([]Instance, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.load()
+}
+
+// GetInstance busca una instancia por ID.
+func (s *jsonStore) GetInstance(id string) (Instance, error) {
+	list, err := s.ListInstances()
+	if err != nil {
+		return Instance{}, err
+	}
+	for _, it := range list {
+		if it.ID == id {
+			return it, nil
+		}
+	}
+	return Instance{}, errInstanceMissing
+}
+
+// ViewInstances ejecuta fn sobre la lista en memoria; los cambios se descartan.
+func (s *jsonStore) ViewInstances(fn func(tx InstanceTx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list, err := s.load()
+	if err != nil {
+		return err
+	}
+	return fn(&jsonTx{list: list})
+}
+
+// UpdateInstances carga la lista, aplica fn sobre una copia en memoria y, si
+// fn no falla, guarda la lista completa.
+func (s *jsonStore) UpdateInstances(fn func(tx InstanceTx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	list, err := s.load()
+	if err != nil {
+		return err
+	}
+	tx := &jsonTx{list: list}
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if !tx.dirty {
+		return nil
+	}
+	return writeJSONFile(s.instancesPath, tx.list)
+}
+
+// load lee hosts.json. Un archivo inexistente equivale a una lista vacía,
+// salvo que haya sido apartado por corrupto. Si no se puede decodificar se
+// aparta y se retorna el error: nunca se reemplaza por una lista vacía.
+func (s *jsonStore) load() ([]Instance, error) {
+	b, err := os.ReadFile(s.instancesPath)
+	if os.IsNotExist(err) {
+		if err := checkQuarantined(s.instancesPath); err != nil {
+			return nil, err
+		}
+		return []Instance{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var list []Instance
+	if err := json.Unmarshal(b, &list); err != nil {
+		return nil, markCorrupt(s.instancesPath, err, true)
+	}
+	for i := range list {
+		normalizeInstance(&list[i])
+	}
+	return list, nil
+}
+
+// AppendDNSLog agrega una entrada con el Seq siguiente al de la última
+// entrada del archivo o, si está vacío, al del último segmento archivado.
+func (s *jsonStore) AppendDNSLog(l DNSLog) error {
+	s.muLogs.Lock()
+	defer s.muLogs.Unlock()
+	logs, err := s.loadLogs()
+	if err != nil {
+		return err
+	}
+	last, err := lastArchivedSeq()
+	if err != nil {
+		return err
+	}
+	if n := len(logs); n > 0 {
+		last = max(last, logs[n-1].Seq)
+	}
+	l.Seq = last + 1
+	return writeJSONFile(s.dnsLogsPath, append(logs, l))
+}
+
+// ListDNSLogs carga los logs DNS desde el archivo JSON.
+func (s *jsonStore) ListDNSLogs() ([]DNSLog, error) {
+	s.muLogs.Lock()
+	defer s.muLogs.Unlock()
+	return s.loadLogs()
+}
+
+// TrimDNSLogs reescribe el archivo sin las entradas con Seq hasta upTo.
+func (s *jsonStore) TrimDNSLogs(upTo uint64) error {
+	s.muLogs.Lock()
+	defer s.muLogs.Unlock()
+	logs, err := s.loadLogs()
+	if err != nil {
+		return err
+	}
+	logs = slices.DeleteFunc(logs, func(l DNSLog) bool { return l.Seq <= upTo })
+	return writeJSONFile(s.dnsLogsPath, logs)
+}
+
+// loadLogs lee dns-logs.json con el mismo criterio que load.
+func (s *jsonStore) loadLogs() ([]DNSLog, error) {
+	b, err := os.ReadFile(s.dnsLogsPath)
+	if os.IsNotExist(err) {
+		if err := checkQuarantined(s.dnsLogsPath); err != nil {
+			return nil, err
+		}
+		return []DNSLog{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var logs []DNSLog
+	if err := json.Unmarshal(b, &logs); err != nil {
+		return nil, markCorrupt(s.dnsLogsPath, err, true)
+	}
+	// Las entradas anteriores al Seq se numeran por posición: el archivo
+	// solo crece, así que la numeración es estable
+	var prev uint64
+	for i := range logs {
+		if logs[i].Seq == 0 {
+			logs[i].Seq = prev + 1
+		}
+		prev = logs[i].Seq
+	}
+	return logs, nil
+}
+
+// Check lee hosts.json y dns-logs.json completos.
+func (s *jsonStore) Check() error {
+	_, err := s.ListInstances()
+	_, logErr := s.ListDNSLogs()
+	return errors.Join(err, logErr)
+}
+
+// Close no hace nada: los archivos se abren en cada operación.
+func (s *jsonStore) Close() error { return nil }
+
+// writeJSONFile guarda v como JSON indentado usando escritura atómica.
+// Escribe primero a un archivo temporal y luego lo renombra para evitar corrupción.
+func writeJSONFile(path string, v any) error {
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// jsonTx es una transacción de jsonStore: opera sobre la lista en memoria.
+type jsonTx struct {
+	list  []Instance
+	dirty bool
+}
+
+// List retorna una copia de la lista.
+func (tx *jsonTx) List() ([]Instance, error) { return slices.Clone(tx.list), nil }
+
+// Get busca por ID.
+func (tx *jsonTx) Get(id string) (Instance, bool, error) {
+	return tx.find(func(it Instance) bool { return it.ID == id })
+}
+
+// ByHost busca por FQDN.
+func (tx *jsonTx) ByHost(fqdn string) (Instance, bool, error) {
+	return tx.find(func(it Instance) bool { return strings.EqualFold(it.Host, fqdn) })
+}
+
+// ByIP busca por IP.
+func (tx *jsonTx) ByIP(ip string) (Instance, bool, error) {
+	return tx.find(func(it Instance) bool { return it.IP == ip })
+}
+
+// find retorna la primera instancia que cumple match.
+func (tx *jsonTx) find(match func(Instance) bool) (Instance, bool, error) {
+	if i := slices.IndexFunc(tx.list, match); i >= 0 {
+		return tx.list[i], true, nil
+	}
+	return Instance{}, false, nil
+}
+
+// Put reemplaza la instancia con el mismo ID o la agrega al final. Falla si
+// otra instancia usa el mismo FQDN o IP.
+func (tx *jsonTx) Put(inst Instance) error {
+	for _, it := range tx.list {
+		if it.ID != inst.ID && (strings.EqualFold(it.Host, inst.Host) || it.IP == inst.IP) {
+			return fmt.Errorf("%w: %s (%s)", errDuplicateHost, it.Host, it.IP)
+		}
+	}
+	tx.dirty = true
+	if i := slices.IndexFunc(tx.list, func(it Instance) bool { return it.ID == inst.ID }); i >= 0 {
+		tx.list[i] = inst
+		return nil
+	}
+	tx.list = append(tx.list, inst)
+	return nil
+}
+
+// Delete elimina la instancia con ese ID.
+func (tx *jsonTx) Delete(id string) error {
+	n := len(tx.list)
+	tx.list = slices.DeleteFunc(tx.list, func(it Instance) bool { return it.ID == id })
+	tx.dirty = tx.dirty || len(tx.list) != n
+	return nil
+}
@@ -0,0 +1,305 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestImportJSONSkipsArchivedLogs(t *testing.T) {
+	dir := t.TempDir()
+	prevArchive := dnsArchiveDir
+	dnsArchiveDir = filepath.Join(dir, "dns-archive")
+	t.Cleanup(func() { dnsArchiveDir = prevArchive })
+
+	src := &jsonStore{instancesPath: filepath.Join(dir, "hosts.json"), dnsLogsPath: filepath.Join(dir, "dns-logs.json")}
+	if err := os.WriteFile(src.instancesPath, []byte("[]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	logs := `[
+  {"seq": 1, "timestamp": "2026-10-01T10:00:00Z", "action": "ADD", "fqdn": "web1.grid.lab", "ip": "192.168.56.21"},
+  {"seq": 2, "timestamp": "2026-10-01T11:00:00Z", "action": "ADD", "fqdn": "web2.grid.lab", "ip": "192.168.56.22"},
+  {"seq": 3, "timestamp": "2026-10-01T12:00:00Z", "action": "DELETE", "fqdn": "web1.grid.lab", "ip": "192.168.56.21"}
+]
+`
+	if err := os.WriteFile(src.dnsLogsPath, []byte(logs), 0644); err != nil {
+		t.Fatal(err)
+	}
+	st, err := openBoltStore(filepath.Join(dir, "data.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+
+	if _, n, err := importJSON(st, src); err != nil || n != 3 {
+		t.Fatalf("primera importación = %d logs, %v; quiero 3", n, err)
+	}
+	// Se archivan los dos primeros y se quitan del storage, como rotateDNSLogs
+	imported, err := st.ListDNSLogs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeDNSArchive(imported[:2]); err != nil {
+		t.Fatal(err)
+	}
+	if err := st.TrimDNSLogs(imported[1].Seq); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, n, err := importJSON(st, src); err != nil || n != 0 {
+		t.Errorf("segunda importación = %d logs, %v; quiero 0", n, err)
+	}
+	if left, _ := st.ListDNSLogs(); len(left) != 1 {
+		t.Errorf("quedan %d logs en el storage, quiero 1", len(left))
+	}
+}
+
+// storeBackends abre cada implementación de Store sobre un directorio temporal.
+func storeBackends(t *testing.T) map[string]Store {
+	t.Helper()
+	dir := t.TempDir()
+	prevArchive := dnsArchiveDir
+	dnsArchiveDir = filepath.Join(dir, "dns-archive")
+	t.Cleanup(func() { dnsArchiveDir = prevArchive })
+
+	bst, err := openBoltStore(filepath.Join(dir, "data.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { bst.Close() })
+	return map[string]Store{
+		"bolt": bst,
+		"json": &jsonStore{instancesPath: filepath.Join(dir, "hosts.json"), dnsLogsPath: filepath.Join(dir, "dns-logs.json")},
+	}
+}
+
+func TestStoreInstances(t *testing.T) {
+	for name, st := range storeBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			web1 := Instance{ID: "i1", Host: "web1.grid.lab", IP: "192.168.56.21", State: instRunning, CreatedAt: "2026-10-01T10:00:00Z"}
+			web2 := Instance{ID: "i2", Host: "web2.grid.lab", IP: "192.168.56.22", State: instPrepared, CreatedAt: "2026-10-01T11:00:00Z"}
+			err := st.UpdateInstances(func(tx InstanceTx) error {
+				if err := tx.Put(web1); err != nil {
+					return err
+				}
+				return tx.Put(web2)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			list, err := st.ListInstances()
+			if err != nil || len(list) != 2 || list[0].ID != "i1" || list[1].ID != "i2" {
+				t.Fatalf("ListInstances = %+v, %v; quiero i1, i2", list, err)
+			}
+			if got, err := st.GetInstance("i2"); err != nil || got.Host != web2.Host || got.VM != "web2" {
+				t.Errorf("GetInstance(i2) = %+v, %v", got, err)
+			}
+			if _, err := st.GetInstance("nada"); !errors.Is(err, errInstanceMissing) {
+				t.Errorf("GetInstance(nada) = %v; quiero errInstanceMissing", err)
+			}
+			st.ViewInstances(func(tx InstanceTx) error {
+				if got, ok, err := tx.ByHost("WEB1.grid.lab"); err != nil || !ok || got.ID != "i1" {
+					t.Errorf("ByHost(WEB1.grid.lab) = %s, %v, %v", got.ID, ok, err)
+				}
+				if got, ok, err := tx.ByIP("192.168.56.22"); err != nil || !ok || got.ID != "i2" {
+					t.Errorf("ByIP(192.168.56.22) = %s, %v, %v", got.ID, ok, err)
+				}
+				if _, ok, err := tx.ByIP("192.168.56.99"); err != nil || ok {
+					t.Errorf("ByIP(192.168.56.99) = %v, %v; quiero no encontrada", ok, err)
+				}
+				return nil
+			})
+
+			// Host o IP de otra instancia
+			for _, dup := range []Instance{
+				{ID: "i3", Host: "Web1.grid.lab", IP: "192.168.56.23", CreatedAt: "2026-10-01T12:00:00Z"},
+				{ID: "i3", Host: "web3.grid.lab", IP: "192.168.56.22", CreatedAt: "2026-10-01T12:00:00Z"},
+			} {
+				err := st.UpdateInstances(func(tx InstanceTx) error { return tx.Put(dup) })
+				if !errors.Is(err, errDuplicateHost) {
+					t.Errorf("Put(%s, %s) = %v; quiero errDuplicateHost", dup.Host, dup.IP, err)
+				}
+			}
+
+			// Un error en la transacción descarta todos sus cambios
+			errAbort := errors.New("abortar")
+			err = st.UpdateInstances(func(tx InstanceTx) error {
+				if err := tx.Delete("i1"); err != nil {
+					return err
+				}
+				return errAbort
+			})
+			if !errors.Is(err, errAbort) {
+				t.Fatalf("UpdateInstances = %v; quiero errAbort", err)
+			}
+			if _, err := st.GetInstance("i1"); err != nil {
+				t.Errorf("i1 tras la transacción abortada: %v", err)
+			}
+
+			// Reemplazar con otro host libera el anterior en los índices
+			web1.Host, web1.State = "web9.grid.lab", instFailed
+			if err := st.UpdateInstances(func(tx InstanceTx) error { return tx.Put(web1) }); err != nil {
+				t.Fatal(err)
+			}
+			err = st.UpdateInstances(func(tx InstanceTx) error {
+				if _, ok, _ := tx.ByHost("web1.grid.lab"); ok {
+					t.Error("web1.grid.lab sigue indexado tras el reemplazo")
+				}
+				if err := tx.Delete("i2"); err != nil {
+					return err
+				}
+				return tx.Delete("nada")
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			list, err = st.ListInstances()
+			if err != nil || len(list) != 1 || list[0].Host != "web9.grid.lab" || list[0].State != instFailed {
+				t.Errorf("ListInstances = %+v, %v; quiero solo web9.grid.lab", list, err)
+			}
+			if err := st.Check(); err != nil {
+				t.Errorf("Check = %v", err)
+			}
+		})
+	}
+}
+
+func TestStoreDNSLogs(t *testing.T) {
+	for name, st := range storeBackends(t) {
+		t.Run(name, func(t *testing.T) {
+			for _, fqdn := range []string{"web1.grid.lab", "web2.grid.lab", "web3.grid.lab"} {
+				if err := st.AppendDNSLog(DNSLog{Action: "ADD", FQDN: fqdn}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			logs, err := st.ListDNSLogs()
+			if err != nil || len(logs) != 3 {
+				t.Fatalf("ListDNSLogs = %d logs, %v; quiero 3", len(logs), err)
+			}
+			for i, l := range logs {
+				if l.Seq != uint64(i+1) {
+					t.Errorf("log %d: Seq = %d; quiero %d", i, l.Seq, i+1)
+				}
+			}
+
+			if err := st.TrimDNSLogs(2); err != nil {
+				t.Fatal(err)
+			}
+			if err := st.AppendDNSLog(DNSLog{Action: "DELETE", FQDN: "web1.grid.lab"}); err != nil {
+				t.Fatal(err)
+			}
+			logs, err = st.ListDNSLogs()
+			if err != nil || len(logs) != 2 || logs[0].Seq != 3 || logs[1].Seq != 4 || logs[1].Action != "DELETE" {
+				t.Errorf("tras TrimDNSLogs(2) = %+v, %v; quiero Seq 3 y 4", logs, err)
+			}
+		})
+	}
+}
+
+func TestBoltMigrations(t *testing.T) {
+	latest := boltMigrations[len(boltMigrations)-1].version
+	schema := func(t *testing.T, path string) string {
+		t.Helper()
+		db, err := bolt.Open(path, 0600, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		var v string
+		db.View(func(tx *bolt.Tx) error {
+			v = string(tx.Bucket(bucketMeta).Get(keySchema))
+			return nil
+		})
+		return v
+	}
+
+	t.Run("vacía", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "data.db")
+		st, err := openBoltStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !st.fresh {
+			t.Error("fresh = false en una base nueva")
+		}
+		st.Close()
+		if v := schema(t, path); v != strconv.Itoa(latest) {
+			t.Errorf("schema_version = %q; quiero %d", v, latest)
+		}
+	})
+
+	t.Run("versión 1", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "data.db")
+		db, err := bolt.Open(path, 0600, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// Base creada por la primera versión: sin índice por fecha
+		insts := []Instance{
+			{ID: "b", Host: "web2.grid.lab", IP: "192.168.56.22", CreatedAt: "2026-10-01T11:00:00Z"},
+			{ID: "a", Host: "web1.grid.lab", IP: "192.168.56.21", CreatedAt: "2026-10-01T12:00:00Z"},
+		}
+		err = db.Update(func(tx *bolt.Tx) error {
+			meta, err := tx.CreateBucket(bucketMeta)
+			if err != nil {
+				return err
+			}
+			if err := boltMigrations[0].fn(tx); err != nil {
+				return err
+			}
+			for _, inst := range insts {
+				b, _ := json.Marshal(inst)
+				tx.Bucket(bucketInstances).Put([]byte(inst.ID), b)
+				tx.Bucket(bucketByHost).Put([]byte(inst.Host), []byte(inst.ID))
+				tx.Bucket(bucketByIP).Put([]byte(inst.IP), []byte(inst.ID))
+			}
+			return meta.Put(keySchema, []byte("1"))
+		})
+		db.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		st, err := openBoltStore(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if st.fresh {
+			t.Error("fresh = true en una base existente")
+		}
+		list, err := st.ListInstances()
+		if err != nil || len(list) != 2 || list[0].ID != "b" || list[1].ID != "a" {
+			t.Errorf("ListInstances = %+v, %v; quiero b, a (orden de creación)", list, err)
+		}
+		if err := st.Check(); err != nil {
+			t.Errorf("Check = %v", err)
+		}
+		st.Close()
+		if v := schema(t, path); v != strconv.Itoa(latest) {
+			t.Errorf("schema_version = %q; quiero %d", v, latest)
+		}
+	})
+
+	t.Run("más nueva", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "data.db")
+		db, err := bolt.Open(path, 0600, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		db.Update(func(tx *bolt.Tx) error {
+			meta, _ := tx.CreateBucket(bucketMeta)
+			return meta.Put(keySchema, []byte(strconv.Itoa(latest+1)))
+		})
+		db.Close()
+		if st, err := openBoltStore(path); err == nil {
+			st.Close()
+			t.Error("openBoltStore abrió una base con esquema más nuevo")
+		}
+	})
+}
@@ -0,0 +1,38 @@
+// Sesión de la interfaz web: redirige a login.html si la API responde 401,
+// muestra el usuario conectado y oculta las acciones que su rol no permite.
+(function () {
+  const originalFetch = window.fetch.bind(window);
+
+  // Envuelve fetch para volver al login cuando la sesión expira.
+  window.fetch = async (...args) => {
+    const res = await originalFetch(...args);
+    if (res.status === 401) {
+      window.location.href = 'login.html';
+    }
+    return res;
+  };
+
+  // Muestra el usuario y el botón de cierre de sesión.
+  function renderUser(me) {
+    const bar = document.createElement('div');
+    bar.className = 'user-bar';
+    const name = document.createElement('span');
+    name.textContent = `${me.username} (${me.role})`;
+    const btn = document.createElement('button');
+    btn.className = 'btn-logout';
+    btn.textContent = 'Cerrar sesión';
+    btn.onclick = async () => {
+      await originalFetch('/logout', { method: 'POST' });
+      window.location.href = 'login.html';
+    };
+    bar.append(name, btn);
+    document.body.prepend(bar);
+    // viewer solo consulta: se ocultan las acciones de crear, publicar y eliminar
+    document.body.classList.add(`role-${me.role}`);
+  }
+
+  window.fetch('/me', { cache: 'no-store' })
+    .then(r => r.ok ? r.json() : null)
+    .then(me => { if (me) renderUser(me); })
+    .catch(err => console.warn('No se pudo leer la sesión', err));
+})();
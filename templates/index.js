@@ -3,6 +3,96 @@ const btnAccept = document.getElementById('btnAccept');
     const hostname = document.getElementById('hostname');
     const zipfile = document.getElementById('zipfile');
     const messages = document.getElementById('messages');
+    const opLog = document.getElementById('opLog');
+    const btnCancel = document.getElementById('btnCancel');
+    let currentJobId = null;
+
+    // Cancela el job en curso (POST /jobs/{id}/cancel).
+    btnCancel.addEventListener('click', async () => {
+      if (!currentJobId) return;
+      btnCancel.disabled = true;
+      try {
+        await fetch(`/jobs/${encodeURIComponent(currentJobId)}/cancel`, { method: 'POST' });
+      } catch (err) {
+        console.warn('No se pudo cancelar el job', err);
+      }
+    });
+
+    // Agrega una línea al panel de progreso; cls opcional para resaltarla.
+    function appendLog(text, cls) {
+      const line = document.createElement('div');
+      if (cls) line.className = cls;
+      line.textContent = text;
+      opLog.appendChild(line);
+      opLog.scrollTop = opLog.scrollHeight;
+    }
+
+    // Limpia y muestra el panel de progreso.
+    function resetLog() {
+      opLog.innerHTML = '';
+      opLog.style.display = 'block';
+    }
+
+    // Arma el mensaje de un error de la API ({"error": {code, message, detail}}).
+    function apiErrorMessage(body, status) {
+      const e = body && body.error;
+      if (!e) return 'HTTP ' + status;
+      if (typeof e === 'string') return e;
+      return e.detail ? `${e.message} (${e.detail})` : e.message;
+    }
+
+    // Consulta /jobs/{id} hasta que el job termine. onStep recibe el paso actual.
+    // Retorna el resultado del job o lanza un Error con el mensaje del fallo.
+    async function waitJob(jobId, onStep) {
+      while (true) {
+        const res = await fetch(`/jobs/${encodeURIComponent(jobId)}`, { cache: 'no-store' });
+        if (!res.ok) throw new Error('HTTP ' + res.status);
+        const job = await res.json();
+        if (job.state === 'succeeded') return job.result;
+        if (job.state === 'failed' || job.state === 'canceled') throw new Error(job.error || 'job fallido');
+        if (onStep) onStep(job);
+        await new Promise(r => setTimeout(r, 1500));
+      }
+    }
+
+    // Sigue el job por Server-Sent Events (/jobs/{id}/events) mostrando cada
+    // paso y línea de salida en el panel de progreso. Si el navegador no
+    // soporta SSE o la conexión falla, vuelve a consultar por polling.
+    function followJob(jobId, onStep) {
+      currentJobId = jobId;
+      btnCancel.disabled = false;
+      btnCancel.style.display = 'inline-block';
+      const done = () => {
+        currentJobId = null;
+        btnCancel.style.display = 'none';
+      };
+      if (typeof EventSource === 'undefined') return waitJob(jobId, onStep).finally(done);
+      return new Promise((resolve, reject) => {
+        const es = new EventSource(`/jobs/${encodeURIComponent(jobId)}/events`);
+        let finished = false;
+        es.addEventListener('step', (ev) => {
+          appendLog(`==> ${ev.data}`, 'op-step');
+          if (onStep) onStep({ step: ev.data, state: 'running' });
+        });
+        es.addEventListener('log', (ev) => appendLog(ev.data));
+        es.addEventListener('done', (ev) => {
+          finished = true;
+          es.close();
+          const job = JSON.parse(ev.data);
+          if (job.state === 'succeeded') {
+            appendLog('OK', 'op-ok');
+            resolve(job.result);
+          } else {
+            appendLog('ERROR: ' + (job.error || 'job fallido'), 'op-error');
+            reject(new Error(job.error || 'job fallido'));
+          }
+        });
+        es.onerror = () => {
+          if (finished || es.readyState !== EventSource.CLOSED) return;
+          waitJob(jobId, onStep).then(resolve, reject);
+        };
+      }).finally(done);
+    }
 
     btnAccept.addEventListener('click', async () => {
       const h = hostname.value.trim();
@@ -22,9 +112,13 @@ const btnAccept = document.getElementById('btnAccept');
         form.append('hostname', h);
         messages.style.color = '#0b3a66';
         messages.textContent = `Preparando VM y DNS para ${h}...`;
+        resetLog();
         const res = await fetch('/prepare', { method: 'POST', body: form });
-        const data = await res.json();
-        if (!res.ok) throw new Error(data.error || ('HTTP '+res.status));
+        const accepted = await res.json();
+        if (!res.ok) throw new Error(apiErrorMessage(accepted, res.status));
+        const data = await followJob(accepted.job_id, (job) => {
+          messages.textContent = `Preparando VM y DNS para ${h}... (${job.step || job.state})`;
+        });
         messages.style.color = '#0b8a57';
         messages.textContent = `Preparación completa. IP: ${data.ip}. Ahora sube el ZIP y pulsa Publicar.`;
         setTimeout(() => {
@@ -61,13 +155,17 @@ const btnAccept = document.getElementById('btnAccept');
         form.append('file', file, file.name);
         messages.style.color = '#0b3a66';
         messages.textContent = `Enviando solicitud de aprovisionamiento para ${hostname.value}...`;
+        resetLog();
 
         const res = await fetch('/publish', {
           method: 'POST',
           body: form
         });
-        if (!res.ok) throw new Error('HTTP ' + res.status);
-        const data = await res.json();
+        const accepted = await res.json();
+        if (!res.ok) throw new Error(apiErrorMessage(accepted, res.status));
+        const data = await followJob(accepted.job_id, (job) => {
+          messages.textContent = `Publicando ${hostname.value}... (${job.step || job.state})`;
+        });
         messages.style.color = '#0b8a57';
         messages.textContent = `Sitio publicado: ${data.url}.`;
         // Actualizar registro de actividad después de publicar
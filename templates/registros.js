@@ -18,6 +18,9 @@ function canonicalUrl(item) {
   return fqdn ? `http://${fqdn}` : '';
 }
 
+// Estados con una operación en curso: no se pueden eliminar.
+const BUSY_STATES = ['preparing', 'publishing', 'destroying'];
+
 function createRow(item) {
   const tr = document.createElement('tr');
   tr.className = 'activity-row';
@@ -31,6 +34,11 @@ function createRow(item) {
   a.textContent = link || (item.url || '');
   a.className = 'activity-link';
   a.target = '_blank';
+  // Solo las instancias publicadas tienen un sitio al que enlazar
+  if (item.state && item.state !== 'running') {
+    a.removeAttribute('href');
+    a.classList.add('activity-link-disabled');
+  }
   tdLink.appendChild(a);
 
   // ip
@@ -43,6 +51,15 @@ function createRow(item) {
   tdHost.className = 'activity-cell';
   tdHost.textContent = item.host;
 
+  // state
+  const tdState = document.createElement('td');
+  tdState.className = 'activity-cell';
+  const badge = document.createElement('span');
+  badge.className = `state-badge state-${item.state || 'running'}`;
+  badge.textContent = item.state || 'running';
+  if (item.error) badge.title = item.error;
+  tdState.appendChild(badge);
+
   // created_at
   const tdCreated = document.createElement('td');
   tdCreated.className = 'activity-cell';
@@ -54,35 +71,43 @@ function createRow(item) {
   const btn = document.createElement('button');
   btn.className = 'btn-delete';
   btn.textContent = 'Eliminar';
+  btn.disabled = BUSY_STATES.includes(item.state);
   btn.onclick = (ev) => {
     ev.preventDefault();
     if (!confirm('¿Eliminar esta instancia?')) return;
-    // Llamada DELETE al backend
+    const refresh = () => {
+      loadAndRender(true);
+      loadDNSLogs();
+      if (typeof loadDNSDirect === 'function') loadDNSDirect();
+    };
+    // Llamada DELETE al backend: encola un job de eliminación (202)
     fetch(`/destroy/${encodeURIComponent(item.id)}`, { method: 'DELETE' })
-      .then(r => {
-        if (r.ok) {
-          loadAndRender(true);
-          loadDNSLogs();
-          if (typeof loadDNSDirect === 'function') loadDNSDirect();
-        } else {
+      .then(async r => {
+        if (!r.ok) {
           console.warn('DELETE failed, reloading file');
-          loadAndRender(true);
-          loadDNSLogs();
-          if (typeof loadDNSDirect === 'function') loadDNSDirect();
+          return;
         }
+        refresh(); // Muestra la instancia en destroying mientras corre el job
+        const body = await r.json();
+        await waitDestroyJob(body.job_id);
       })
-      .catch(err => {
-        console.warn('No hay backend DELETE, actualizando vista localmente', err);
-        loadAndRender(true);
-        loadDNSLogs();
-        if (typeof loadDNSDirect === 'function') loadDNSDirect();
-      });
+      .catch(err => console.warn('Error eliminando instancia', err))
+      .finally(refresh);
   };
   tdAction.appendChild(btn);
 
+  // logs de operaciones (descarga en texto plano)
+  const logs = document.createElement('a');
+  logs.className = 'btn-logs';
+  logs.textContent = 'Logs';
+  logs.href = `/instances/${encodeURIComponent(item.id)}/logs?format=text`;
+  logs.target = '_blank';
+  tdAction.appendChild(logs);
+
   tr.appendChild(tdLink);
   tr.appendChild(tdIp);
   tr.appendChild(tdHost);
+  tr.appendChild(tdState);
   tr.appendChild(tdCreated);
   tr.appendChild(tdAction);
 
@@ -137,6 +162,18 @@ async function loadAndRender(force=false) {
   }
 }
 
+// Consulta /jobs/{id} hasta que el job de eliminación termine.
+async function waitDestroyJob(jobId) {
+  while (true) {
+    const res = await fetch(`/jobs/${encodeURIComponent(jobId)}`, {cache: 'no-store'});
+    if (!res.ok) throw new Error('HTTP ' + res.status);
+    const job = await res.json();
+    if (job.state === 'succeeded') return;
+    if (job.state === 'failed' || job.state === 'canceled') throw new Error(job.error || 'job fallido');
+    await new Promise(r => setTimeout(r, POLL_INTERVAL_MS));
+  }
+}
+
 /* -------- DNS LOGS ------- */
 function formatTimestamp(iso) {
   if(!iso) return '';
@@ -171,7 +208,7 @@ function renderDNSLogs(logs) {
       <span style="color:#333;">${log.fqdn}</span>
       <span style="color:#666;">→</span>
       <span style="color:#1a73e8;">${log.ip}</span>
-      <span style="color:#999;margin-left:12px;font-size:0.8em;">${formatTimestamp(log.timestamp)}</span>
+      <span style="color:#999;margin-left:12px;font-size:0.8em;">${formatTimestamp(log.timestamp)}${log.actor ? ' · ' + log.actor : ''}</span>
     `;
     
     container.appendChild(div);
@@ -237,7 +274,8 @@ async function loadDNSDirect() {
     const res = await fetch('/dns-direct', { cache: 'no-store' });
     const data = await res.json();
     if (!res.ok || data.error) {
-      throw new Error(data.error || 'Error HTTP ' + res.status);
+      const e = data.error || {};
+      throw new Error(e.detail || e.message || 'Error HTTP ' + res.status);
     }
     renderDNSDirect(data);
   } catch (err) {
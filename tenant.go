@@ -0,0 +1,424 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ============================== Tenants & Quotas ============================
+
+// Cada usuario pertenece a un tenant (por defecto, uno con su mismo nombre) y
+// cada instancia al tenant de quien la preparó. Los usuarios ven y operan
+// solo las instancias de su tenant; los admin ven todas. Las cuotas limitan
+// lo que cada tenant puede crear y publicar.
+
+// Quota límites de un tenant. Los valores cero no limitan.
+type Quota struct {
+	MaxInstances int      `json:"max_instances"`        // Instancias en cualquier estado (cada una ocupa una IP)
+	MaxZipBytes  int64    `json:"max_zip_bytes"`        // Tamaño máximo del ZIP de /publish
+	Subdomains   []string `json:"subdomains,omitempty"` // Patrones del host sin la zona (ej: "equipo1-*"); vacío = todos
+}
+
+// TenantQuota cuota configurada para un tenant en tenantsPath.
+type TenantQuota struct {
+	Tenant string `json:"tenant"`
+	Quota
+}
+
+// tenantStatus es un elemento de la respuesta de GET /tenants.
+type tenantStatus struct {
+	Tenant    string   `json:"tenant"`
+	Quota     Quota    `json:"quota"`     // Cuota vigente
+	Custom    bool     `json:"custom"`    // false si se aplica defaultQuota
+	Instances int      `json:"instances"` // Instancias del tenant
+	Users     []string `json:"users"`     // Usuarios del tenant
+}
+
+// Errores de tenants y cuotas.
+var (
+	errQuotaExceeded = errors.New("cuota del tenant excedida")
+	errHostForbidden = errors.New("el tenant no admite ese nombre de host")
+	errZipTooLarge   = errors.New("el ZIP supera el máximo del tenant")
+)
+
+var (
+	muTenants    sync.Mutex    // Protege tenantQuotas
+	tenantQuotas []TenantQuota // Cuotas configuradas (el resto usa defaultQuota)
+)
+
+// tenantOf retorna el tenant del usuario.
+func (u User) tenantOf() string {
+	if u.Tenant != "" {
+		return u.Tenant
+	}
+	return u.Username
+}
+
+// tenantFrom retorna el tenant del usuario de la petición, o "" si no hay un
+// usuario autenticado (autenticación deshabilitada o tareas del servidor).
+func tenantFrom(ctx context.Context) string {
+	u, ok := currentUser(ctx)
+	if !ok || !authEnabled {
+		return ""
+	}
+	return u.tenantOf()
+}
+
+// seesAll indica si el usuario de la petición puede ver las instancias de
+// todos los tenants: los admin y las peticiones sin usuario autenticado.
+func seesAll(ctx context.Context) bool {
+	u, ok := currentUser(ctx)
+	return !ok || !authEnabled || u.Role == roleAdmin
+}
+
+// canAccess indica si el usuario de la petición puede ver y operar una
+// instancia o job del tenant indicado.
+func canAccess(ctx context.Context, tenant string) bool {
+	return seesAll(ctx) || tenant == tenantFrom(ctx)
+}
+
+// ownedBy restringe match a las instancias que el usuario de ctx puede
+// operar: las de otros tenants se tratan como inexistentes, para no revelar
+// qué hosts tienen los demás.
+func ownedBy(ctx context.Context, match instanceMatcher) instanceMatcher {
+	return func(tx InstanceTx) (Instance, bool, error) {
+		it, ok, err := match(tx)
+		if err != nil || !ok || canAccess(ctx, it.Tenant) {
+			return it, ok, err
+		}
+		return Instance{}, false, nil
+	}
+}
+
+// tenantScope son los hosts e instancias que el usuario de una petición
+// puede ver en los informes que mezclan datos de todos los tenants (IPAM,
+// zona DNS, historial DNS, drift).
+type tenantScope struct {
+	all   bool            // Admin o sin autenticación: ve todo
+	hosts map[string]bool // FQDN de sus instancias, en minúsculas
+	ids   map[string]bool // IDs de sus instancias
+}
+
+// scopeFor arma el tenantScope del usuario de ctx.
+func scopeFor(ctx context.Context) (tenantScope, error) {
+	if seesAll(ctx) {
+		return tenantScope{all: true}, nil
+	}
+	list, err := store.ListInstances()
+	if err != nil {
+		return tenantScope{}, err
+	}
+	s := tenantScope{hosts: map[string]bool{}, ids: map[string]bool{}}
+	for _, it := range list {
+		if canAccess(ctx, it.Tenant) {
+			s.hosts[strings.ToLower(it.Host)] = true
+			s.ids[it.ID] = true
+		}
+	}
+	return s, nil
+}
+
+// host indica si el FQDN es de una instancia visible.
+func (s tenantScope) host(fqdn string) bool {
+	return s.all || s.hosts[strings.ToLower(strings.TrimSuffix(fqdn, "."))]
+}
+
+// instance indica si la instancia con ese ID es visible.
+func (s tenantScope) instance(id string) bool {
+	return s.all || (id != "" && s.ids[id])
+}
+
+// loadTenants carga las cuotas de tenantsPath; si no existe se usa
+// defaultQuota para todos los tenants.
+func loadTenants() error {
+	b, err := os.ReadFile(tenantsPath)
+	if os.IsNotExist(err) {
+		return checkQuarantined(tenantsPath)
+	}
+	if err != nil {
+		return err
+	}
+	var list []TenantQuota
+	if err := json.Unmarshal(b, &list); err != nil {
+		return markCorrupt(tenantsPath, err, true)
+	}
+	tenantQuotas = list
+	return nil
+}
+
+// saveTenantsLocked guarda tenantsPath usando escritura atómica. Debe
+// llamarse con muTenants tomado.
+func saveTenantsLocked() error {
+	if err := checkWritable(); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(tenantQuotas, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := tenantsPath + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, tenantsPath)
+}
+
+// quotaFor retorna la cuota vigente del tenant y si está configurada. Sin
+// tenant (autenticación deshabilitada) no hay límites.
+func quotaFor(tenant string) (Quota, bool) {
+	if tenant == "" {
+		return Quota{}, false
+	}
+	muTenants.Lock()
+	defer muTenants.Unlock()
+	if i := slices.IndexFunc(tenantQuotas, func(t TenantQuota) bool { return t.Tenant == tenant }); i >= 0 {
+		return tenantQuotas[i].Quota, true
+	}
+	return defaultQuota, false
+}
+
+// checkHostAllowed verifica que el nombre del host sin la zona (ej: "web1" o
+// "web1.equipo1") cumpla alguno de los patrones de la cuota del tenant. Se
+// compara etiqueta por etiqueta: "*" no cruza puntos, así "equipo1-*" no
+// permite "equipo1-x.otro" ni "otro.equipo1-x".
+func checkHostAllowed(tenant, fqdn string) error {
+	q, _ := quotaFor(tenant)
+	if len(q.Subdomains) == 0 {
+		return nil
+	}
+	name := strings.TrimSuffix(strings.ToLower(fqdn), "."+strings.ToLower(dnsZone))
+	for _, p := range q.Subdomains {
+		if ok, _ := path.Match(strings.ReplaceAll(strings.ToLower(p), ".", "/"), strings.ReplaceAll(name, ".", "/")); ok {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s (permitidos: %s)", errHostForbidden, name, strings.Join(q.Subdomains, ", "))
+}
+
+// checkInstanceQuota verifica dentro de la transacción que el tenant pueda
+// registrar una instancia más.
+func checkInstanceQuota(tx InstanceTx, tenant string) error {
+	q, _ := quotaFor(tenant)
+	if q.MaxInstances <= 0 {
+		return nil
+	}
+	list, err := tx.List()
+	if err != nil {
+		return err
+	}
+	n := 0
+	for _, it := range list {
+		if it.Tenant == tenant {
+			n++
+		}
+	}
+	if n >= q.MaxInstances {
+		return fmt.Errorf("%w: %s tiene %d de %d instancias", errQuotaExceeded, tenant, n, q.MaxInstances)
+	}
+	return nil
+}
+
+// checkZipQuota verifica el tamaño del ZIP que publica el tenant.
+func checkZipQuota(tenant string, size int64) error {
+	q, _ := quotaFor(tenant)
+	if q.MaxZipBytes > 0 && size > q.MaxZipBytes {
+		return fmt.Errorf("%w: pesa %d bytes y el máximo de %s es %d", errZipTooLarge, size, tenant, q.MaxZipBytes)
+	}
+	return nil
+}
+
+// listTenants arma el estado de todos los tenants conocidos: los de los
+// usuarios, los de las instancias y los que tienen cuota configurada.
+func listTenants() ([]tenantStatus, error) {
+	list, err := store.ListInstances()
+	if err != nil {
+		return nil, err
+	}
+	byName := map[string]*tenantStatus{}
+	get := func(name string) *tenantStatus {
+		if t, ok := byName[name]; ok {
+			return t
+		}
+		t := &tenantStatus{Tenant: name, Users: []string{}}
+		t.Quota, t.Custom = quotaFor(name)
+		byName[name] = t
+		return t
+	}
+	muUsers.Lock()
+	for _, u := range users {
+		t := get(u.tenantOf())
+		t.Users = append(t.Users, u.Username)
+	}
+	muUsers.Unlock()
+	for _, it := range list {
+		if it.Tenant != "" {
+			get(it.Tenant).Instances++
+		}
+	}
+	muTenants.Lock()
+	names := make([]string, 0, len(tenantQuotas))
+	for _, t := range tenantQuotas {
+		names = append(names, t.Tenant)
+	}
+	muTenants.Unlock()
+	for _, n := range names {
+		get(n)
+	}
+	out := make([]tenantStatus, 0, len(byName))
+	for _, t := range byName {
+		out = append(out, *t)
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Tenant < out[j].Tenant })
+	return out, nil
+}
+
+// setTenantQuota configura la cuota del tenant (o vuelve a defaultQuota si
+// q es nil).
+func setTenantQuota(tenant string, q *Quota) error {
+	muTenants.Lock()
+	defer muTenants.Unlock()
+	prev := slices.Clone(tenantQuotas)
+	i := slices.IndexFunc(tenantQuotas, func(t TenantQuota) bool { return t.Tenant == tenant })
+	switch {
+	case q == nil && i < 0:
+		return nil
+	case q == nil:
+		tenantQuotas = slices.Delete(tenantQuotas, i, i+1)
+	case i < 0:
+		tenantQuotas = append(tenantQuotas, TenantQuota{Tenant: tenant, Quota: *q})
+	default:
+		tenantQuotas[i].Quota = *q
+	}
+	if err := saveTenantsLocked(); err != nil {
+		tenantQuotas = prev
+		return err
+	}
+	return nil
+}
+
+// validateQuota verifica los valores y los patrones de q.
+func validateQuota(q Quota) error {
+	if q.MaxInstances < 0 || q.MaxZipBytes < 0 {
+		return errors.New("los límites no pueden ser negativos")
+	}
+	for _, p := range q.Subdomains {
+		if _, err := path.Match(p, ""); err != nil || p == "" {
+			return fmt.Errorf("patrón de host inválido %q", p)
+		}
+	}
+	return nil
+}
+
+// handleTenants maneja GET /tenants (todos los tenants para un admin; el
+// propio para el resto), PUT /tenants/{name} con una Quota para
+// configurarla y DELETE /tenants/{name} para volver a la cuota por defecto.
+func handleTenants(w http.ResponseWriter, r *http.Request) {
+	name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/tenants"), "/")
+	switch {
+	case r.Method == http.MethodGet:
+		out, err := listTenants()
+		if err != nil {
+			writeAPIError(w, toAPIError(err, "leyendo instancias"))
+			return
+		}
+		if !seesAll(r.Context()) {
+			own := tenantFrom(r.Context())
+			out = slices.DeleteFunc(out, func(t tenantStatus) bool { return t.Tenant != own })
+		}
+		if name != "" {
+			out = slices.DeleteFunc(out, func(t tenantStatus) bool { return t.Tenant != name })
+			if len(out) == 0 {
+				writeError(w, codeNotFound, "tenant no encontrado")
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(out)
+	case r.Method == http.MethodPut && name != "":
+		var q Quota
+		if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+			writeError(w, codeBadRequest, "JSON inválido: "+err.Error())
+			return
+		}
+		if err := validateQuota(q); err != nil {
+			writeError(w, codeBadRequest, err.Error())
+			return
+		}
+		if err := setTenantQuota(name, &q); err != nil {
+			writeAPIError(w, toAPIError(err, "guardando cuota"))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(TenantQuota{Tenant: name, Quota: q})
+	case r.Method == http.MethodDelete && name != "":
+		if err := setTenantQuota(name, nil); err != nil {
+			writeAPIError(w, toAPIError(err, "guardando cuota"))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		writeError(w, codeMethodNotAllowed, "method not allowed")
+	}
+}
+
+// handleInstanceByID maneja GET /instances/{id} y PATCH /instances/{id}
+// con {"tenant": ...} (solo admin) para asignar la instancia a otro tenant,
+// por ejemplo las registradas antes de que existieran los tenants.
+func handleInstanceByID(w http.ResponseWriter, r *http.Request, id string) {
+	switch r.Method {
+	case http.MethodGet:
+		it, err := store.GetInstance(id)
+		if err == nil && !canAccess(r.Context(), it.Tenant) {
+			err = errInstanceMissing
+		}
+		if err != nil {
+			writeAPIError(w, toAPIError(err, ""))
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(it)
+	case http.MethodPatch:
+		var req struct {
+			Tenant string `json:"tenant"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			writeError(w, codeBadRequest, "JSON inválido: "+err.Error())
+			return
+		}
+		if !usernameRe.MatchString(req.Tenant) {
+			writeError(w, codeBadRequest, fmt.Sprintf("tenant inválido %q", req.Tenant))
+			return
+		}
+		var out Instance
+		err := store.UpdateInstances(func(tx InstanceTx) error {
+			it, ok, err := tx.Get(id)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return errInstanceMissing
+			}
+			it.Tenant = req.Tenant
+			out = it
+			return tx.Put(it)
+		})
+		if err != nil {
+			writeAPIError(w, toAPIError(err, ""))
+			return
+		}
+		auditTarget(r.Context(), out.Host, out.ID)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(out)
+	default:
+		writeError(w, codeMethodNotAllowed, "method not allowed")
+	}
+}
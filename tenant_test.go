@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+)
+
+// tenantTestEnv habilita la autenticación y usa solo las cuotas dadas
+// (sin límites por defecto) en la zona grid.lab.
+func tenantTestEnv(t *testing.T, quotas ...TenantQuota) {
+	t.Helper()
+	prevAuth, prevQuotas, prevDefault, prevZone := authEnabled, tenantQuotas, defaultQuota, dnsZone
+	authEnabled, tenantQuotas, defaultQuota, dnsZone = true, quotas, Quota{}, "grid.lab"
+	t.Cleanup(func() { authEnabled, tenantQuotas, defaultQuota, dnsZone = prevAuth, prevQuotas, prevDefault, prevZone })
+}
+
+// asUser retorna un contexto con el usuario autenticado (su tenant es su nombre).
+func asUser(username, role string) context.Context {
+	return context.WithValue(context.Background(), userKey{}, User{Username: username, Role: role})
+}
+
+func TestCheckHostAllowed(t *testing.T) {
+	tenantTestEnv(t,
+		TenantQuota{Tenant: "equipo1", Quota: Quota{Subdomains: []string{"equipo1-*", "*.Equipo1"}}},
+		TenantQuota{Tenant: "libre"},
+	)
+	for _, tc := range []struct {
+		tenant, fqdn string
+		ok           bool
+	}{
+		{"equipo1", "equipo1-web.grid.lab", true},
+		{"equipo1", "EQUIPO1-web.grid.lab", true},
+		{"equipo1", "web.equipo1.grid.lab", true},
+		{"equipo1", "web.grid.lab", false},
+		{"equipo1", "equipo1-x.otro.grid.lab", false}, // "*" no cruza puntos
+		{"equipo1", "a.b.equipo1.grid.lab", false},
+		{"equipo1", "otro.equipo1-x.grid.lab", false},
+		{"libre", "cualquiera.grid.lab", true},
+		{"", "web.grid.lab", true}, // Sin autenticación no hay cuotas
+	} {
+		err := checkHostAllowed(tc.tenant, tc.fqdn)
+		if tc.ok && err != nil || !tc.ok && !errors.Is(err, errHostForbidden) {
+			t.Errorf("checkHostAllowed(%q, %q) = %v; permitido = %v", tc.tenant, tc.fqdn, err, tc.ok)
+		}
+	}
+}
+
+func TestCheckZipQuota(t *testing.T) {
+	tenantTestEnv(t, TenantQuota{Tenant: "ana", Quota: Quota{MaxZipBytes: 1000}})
+	defaultQuota.MaxZipBytes = 10
+	for _, tc := range []struct {
+		tenant string
+		size   int64
+		ok     bool
+	}{
+		{"ana", 1000, true},
+		{"ana", 1001, false},
+		{"beto", 10, true}, // Cuota por defecto
+		{"beto", 11, false},
+		{"", 1 << 30, true},
+	} {
+		err := checkZipQuota(tc.tenant, tc.size)
+		if tc.ok && err != nil || !tc.ok && !errors.Is(err, errZipTooLarge) {
+			t.Errorf("checkZipQuota(%q, %d) = %v; permitido = %v", tc.tenant, tc.size, err, tc.ok)
+		}
+	}
+}
+
+func TestInstanceQuota(t *testing.T) {
+	lifecycleTestEnv(t)
+	tenantTestEnv(t, TenantQuota{Tenant: "ana", Quota: Quota{MaxInstances: 2}})
+	ana, beto := asUser("ana", roleDeployer), asUser("beto", roleDeployer)
+
+	for _, host := range []string{"web1.grid.lab", "web2.grid.lab"} {
+		if _, _, err := createInstance(ana, host); err != nil {
+			t.Fatalf("createInstance(%s) = %v", host, err)
+		}
+	}
+	_, _, err := createInstance(ana, "web3.grid.lab")
+	if !errors.Is(err, errQuotaExceeded) {
+		t.Fatalf("tercera instancia de ana = %v; quiero errQuotaExceeded", err)
+	}
+	if e := toAPIError(err, ""); e.Code != codeQuotaExceeded || e.Status != http.StatusForbidden {
+		t.Errorf("APIError = %s %d; quiero %s 403", e.Code, e.Status, codeQuotaExceeded)
+	}
+	// La cuota es por tenant: beto usa la cuota por defecto, sin límite
+	if _, _, err := createInstance(beto, "web3.grid.lab"); err != nil {
+		t.Errorf("instancia de beto = %v", err)
+	}
+}
+
+func TestTenantScoping(t *testing.T) {
+	lifecycleTestEnv(t)
+	tenantTestEnv(t)
+	prevJobs, prevJobsPath := jobs, jobsPath
+	jobsPath = filepath.Join(t.TempDir(), "jobs.json")
+	t.Cleanup(func() { jobs, jobsPath = prevJobs, prevJobsPath })
+	m, err := newJobManager(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobs = m
+
+	ana, beto, admin := asUser("ana", roleDeployer), asUser("beto", roleDeployer), asUser("root", roleAdmin)
+	anaInst, _, err := createInstance(ana, "web1.grid.lab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	betoInst, _, err := createInstance(beto, "web2.grid.lab")
+	if err != nil {
+		t.Fatal(err)
+	}
+	task := func(context.Context, func(string)) (any, error) { return nil, nil }
+	anaJob, err := jobs.Submit(ana, "prepare", anaInst.Host, task, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	betoJob, err := jobs.Submit(beto, "prepare", betoInst.Host, task, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// get ejecuta h como el usuario de ctx y decodifica la respuesta en out
+	get := func(ctx context.Context, h http.HandlerFunc, path string, out any) int {
+		w := httptest.NewRecorder()
+		h(w, httptest.NewRequest(http.MethodGet, path, nil).WithContext(ctx))
+		if out != nil && w.Code == http.StatusOK {
+			if err := json.NewDecoder(w.Body).Decode(out); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return w.Code
+	}
+	for _, tc := range []struct {
+		name      string
+		ctx       context.Context
+		instances []string // IDs visibles, en orden
+		jobs      []string
+	}{
+		{"ana", ana, []string{anaInst.ID}, []string{anaJob.ID}},
+		{"beto", beto, []string{betoInst.ID}, []string{betoJob.ID}},
+		{"admin", admin, []string{anaInst.ID, betoInst.ID}, []string{anaJob.ID, betoJob.ID}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var insts []Instance
+			get(tc.ctx, handleInstances, "/instances", &insts)
+			if len(insts) != len(tc.instances) {
+				t.Fatalf("GET /instances = %+v; quiero %v", insts, tc.instances)
+			}
+			for i, id := range tc.instances {
+				if insts[i].ID != id {
+					t.Errorf("GET /instances[%d] = %s; quiero %s", i, insts[i].ID, id)
+				}
+			}
+			var js []Job
+			get(tc.ctx, handleJobs, "/jobs", &js)
+			if len(js) != len(tc.jobs) {
+				t.Errorf("GET /jobs = %d jobs; quiero %v", len(js), tc.jobs)
+			}
+			for _, j := range js {
+				if !canAccess(tc.ctx, j.Tenant) {
+					t.Errorf("GET /jobs incluye %s del tenant %s", j.ID, j.Tenant)
+				}
+			}
+
+			scope, err := scopeFor(tc.ctx)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, inst := range []Instance{anaInst, betoInst} {
+				visible := canAccess(tc.ctx, inst.Tenant)
+				if scope.host(inst.Host) != visible || scope.instance(inst.ID) != visible {
+					t.Errorf("scopeFor: %s visible = %v/%v; quiero %v", inst.Host, scope.host(inst.Host), scope.instance(inst.ID), visible)
+				}
+				want := http.StatusOK
+				if !visible {
+					want = http.StatusNotFound
+				}
+				if code := get(tc.ctx, handleInstance, "/instances/"+inst.ID, nil); code != want {
+					t.Errorf("GET /instances/%s = %d; quiero %d", inst.ID, code, want)
+				}
+			}
+			for _, j := range []Job{anaJob, betoJob} {
+				want := http.StatusOK
+				if !canAccess(tc.ctx, j.Tenant) {
+					want = http.StatusNotFound
+				}
+				if code := get(tc.ctx, handleJob, "/jobs/"+j.ID, nil); code != want {
+					t.Errorf("GET /jobs/%s = %d; quiero %d", j.ID, code, want)
+				}
+			}
+		})
+	}
+}
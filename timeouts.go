@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+// ============================== Step Timeouts ===============================
+
+// waitDelay tiempo que se espera a que un comando cancelado cierre su salida
+// antes de abandonarlo.
+const waitDelay = 5 * time.Second
+
+// stepTimeout retorna el tiempo máximo configurado para el paso, o 0 si no tiene.
+func stepTimeout(step string) time.Duration {
+	return stepTimeouts[step]
+}
+
+// stepContext deriva de ctx un contexto con el timeout del paso.
+func stepContext(ctx context.Context, step string) (context.Context, context.CancelFunc) {
+	if d := stepTimeout(step); d > 0 {
+		return context.WithTimeout(ctx, d)
+	}
+	return context.WithCancel(ctx)
+}
+
+// stepErr reemplaza err por la causa de la interrupción si el paso fue
+// cancelado (ctx) o excedió su timeout (sctx). Si no, retorna err sin cambios.
+func stepErr(ctx, sctx context.Context, step string, err error) error {
+	if err == nil || sctx.Err() == nil {
+		return err
+	}
+	if ctx.Err() != nil {
+		return fmt.Errorf("%s: %w", step, ctx.Err())
+	}
+	return fmt.Errorf("%s excedió %s: %w", step, stepTimeout(step), context.DeadlineExceeded)
+}
+
+// commandContext crea un comando ligado a ctx que, al cancelarse, mata todo
+// su grupo de procesos (ej: el .bat y los VBoxManage/ssh que lanzó).
+func commandContext(ctx context.Context, name string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
+	setProcessGroup(cmd)
+	cmd.WaitDelay = waitDelay
+	return cmd
+}
+
+// sleepCtx espera d o hasta que ctx se cancele; en ese caso retorna ctx.Err().
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
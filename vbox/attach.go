@@ -0,0 +1,176 @@
+package vbox
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+)
+
+// AttachOptions parámetros del adjuntado de un disco multiattach.
+type AttachOptions struct {
+	Disk       string // Ruta al .vdi o medio registrado (debe ser multiattach)
+	VM         string // Nombre de la VM destino
+	Controller string // Nombre del controlador (ej: SATA)
+	Device     int    // Dispositivo dentro del puerto (normalmente 0)
+	Force      bool   // Permite adjuntar con la VM en ejecución
+}
+
+// AttachResult describe dónde quedó adjuntado el disco.
+type AttachResult struct {
+	Port       int    // Puerto libre elegido
+	Device     int    // Dispositivo usado
+	DiskUUID   string // UUID del disco
+	Workaround bool   // true si se aplicó el workaround de VirtualBox 7.2.0
+}
+
+// AttachMultiattach adjunta un disco multiattach a la VM siguiendo los mismos
+// pasos y validaciones que UnirMaquinaDisco.bat. Los fallos se reportan con
+// los sentinelas ErrVMNotFound, ErrControllerNotFound, ErrDiskNotFound,
+// ErrNotMultiattach, ErrVMRunning, ErrNoFreePort, ErrDuplicateDisk y
+// ErrAttachFailed.
+func (m *Manager) AttachMultiattach(ctx context.Context, opt AttachOptions) (AttachResult, error) {
+	res := AttachResult{Device: opt.Device}
+	if opt.Disk == "" || opt.VM == "" || opt.Controller == "" {
+		return res, &Error{Code: ExitNoVBoxManage, Detail: "disco, VM y controlador son requeridos"}
+	}
+
+	m.logf("[1/10] Verificando VBoxManage...")
+	if err := m.CheckInstalled(); err != nil {
+		return res, err
+	}
+
+	m.logf("[2/10] Verificando existencia de la VM %q...", opt.VM)
+	info, err := m.VMInfo(ctx, opt.VM)
+	if err != nil {
+		return res, err
+	}
+
+	m.logf("[3/10] Verificando controlador %q...", opt.Controller)
+	ctrl, ok := info.Controller(opt.Controller)
+	if !ok {
+		names := make([]string, 0)
+		for _, c := range info.Controllers() {
+			names = append(names, c.Name)
+		}
+		return res, &Error{Code: ExitControllerNotFound,
+			Detail: opt.Controller + " (disponibles: " + strings.Join(names, ", ") + ")"}
+	}
+	m.logf("OK: Controlador encontrado (index: %d, tipo: %s, puertos: %d)", ctrl.Index, ctrl.Type, ctrl.PortCount)
+	if ctrl.Type == "PIIX3" || ctrl.Type == "PIIX4" {
+		m.logf("   ADVERTENCIA: Controlador IDE %s puede tener limitaciones con multiattach", ctrl.Type)
+	}
+
+	m.logf("[4/10] Verificando disco virtual...")
+	if _, statErr := os.Stat(opt.Disk); statErr != nil {
+		if _, err := m.MediumInfo(ctx, opt.Disk); errors.Is(err, ErrDiskNotFound) {
+			return res, &Error{Code: ExitDiskNotFound, Detail: opt.Disk, Err: statErr}
+		} else if err != nil {
+			return res, err
+		}
+	}
+
+	m.logf("[5/10] Verificando tipo de disco (debe ser multiattach)...")
+	medium, err := m.MediumInfo(ctx, opt.Disk)
+	if err != nil {
+		return res, err
+	}
+	if medium.Type != "multiattach" {
+		return res, &Error{Code: ExitNotMultiattach,
+			Detail: "tipo actual " + medium.Type + "; convertir con: VBoxManage modifymedium disk \"" + opt.Disk + "\" --type multiattach"}
+	}
+	res.DiskUUID = medium.UUID
+
+	m.logf("[6/10] Verificando estado de la VM...")
+	if info.State() == "running" {
+		if !opt.Force {
+			return res, &Error{Code: ExitVMRunning, Detail: opt.VM}
+		}
+		m.logf("   ADVERTENCIA: VM en ejecución, modo force activado")
+	}
+
+	m.logf("[7/10] Buscando puerto libre en el controlador...")
+	port, err := freePort(info, ctrl, opt.Device)
+	if err != nil {
+		return res, err
+	}
+	res.Port = port
+	m.logf("OK: Puerto libre encontrado: %d", port)
+
+	m.logf("[8/10] Verificando duplicados (por UUID)...")
+	if dup, err := m.isAttached(ctx, info, ctrl.Name, medium.UUID); err != nil {
+		return res, err
+	} else if dup {
+		return res, &Error{Code: ExitDuplicateDisk, Detail: "UUID " + medium.UUID}
+	}
+
+	m.logf("[9/10] Intentando adjunción directa...")
+	if err := m.StorageAttach(ctx, opt.VM, ctrl.Name, port, opt.Device, opt.Disk, "multiattach"); err == nil {
+		m.logf("OK: Adjunción directa exitosa.")
+		return res, nil
+	}
+
+	// Algunas versiones de VirtualBox 7.2.0 fallan al adjuntar discos multiattach:
+	// se convierte temporalmente a normal, se adjunta y se convierte de vuelta.
+	m.logf("[10/10] Aplicando workaround para VirtualBox 7.2.0...")
+	res.Workaround = true
+	if err := m.SetMediumType(ctx, opt.Disk, "normal"); err != nil {
+		m.logf("   ADVERTENCIA: No se pudo convertir a normal, continuando...")
+	}
+	if err := m.StorageAttach(ctx, opt.VM, ctrl.Name, port, opt.Device, opt.Disk, ""); err != nil {
+		return res, &Error{Code: ExitAttachFailed, Detail: opt.VM, Err: err}
+	}
+	if err := m.SetMediumType(ctx, opt.Disk, "multiattach"); err != nil {
+		m.logf("   ADVERTENCIA: No se pudo convertir de vuelta a multiattach")
+	}
+	return res, nil
+}
+
+// freePort busca el primer puerto del controlador sin medio conectado.
+// "none" y "emptydrive" se consideran libres.
+func freePort(info Info, ctrl Controller, device int) (int, error) {
+	max := ctrl.PortCount
+	if max <= 0 {
+		max = DefaultMaxPorts
+	}
+	for p := 0; p < max; p++ {
+		v := info.Attachment(ctrl.Name, p, device)
+		if v == "" || v == "none" || v == "emptydrive" {
+			return p, nil
+		}
+	}
+	return -1, &Error{Code: ExitNoFreePort, Detail: ctrl.Name}
+}
+
+// isAttached indica si el disco con uuid ya está conectado al controlador.
+// Usa las claves ImageUUID de la VM y, si no existen, consulta cada .vdi conectado.
+func (m *Manager) isAttached(ctx context.Context, info Info, controller, uuid string) (bool, error) {
+	if uuid == "" {
+		return false, nil
+	}
+	uuids := info.AttachedUUIDs(controller)
+	if len(uuids) > 0 {
+		for _, u := range uuids {
+			if strings.EqualFold(u, uuid) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	for k, v := range info {
+		if !strings.HasPrefix(k, controller+"-") || !strings.HasSuffix(strings.ToLower(v), ".vdi") {
+			continue
+		}
+		med, err := m.MediumInfo(ctx, v)
+		if err != nil {
+			if errors.Is(err, ErrDiskNotFound) {
+				continue
+			}
+			return false, err
+		}
+		if strings.EqualFold(med.UUID, uuid) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,80 @@
+package vbox
+
+import (
+	"context"
+	"strings"
+)
+
+// DefaultHostOnlyNetwork red DHCP de la interfaz host-only por defecto en Windows.
+const DefaultHostOnlyNetwork = "HostInterfaceNetworking-VirtualBox Host-Only Ethernet Adapter"
+
+// Reservation es una reserva DHCP MAC -> IP del servidor DHCP de VirtualBox.
+type Reservation struct {
+	MAC string `json:"mac"` // MAC en formato 08:00:27:64:FE:5B
+	IP  string `json:"ip"`  // Dirección fija asignada
+}
+
+// SetDHCPReservation reserva ip para la MAC en la red indicada
+// (equivale a configurarIPs.bat).
+func (m *Manager) SetDHCPReservation(ctx context.Context, network, mac, ip string) error {
+	_, err := m.run(ctx, "dhcpserver", "modify", "--network="+network,
+		"--mac-address="+mac, "--fixed-address="+ip)
+	return err
+}
+
+// RemoveDHCPReservation elimina la reserva de la MAC en la red indicada.
+func (m *Manager) RemoveDHCPReservation(ctx context.Context, network, mac string) error {
+	_, err := m.run(ctx, "dhcpserver", "modify", "--network="+network,
+		"--mac-address="+mac, "--remove")
+	return err
+}
+
+// RestartDHCP reinicia el servidor DHCP de la red para aplicar cambios.
+func (m *Manager) RestartDHCP(ctx context.Context, network string) error {
+	_, err := m.run(ctx, "dhcpserver", "restart", "--network="+network)
+	return err
+}
+
+// DHCPReservations lista las reservas fijas de la red, leyendo la salida de
+// "VBoxManage list dhcpservers". Cada bloque de configuración por MAC incluye
+// una línea "Fixed Address:".
+func (m *Manager) DHCPReservations(ctx context.Context, network string) ([]Reservation, error) {
+	out, err := m.run(ctx, "list", "dhcpservers")
+	if err != nil {
+		return nil, err
+	}
+	return parseDHCPServers(out, network), nil
+}
+
+// parseDHCPServers extrae las reservas de la red indicada de la salida de
+// "list dhcpservers".
+func parseDHCPServers(out, network string) []Reservation {
+	var (
+		res    []Reservation
+		inNet  bool
+		curMAC string
+	)
+	for _, ln := range strings.Split(out, "\n") {
+		key, val, ok := strings.Cut(strings.TrimSpace(strings.TrimRight(ln, "\r")), ":")
+		if !ok {
+			continue
+		}
+		key = strings.TrimSpace(key)
+		val = strings.TrimSpace(val)
+		switch {
+		case key == "NetworkName":
+			inNet = val == network
+			curMAC = ""
+		case !inNet:
+			continue
+		case key == "Config" && strings.HasPrefix(val, "MAC"):
+			// Ej: "Config:  MAC 08:00:27:64:fe:5b"
+			curMAC = strings.ToUpper(strings.TrimSpace(strings.TrimPrefix(val, "MAC")))
+		case key == "Config":
+			curMAC = ""
+		case key == "Fixed Address" && curMAC != "":
+			res = append(res, Reservation{MAC: curMAC, IP: val})
+		}
+	}
+	return res
+}
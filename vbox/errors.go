@@ -0,0 +1,135 @@
+package vbox
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ExitCode replica los códigos de salida documentados en UnirMaquinaDisco.bat.
+type ExitCode int
+
+// Códigos de salida del adjuntado de discos multiattach.
+const (
+	ExitOK                 ExitCode = 0 // Éxito
+	ExitNoVBoxManage       ExitCode = 1 // Parámetros faltantes o VBoxManage no encontrado
+	ExitVMNotFound         ExitCode = 2 // Máquina virtual no existe
+	ExitControllerNotFound ExitCode = 3 // Controlador no encontrado
+	ExitDiskNotFound       ExitCode = 4 // Disco no encontrado
+	ExitNoFreePort         ExitCode = 5 // No hay puertos libres
+	ExitAttachFailed       ExitCode = 6 // Fallo al ejecutar storageattach
+	ExitNotMultiattach     ExitCode = 7 // Disco no es tipo multiattach
+	ExitVMRunning          ExitCode = 8 // VM en ejecución (requiere force)
+	ExitDuplicateDisk      ExitCode = 9 // Disco ya adjuntado (duplicado)
+)
+
+// exitText descripción de cada código de salida.
+var exitText = map[ExitCode]string{
+	ExitOK:                 "éxito",
+	ExitNoVBoxManage:       "VBoxManage no encontrado",
+	ExitVMNotFound:         "la máquina virtual no existe",
+	ExitControllerNotFound: "controlador no encontrado",
+	ExitDiskNotFound:       "disco no encontrado",
+	ExitNoFreePort:         "no hay puertos libres en el controlador",
+	ExitAttachFailed:       "fallo al ejecutar storageattach",
+	ExitNotMultiattach:     "el disco no es tipo multiattach",
+	ExitVMRunning:          "la VM está en ejecución",
+	ExitDuplicateDisk:      "el disco ya está adjuntado a la VM",
+}
+
+// String retorna la descripción del código de salida.
+func (c ExitCode) String() string {
+	if s, ok := exitText[c]; ok {
+		return s
+	}
+	return fmt.Sprintf("código %d", int(c))
+}
+
+// Error es un error tipado con el código de salida equivalente del script batch.
+// Dos *Error se consideran iguales para errors.Is si comparten Code, lo que
+// permite comparar contra los sentinelas Err*.
+type Error struct {
+	Code   ExitCode // Código de salida documentado
+	Detail string   // Detalle adicional (VM, controlador, disco, etc.)
+	Err    error    // Error subyacente, si existe
+}
+
+// Error implementa la interfaz error.
+func (e *Error) Error() string {
+	msg := e.Code.String()
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// Unwrap expone el error subyacente.
+func (e *Error) Unwrap() error { return e.Err }
+
+// Is compara por código de salida.
+func (e *Error) Is(target error) bool {
+	var t *Error
+	if !errors.As(target, &t) {
+		return false
+	}
+	return t.Code == e.Code
+}
+
+// Sentinelas para comparar con errors.Is.
+var (
+	ErrNoVBoxManage       = &Error{Code: ExitNoVBoxManage}
+	ErrVMNotFound         = &Error{Code: ExitVMNotFound}
+	ErrControllerNotFound = &Error{Code: ExitControllerNotFound}
+	ErrDiskNotFound       = &Error{Code: ExitDiskNotFound}
+	ErrNoFreePort         = &Error{Code: ExitNoFreePort}
+	ErrAttachFailed       = &Error{Code: ExitAttachFailed}
+	ErrNotMultiattach     = &Error{Code: ExitNotMultiattach}
+	ErrVMRunning          = &Error{Code: ExitVMRunning}
+	ErrDuplicateDisk      = &Error{Code: ExitDuplicateDisk}
+
+	// ErrVMExists indica que ya existe una VM registrada con el nombre pedido
+	// (equivale al exit 2 de crearVMyDNS.bat).
+	ErrVMExists = errors.New("la VM ya existe")
+	// ErrNoMAC indica que la VM no tiene MAC en el adaptador pedido.
+	ErrNoMAC = errors.New("no se pudo obtener la MAC de la VM")
+)
+
+// ExitCodeOf retorna el código de salida asociado a err, o ExitOK si err es nil.
+// Para errores que no son *Error retorna ExitAttachFailed como genérico.
+func ExitCodeOf(err error) ExitCode {
+	if err == nil {
+		return ExitOK
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return ExitAttachFailed
+}
+
+// CommandError describe un fallo al ejecutar VBoxManage.
+type CommandError struct {
+	Args     []string // Argumentos pasados a VBoxManage
+	ExitCode int      // Código de salida del proceso (-1 si no arrancó)
+	Stderr   string   // Salida de error capturada
+	Err      error    // Error original de os/exec
+}
+
+// Error implementa la interfaz error.
+func (e *CommandError) Error() string {
+	verb := ""
+	if len(e.Args) > 0 {
+		verb = e.Args[0]
+	}
+	msg := fmt.Sprintf("VBoxManage %s falló (exit %d)", verb, e.ExitCode)
+	if s := strings.TrimSpace(e.Stderr); s != "" {
+		msg += ": " + s
+	}
+	return msg
+}
+
+// Unwrap expone el error de os/exec.
+func (e *CommandError) Unwrap() error { return e.Err }
@@ -0,0 +1,176 @@
+package vbox
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Info es la salida de "VBoxManage showvminfo --machinereadable" como mapa
+// clave/valor, con las comillas ya removidas.
+type Info map[string]string
+
+// Controller describe un controlador de almacenamiento de la VM.
+type Controller struct {
+	Index     int    // Índice N de storagecontrollernameN
+	Name      string // Nombre (ej: SATA)
+	Type      string // Tipo (ej: IntelAhci, PIIX4)
+	PortCount int    // Puertos configurados (0 si no se informa)
+}
+
+// ParseMachineReadable parsea la salida --machinereadable de VBoxManage.
+// Cada línea tiene la forma clave=valor, donde clave y valor pueden venir
+// entre comillas (ej: "SATA-0-0"="C:\disco.vdi").
+func ParseMachineReadable(out string) Info {
+	info := make(Info)
+	for _, ln := range strings.Split(out, "\n") {
+		ln = strings.TrimRight(ln, "\r")
+		if ln == "" {
+			continue
+		}
+		key, val, ok := splitKeyValue(ln)
+		if !ok {
+			continue
+		}
+		info[key] = val
+	}
+	return info
+}
+
+// splitKeyValue separa una línea clave=valor respetando comillas en la clave.
+func splitKeyValue(ln string) (key, val string, ok bool) {
+	if strings.HasPrefix(ln, `"`) {
+		end := strings.Index(ln[1:], `"`)
+		if end < 0 {
+			return "", "", false
+		}
+		key = ln[1 : end+1]
+		rest := ln[end+2:]
+		if !strings.HasPrefix(rest, "=") {
+			return "", "", false
+		}
+		return key, unquote(rest[1:]), true
+	}
+	key, val, ok = strings.Cut(ln, "=")
+	if !ok {
+		return "", "", false
+	}
+	return key, unquote(val), true
+}
+
+// unquote quita las comillas dobles externas de un valor, si las tiene.
+func unquote(s string) string {
+	if len(s) >= 2 && strings.HasPrefix(s, `"`) && strings.HasSuffix(s, `"`) {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// State retorna el valor de VMState (running, poweroff, saved, ...).
+func (i Info) State() string { return i["VMState"] }
+
+// MAC retorna la MAC del adaptador nic (1..N) en formato 08:00:27:64:FE:5B.
+func (i Info) MAC(nic int) string {
+	return FormatMAC(i["macaddress"+strconv.Itoa(nic)])
+}
+
+// Controllers retorna los controladores de almacenamiento ordenados por índice.
+func (i Info) Controllers() []Controller {
+	var out []Controller
+	for k, v := range i {
+		idx, ok := strings.CutPrefix(k, "storagecontrollername")
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(idx)
+		if err != nil {
+			continue
+		}
+		c := Controller{Index: n, Name: v, Type: i["storagecontrollertype"+idx]}
+		c.PortCount, _ = strconv.Atoi(i["storagecontrollerportcount"+idx])
+		out = append(out, c)
+	}
+	sort.Slice(out, func(a, b int) bool { return out[a].Index < out[b].Index })
+	return out
+}
+
+// Controller busca un controlador por nombre (sin distinguir mayúsculas).
+func (i Info) Controller(name string) (Controller, bool) {
+	for _, c := range i.Controllers() {
+		if strings.EqualFold(c.Name, name) {
+			return c, true
+		}
+	}
+	return Controller{}, false
+}
+
+// Attachment retorna el medio conectado en controlador-puerto-dispositivo.
+// Un puerto sin medio reporta "none" o "emptydrive", o no aparece.
+func (i Info) Attachment(controller string, port, device int) string {
+	return i[controller+"-"+strconv.Itoa(port)+"-"+strconv.Itoa(device)]
+}
+
+// AttachedUUIDs retorna los UUID de las imágenes adjuntas en el controlador.
+func (i Info) AttachedUUIDs(controller string) []string {
+	var out []string
+	prefix := controller + "-ImageUUID-"
+	for k, v := range i {
+		if strings.HasPrefix(k, prefix) && v != "" {
+			out = append(out, v)
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+// FormatMAC convierte 08002764FE5B en 08:00:27:64:FE:5B.
+// Retorna "" si la entrada no tiene 12 dígitos hexadecimales.
+func FormatMAC(raw string) string {
+	raw = strings.ToUpper(strings.TrimSpace(raw))
+	if len(raw) != 12 {
+		return ""
+	}
+	for _, r := range raw {
+		if !strings.ContainsRune("0123456789ABCDEF", r) {
+			return ""
+		}
+	}
+	parts := make([]string, 0, 6)
+	for k := 0; k < 12; k += 2 {
+		parts = append(parts, raw[k:k+2])
+	}
+	return strings.Join(parts, ":")
+}
+
+// Medium es la información relevante de "VBoxManage showmediuminfo disk".
+type Medium struct {
+	UUID     string // UUID del medio
+	Type     string // normal, multiattach, immutable, ...
+	Location string // Ruta del archivo
+}
+
+// ParseMediumInfo parsea la salida "Clave: valor" de showmediuminfo.
+func ParseMediumInfo(out string) Medium {
+	var m Medium
+	for _, ln := range strings.Split(out, "\n") {
+		key, val, ok := strings.Cut(strings.TrimRight(ln, "\r"), ":")
+		if !ok {
+			continue
+		}
+		val = strings.TrimSpace(val)
+		switch strings.TrimSpace(key) {
+		case "UUID":
+			if m.UUID == "" {
+				m.UUID = val
+			}
+		case "Type":
+			// Ej: "multiattach" o "normal (base)"
+			if f := strings.Fields(val); len(f) > 0 {
+				m.Type = f[0]
+			}
+		case "Location":
+			m.Location = val
+		}
+	}
+	return m
+}
@@ -0,0 +1,289 @@
+// Package vbox controla VirtualBox invocando VBoxManage directamente.
+//
+// Reemplaza la lógica de crearVMyDNS.bat, UnirMaquinaDisco.bat,
+// configurarIPs.bat y get_mac.bat: crea y configura VMs, adjunta discos
+// multiattach (con el workaround de VirtualBox 7.2.0) y administra reservas
+// DHCP de la red host-only. Los fallos se reportan como *Error con los mismos
+// códigos de salida documentados en los scripts.
+//
+// El binario se puede reemplazar con Manager.Bin, lo que permite ejecutar el
+// paquete contra un VBoxManage falso en Linux.
+package vbox
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultMaxPorts puertos revisados cuando el controlador no informa portcount
+// (máximo de un controlador SATA).
+const DefaultMaxPorts = 30
+
+// Manager ejecuta comandos VBoxManage.
+type Manager struct {
+	Bin   string           // Ruta o nombre del ejecutable (por defecto "VBoxManage")
+	Out   io.Writer        // Destino de los mensajes de progreso (nil = descartar)
+	Trace func(Invocation) // Si no es nil, recibe cada invocación de VBoxManage
+
+	// Timeout tiempo máximo de cada invocación de VBoxManage (0 = sin límite).
+	Timeout time.Duration
+}
+
+// waitDelay tiempo que se espera a que un VBoxManage cancelado cierre su
+// salida antes de abandonarlo.
+const waitDelay = 5 * time.Second
+
+// Invocation describe una ejecución de VBoxManage ya terminada.
+type Invocation struct {
+	Args     []string      // Argumentos pasados a VBoxManage
+	Start    time.Time     // Inicio de la ejecución
+	Duration time.Duration // Duración
+	ExitCode int           // Código de salida (-1 si no arrancó)
+	Stdout   string        // Salida estándar
+	Stderr   string        // Salida de error
+	Err      error         // Error de ejecución, si lo hubo
+}
+
+// New crea un Manager usando VBOXMANAGE del entorno o "VBoxManage" del PATH.
+func New() *Manager {
+	bin := os.Getenv("VBOXMANAGE")
+	if bin == "" {
+		bin = "VBoxManage"
+	}
+	return &Manager{Bin: bin}
+}
+
+// logf escribe una línea de progreso en Out.
+func (m *Manager) logf(format string, args ...any) {
+	if m.Out == nil {
+		return
+	}
+	fmt.Fprintf(m.Out, format+"\n", args...)
+}
+
+// run ejecuta VBoxManage con args y retorna su stdout. Cancelar ctx
+// interrumpe el comando.
+// Un fallo se reporta como *CommandError con stderr y código de salida.
+func (m *Manager) run(ctx context.Context, args ...string) (string, error) {
+	if m.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, m.Timeout)
+		defer cancel()
+	}
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, m.Bin, args...)
+	cmd.WaitDelay = waitDelay
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	start := time.Now()
+	err := cmd.Run()
+	if err != nil && ctx.Err() != nil {
+		err = fmt.Errorf("VBoxManage %s: %w", strings.Join(args, " "), ctx.Err())
+	}
+	code := 0
+	if err != nil {
+		code = -1
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			code = exitErr.ExitCode()
+		}
+	}
+	if m.Trace != nil {
+		m.Trace(Invocation{
+			Args:     args,
+			Start:    start,
+			Duration: time.Since(start),
+			ExitCode: code,
+			Stdout:   stdout.String(),
+			Stderr:   stderr.String(),
+			Err:      err,
+		})
+	}
+	if err != nil {
+		if errors.Is(err, exec.ErrNotFound) {
+			return "", &Error{Code: ExitNoVBoxManage, Detail: m.Bin, Err: err}
+		}
+		return stdout.String(), &CommandError{Args: args, ExitCode: code, Stderr: stderr.String(), Err: err}
+	}
+	return stdout.String(), nil
+}
+
+// CheckInstalled verifica que VBoxManage esté disponible.
+func (m *Manager) CheckInstalled() error {
+	if _, err := exec.LookPath(m.Bin); err != nil {
+		return &Error{Code: ExitNoVBoxManage, Detail: m.Bin, Err: err}
+	}
+	return nil
+}
+
+// VMInfo retorna la información --machinereadable de la VM. Solo reporta
+// ErrVMNotFound si VBoxManage indica que la VM no está registrada; otros
+// fallos (ej: VM bloqueada, servicio VBoxSVC caído) quedan como *CommandError.
+func (m *Manager) VMInfo(ctx context.Context, name string) (Info, error) {
+	out, err := m.run(ctx, "showvminfo", name, "--machinereadable")
+	if err != nil {
+		var cmdErr *CommandError
+		if errors.As(err, &cmdErr) && vmNotFound(cmdErr.Stderr) {
+			return nil, &Error{Code: ExitVMNotFound, Detail: name, Err: err}
+		}
+		return nil, err
+	}
+	return ParseMachineReadable(out), nil
+}
+
+// vmNotFound indica si stderr de VBoxManage corresponde a una VM no registrada.
+func vmNotFound(stderr string) bool {
+	return strings.Contains(stderr, "VBOX_E_OBJECT_NOT_FOUND") ||
+		strings.Contains(stderr, "Could not find a registered machine")
+}
+
+// VMExists indica si hay una VM registrada con ese nombre.
+func (m *Manager) VMExists(ctx context.Context, name string) (bool, error) {
+	_, err := m.VMInfo(ctx, name)
+	if errors.Is(err, ErrVMNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// ListVMs retorna los nombres de las VMs registradas.
+func (m *Manager) ListVMs(ctx context.Context) ([]string, error) {
+	out, err := m.run(ctx, "list", "vms")
+	if err != nil {
+		return nil, err
+	}
+	return parseVMList(out), nil
+}
+
+// ListRunningVMs retorna los nombres de las VMs en ejecución.
+func (m *Manager) ListRunningVMs(ctx context.Context) ([]string, error) {
+	out, err := m.run(ctx, "list", "runningvms")
+	if err != nil {
+		return nil, err
+	}
+	return parseVMList(out), nil
+}
+
+// parseVMList extrae los nombres de la salida de "list vms" o "list runningvms".
+func parseVMList(out string) []string {
+	var names []string
+	for _, ln := range strings.Split(out, "\n") {
+		// Formato: "nombre" {uuid}
+		ln = strings.TrimSpace(ln)
+		if !strings.HasPrefix(ln, `"`) {
+			continue
+		}
+		if end := strings.Index(ln[1:], `"`); end >= 0 {
+			names = append(names, ln[1:end+1])
+		}
+	}
+	return names
+}
+
+// CreateVM crea y registra una VM vacía. Retorna ErrVMExists si ya existe.
+func (m *Manager) CreateVM(ctx context.Context, name, osType string) error {
+	exists, err := m.VMExists(ctx, name)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("%w: %s", ErrVMExists, name)
+	}
+	_, err = m.run(ctx, "createvm", "--name", name, "--ostype", osType, "--register")
+	return err
+}
+
+// ModifyVM aplica opciones de "VBoxManage modifyvm" (ej: "--memory", "1024").
+func (m *Manager) ModifyVM(ctx context.Context, name string, opts ...string) error {
+	_, err := m.run(ctx, append([]string{"modifyvm", name}, opts...)...)
+	return err
+}
+
+// StartVM arranca la VM en modo headless.
+func (m *Manager) StartVM(ctx context.Context, name string) error {
+	_, err := m.run(ctx, "startvm", name, "--type", "headless")
+	return err
+}
+
+// PowerOff apaga la VM de forma inmediata.
+func (m *Manager) PowerOff(ctx context.Context, name string) error {
+	_, err := m.run(ctx, "controlvm", name, "poweroff")
+	return err
+}
+
+// UnregisterVM desregistra la VM y, si deleteFiles, borra sus archivos.
+func (m *Manager) UnregisterVM(ctx context.Context, name string, deleteFiles bool) error {
+	args := []string{"unregistervm", name}
+	if deleteFiles {
+		args = append(args, "--delete")
+	}
+	_, err := m.run(ctx, args...)
+	return err
+}
+
+// AddStorageController agrega un controlador (ej: "SATA", "sata", "IntelAhci", 4).
+func (m *Manager) AddStorageController(ctx context.Context, vm, name, bus, chipset string, ports int) error {
+	_, err := m.run(ctx, "storagectl", vm, "--name", name, "--add", bus,
+		"--controller", chipset, "--portcount", strconv.Itoa(ports))
+	return err
+}
+
+// MediumInfo retorna UUID, tipo y ubicación de un disco.
+func (m *Manager) MediumInfo(ctx context.Context, disk string) (Medium, error) {
+	out, err := m.run(ctx, "showmediuminfo", "disk", disk)
+	if err != nil {
+		var cmdErr *CommandError
+		if errors.As(err, &cmdErr) && mediumNotFound(cmdErr.Stderr) {
+			return Medium{}, &Error{Code: ExitDiskNotFound, Detail: disk, Err: err}
+		}
+		return Medium{}, err
+	}
+	return ParseMediumInfo(out), nil
+}
+
+// mediumNotFound indica si stderr de VBoxManage corresponde a un disco
+// inexistente o no registrado.
+func mediumNotFound(stderr string) bool {
+	return strings.Contains(stderr, "VBOX_E_OBJECT_NOT_FOUND") ||
+		strings.Contains(stderr, "VERR_FILE_NOT_FOUND")
+}
+
+// SetMediumType cambia el tipo de un disco (normal, multiattach, ...).
+func (m *Manager) SetMediumType(ctx context.Context, disk, typ string) error {
+	_, err := m.run(ctx, "modifymedium", "disk", disk, "--type", typ)
+	return err
+}
+
+// StorageAttach conecta un disco hdd en controlador/puerto/dispositivo.
+// mtype vacío adjunta sin forzar tipo de medio.
+func (m *Manager) StorageAttach(ctx context.Context, vm, controller string, port, device int, disk, mtype string) error {
+	args := []string{"storageattach", vm, "--storagectl", controller,
+		"--port", strconv.Itoa(port), "--device", strconv.Itoa(device),
+		"--type", "hdd", "--medium", disk}
+	if mtype != "" {
+		args = append(args, "--mtype", mtype)
+	}
+	_, err := m.run(ctx, args...)
+	return err
+}
+
+// MAC retorna la MAC del adaptador nic de la VM en formato con dos puntos.
+func (m *Manager) MAC(ctx context.Context, vm string, nic int) (string, error) {
+	info, err := m.VMInfo(ctx, vm)
+	if err != nil {
+		return "", err
+	}
+	mac := info.MAC(nic)
+	if mac == "" {
+		return "", fmt.Errorf("%w: %s (nic%d)", ErrNoMAC, vm, nic)
+	}
+	return mac, nil
+}
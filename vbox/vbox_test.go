@@ -0,0 +1,340 @@
+package vbox
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// vmInfo es la salida de showvminfo --machinereadable de la VM de prueba:
+// controlador SATA de 4 puertos con el puerto 0 ocupado por otro disco.
+const vmInfo = `name="web1"
+VMState="poweroff"
+macaddress1="08002764FE5B"
+storagecontrollername0="SATA"
+storagecontrollertype0="IntelAhci"
+storagecontrollerportcount0="4"
+"SATA-0-0"="/vms/other.vdi"
+"SATA-ImageUUID-0-0"="aaaa-1111"
+"SATA-1-0"="none"
+`
+
+// fakeVBox crea un VBoxManage falso que registra cada invocación y ejecuta
+// body (sh, con los argumentos en $@). Retorna el Manager que lo usa y una
+// función que lee las invocaciones registradas.
+func fakeVBox(t *testing.T, body string) (*Manager, func() []string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("el VBoxManage falso es un script sh")
+	}
+	dir := t.TempDir()
+	log := filepath.Join(dir, "calls.log")
+	bin := filepath.Join(dir, "VBoxManage")
+	script := "#!/bin/sh\necho \"$*\" >> '" + log + "'\n" + body + "\n"
+	if err := os.WriteFile(bin, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	calls := func() []string {
+		b, err := os.ReadFile(log)
+		if err != nil {
+			return nil
+		}
+		return strings.Split(strings.TrimSpace(string(b)), "\n")
+	}
+	return &Manager{Bin: bin}, calls
+}
+
+// vboxScript arma el cuerpo de un VBoxManage falso: responde showvminfo con
+// info y showmediuminfo con un disco del tipo mtype y UUID bbbb-2222; extra
+// son casos adicionales del case (se evalúan primero).
+func vboxScript(info, mtype, extra string) string {
+	return `case "$*" in
+` + extra + `
+"showvminfo "*) cat <<'EOF'
+` + info + `EOF
+;;
+"showmediuminfo "*) printf 'UUID:           bbbb-2222\nType:           ` + mtype + `\nLocation:       /vms/base.vdi\n' ;;
+*) ;;
+esac`
+}
+
+func TestRunCommandError(t *testing.T) {
+	m, _ := fakeVBox(t, `echo "VBoxManage: error: algo falló" >&2; exit 3`)
+	err := m.StartVM(t.Context(), "web1")
+	var ce *CommandError
+	if !errors.As(err, &ce) {
+		t.Fatalf("StartVM = %v, quiero *CommandError", err)
+	}
+	if ce.ExitCode != 3 || !strings.Contains(ce.Stderr, "algo falló") {
+		t.Errorf("CommandError = %+v", ce)
+	}
+	if want := []string{"startvm", "web1", "--type", "headless"}; !slices.Equal(ce.Args, want) {
+		t.Errorf("Args = %q, quiero %q", ce.Args, want)
+	}
+}
+
+func TestRunMissingBinary(t *testing.T) {
+	m := &Manager{Bin: filepath.Join(t.TempDir(), "no-existe")}
+	if err := m.CheckInstalled(); !errors.Is(err, ErrNoVBoxManage) {
+		t.Errorf("CheckInstalled = %v, quiero ErrNoVBoxManage", err)
+	}
+	m.Bin = "vboxmanage-que-no-existe"
+	if _, err := m.ListVMs(t.Context()); !errors.Is(err, ErrNoVBoxManage) {
+		t.Errorf("ListVMs = %v, quiero ErrNoVBoxManage", err)
+	}
+}
+
+func TestRunTimeoutAndTrace(t *testing.T) {
+	m, _ := fakeVBox(t, `exec sleep 10`)
+	m.Timeout = 200 * time.Millisecond
+	var traced []Invocation
+	m.Trace = func(inv Invocation) { traced = append(traced, inv) }
+	start := time.Now()
+	err := m.RestartDHCP(t.Context(), "red")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("RestartDHCP = %v, quiero DeadlineExceeded", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("el timeout tardó %v", d)
+	}
+	if len(traced) != 1 || traced[0].Err == nil || traced[0].Args[0] != "dhcpserver" {
+		t.Errorf("Trace = %+v", traced)
+	}
+}
+
+func TestVMInfoAndMAC(t *testing.T) {
+	m, _ := fakeVBox(t, vboxScript(vmInfo, "multiattach", ""))
+	info, err := m.VMInfo(t.Context(), "web1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.State() != "poweroff" || info["SATA-0-0"] != "/vms/other.vdi" {
+		t.Errorf("Info = %v", info)
+	}
+	mac, err := m.MAC(t.Context(), "web1", 1)
+	if err != nil || mac != "08:00:27:64:FE:5B" {
+		t.Errorf("MAC = %q, %v", mac, err)
+	}
+	if _, err := m.MAC(t.Context(), "web1", 2); !errors.Is(err, ErrNoMAC) {
+		t.Errorf("MAC(nic2) = %v, quiero ErrNoMAC", err)
+	}
+}
+
+func TestVMExistsNotFound(t *testing.T) {
+	m, _ := fakeVBox(t, `echo "VBoxManage: error: Could not find a registered machine named 'web9'" >&2
+echo "VBoxManage: error: Details: code VBOX_E_OBJECT_NOT_FOUND (0x80bb0001)" >&2
+exit 1`)
+	ok, err := m.VMExists(t.Context(), "web9")
+	if ok || err != nil {
+		t.Errorf("VMExists = %v, %v; quiero false, nil", ok, err)
+	}
+	if _, err := m.VMInfo(t.Context(), "web9"); !errors.Is(err, ErrVMNotFound) {
+		t.Errorf("VMInfo = %v, quiero ErrVMNotFound", err)
+	}
+}
+
+func TestVMInfoOtherErrors(t *testing.T) {
+	m, calls := fakeVBox(t, `echo "VBoxManage: error: The object is not ready" >&2
+echo "VBoxManage: error: Details: code E_ACCESSDENIED (0x80070005)" >&2
+exit 1`)
+	_, err := m.VMInfo(t.Context(), "web1")
+	var ce *CommandError
+	if errors.Is(err, ErrVMNotFound) || !errors.As(err, &ce) {
+		t.Errorf("VMInfo = %v, quiero *CommandError sin ErrVMNotFound", err)
+	}
+	if ok, err := m.VMExists(t.Context(), "web1"); ok || err == nil {
+		t.Errorf("VMExists = %v, %v; quiero el error de VBoxManage", ok, err)
+	}
+	if err := m.CreateVM(t.Context(), "web1", "Ubuntu_64"); err == nil || errors.Is(err, ErrVMNotFound) {
+		t.Errorf("CreateVM = %v, quiero el error de VBoxManage", err)
+	}
+	for _, c := range calls() {
+		if strings.HasPrefix(c, "createvm") {
+			t.Errorf("se ejecutó %q sin saber si la VM existe", c)
+		}
+	}
+}
+
+func TestMediumInfoErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name, stderr string
+		notFound     bool
+	}{
+		{"archivo inexistente", "VBoxManage: error: Could not find file for the medium '/vms/x.vdi' (VERR_FILE_NOT_FOUND)", true},
+		{"UUID no registrado", "VBoxManage: error: Details: code VBOX_E_OBJECT_NOT_FOUND (0x80bb0001)", true},
+		{"disco bloqueado", "VBoxManage: error: Details: code VBOX_E_INVALID_OBJECT_STATE (0x80bb0007)", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			m, _ := fakeVBox(t, "echo \""+tc.stderr+"\" >&2\nexit 1")
+			_, err := m.MediumInfo(t.Context(), "/vms/x.vdi")
+			if errors.Is(err, ErrDiskNotFound) != tc.notFound {
+				t.Errorf("MediumInfo = %v; ErrDiskNotFound = %v, quiero %v", err, !tc.notFound, tc.notFound)
+			}
+			var ce *CommandError
+			if !errors.As(err, &ce) {
+				t.Errorf("MediumInfo = %v, quiero conservar el *CommandError", err)
+			}
+		})
+	}
+}
+
+func TestCreateVMExists(t *testing.T) {
+	m, calls := fakeVBox(t, vboxScript(vmInfo, "multiattach", ""))
+	if err := m.CreateVM(t.Context(), "web1", "Ubuntu_64"); !errors.Is(err, ErrVMExists) {
+		t.Fatalf("CreateVM = %v, quiero ErrVMExists", err)
+	}
+	for _, c := range calls() {
+		if strings.HasPrefix(c, "createvm") {
+			t.Errorf("se ejecutó %q con la VM existente", c)
+		}
+	}
+}
+
+func TestListVMs(t *testing.T) {
+	m, _ := fakeVBox(t, `printf '"web1" {1111}\r\n"mi vm" {2222}\n\n"DNS" {3333}\n'`)
+	got, err := m.ListVMs(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"web1", "mi vm", "DNS"}; !slices.Equal(got, want) {
+		t.Errorf("ListVMs = %q, quiero %q", got, want)
+	}
+}
+
+func TestDHCPReservations(t *testing.T) {
+	m, calls := fakeVBox(t, `cat <<'EOF'
+NetworkName:    otra
+Config:         MAC 08:00:27:00:00:01
+Fixed Address:  10.0.0.5
+
+NetworkName:    red
+Dhcpd IP:       192.168.56.2
+Config:         Global
+Fixed Address:  192.168.56.250
+Config:         MAC 08:00:27:64:fe:5b
+Fixed Address:  192.168.56.21
+Config:         MAC 08:00:27:aa:bb:cc
+Fixed Address:  192.168.56.22
+EOF`)
+	got, err := m.DHCPReservations(t.Context(), "red")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Reservation{{MAC: "08:00:27:64:FE:5B", IP: "192.168.56.21"}, {MAC: "08:00:27:AA:BB:CC", IP: "192.168.56.22"}}
+	if !slices.Equal(got, want) {
+		t.Errorf("DHCPReservations = %v, quiero %v", got, want)
+	}
+	if err := m.SetDHCPReservation(t.Context(), "red", "08:00:27:64:FE:5B", "192.168.56.21"); err != nil {
+		t.Fatal(err)
+	}
+	c := calls()
+	if want := "dhcpserver modify --network=red --mac-address=08:00:27:64:FE:5B --fixed-address=192.168.56.21"; c[len(c)-1] != want {
+		t.Errorf("invocación = %q, quiero %q", c[len(c)-1], want)
+	}
+}
+
+func TestAttachMultiattach(t *testing.T) {
+	disk := filepath.Join(t.TempDir(), "base.vdi")
+	if err := os.WriteFile(disk, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	opt := AttachOptions{Disk: disk, VM: "web1", Controller: "sata"}
+	running := strings.Replace(vmInfo, "poweroff", "running", 1)
+	duplicate := vmInfo + `"SATA-1-0"="/vms/base.vdi"` + "\n" + `"SATA-ImageUUID-1-0"="BBBB-2222"` + "\n"
+	full := vmInfo + `"SATA-1-0"="/vms/x.vdi"` + "\n" + `"SATA-2-0"="/vms/y.vdi"` + "\n" + `"SATA-3-0"="/vms/z.vdi"` + "\n"
+
+	tests := []struct {
+		name       string
+		script     string
+		opt        AttachOptions
+		wantErr    error
+		wantPort   int
+		workaround bool
+		wantCall   string // Invocación que debe aparecer
+	}{
+		{name: "directa", script: vboxScript(vmInfo, "multiattach", ""), opt: opt, wantPort: 1,
+			wantCall: "storageattach web1 --storagectl SATA --port 1 --device 0 --type hdd --medium " + disk + " --mtype multiattach"},
+		{name: "workaround 7.2.0", opt: opt, wantPort: 1, workaround: true,
+			script:   vboxScript(vmInfo, "multiattach", `*"--mtype multiattach") exit 1 ;;`),
+			wantCall: "modifymedium disk " + disk + " --type normal"},
+		{name: "falla el workaround", opt: opt, wantErr: ErrAttachFailed,
+			script: vboxScript(vmInfo, "multiattach", `"storageattach "*) exit 1 ;;`)},
+		{name: "disco normal", script: vboxScript(vmInfo, "normal", ""), opt: opt, wantErr: ErrNotMultiattach},
+		{name: "controlador inexistente", script: vboxScript(vmInfo, "multiattach", ""),
+			opt: AttachOptions{Disk: disk, VM: "web1", Controller: "IDE"}, wantErr: ErrControllerNotFound},
+		{name: "VM en ejecución", script: vboxScript(running, "multiattach", ""), opt: opt, wantErr: ErrVMRunning},
+		{name: "VM en ejecución con force", script: vboxScript(running, "multiattach", ""), wantPort: 1,
+			opt: AttachOptions{Disk: disk, VM: "web1", Controller: "SATA", Force: true}},
+		{name: "duplicado", script: vboxScript(duplicate, "multiattach", ""), opt: opt, wantErr: ErrDuplicateDisk},
+		{name: "sin puertos", script: vboxScript(full, "multiattach", ""), opt: opt, wantErr: ErrNoFreePort},
+		{name: "faltan parámetros", script: "", opt: AttachOptions{VM: "web1"}, wantErr: ErrNoVBoxManage},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, calls := fakeVBox(t, tt.script)
+			res, err := m.AttachMultiattach(t.Context(), tt.opt)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("AttachMultiattach = %v, quiero %v", err, tt.wantErr)
+				}
+				for _, c := range calls() {
+					if strings.HasPrefix(c, "storageattach") && tt.wantErr != ErrAttachFailed {
+						t.Errorf("se ejecutó %q aunque la validación falló", c)
+					}
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if res.Port != tt.wantPort || res.Workaround != tt.workaround || res.DiskUUID != "bbbb-2222" {
+				t.Errorf("AttachResult = %+v", res)
+			}
+			if tt.wantCall != "" && !slices.Contains(calls(), tt.wantCall) {
+				t.Errorf("falta la invocación %q en %q", tt.wantCall, calls())
+			}
+		})
+	}
+}
+
+func TestParseMachineReadable(t *testing.T) {
+	info := ParseMachineReadable("name=\"web1\"\r\n\"SATA-0-0\"=\"C:\\vms\\a.vdi\"\r\nmemory=1024\r\nbasura\r\n\"sin=cierre\r\n")
+	want := Info{"name": "web1", "SATA-0-0": `C:\vms\a.vdi`, "memory": "1024"}
+	if len(info) != len(want) {
+		t.Fatalf("Info = %v, quiero %v", info, want)
+	}
+	for k, v := range want {
+		if info[k] != v {
+			t.Errorf("info[%q] = %q, quiero %q", k, info[k], v)
+		}
+	}
+}
+
+func TestFormatMAC(t *testing.T) {
+	tests := map[string]string{
+		"08002764fe5b":   "08:00:27:64:FE:5B",
+		" 08002764FE5B ": "08:00:27:64:FE:5B",
+		"08002764FE5":    "",
+		"08002764FE5G":   "",
+		"":               "",
+	}
+	for in, want := range tests {
+		if got := FormatMAC(in); got != want {
+			t.Errorf("FormatMAC(%q) = %q, quiero %q", in, got, want)
+		}
+	}
+}
+
+func TestParseMediumInfo(t *testing.T) {
+	out := "UUID:           bbbb-2222\r\nParent UUID:    base\r\nType:           normal (base)\r\nLocation:       C:\\vms\\base.vdi\r\nUUID:           otro\r\n"
+	want := Medium{UUID: "bbbb-2222", Type: "normal", Location: `C:\vms\base.vdi`}
+	if got := ParseMediumInfo(out); got != want {
+		t.Errorf("ParseMediumInfo = %+v, quiero %+v", got, want)
+	}
+}